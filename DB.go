@@ -0,0 +1,35 @@
+package kvstore
+
+type DB interface {
+	Set(key []byte, value []byte) error
+
+	Get(key []byte) ([]byte, error)
+
+	TryGet(key []byte) ([]byte, bool, error)
+
+	GetOrDefault(key, def []byte) ([]byte, error)
+
+	Del(key []byte) ([]byte, error)
+
+	Has(key []byte) (bool, error)
+
+	CompareAndSwap(key, expected, newValue []byte) (bool, error)
+
+	// Scan returns an iterator over the live keys in [start, end). A nil start
+	// or end leaves that side of the range open.
+	Scan(start, end []byte) (KeyIterator, error)
+}
+
+// KeyIterator walks keys in ascending order with their values. It starts
+// before the first key: Next must be called to move to it. Iterator, that of
+// MemDB, implements it.
+type KeyIterator interface {
+	// Next moves to the next key. It returns false once the keys are
+	// exhausted or an error occurred, which Err then reports.
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Err() error
+	// Close releases the iterator, which must not be used afterwards.
+	Close() error
+}
@@ -0,0 +1,36 @@
+package kvstore
+
+// The sizes of the chunks arenas allocate from: they start small so that
+// small memtables stay small, and double up to arenaMaxChunk.
+const (
+	arenaMinChunk = 4 << 10
+	arenaMaxChunk = 1 << 20
+)
+
+// arena allocates the keys and values of a memtable from large chunks, so
+// that writes don't allocate every entry on its own and the garbage collector
+// tracks a few chunks rather than every key and value. It is dropped with its
+// memtable once it is flushed, freeing the chunks all at once. The zero value
+// is an empty arena. It isn't safe for concurrent use.
+type arena struct {
+	chunk []byte // The current chunk, allocated up to its length.
+}
+
+// copy returns a copy of b allocated from the arena, nil if b is nil. Values
+// of more than a quarter of arenaMaxChunk are allocated on their own, so as
+// not to waste the end of a chunk.
+func (a *arena) copy(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	if len(b) > arenaMaxChunk/4 {
+		return append([]byte(nil), b...)
+	}
+	if len(b) > cap(a.chunk)-len(a.chunk) {
+		a.chunk = make([]byte, 0, min(max(2*cap(a.chunk), arenaMinChunk, len(b)), arenaMaxChunk))
+	}
+	n := len(a.chunk)
+	a.chunk = append(a.chunk, b...)
+	// Capped, so that appending to the copy can't overwrite the next one.
+	return a.chunk[n:len(a.chunk):len(a.chunk)]
+}
@@ -0,0 +1,98 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	var a arena
+	if a.copy(nil) != nil {
+		t.Error("Expected nil to stay nil")
+	}
+
+	src := []byte("key")
+	first := a.copy(src)
+	second := a.copy([]byte("value"))
+	src[0] = 'X'
+	if string(first) != "key" || string(second) != "value" {
+		t.Fatalf("Expected copies independent of their source, got %q and %q", first, second)
+	}
+	if cap(first) != len(first) {
+		t.Errorf("Expected a capped copy, got a capacity of %d", cap(first))
+	}
+	_ = append(first, "!!!"...)
+	if string(second) != "value" {
+		t.Errorf("Expected appends not to overwrite the next copy, got %q", second)
+	}
+
+	// Small copies share chunks, large ones get their own allocation.
+	chunk := a.chunk
+	if allocs := testing.AllocsPerRun(100, func() { a.copy(src) }); allocs > 0.1 {
+		t.Errorf("Expected small copies to share chunks, got %v allocations per copy", allocs)
+	}
+	large := bytes.Repeat([]byte("x"), arenaMaxChunk/2)
+	if got := a.copy(large); !bytes.Equal(got, large) {
+		t.Error("Expected the large value copied")
+	}
+	if &a.chunk[0] != &chunk[0] {
+		t.Error("Expected a large value to leave the chunk alone")
+	}
+}
+
+func TestMemtableCopiesWrites(t *testing.T) {
+	mem := NewTempDB(t)
+
+	// Callers may reuse their buffers once a write returns.
+	key, value := []byte("key"), []byte("value")
+	if err := mem.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	copy(key, "xxx")
+	copy(value, "xxxxx")
+	if got, err := mem.Get([]byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("Expected the value written, got %q (%v)", got, err)
+	}
+	if _, err := mem.Get([]byte("xxx")); err == nil {
+		t.Error("Expected the reused key buffer not to change the memtable")
+	}
+
+	// Frozen memtables keep their entries, the active one starting a new arena.
+	mem.mu.Lock()
+	err := mem.freeze()
+	mem.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.arena.chunk) != 0 {
+		t.Errorf("Expected a new arena for the active memtable, got %d bytes", len(mem.arena.chunk))
+	}
+	mem.Set([]byte("other"), []byte("value2"))
+	if got, err := mem.Get([]byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("Expected the frozen value, got %q (%v)", got, err)
+	}
+}
+
+func TestOverwritesFillMemtable(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MemtableSizeLimit: 1000, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Every overwrite leaves the previous value in the arena, so a single hot
+	// key still fills the memtable.
+	for i := 0; i < 100; i++ {
+		if err := mem.Set([]byte("hot"), []byte(fmt.Sprintf("value%05d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForFlush(t, mem)
+	if files := mem.manifest.current(); len(files) == 0 {
+		t.Error("Expected the overwrites to freeze and flush the memtable")
+	}
+	if value, err := mem.Get([]byte("hot")); err != nil || string(value) != "value00099" {
+		t.Errorf("Expected the last value, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,207 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	UniformDistribution = "uniform"
+	ZipfianDistribution = "zipfian"
+)
+
+// BenchConfig describes a synthetic load run against a remote server.
+type BenchConfig struct {
+	Addr         string  // Base URL of the server, e.g. http://localhost:8080.
+	Requests     int     // Total number of requests to issue.
+	Concurrency  int     // Number of concurrent clients.
+	KeySpace     int     // Number of distinct keys to draw from.
+	ValueSize    int     // Size in bytes of every written value.
+	Distribution string  // Key distribution, uniform or zipfian.
+	ZipfS        float64 // Zipfian skew parameter, must be > 1.
+	ReadRatio    float64 // Fraction of requests that are reads, between 0 and 1.
+	Seed         int64
+}
+
+// BenchResult summarizes a load run.
+type BenchResult struct {
+	Requests   int
+	Writes     int
+	Reads      int
+	Errors     int
+	Duration   time.Duration
+	Throughput float64 // Requests per second.
+	P50        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// keyGenerator draws key indexes according to the configured distribution.
+type keyGenerator struct {
+	rnd  *rand.Rand
+	zipf *rand.Zipf
+	n    int
+}
+
+func newKeyGenerator(distribution string, keySpace int, s float64, seed int64) (*keyGenerator, error) {
+	if keySpace <= 0 {
+		return nil, fmt.Errorf("key space must be positive, got %d", keySpace)
+	}
+
+	gen := &keyGenerator{rnd: rand.New(rand.NewSource(seed)), n: keySpace}
+
+	switch distribution {
+	case UniformDistribution, "":
+	case ZipfianDistribution:
+		if s <= 1 {
+			return nil, fmt.Errorf("zipfian skew must be > 1, got %v", s)
+		}
+		gen.zipf = rand.NewZipf(gen.rnd, s, 1, uint64(keySpace-1))
+	default:
+		return nil, fmt.Errorf("unknown key distribution: %s", distribution)
+	}
+
+	return gen, nil
+}
+
+// next returns the index of the next key to use.
+func (g *keyGenerator) next() int {
+	if g.zipf != nil {
+		return int(g.zipf.Uint64())
+	}
+	return g.rnd.Intn(g.n)
+}
+
+// RunBench issues cfg.Requests requests against the server at cfg.Addr and reports
+// throughput and latency figures.
+func RunBench(cfg BenchConfig) (BenchResult, error) {
+	if cfg.Requests <= 0 {
+		return BenchResult{}, fmt.Errorf("number of requests must be positive, got %d", cfg.Requests)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.ReadRatio < 0 || cfg.ReadRatio > 1 {
+		return BenchResult{}, fmt.Errorf("read ratio must be between 0 and 1, got %v", cfg.ReadRatio)
+	}
+
+	// Validate the distribution once before starting the workers.
+	if _, err := newKeyGenerator(cfg.Distribution, cfg.KeySpace, cfg.ZipfS, cfg.Seed); err != nil {
+		return BenchResult{}, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	value := bytes.Repeat([]byte("x"), cfg.ValueSize)
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		result    BenchResult
+		latencies = make([]time.Duration, 0, cfg.Requests)
+	)
+
+	// Split the requests between the workers.
+	jobs := make(chan struct{}, cfg.Requests)
+	for i := 0; i < cfg.Requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	start := time.Now()
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			// Every worker gets its own generator since rand.Rand is not safe for concurrent use.
+			gen, _ := newKeyGenerator(cfg.Distribution, cfg.KeySpace, cfg.ZipfS, cfg.Seed+int64(worker))
+
+			for range jobs {
+				key := fmt.Sprintf("bench%08d", gen.next())
+				read := gen.rnd.Float64() < cfg.ReadRatio
+
+				begin := time.Now()
+				var err error
+				if read {
+					err = benchGet(client, cfg.Addr, key)
+				} else {
+					err = benchSet(client, cfg.Addr, key, value)
+				}
+				elapsed := time.Since(begin)
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if read {
+					result.Reads++
+				} else {
+					result.Writes++
+				}
+				if err != nil {
+					result.Errors++
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	result.Requests = len(latencies)
+	result.Throughput = float64(result.Requests) / result.Duration.Seconds()
+
+	// Compute latency percentiles.
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P50 = latencies[len(latencies)*50/100]
+	result.P99 = latencies[len(latencies)*99/100]
+	result.Max = latencies[len(latencies)-1]
+
+	return result, nil
+}
+
+// benchSet writes a single key through the /set endpoint.
+func benchSet(client *http.Client, addr, key string, value []byte) error {
+	body, err := json.Marshal(map[string]string{"key": key, "value": string(value)})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(addr+"/set", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// benchGet reads a single key through the /get endpoint. A missing key is not an error.
+func benchGet(client *http.Client, addr, key string) error {
+	resp, err := client.Get(addr + "/get?key=" + key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// String formats the result as a short human-readable report.
+func (r BenchResult) String() string {
+	return fmt.Sprintf("requests: %d (writes: %d, reads: %d, errors: %d)\nduration: %v\nthroughput: %.2f req/s\nlatency p50: %v, p99: %v, max: %v",
+		r.Requests, r.Writes, r.Reads, r.Errors, r.Duration, r.Throughput, r.P50, r.P99, r.Max)
+}
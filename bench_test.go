@@ -0,0 +1,69 @@
+package kvstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyGeneratorZipfianSkew(t *testing.T) {
+	gen, err := newKeyGenerator(ZipfianDistribution, 1000, 1.5, 1)
+	if err != nil {
+		t.Fatalf("Error creating key generator: %v", err)
+	}
+
+	// With a skewed distribution the first key should be drawn far more often than 1/1000.
+	var hits int
+	for i := 0; i < 10000; i++ {
+		k := gen.next()
+		if k < 0 || k >= 1000 {
+			t.Fatalf("Key index out of range: %d", k)
+		}
+		if k == 0 {
+			hits++
+		}
+	}
+	if hits < 1000 {
+		t.Errorf("Expected a skewed distribution, key 0 drawn %d times", hits)
+	}
+
+	if _, err := newKeyGenerator("gaussian", 1000, 1.5, 1); err == nil {
+		t.Errorf("Expected an error for an unknown distribution")
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	var sets, gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/set":
+			atomic.AddInt32(&sets, 1)
+			w.WriteHeader(http.StatusCreated)
+		case "/get":
+			atomic.AddInt32(&gets, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	res, err := RunBench(BenchConfig{
+		Addr:         ts.URL,
+		Requests:     200,
+		Concurrency:  4,
+		KeySpace:     50,
+		ValueSize:    16,
+		Distribution: UniformDistribution,
+		ReadRatio:    0.5,
+	})
+	if err != nil {
+		t.Fatalf("Error running benchmark: %v", err)
+	}
+
+	if res.Requests != 200 || res.Errors != 0 {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	if int(sets) != res.Writes || int(gets) != res.Reads {
+		t.Errorf("Server saw %d sets and %d gets, result reports %d writes and %d reads", sets, gets, res.Writes, res.Reads)
+	}
+}
@@ -0,0 +1,114 @@
+package kvstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultBlockCacheSize is the size in bytes of the block cache when
+// Options.BlockCacheSize is unset.
+const DefaultBlockCacheSize = 8 << 20
+
+// blockCacheOverhead is the memory a cached block takes besides its
+// contents, as charged against the capacity of the cache.
+const blockCacheOverhead = 64
+
+// cacheable is a block the block cache can keep: a data block or a bloom
+// filter partition.
+type cacheable interface {
+	// cacheSize returns the bytes of the contents of the block.
+	cacheSize() int64
+}
+
+// blockKey identifies a block among the SST files of the store.
+type blockKey struct {
+	file   int // Number of the SST file.
+	offset int64
+}
+
+type cachedBlock struct {
+	key   blockKey
+	block cacheable
+	size  int64
+}
+
+// blockCache keeps the most recently read blocks of the SST files, data
+// blocks decompressed and with their checksum checked, so that the reads of
+// hot keys don't go to disk. It is shared by the tables of a tableCache. Blocks are
+// never modified once parsed, so reads share the cached ones. A nil
+// blockCache caches nothing.
+type blockCache struct {
+	capacity int64 // In bytes.
+
+	mu     sync.Mutex
+	size   int64
+	lru    *list.List // Of *cachedBlock, most recently used first.
+	blocks map[blockKey]*list.Element
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &blockCache{capacity: capacity, lru: list.New(), blocks: map[blockKey]*list.Element{}}
+}
+
+// get returns the cached block of key.
+func (c *blockCache) get(key blockKey) (cacheable, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.blocks[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).block, true
+}
+
+// add caches block under key, evicting the least recently used blocks past
+// the capacity of the cache. Blocks larger than the cache aren't kept.
+func (c *blockCache) add(key blockKey, block cacheable) {
+	if c == nil {
+		return
+	}
+	size := block.cacheSize() + blockCacheOverhead
+	if size > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; ok {
+		// Another read cached it meanwhile.
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, block: block, size: size})
+	c.size += size
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+// evictFile drops the blocks of SST file n, once it is replaced or removed.
+func (c *blockCache) evictFile(n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.blocks {
+		if key.file == n {
+			c.remove(elem)
+		}
+	}
+}
+
+// remove evicts the block of elem. c.mu must be held.
+func (c *blockCache) remove(elem *list.Element) {
+	b := c.lru.Remove(elem).(*cachedBlock)
+	delete(c.blocks, b.key)
+	c.size -= b.size
+}
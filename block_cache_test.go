@@ -0,0 +1,98 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	block := func(n int) dataBlock {
+		return dataBlock{tuples: bytes.Repeat([]byte{1}, n)}
+	}
+	c := newBlockCache(3 * (100 + blockCacheOverhead))
+	for i := 0; i < 3; i++ {
+		c.add(blockKey{file: 1, offset: int64(i)}, block(100))
+	}
+	if _, ok := c.get(blockKey{file: 1, offset: 0}); !ok {
+		t.Fatal("Expected block 0 to be cached")
+	}
+
+	// The least recently used block makes room for the new one.
+	c.add(blockKey{file: 2, offset: 0}, block(100))
+	if _, ok := c.get(blockKey{file: 1, offset: 1}); ok {
+		t.Error("Expected block 1 to be evicted")
+	}
+	for _, key := range []blockKey{{1, 0}, {1, 2}, {2, 0}} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("Expected block %v to be cached", key)
+		}
+	}
+
+	// Blocks larger than the cache aren't kept.
+	c.add(blockKey{file: 3, offset: 0}, block(1000))
+	if _, ok := c.get(blockKey{file: 3, offset: 0}); ok || c.lru.Len() != 3 {
+		t.Errorf("Expected the large block not to be cached, %d blocks", c.lru.Len())
+	}
+
+	c.evictFile(1)
+	if c.lru.Len() != 1 || c.size != 100+blockCacheOverhead {
+		t.Errorf("Expected only the block of file 2 to be left, got %d blocks of %d bytes", c.lru.Len(), c.size)
+	}
+
+	// A nil cache caches nothing.
+	var none *blockCache
+	none.add(blockKey{}, block(1))
+	if _, ok := none.get(blockKey{}); ok || newBlockCache(-1) != nil {
+		t.Error("Expected a disabled cache")
+	}
+}
+
+func TestReadsUseBlockCache(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), TableCacheSize: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	for i := 0; i < 1000; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value"))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := mem.Get([]byte("key0500")); err != nil || string(value) != "value" {
+		t.Fatalf("Unexpected value %q (%v)", value, err)
+	}
+
+	// Damage every data block: the block that was read stays served from
+	// memory, the others are read again.
+	path := filepath.Join(mem.sstDir, sstFileName(0, 1))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newSSTReader(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range r.blocks {
+		data[h.offset+5] ^= 0xff
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := mem.Get([]byte("key0500")); err != nil || string(value) != "value" {
+		t.Errorf("Expected key0500 to be read from the cache, got %q (%v)", value, err)
+	}
+	if _, err := mem.Get([]byte("key0001")); err == nil {
+		t.Error("Expected reading a damaged block to fail")
+	}
+}
@@ -0,0 +1,175 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultBulkBatchSize is the number of keys a bulk update changes per batch
+// when BulkUpdate.BatchSize is unset.
+const DefaultBulkBatchSize = 1000
+
+// BulkAction is the change a bulk update makes to the keys it matches.
+type BulkAction int
+
+const (
+	// BulkSetValue replaces the value of every key by BulkUpdate.Template,
+	// in which {key} and {value} stand for the key and its current value.
+	// Expirations are kept.
+	BulkSetValue BulkAction = iota
+	// BulkSetTTL makes every key expire BulkUpdate.TTL from the batch on.
+	BulkSetTTL
+	// BulkDelete deletes every key.
+	BulkDelete
+)
+
+// String returns the name of the action.
+func (a BulkAction) String() string {
+	switch a {
+	case BulkSetValue:
+		return "set-value"
+	case BulkSetTTL:
+		return "set-ttl"
+	case BulkDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("BulkAction(%d)", int(a))
+}
+
+// BulkUpdate describes a change to the live keys of a range, made by
+// MemDB.BulkUpdate.
+type BulkUpdate struct {
+	// Start and End bound the range of keys [Start, End), a nil Start or End
+	// leaving that side of it open.
+	Start, End []byte
+	// Match guards the update, which only changes the keys it returns true
+	// for, given their current value. Nil matches every key. It must not
+	// retain key or value.
+	Match  func(key, value []byte) bool
+	Action BulkAction
+	// Template is the new value of BulkSetValue.
+	Template string
+	// TTL is the time to live BulkSetTTL sets.
+	TTL time.Duration
+	// BatchSize is the number of keys changed at most per batch, each batch
+	// applied atomically, DefaultBulkBatchSize if zero.
+	BatchSize int
+	// DryRun counts the keys the update would change without changing them.
+	DryRun bool
+}
+
+// BulkResult reports what a bulk update did.
+type BulkResult struct {
+	Keys    int // Keys changed, or that would be in a dry run.
+	Batches int // Batches applied, 0 in a dry run.
+}
+
+// BulkUpdate applies u to the live keys of its range that u.Match matches.
+// The keys are found by an iterator, which holds no lock of the store, in
+// batches of u.BatchSize matching keys. Every batch is then applied
+// atomically, as a single WAL record: the keys are matched again against
+// their values at that time, so that a key written meanwhile is only changed
+// if it still matches. The update stops at the first batch that fails,
+// leaving the previous ones applied, as the result reports.
+func (mem *MemDB) BulkUpdate(u BulkUpdate) (result BulkResult, err error) {
+	switch u.Action {
+	case BulkSetValue, BulkDelete:
+	case BulkSetTTL:
+		if u.TTL <= 0 {
+			return result, errors.New("TTL must be positive")
+		}
+	default:
+		return result, fmt.Errorf("unknown bulk action %v", u.Action)
+	}
+	if u.BatchSize <= 0 {
+		u.BatchSize = DefaultBulkBatchSize
+	}
+
+	start := u.Start
+	for {
+		keys, err := mem.matchingKeys(start, u.End, u.BatchSize, u.Match)
+		if err != nil {
+			return result, err
+		}
+		if u.DryRun {
+			result.Keys += len(keys)
+		} else if len(keys) > 0 {
+			var n int
+			err := mem.write("", func() (err error) {
+				n, err = mem.applyBulk(&u, keys)
+				return err
+			})
+			if err != nil {
+				return result, err
+			}
+			result.Keys += n
+			result.Batches++
+		}
+		if len(keys) < u.BatchSize {
+			return result, nil
+		}
+		// Resume right after the last key of the batch.
+		start = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	}
+}
+
+// matchingKeys returns up to n live keys in [start, end) that match, nil
+// matching every key.
+func (mem *MemDB) matchingKeys(start, end []byte, n int, match func(key, value []byte) bool) ([][]byte, error) {
+	it, err := mem.NewRangeIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	for len(keys) < n && it.Next() {
+		if match == nil || match(it.Key(), it.Value()) {
+			keys = append(keys, it.Key())
+		}
+	}
+	it.Close()
+	return keys, it.Err()
+}
+
+// applyBulk applies u to those of keys that are live and still match, as a
+// single transaction, and returns how many there were. mu must be held.
+func (mem *MemDB) applyBulk(u *BulkUpdate, keys [][]byte) (int, error) {
+	var entries []WALEntry
+	for _, key := range keys {
+		pair, err := mem.getPair(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if u.Match != nil && !u.Match(key, pair.Value) {
+			continue
+		}
+
+		entry := WALEntry{Operation: setOperation, Key: key}
+		switch u.Action {
+		case BulkSetValue:
+			entry.Value = []byte(strings.NewReplacer("{key}", string(key), "{value}", string(pair.Value)).Replace(u.Template))
+			if pair.ExpiresAt != 0 {
+				entry.Operation, entry.Value = ttlOperation, encodeTTLValue(pair.ExpiresAt, entry.Value)
+			}
+		case BulkSetTTL:
+			entry.Operation, entry.Value = ttlOperation, encodeTTLValue(now()+int64(u.TTL), pair.Value)
+		case BulkDelete:
+			entry.Operation, entry.Value = delOperation, pair.Value
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	// Write the operation to the WAL
+	if err := mem.wal.AppendEntry(WatermarkPlaceholder, txnOperation, nil, encodeTxnBatch(entries)); err != nil {
+		return 0, err
+	}
+	mem.applyBatch(entries)
+	return len(entries), nil
+}
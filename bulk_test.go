@@ -0,0 +1,92 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+func TestBulkUpdate(t *testing.T) {
+	clock := time.Now().UnixNano()
+	setClock(t, &clock)
+
+	mem := newTempMemDB(t)
+	for i := 0; i < 25; i++ {
+		mem.Set([]byte(fmt.Sprintf("user:%02d", i)), []byte(fmt.Sprintf("v%d", i%2)))
+	}
+	mem.Set([]byte("vip"), []byte("v1"))
+	odd := func(key, value []byte) bool { return bytes.Equal(value, []byte("v1")) }
+
+	// A dry run counts the keys without changing them.
+	update := BulkUpdate{Start: []byte("user:"), End: []byte("user;"), Match: odd, Action: BulkSetValue,
+		Template: "{value}@{key}", BatchSize: 5, DryRun: true}
+	if result, err := mem.BulkUpdate(update); err != nil || result.Keys != 12 || result.Batches != 0 {
+		t.Fatalf("Expected 12 keys in a dry run, got %+v (%v)", result, err)
+	}
+	if value, _ := mem.Get([]byte("user:01")); string(value) != "v1" {
+		t.Fatalf("Expected the dry run to leave user:01 alone, got %q", value)
+	}
+
+	update.DryRun = false
+	if result, err := mem.BulkUpdate(update); err != nil || result.Keys != 12 || result.Batches != 3 {
+		t.Fatalf("Expected 12 keys in 3 batches, got %+v (%v)", result, err)
+	}
+	for key, want := range map[string]string{"user:01": "v1@user:01", "user:02": "v0", "vip": "v1"} {
+		if value, err := mem.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("Expected %s to be %q, got %q (%v)", key, want, value, err)
+		}
+	}
+
+	// Expirations are set, and kept by later updates.
+	ttl := BulkUpdate{Start: []byte("user:2"), End: []byte("user:3"), Action: BulkSetTTL, TTL: time.Minute}
+	if result, err := mem.BulkUpdate(ttl); err != nil || result.Keys != 5 {
+		t.Fatalf("Expected the TTL of 5 keys to be set, got %+v (%v)", result, err)
+	}
+	template := BulkUpdate{Start: []byte("user:24"), Action: BulkSetValue, Template: "new"}
+	if result, err := mem.BulkUpdate(template); err != nil || result.Keys != 2 {
+		t.Fatalf("Expected 2 keys to be set, got %+v (%v)", result, err)
+	}
+
+	// Only the keys matching their current value are deleted.
+	del := BulkUpdate{Match: func(key, value []byte) bool { return bytes.HasPrefix(value, []byte("v0")) }, Action: BulkDelete}
+	if result, err := mem.BulkUpdate(del); err != nil || result.Keys != 12 {
+		t.Fatalf("Expected 12 keys to be deleted, got %+v (%v)", result, err)
+	}
+	if _, err := mem.Get([]byte("user:02")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected user:02 to be deleted, got %v", err)
+	}
+
+	// The batches survive a replay of the WAL.
+	replayed := &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: mem.wal}
+	if err := replayed.Load(); err != nil {
+		t.Fatalf("Error loading WAL: %v", err)
+	}
+	for key, want := range map[string]*Value{
+		"user:01": {Operation: "SET", Value: []byte("v1@user:01")},
+		"user:02": {Operation: "DEL", Value: []byte("v0")},
+		"user:23": {Operation: "SET", Value: []byte("v1@user:23"), ExpiresAt: clock + int64(time.Minute)},
+		"user:24": {Operation: "SET", Value: []byte("new"), ExpiresAt: clock + int64(time.Minute)},
+	} {
+		elem := replayed.skiplist.Get([]byte(key))
+		if elem == nil {
+			t.Errorf("Expected %s to be replayed", key)
+			continue
+		}
+		got := elem.Value.(*Value)
+		if got.Operation != want.Operation || !bytes.Equal(got.Value, want.Value) || got.ExpiresAt != want.ExpiresAt {
+			t.Errorf("Expected %s to replay as %+v, got %+v", key, want, got)
+		}
+	}
+
+	clock += int64(time.Minute)
+	if _, err := mem.Get([]byte("user:23")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected user:23 to expire, got %v", err)
+	}
+	if _, err := mem.BulkUpdate(BulkUpdate{Action: BulkSetTTL}); err == nil {
+		t.Error("Expected an error setting a TTL of 0")
+	}
+}
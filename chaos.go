@@ -0,0 +1,140 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults describes the artificial latency and errors injected into the
+// requests of a server, for testing how clients handle a slow or failing store.
+type Faults struct {
+	ReadLatency    time.Duration // Delay added to every read.
+	WriteLatency   time.Duration // Delay added to every write.
+	ReadErrorRate  float64       // Fraction of reads failing with a 500, between 0 and 1.
+	WriteErrorRate float64       // Fraction of writes failing with a 500, between 0 and 1.
+}
+
+// faultInjector holds the faults currently injected.
+type faultInjector struct {
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+}
+
+// decide returns the latency to add to a request and whether it must fail.
+func (f *faultInjector) decide(write bool) (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	latency, rate := f.faults.ReadLatency, f.faults.ReadErrorRate
+	if write {
+		latency, rate = f.faults.WriteLatency, f.faults.WriteErrorRate
+	}
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return latency, rate > 0 && f.rand.Float64() < rate
+}
+
+// injectFaults is a middleware applying the configured faults to the read
+// (GET) and write requests, leaving the debug endpoints alone.
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		latency, fail := s.faults.decide(r.Method != http.MethodGet)
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fail {
+			http.Error(w, "Injected fault", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faultsJSON is the representation of Faults used by the debug endpoints, with
+// latencies written as durations such as "150ms".
+type faultsJSON struct {
+	ReadLatency    string  `json:"read_latency"`
+	WriteLatency   string  `json:"write_latency"`
+	ReadErrorRate  float64 `json:"read_error_rate"`
+	WriteErrorRate float64 `json:"write_error_rate"`
+}
+
+// FaultsHandler returns the faults currently injected.
+func (s *Server) FaultsHandler(w http.ResponseWriter, r *http.Request) {
+	s.faults.mu.Lock()
+	faults := s.faults.faults
+	s.faults.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(faultsJSON{
+		ReadLatency:    faults.ReadLatency.String(),
+		WriteLatency:   faults.WriteLatency.String(),
+		ReadErrorRate:  faults.ReadErrorRate,
+		WriteErrorRate: faults.WriteErrorRate,
+	})
+}
+
+// SetFaultsHandler replaces the faults injected. Omitted fields are cleared.
+func (s *Server) SetFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	var data faultsJSON
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		faults Faults
+		err    error
+	)
+	if data.ReadLatency != "" {
+		if faults.ReadLatency, err = time.ParseDuration(data.ReadLatency); err != nil {
+			http.Error(w, "Invalid 'read_latency' in JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if data.WriteLatency != "" {
+		if faults.WriteLatency, err = time.ParseDuration(data.WriteLatency); err != nil {
+			http.Error(w, "Invalid 'write_latency' in JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if data.ReadErrorRate < 0 || data.ReadErrorRate > 1 || data.WriteErrorRate < 0 || data.WriteErrorRate > 1 {
+		http.Error(w, "Error rates must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	faults.ReadErrorRate, faults.WriteErrorRate = data.ReadErrorRate, data.WriteErrorRate
+
+	s.faults.mu.Lock()
+	s.faults.faults = faults
+	s.faults.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClearFaultsHandler stops injecting faults.
+func (s *Server) ClearFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	s.faults.mu.Lock()
+	s.faults.faults = Faults{}
+	s.faults.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
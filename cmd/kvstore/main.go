@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"kvstore"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "wal" {
+		os.Exit(wal(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sst" {
+		os.Exit(sst(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(export(os.Args[2:]))
+	}
+
+	db, err := kvstore.NewMemDB()
+	if err != nil {
+		fmt.Println("Error creating MemDB:", err)
+		return
+	}
+	defer db.Close()
+	repl := &kvstore.Repl{
+		Db:  db,
+		In:  os.Stdin,
+		Out: os.Stdout,
+	}
+
+	repl.Start()
+}
+
+// bench runs the synthetic load generator against a remote server.
+func bench(args []string) {
+	var cfg kvstore.BenchConfig
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&cfg.Addr, "addr", "http://localhost:8080", "server address")
+	fs.IntVar(&cfg.Requests, "n", 10000, "total number of requests")
+	fs.IntVar(&cfg.Concurrency, "c", 8, "number of concurrent clients")
+	fs.IntVar(&cfg.KeySpace, "keys", 100000, "number of distinct keys")
+	fs.IntVar(&cfg.ValueSize, "value-size", 100, "value size in bytes")
+	fs.StringVar(&cfg.Distribution, "dist", kvstore.UniformDistribution, "key distribution (uniform, zipfian)")
+	fs.Float64Var(&cfg.ZipfS, "zipf-s", 1.1, "zipfian skew parameter")
+	fs.Float64Var(&cfg.ReadRatio, "read-ratio", 0, "fraction of requests that are reads")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "random seed")
+	fs.Parse(args)
+
+	res, err := kvstore.RunBench(cfg)
+	if err != nil {
+		fmt.Println("Error running benchmark:", err)
+		os.Exit(1)
+	}
+	fmt.Println(res)
+}
+
+// serve runs the HTTP server until interrupted, then drains it.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	timeout := fs.Duration("drain-timeout", 30*time.Second, "maximum time to drain on shutdown")
+	debug := fs.Bool("debug", false, "enable the fault injection endpoints")
+	syncPolicy := fs.String("sync", "never", "when to fsync the WAL: always, interval or never")
+	syncInterval := fs.Duration("sync-interval", kvstore.DefaultSyncInterval, "period of the WAL fsyncs with -sync interval")
+	engineName := fs.String("engine", "lsm", "storage engine, one of "+strings.Join(kvstore.Engines(), ", "))
+	compaction := fs.String("compaction", "leveled", "compaction strategy of the SST files: leveled or size-tiered")
+	verify := fs.String("verify", "none", "checks of the SST files on startup: none, manifest, checksums or full")
+	fs.Parse(args)
+
+	policy, err := kvstore.ParseSyncPolicy(*syncPolicy)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	strategy, err := kvstore.ParseCompactionStrategy(*compaction)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	level, err := kvstore.ParseVerifyLevel(*verify)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+
+	engine, err := kvstore.OpenEngine(*engineName, kvstore.Options{SyncPolicy: policy, SyncInterval: *syncInterval, CompactionStrategy: strategy, VerifyOnOpen: level})
+	if err != nil {
+		fmt.Println("Error creating server:", err)
+		os.Exit(1)
+	}
+	server := kvstore.NewServerWithEngine(engine)
+	server.Debug = *debug
+	server.SetupRoutes()
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		took, err := server.Shutdown(ctx)
+		if err != nil {
+			fmt.Println("Error draining server:", err)
+			return
+		}
+		fmt.Printf("Drained in %v\n", took)
+	}()
+
+	fmt.Printf("Server is running on %s...\n", *addr)
+	if err := server.ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+	<-drained
+}
+
+// wal inspects or repairs WAL segment files, returning the exit status: 1 if
+// a file is corrupt or can't be read, 2 on usage errors.
+func wal(args []string) int {
+	if len(args) < 2 || (args[0] != "inspect" && args[0] != "repair") {
+		fmt.Println("Usage: kvstore wal inspect|repair <file>...")
+		fmt.Println("  inspect dumps the entries of WAL files and reports the first corrupt offset.")
+		fmt.Println("  repair truncates WAL files at their first corrupt entry. Stop the store first.")
+		return 2
+	}
+
+	status := 0
+	for _, path := range args[1:] {
+		var (
+			report kvstore.WALReport
+			err    error
+		)
+		if args[0] == "inspect" {
+			report, err = kvstore.InspectWAL(path, printWALRecord)
+		} else {
+			report, err = kvstore.RepairWAL(path)
+		}
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		end := "end of file"
+		if report.Footer {
+			end = "footer"
+		}
+		switch {
+		case report.Corrupt == nil:
+			fmt.Printf("%s: %d entries, intact up to the %s at offset %d\n", path, report.Entries, end, report.End)
+		case args[0] == "repair":
+			fmt.Printf("%s: %d entries, truncated at offset %d, dropping %d bytes: %v\n",
+				path, report.Entries, report.End, report.Size-report.End, report.Corrupt)
+		default:
+			fmt.Printf("%s: %d entries, corrupt at offset %d (%d bytes after it): %v\n",
+				path, report.Entries, report.End, report.Size-report.End, report.Corrupt)
+			status = 1
+		}
+	}
+	return status
+}
+
+// export dumps the live keys of the store under a data directory to stdout,
+// returning the exit status: 1 if the store can't be read, 2 on usage errors.
+// The store must not be running, as it holds the lock of the directory.
+func export(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := fs.String("dir", "", "data directory of the store, the default one if empty")
+	formatName := fs.String("format", "ndjson", "output format, ndjson or csv")
+	fs.Parse(args)
+
+	format, err := kvstore.ParseExportFormat(*formatName)
+	if err != nil || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: kvstore export [-dir <data directory>] [-format ndjson|csv]")
+		return 2
+	}
+
+	kvstore.Logger.SetOutput(io.Discard)
+	db, err := kvstore.Open(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening store:", err)
+		return 1
+	}
+	defer db.Close()
+	n, err := db.Export(os.Stdout, format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error exporting store:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d keys\n", n)
+	return 0
+}
+
+// sst verifies or exports SST files, returning the exit status: 1 if a file is
+// damaged or can't be read, 2 on usage errors.
+func sst(args []string) int {
+	if len(args) > 0 && args[0] == "export" {
+		return sstExport(args[1:])
+	}
+	if len(args) < 2 || args[0] != "verify" {
+		fmt.Println("Usage: kvstore sst verify <file>...")
+		fmt.Println("       kvstore sst export [-format ndjson|csv] <file>")
+		fmt.Println("  verify checks the checksums, key order and header of SST files, such as restored backups.")
+		fmt.Println("  export dumps every tuple of an SST file to stdout, deletions included.")
+		return 2
+	}
+
+	status := 0
+	for _, path := range args[1:] {
+		report, err := kvstore.VerifySST(path)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		fmt.Printf("%s: version %d, %d entries in %d blocks, keys %q to %q, %d bytes\n",
+			path, report.Version, report.Entries, report.Blocks, report.Smallest, report.Largest, report.Size)
+		for _, problem := range report.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+		if !report.OK() {
+			fmt.Printf("%s: %d problems found\n", path, len(report.Problems))
+			status = 1
+		}
+	}
+	return status
+}
+
+// sstExport dumps the tuples of an SST file to stdout.
+func sstExport(args []string) int {
+	fs := flag.NewFlagSet("sst export", flag.ExitOnError)
+	formatName := fs.String("format", "ndjson", "output format, ndjson or csv")
+	fs.Parse(args)
+
+	format, err := kvstore.ParseExportFormat(*formatName)
+	if err != nil || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: kvstore sst export [-format ndjson|csv] <file>")
+		return 2
+	}
+	if _, err := kvstore.ExportSST(fs.Arg(0), os.Stdout, format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+// maxPrintedValue is the number of bytes of a value printed by wal inspect.
+const maxPrintedValue = 64
+
+func printWALRecord(record kvstore.WALRecord) error {
+	value := record.Entry.Value
+	suffix := ""
+	if len(value) > maxPrintedValue {
+		value, suffix = value[:maxPrintedValue], fmt.Sprintf("... (%d bytes)", len(record.Entry.Value))
+	}
+	flushed := ""
+	if record.Flushed {
+		flushed = " flushed"
+	}
+	fmt.Printf("%10d seq=%d %s %q %q%s%s\n", record.Offset, record.Entry.Seq, record.Entry.Operation, record.Entry.Key, value, suffix, flushed)
+	return nil
+}
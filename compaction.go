@@ -0,0 +1,1103 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CompactionStrategy selects how the background compaction merges the SST
+// files of the store.
+type CompactionStrategy int
+
+const (
+	// LeveledCompaction merges the files of level 0 into levels of sorted,
+	// disjoint files, each LevelSizeMultiplier times larger than the previous
+	// one. Reads probe few files, at the cost of rewriting keys once per level.
+	LeveledCompaction CompactionStrategy = iota
+	// SizeTieredCompaction merges runs of files of similar sizes into a
+	// single larger run, every file staying in level 0. Keys are rewritten less often,
+	// which suits write-heavy workloads, but reads probe a file per tier.
+	SizeTieredCompaction
+)
+
+// ParseCompactionStrategy returns the strategy named leveled or size-tiered.
+func ParseCompactionStrategy(name string) (CompactionStrategy, error) {
+	switch name {
+	case "leveled":
+		return LeveledCompaction, nil
+	case "size-tiered":
+		return SizeTieredCompaction, nil
+	}
+	return LeveledCompaction, fmt.Errorf("unknown compaction strategy %q", name)
+}
+
+func (s CompactionStrategy) String() string {
+	switch s {
+	case LeveledCompaction:
+		return "leveled"
+	case SizeTieredCompaction:
+		return "size-tiered"
+	}
+	return fmt.Sprintf("CompactionStrategy(%d)", int(s))
+}
+
+// CompactionDecision tells a compaction what to do with an entry, as a
+// CompactionFilter decides.
+type CompactionDecision int
+
+const (
+	// CompactionKeep keeps the entry as it is.
+	CompactionKeep CompactionDecision = iota
+	// CompactionDrop deletes the key, as Del would have.
+	CompactionDrop
+	// CompactionChange replaces the value of the entry with the one the
+	// filter returns, keeping its expiration.
+	CompactionChange
+)
+
+// CompactionFilter decides, for the newest version of every key that a
+// compaction merges into level, whether to keep it, drop it or change its
+// value. It lets applications purge or rewrite entries in bulk, such as the
+// keys of a deletion list, without a write per key. Deleted and expired keys
+// aren't passed to it, nor are the files flushed from the memtable, so a key
+// may be read after being written until a compaction reaches it. While a
+// filter is set, files are always rewritten rather than moved to the next
+// level, so that it sees their keys. The filter is called by one compaction
+// at a time, but concurrently by its sub-compactions if
+// Options.MaxSubcompactions allows them. It must not retain key or value.
+type CompactionFilter func(level int, key, value []byte) (CompactionDecision, []byte)
+
+// KeyBoundary returns the part of key, such as its namespace, whose keys
+// compactions keep together: once a file holds a quarter of TargetFileSize,
+// they start the next one where it changes between two keys, so that later
+// compactions and deletions of a namespace touch few files shared with
+// others. It must not retain key.
+type KeyBoundary func(key []byte) []byte
+
+// NamespaceBoundary returns the KeyBoundary of the keys whose namespace ends
+// at the first separator byte, such as "users:" of "users:42". Keys without
+// one share an empty namespace.
+func NamespaceBoundary(separator byte) KeyBoundary {
+	return func(key []byte) []byte {
+		if i := bytes.IndexByte(key, separator); i >= 0 {
+			return key[:i+1]
+		}
+		return nil
+	}
+}
+
+const (
+	// numLevels is the number of levels of SST files. Files of the last
+	// level are never compacted further.
+	numLevels = 7
+
+	// DefaultL0CompactionTrigger is the number of files of level 0 that
+	// starts their compaction when Options.L0CompactionTrigger is unset.
+	DefaultL0CompactionTrigger = 4
+	// DefaultLevelSizeBase is the size of level 1 past which it is compacted
+	// when Options.LevelSizeBase is unset.
+	DefaultLevelSizeBase = 10 << 20
+	// DefaultLevelSizeMultiplier is the growth of the size of every level
+	// over the previous one when Options.LevelSizeMultiplier is unset.
+	DefaultLevelSizeMultiplier = 10
+	// DefaultTargetFileSize is the size of the files compactions write when
+	// Options.TargetFileSize is unset.
+	DefaultTargetFileSize = 2 << 20
+	// boundaryFileShare is the share of the target size of the files from
+	// which compactions start a new file at a KeyBoundary.
+	boundaryFileShare = 4
+
+	// sizeTieredBucketLow and sizeTieredBucketHigh bound the sizes of the
+	// files of a tier, relative to their average size.
+	sizeTieredBucketLow  = 0.5
+	sizeTieredBucketHigh = 1.5
+	// sizeTieredMaxRuns is the number of runs merged at most at once.
+	sizeTieredMaxRuns = 32
+
+	// compactionTombstoneRatio is the share of deletions among the tuples of
+	// a level from which leveled compaction compacts it, whatever its size,
+	// so that deletions reach the level where they are dropped rather than
+	// shadowing their keys for every read.
+	compactionTombstoneRatio = 0.5
+)
+
+// errCompactionStopped is returned by the compactions interrupted by Close.
+var errCompactionStopped = errors.New("compaction stopped")
+
+// Files flushed from the memtable go to level 0, where they may overlap.
+// Compactions merge them into level 1, and the files of every other level
+// into the next one, so that from level 1 on the files of a level hold
+// disjoint ranges of keys: a read probes the files of level 0, then at most
+// one file per level. Only the newest version of every key is kept, and
+// deletions and expired values are dropped once no deeper level may hold an
+// older version they shadow. Files that overlap nothing in the next level are
+// moved to it without being rewritten.
+
+// compaction merges SST files into new files of level output.
+type compaction struct {
+	level    int        // Of the first input.
+	output   int        // Level of the new files.
+	inputs   []*sstMeta // Ordered as the manifest lists them.
+	fileSize int64      // Size of the keys and values past which a new file is started.
+	// levels holds the files of the store by level, to tell whether a
+	// deeper level may hold a key, and others the files of level 0 a
+	// size-tiered compaction leaves out.
+	levels [numLevels][]*sstMeta
+	others []*sstMeta
+	reason string // Why the compaction was picked.
+}
+
+// pickCompaction returns the compaction that files need most as the picker
+// of the store chooses it, or nil if none does. mem.compactMu must be held.
+func (mem *MemDB) pickCompaction(files []*sstMeta) (*compaction, error) {
+	job := mem.picker().PickCompaction(mem.compactionState(files))
+	if job == nil {
+		return nil, nil
+	}
+	return mem.compactionOf(files, *job)
+}
+
+// newCompaction returns a compaction of none of files yet, which knows their
+// levels.
+func (mem *MemDB) newCompaction(files []*sstMeta) *compaction {
+	c := &compaction{fileSize: mem.opts.TargetFileSize}
+	for _, f := range files {
+		// Levels past the last are only left by other versions; they are read
+		// but never compacted.
+		if f.level < numLevels {
+			c.levels[f.level] = append(c.levels[f.level], f)
+		}
+	}
+	return c
+}
+
+// pickLeveled returns the compaction of c.levels leveled compaction needs
+// most, or nil: that of the level of the highest score, if it reaches 1, see
+// levelScores. Level 0 is compacted whole, the other levels one file at a
+// time, taken in turn across the range of keys of the level, or the file of
+// the most deletions first when they rather than the size of the level make
+// its score.
+func (mem *MemDB) pickLeveled(c *compaction) *compaction {
+	scores := mem.levelScores(c.levels)
+	level := 0
+	for l, info := range scores {
+		if info.Score > scores[level].Score {
+			level = l
+		}
+	}
+	if scores[level].Score < 1 {
+		return nil
+	}
+
+	if level == 0 {
+		c.output = 1
+		c.reason = fmt.Sprintf("level 0 has %d files, L0CompactionTrigger is %d", scores[0].Files, mem.opts.L0CompactionTrigger)
+		c.inputs = append(c.inputs, c.levels[0]...)
+		smallest, largest := keyRange(c.levels[0])
+		c.inputs = append(c.inputs, overlapping(c.levels[1], smallest, largest)...)
+		return c
+	}
+
+	next := c.levels[level][0]
+	if limit := mem.levelSizeLimit(level); scores[level].Bytes > limit {
+		c.reason = fmt.Sprintf("level %d holds %d bytes, over its limit of %d", level, scores[level].Bytes, limit)
+		// Start after the file compacted last in the level, wrapping around.
+		for _, f := range c.levels[level] {
+			if bytes.Compare(f.smallest, mem.compactPointers[level]) > 0 {
+				next = f
+				break
+			}
+		}
+	} else {
+		c.reason = fmt.Sprintf("%.0f%% of the tuples of level %d are deletions", 100*deletionRatio(scores[level].Deletions, scores[level].Entries), level)
+		for _, f := range c.levels[level] {
+			if deletionRatio(f.deletions, f.entries) > deletionRatio(next.deletions, next.entries) {
+				next = f
+			}
+		}
+	}
+	c.level, c.output = level, level+1
+	c.inputs = append([]*sstMeta{next}, overlapping(c.levels[level+1], next.smallest, next.largest)...)
+	return c
+}
+
+// levelScores returns how much leveled compaction needs to compact every
+// level of levels, described by the Score of its LevelInfo. A level scoring
+// at least 1 needs a compaction:
+//   - level 0 from L0CompactionTrigger files,
+//   - the other levels from the size LevelSizeBase and LevelSizeMultiplier
+//     give them, or once compactionTombstoneRatio of their tuples are
+//     deletions.
+//
+// The last level, which is never compacted, scores 0.
+func (mem *MemDB) levelScores(levels [numLevels][]*sstMeta) []LevelInfo {
+	scores := make([]LevelInfo, numLevels)
+	for level, files := range levels {
+		info := &scores[level]
+		info.Level, info.Files = level, len(files)
+		for _, f := range files {
+			info.Bytes += f.size
+			info.Entries += f.entries
+			info.Deletions += f.deletions
+		}
+		switch {
+		case level == 0:
+			if trigger := mem.opts.L0CompactionTrigger; trigger > 0 {
+				info.Score = float64(len(files)) / float64(trigger)
+			}
+		case level < numLevels-1:
+			info.Score = max(float64(info.Bytes)/float64(mem.levelSizeLimit(level)),
+				deletionRatio(info.Deletions, info.Entries)/compactionTombstoneRatio)
+		}
+	}
+	return scores
+}
+
+// levelSizeLimit returns the size of the files of level, from 1 on, past
+// which leveled compaction compacts them into the next level.
+func (mem *MemDB) levelSizeLimit(level int) int64 {
+	limit := mem.opts.LevelSizeBase
+	for l := 1; l < level; l++ {
+		limit *= int64(mem.opts.LevelSizeMultiplier)
+	}
+	return limit
+}
+
+// deletionRatio returns the share of deletions among entries tuples, 0 if
+// the number of tuples is unknown.
+func deletionRatio(deletions, entries int) float64 {
+	if entries == 0 {
+		return 0
+	}
+	return float64(deletions) / float64(entries)
+}
+
+// sortedRun is a run of files of level 0 of disjoint key ranges, such as
+// those a size-tiered compaction writes, and their total size.
+type sortedRun struct {
+	files []*sstMeta
+	size  int64
+}
+
+// sortedRuns groups files of level 0, ordered as the manifest lists them, in
+// runs of consecutive files of disjoint key ranges, which size-tiered
+// compaction sizes and merges as a whole.
+func sortedRuns(files []*sstMeta) []sortedRun {
+	var runs []sortedRun
+	for _, f := range files {
+		if n := len(runs); n > 0 && len(overlapping(runs[n-1].files, f.smallest, f.largest)) == 0 {
+			runs[n-1].files = append(runs[n-1].files, f)
+			runs[n-1].size += f.size
+		} else {
+			runs = append(runs, sortedRun{files: []*sstMeta{f}, size: f.size})
+		}
+	}
+	return runs
+}
+
+// pickSizeTiered returns the compaction of the files of level 0 size-tiered
+// compaction needs, or nil. The sorted runs of the files are grouped in tiers
+// of similar sizes, those smaller than TargetFileSize all sharing the first
+// one, and the smallest runs of the first tier reaching L0CompactionTrigger
+// runs are merged into a single run of level 0, of files of TargetFileSize.
+// Files that leveled compaction left in other levels stay there.
+func (mem *MemDB) pickSizeTiered(c *compaction) *compaction {
+	threshold := max(mem.opts.L0CompactionTrigger, 2)
+	runs := sortedRuns(c.levels[0])
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].size < runs[j].size })
+
+	var tier []sortedRun
+	var total int64
+	for i, run := range runs {
+		avg := float64(total) / float64(max(len(tier), 1))
+		similar := len(tier) > 0 && float64(run.size) >= avg*sizeTieredBucketLow && float64(run.size) <= avg*sizeTieredBucketHigh
+		if len(tier) > 0 && !similar && run.size >= mem.opts.TargetFileSize {
+			if len(tier) >= threshold {
+				break
+			}
+			tier, total = nil, 0
+		}
+		tier = append(tier, run)
+		total += run.size
+		if len(tier) == sizeTieredMaxRuns || i == len(runs)-1 {
+			break
+		}
+	}
+	if len(tier) < threshold {
+		return nil
+	}
+
+	c.reason = fmt.Sprintf("level 0 has %d runs of files of similar sizes, which are merged from %d", len(tier), threshold)
+	merged := make(map[int]bool)
+	for _, run := range tier {
+		for _, f := range run.files {
+			merged[f.number] = true
+		}
+	}
+	// Keep the inputs in the order of the manifest, which breaks the ties of
+	// sequence numbers.
+	for _, f := range c.levels[0] {
+		if merged[f.number] {
+			c.inputs = append(c.inputs, f)
+		} else {
+			c.others = append(c.others, f)
+		}
+	}
+	return c
+}
+
+// keyRange returns the smallest and largest keys of files.
+func keyRange(files []*sstMeta) (smallest, largest []byte) {
+	for i, f := range files {
+		if i == 0 || bytes.Compare(f.smallest, smallest) < 0 {
+			smallest = f.smallest
+		}
+		if i == 0 || bytes.Compare(f.largest, largest) > 0 {
+			largest = f.largest
+		}
+	}
+	return smallest, largest
+}
+
+// overlapping returns the files holding keys in [smallest, largest].
+func overlapping(files []*sstMeta, smallest, largest []byte) []*sstMeta {
+	var found []*sstMeta
+	for _, f := range files {
+		if bytes.Compare(f.largest, smallest) >= 0 && bytes.Compare(f.smallest, largest) <= 0 {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// overlappingRange returns the files holding keys in [start, end), a nil
+// start or end leaving that side of the range open.
+func overlappingRange(files []*sstMeta, start, end []byte) []*sstMeta {
+	var found []*sstMeta
+	for _, f := range files {
+		if (start == nil || bytes.Compare(f.largest, start) >= 0) && (end == nil || bytes.Compare(f.smallest, end) < 0) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// isBaseLevel reports whether no file left out of c may hold an older
+// version of key, so that its deletion shadows nothing. The levels above the
+// output only hold newer versions.
+func (c *compaction) isBaseLevel(key []byte) bool {
+	for _, f := range c.others {
+		if f.contains(key) {
+			return false
+		}
+	}
+	for l := c.output + 1; l < numLevels; l++ {
+		files := c.levels[l]
+		i := sort.Search(len(files), func(i int) bool { return bytes.Compare(files[i].largest, key) >= 0 })
+		if i < len(files) && files[i].contains(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterEntry returns the version pair of key that remains once the
+// CompactionFilter of the store decided on it. A dropped key becomes a
+// deletion, which the caller leaves out if it shadows nothing. Values kept in
+// the value log are read for the filter, and those it changes move into the
+// SST file.
+func (mem *MemDB) filterEntry(c *compaction, key []byte, pair SSTPair) (SSTPair, error) {
+	resolved, err := mem.tables.values.resolve(key, pair)
+	if err != nil {
+		return pair, err
+	}
+	decision, value := mem.opts.CompactionFilter(c.output, key, resolved.Value)
+	switch decision {
+	case CompactionDrop:
+		return SSTPair{Operation: delOperation, Seq: pair.Seq}, nil
+	case CompactionChange:
+		resolved.Value = value
+		return resolved, nil
+	}
+	return pair, nil
+}
+
+// Compact merges every SST file of the store into a minimal set: files of
+// disjoint key ranges in a single level, the first one whose size they fit,
+// or a single sorted run of level 0 under SizeTieredCompaction. Only the newest version of
+// every key is kept and deletions are dropped, which reclaims their space
+// after bulk deletions or before a backup. Files of older format versions are
+// rewritten in the current one. Files flushed meanwhile are left out. It does
+// nothing if the files already form such a set.
+func (mem *MemDB) Compact() error {
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	mem.compactMu.Lock()
+	defer mem.compactMu.Unlock()
+
+	c := mem.fullCompaction(mem.manifest.current())
+	if c == nil {
+		return nil
+	}
+	return mem.runCompaction(c)
+}
+
+// fullCompaction returns the compaction of every file of files for Compact,
+// or nil if they already are a minimal set.
+func (mem *MemDB) fullCompaction(files []*sstMeta) *compaction {
+	if len(files) == 0 {
+		return nil
+	}
+	c := &compaction{level: files[0].level, inputs: files, fileSize: mem.opts.TargetFileSize}
+	if mem.opts.CompactionStrategy == SizeTieredCompaction {
+		c.output = 0
+	} else {
+		var size int64
+		for _, f := range files {
+			size += f.size
+		}
+		c.output = 1
+		for size > mem.levelSizeLimit(c.output) && c.output < numLevels-1 {
+			c.output++
+		}
+	}
+
+	// Files of a single level other than 0, written in the current version,
+	// are left as they are. So is a single sorted run of level 0 under
+	// SizeTieredCompaction.
+	minimal := mem.opts.CompactionStrategy != SizeTieredCompaction || len(sortedRuns(files)) == 1
+	for _, f := range files {
+		if f.level != files[0].level || (f.level == 0) != (c.output == 0) {
+			minimal = false
+		}
+	}
+	if minimal {
+		upgraded, err := mem.currentVersion(files)
+		if err != nil {
+			Logger.Printf("error reading the SST files to compact: %v", err)
+		}
+		if upgraded {
+			return nil
+		}
+	}
+	return c
+}
+
+// currentVersion reports whether files are all written in the current format
+// version.
+func (mem *MemDB) currentVersion(files []*sstMeta) (bool, error) {
+	for _, f := range files {
+		t, err := mem.tables.get(f)
+		if err != nil {
+			return false, err
+		}
+		version := t.reader.header.Version
+		mem.tables.release(t)
+		if version != sstVersion {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CompactRange merges the SST files holding keys in [start, end), a nil
+// start or end leaving that side of the range open, so that the versions
+// overwritten or deleted in a hot or bulk-deleted range are reclaimed without
+// rewriting the whole store as Compact does. The files are compacted level by
+// level down to the deepest one holding keys of the range, along with the
+// files of the next level they overlap, or rewritten in place if they are all
+// in a single level. Under SizeTieredCompaction, the files of level 0 are
+// merged into a single sorted run. Files flushed meanwhile are left out.
+func (mem *MemDB) CompactRange(start, end []byte) error {
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	mem.compactMu.Lock()
+	defer mem.compactMu.Unlock()
+
+	files := mem.manifest.current()
+	deepest := 0
+	if mem.opts.CompactionStrategy != SizeTieredCompaction {
+		for _, f := range overlappingRange(files, start, end) {
+			if f.level < numLevels {
+				deepest = max(deepest, f.level)
+			}
+		}
+		deepest = max(deepest, 1)
+	}
+	ran := false
+	for level := 0; level < deepest; level++ {
+		// Every compaction replaces files, so the next one starts from the files
+		// it left.
+		c := mem.rangeCompaction(mem.manifest.current(), level, level+1, start, end)
+		if c == nil {
+			continue
+		}
+		if err := mem.runCompaction(c); err != nil {
+			return err
+		}
+		ran = true
+	}
+	if ran {
+		return nil
+	}
+	if c := mem.rangeCompaction(files, deepest, deepest, start, end); c != nil {
+		return mem.runCompaction(c)
+	}
+	return nil
+}
+
+// rangeCompaction returns the compaction of the files of level holding keys
+// in [start, end) into output for CompactRange, or nil if there are none.
+func (mem *MemDB) rangeCompaction(files []*sstMeta, level, output int, start, end []byte) *compaction {
+	c := mem.newCompaction(files)
+	inputs := overlappingRange(c.levels[level], start, end)
+	if len(inputs) == 0 {
+		return nil
+	}
+	if level == 0 {
+		// The files of level 0 overlapping the inputs go with them, lest an
+		// older version they hold shadow a newer one compacted away.
+		for {
+			smallest, largest := keyRange(inputs)
+			more := overlapping(c.levels[0], smallest, largest)
+			if len(more) == len(inputs) {
+				break
+			}
+			inputs = more
+		}
+	}
+
+	c.level, c.output, c.inputs = level, output, inputs
+	if output != level {
+		smallest, largest := keyRange(inputs)
+		c.inputs = append(c.inputs, overlapping(c.levels[output], smallest, largest)...)
+	} else if level == 0 {
+		merged := make(map[int]bool, len(inputs))
+		for _, f := range inputs {
+			merged[f.number] = true
+		}
+		for _, f := range c.levels[0] {
+			if !merged[f.number] {
+				c.others = append(c.others, f)
+			}
+		}
+	}
+	return c
+}
+
+// compact runs the compaction that the files of the store need most, if any,
+// and reports whether it ran one.
+func (mem *MemDB) compact() (bool, error) {
+	mem.compactMu.Lock()
+	defer mem.compactMu.Unlock()
+
+	if mem.compactionPaused.Load() {
+		return false, nil
+	}
+	c, err := mem.pickCompaction(mem.manifest.current())
+	if c == nil || err != nil {
+		return false, err
+	}
+	if c.trivial() && mem.opts.CompactionFilter == nil {
+		return true, mem.moveFiles(c)
+	}
+	return true, mem.runCompaction(c)
+}
+
+// trivial reports whether c can move its inputs to the output level as they
+// are: they all come from a level above it, and overlap neither each other
+// nor the files of the output level. That is how sequential inserts leave
+// flushed files.
+func (c *compaction) trivial() bool {
+	if c.level == c.output {
+		return false
+	}
+	inputs := append([]*sstMeta(nil), c.inputs...)
+	sort.Slice(inputs, func(i, j int) bool { return bytes.Compare(inputs[i].smallest, inputs[j].smallest) < 0 })
+	for i, f := range inputs {
+		if f.level != c.level || (i > 0 && bytes.Compare(inputs[i-1].largest, f.smallest) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// moveFiles carries out the trivial compaction c by linking its inputs under
+// names of the output level, or copying them where the filesystem has no hard
+// links, then swapping them in the manifest, so that no key is rewritten. The
+// former names are deleted once no read uses them. mem.compactMu must be
+// held.
+func (mem *MemDB) moveFiles(c *compaction) (err error) {
+	var outputs []*sstMeta
+	defer func() {
+		if err != nil {
+			for _, f := range outputs {
+				os.Remove(filepath.Join(mem.sstDir, f.name()))
+			}
+		}
+	}()
+	edit := manifestEdit{}
+	var size int64
+	for _, f := range c.inputs {
+		moved := *f
+		moved.level, moved.number = c.output, mem.manifest.newFileNumber()
+		if err := linkOrCopy(filepath.Join(mem.sstDir, f.name()), filepath.Join(mem.sstDir, moved.name())); err != nil {
+			return err
+		}
+		outputs = append(outputs, &moved)
+		edit.remove = append(edit.remove, f.number)
+		size += f.size
+	}
+	edit.add = outputs
+	if err := mem.manifest.apply(edit); err != nil {
+		return err
+	}
+	if c.level > 0 {
+		mem.compactPointers[c.level] = c.inputs[0].largest
+	}
+	mem.compactionStats.moved(len(outputs), size)
+	Logger.Printf("Moved %d SST files of %d bytes from level %d to level %d", len(outputs), size, c.level, c.output)
+	return nil
+}
+
+// linkFile is os.Link; tests replace it to fail as filesystems without hard
+// links do.
+var linkFile = os.Link
+
+// linkOrCopy makes dst a hard link to src, or a synced copy of it if the
+// filesystem doesn't support hard links. The copy can't be a rename, as
+// reads of the files the manifest listed before the move keep opening src.
+func linkOrCopy(src, dst string) error {
+	err := linkFile(src, dst)
+	if err == nil || !hardLinkUnsupported(err) {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to the new file dst and syncs it.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// runCompaction merges the inputs of c into new files of the next level, then
+// replaces the inputs with them in the manifest. The inputs are deleted once
+// no read uses them anymore. Large compactions are split into ranges of keys
+// merged in parallel, see Options.MaxSubcompactions, whose files go to the
+// manifest together. mem.compactMu must be held.
+func (mem *MemDB) runCompaction(c *compaction) (err error) {
+	progress := mem.compactionStats.start(c)
+	var outputs []*sstMeta
+	defer func() {
+		if err != nil {
+			for _, f := range outputs {
+				os.Remove(filepath.Join(mem.sstDir, f.name()))
+			}
+		}
+		info := mem.compactionStats.finish(progress, err)
+		if err == nil {
+			Logger.Printf("Compacted %d SST files of %d bytes from level %d into %d files of %d bytes of level %d in %v, dropping %d versions",
+				info.InputFiles, info.InputBytes, info.Level, info.OutputFiles, info.OutputBytes, info.OutputLevel, info.Duration.Round(time.Millisecond), info.KeysDropped)
+		}
+	}()
+
+	bounds := mem.subcompactionBounds(c)
+	results := make([][]*sstMeta, len(bounds)+1)
+	errs := make([]error, len(bounds)+1)
+	if len(bounds) == 0 {
+		results[0], errs[0] = mem.mergeRange(c, progress, nil, nil)
+	} else {
+		var wg sync.WaitGroup
+		for i := range results {
+			var start, end []byte
+			if i > 0 {
+				start = bounds[i-1]
+			}
+			if i < len(bounds) {
+				end = bounds[i]
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = mem.mergeRange(c, progress, start, end)
+			}(i)
+		}
+		wg.Wait()
+	}
+	// The ranges follow each other, and so do their files.
+	for _, files := range results {
+		outputs = append(outputs, files...)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	edit := manifestEdit{add: outputs}
+	for _, f := range c.inputs {
+		edit.remove = append(edit.remove, f.number)
+	}
+	if err := mem.manifest.apply(edit); err != nil {
+		return err
+	}
+	if c.level > 0 {
+		mem.compactPointers[c.level] = c.inputs[0].largest
+	}
+	return nil
+}
+
+// subcompactionBounds returns the keys splitting the inputs of c into ranges
+// of similar sizes, merged in parallel, none if c is merged at once. The keys
+// start data blocks of the inputs, whose sizes measure the ranges, and there
+// are fewer ranges than output files, so that splitting doesn't leave small
+// files behind.
+func (mem *MemDB) subcompactionBounds(c *compaction) [][]byte {
+	if mem.opts.MaxSubcompactions <= 1 {
+		return nil
+	}
+	var blocks []blockHandle
+	var total int64
+	for _, f := range c.inputs {
+		fileBlocks, err := mem.dataBlocks(f)
+		if err != nil || len(fileBlocks) == 0 {
+			// The merge reports the error, if the file can't be read.
+			fileBlocks = []blockHandle{{firstKey: f.smallest, size: f.size}}
+		}
+		for _, b := range fileBlocks {
+			blocks = append(blocks, b)
+			total += b.size
+		}
+	}
+	n := min(int64(mem.opts.MaxSubcompactions), total/c.fileSize)
+	if n <= 1 {
+		return nil
+	}
+	sort.Slice(blocks, func(i, j int) bool { return bytes.Compare(blocks[i].firstKey, blocks[j].firstKey) < 0 })
+
+	var bounds [][]byte
+	var size int64
+	for _, b := range blocks {
+		if size >= total*int64(len(bounds)+1)/n && bytes.Compare(b.firstKey, blocks[0].firstKey) > 0 &&
+			(len(bounds) == 0 || bytes.Compare(b.firstKey, bounds[len(bounds)-1]) > 0) {
+			bounds = append(bounds, b.firstKey)
+			if int64(len(bounds)) == n-1 {
+				break
+			}
+		}
+		size += b.size
+	}
+	return bounds
+}
+
+// dataBlocks returns the data blocks of SST file f.
+func (mem *MemDB) dataBlocks(f *sstMeta) ([]blockHandle, error) {
+	t, err := mem.tables.get(f)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.tables.release(t)
+	return t.reader.allBlocks()
+}
+
+// mergeRange merges the versions of the keys of the inputs of c in
+// [start, end), a nil start or end leaving that side of the range open, into
+// new files of the output level. It returns the files it wrote, even with an
+// error, for the caller to remove them.
+func (mem *MemDB) mergeRange(c *compaction, progress *compactionProgress, start, end []byte) (outputs []*sstMeta, err error) {
+	var sources []iteratorSource
+	defer func() {
+		for _, src := range sources {
+			src.close()
+		}
+	}()
+	for _, f := range c.inputs {
+		cursor, err := newTableCursor(mem.tables, f, start)
+		if err != nil {
+			return outputs, err
+		}
+		sources = append(sources, cursor)
+	}
+
+	// The tuples are written as they are merged, into a file started on the
+	// first one and finished once full or at a KeyBoundary.
+	var out *compactionOutput
+	defer func() {
+		if out != nil {
+			out.abort()
+		}
+	}()
+	finishOutput := func() error {
+		f, err := out.finish()
+		out = nil
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, f)
+		progress.outputFiles.Add(1)
+		progress.outputBytes.Add(f.size)
+		return nil
+	}
+
+	for {
+		winner := smallestTuple(sources)
+		if winner == nil || (end != nil && bytes.Compare(winner.Key, end) >= 0) {
+			break
+		}
+		key, pair := winner.Key, winner.Value
+
+		// Every version of the key is read, shadowed or not.
+		read, versions := 0, 0
+		for _, src := range sources {
+			if cur := src.current(); cur != nil && bytes.Equal(cur.Key, key) {
+				read += len(cur.Key) + len(cur.Value.Value)
+				versions++
+			}
+		}
+		progress.bytesRead.Add(int64(read))
+		if !mem.compactionLimiter.wait(read, mem.stopCompaction) {
+			return outputs, errCompactionStopped
+		}
+
+		drop := (pair.Operation == delOperation || expired(pair.ExpiresAt)) && c.isBaseLevel(key)
+		if !drop && pair.Operation == setOperation && !expired(pair.ExpiresAt) && mem.opts.CompactionFilter != nil {
+			if pair, err = mem.filterEntry(c, key, pair); err != nil {
+				return outputs, err
+			}
+			drop = pair.Operation == delOperation && c.isBaseLevel(key)
+		}
+		if !drop {
+			if out != nil && (!out.w.fits(key) || out.crossesBoundary(key, c.fileSize)) {
+				if err := finishOutput(); err != nil {
+					return outputs, err
+				}
+			}
+			if out == nil {
+				if out, err = mem.newCompactionOutput(c.output, key); err != nil {
+					return outputs, err
+				}
+			}
+			if err := out.add(key, pair); err != nil {
+				return outputs, err
+			}
+			versions--
+		}
+		progress.keysDropped.Add(int64(versions))
+		if err := advancePast(sources, key); err != nil {
+			return outputs, err
+		}
+		if out != nil && out.size >= c.fileSize {
+			if err := finishOutput(); err != nil {
+				return outputs, err
+			}
+		}
+	}
+	if out != nil {
+		if err := finishOutput(); err != nil {
+			return outputs, err
+		}
+	}
+	return outputs, nil
+}
+
+// minOutputKeyRoom is the length of the keys the header of a compaction
+// output has room for at least, see newStreamWriter.
+const minOutputKeyRoom = 256
+
+// compactionOutput is a new SST file a compaction writes its tuples to as it
+// merges them.
+type compactionOutput struct {
+	mem    *MemDB
+	level  int
+	number int
+	file   *os.File
+	w      *SSTWriter
+	size   int64 // Of the keys and values added.
+}
+
+// newCompactionOutput creates a new SST file of level, to start with key.
+// Its header has room for keys twice as long, or minOutputKeyRoom: a longer
+// key starts the next file.
+func (mem *MemDB) newCompactionOutput(level int, key []byte) (*compactionOutput, error) {
+	number := mem.manifest.newFileNumber()
+	file, err := os.Create(filepath.Join(mem.sstDir, sstFileName(level, number)))
+	if err != nil {
+		return nil, err
+	}
+	sst := &SSTFile{File: file, compression: mem.opts.SSTCompression, filterBitsPerKey: mem.opts.FilterBitsPerKey}
+	w, err := sst.newStreamWriter(max(2*len(key), minOutputKeyRoom))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &compactionOutput{mem: mem, level: level, number: number, file: file, w: w}, nil
+}
+
+// add writes the tuple of key and pair to the file, at the rate the
+// compaction is limited to.
+func (o *compactionOutput) add(key []byte, pair SSTPair) error {
+	if !o.mem.compactionLimiter.wait(len(key)+len(pair.Value), o.mem.stopCompaction) {
+		return errCompactionStopped
+	}
+	if err := o.w.Add(key, pair); err != nil {
+		return err
+	}
+	o.size += int64(len(key) + len(pair.Value))
+	return nil
+}
+
+// finish completes and syncs the file, or removes it on failure.
+func (o *compactionOutput) finish() (f *sstMeta, err error) {
+	defer func() {
+		if closeErr := o.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(o.file.Name())
+		}
+	}()
+	select {
+	case <-o.mem.stopCompaction:
+		return nil, errCompactionStopped
+	default:
+	}
+	if err := o.w.Finish(); err != nil {
+		return nil, err
+	}
+	if err := o.file.Sync(); err != nil {
+		return nil, err
+	}
+	info, err := o.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &sstMeta{
+		level:       o.level,
+		number:      o.number,
+		size:        info.Size(),
+		smallest:    o.w.header.SmallestKey,
+		largest:     o.w.header.LongestKey,
+		smallestSeq: o.w.smallestSeq,
+		largestSeq:  o.w.largestSeq,
+		entries:     o.w.count,
+		deletions:   o.w.deletions,
+	}, nil
+}
+
+// crossesBoundary reports whether key starts past the KeyBoundary of the
+// store from the last key of the file, then holding enough of fileSize to be
+// finished there.
+func (o *compactionOutput) crossesBoundary(key []byte, fileSize int64) bool {
+	boundary := o.mem.opts.CompactionBoundary
+	return boundary != nil && o.size >= fileSize/boundaryFileShare && !bytes.Equal(boundary(o.w.last), boundary(key))
+}
+
+// abort removes the file of a compaction that failed.
+func (o *compactionOutput) abort() {
+	o.file.Close()
+	os.Remove(o.file.Name())
+}
+
+// scheduleCompaction wakes up the background compaction, if it runs, to check
+// whether the files of the store need one.
+func (mem *MemDB) scheduleCompaction() {
+	if mem.compactTrigger == nil {
+		return
+	}
+	select {
+	case mem.compactTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// PauseCompaction stops the background compaction until ResumeCompaction is
+// called, so that only flushes change the SST files, such as while taking a
+// snapshot of the filesystem or during a window of latency-sensitive traffic.
+// It returns once the compaction in progress, if any, is done. Compact and
+// CompactRange still run when called.
+func (mem *MemDB) PauseCompaction() error {
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	mem.compactionPaused.Store(true)
+	mem.compactMu.Lock()
+	mem.compactMu.Unlock()
+	return nil
+}
+
+// ResumeCompaction restarts the background compaction stopped by
+// PauseCompaction, which catches up with the files flushed meanwhile.
+func (mem *MemDB) ResumeCompaction() error {
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	mem.compactionPaused.Store(false)
+	mem.scheduleCompaction()
+	return nil
+}
+
+// compactInBackground runs compactions as scheduleCompaction asks, until
+// mem.stopCompaction is closed. A failed compaction is logged and retried on
+// the next trigger.
+func (mem *MemDB) compactInBackground() {
+	defer mem.compactions.Done()
+	for {
+		select {
+		case <-mem.stopCompaction:
+			return
+		case <-mem.compactTrigger:
+		}
+		for {
+			ran, err := mem.compact()
+			if errors.Is(err, errCompactionStopped) {
+				return
+			}
+			if err != nil {
+				Logger.Printf("compaction failed: %v", err)
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+	}
+}
+
+// deleteSSTFile deletes SST file f, once compaction dropped it and no read
+// uses it.
+func deleteSSTFile(tables *tableCache, dir string, f *sstMeta) {
+	tables.evict(f.number)
+	if err := os.Remove(filepath.Join(dir, f.name())); err != nil {
+		Logger.Printf("error deleting SST file %s: %v", f.name(), err)
+	}
+}
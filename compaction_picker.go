@@ -0,0 +1,227 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCompaction is returned by the compactions a CompactionPicker
+// picks that would break the ordering of the levels.
+var ErrInvalidCompaction = errors.New("invalid compaction job")
+
+// CompactionPicker chooses the compactions of the SST files, see
+// Options.CompactionPicker. The leveled and size-tiered strategies are
+// built-in pickers.
+type CompactionPicker interface {
+	// PickCompaction returns the compaction the files described by state
+	// need most, or nil if none does. It is called by one compaction at a
+	// time, and must not retain state.
+	PickCompaction(state CompactionState) *CompactionJob
+}
+
+// CompactionState describes the SST files of the store to a CompactionPicker.
+type CompactionState struct {
+	// Levels describes every level, from level 0, with the scores that
+	// leveled compaction gives them.
+	Levels []LevelInfo
+	// Files lists the files of every level, from level 0: newest first in
+	// level 0, whose files may overlap, and by key in the other levels.
+	Files [][]FileInfo
+
+	files []*sstMeta // As the manifest lists them.
+}
+
+// FileInfo describes an SST file.
+type FileInfo struct {
+	Number                  int // Unique among the files of the store.
+	Level                   int
+	Size                    int64
+	Smallest, Largest       []byte // Range of the keys of the file, bounds included.
+	SmallestSeq, LargestSeq uint64 // Range of the sequence numbers of its tuples, 0 if unknown.
+	Entries, Deletions      int    // Tuples of the file, and deletions among them, 0 if unknown.
+}
+
+// CompactionJob is a compaction chosen by a CompactionPicker: its input files
+// are merged into new files of OutputLevel. OutputLevel must be the level
+// after that of the first input, whose files overlapping the inputs must all
+// be inputs too, or 0 to merge files of level 0 into a sorted run of level 0,
+// files of disjoint key ranges.
+// Inputs of level 0 compacted into level 1 must include the files of level 0
+// that overlap them.
+type CompactionJob struct {
+	Inputs      []int // Numbers of the files to merge.
+	OutputLevel int
+	Reason      string // Why the job was picked, reported by MemDB.CompactionPlan.
+}
+
+// leveledPicker and sizeTieredPicker pick the compactions of the built-in
+// strategies.
+type leveledPicker struct{ mem *MemDB }
+type sizeTieredPicker struct{ mem *MemDB }
+
+func (p leveledPicker) PickCompaction(state CompactionState) *CompactionJob {
+	return jobOf(p.mem.pickLeveled(p.mem.newCompaction(state.files)))
+}
+
+func (p sizeTieredPicker) PickCompaction(state CompactionState) *CompactionJob {
+	return jobOf(p.mem.pickSizeTiered(p.mem.newCompaction(state.files)))
+}
+
+// picker returns the CompactionPicker of the store.
+func (mem *MemDB) picker() CompactionPicker {
+	switch {
+	case mem.opts.CompactionPicker != nil:
+		return mem.opts.CompactionPicker
+	case mem.opts.CompactionStrategy == SizeTieredCompaction:
+		return sizeTieredPicker{mem}
+	}
+	return leveledPicker{mem}
+}
+
+// compactionState returns the description of files given to the pickers.
+func (mem *MemDB) compactionState(files []*sstMeta) CompactionState {
+	c := mem.newCompaction(files)
+	state := CompactionState{Levels: mem.levelScores(c.levels), Files: make([][]FileInfo, numLevels), files: files}
+	for level, files := range c.levels {
+		for _, f := range files {
+			state.Files[level] = append(state.Files[level], fileInfo(f))
+		}
+	}
+	return state
+}
+
+func fileInfo(f *sstMeta) FileInfo {
+	return FileInfo{
+		Number:      f.number,
+		Level:       f.level,
+		Size:        f.size,
+		Smallest:    f.smallest,
+		Largest:     f.largest,
+		SmallestSeq: f.smallestSeq,
+		LargestSeq:  f.largestSeq,
+		Entries:     f.entries,
+		Deletions:   f.deletions,
+	}
+}
+
+// jobOf returns the job of compaction c, nil if c is.
+func jobOf(c *compaction) *CompactionJob {
+	if c == nil {
+		return nil
+	}
+	job := &CompactionJob{OutputLevel: c.output, Reason: c.reason}
+	for _, f := range c.inputs {
+		job.Inputs = append(job.Inputs, f.number)
+	}
+	return job
+}
+
+// compactionOf returns the compaction of files that job describes, or an
+// error wrapping ErrInvalidCompaction if it can't be run.
+func (mem *MemDB) compactionOf(files []*sstMeta, job CompactionJob) (*compaction, error) {
+	c := mem.newCompaction(files)
+	c.reason = job.Reason
+	picked := make(map[int]bool, len(job.Inputs))
+	for _, n := range job.Inputs {
+		picked[n] = true
+	}
+
+	// Keep the inputs in the order of the manifest, which breaks the ties
+	// of sequence numbers.
+	c.level = numLevels
+	for _, level := range c.levels {
+		for _, f := range level {
+			if picked[f.number] {
+				c.inputs = append(c.inputs, f)
+				c.level = min(c.level, f.level)
+			}
+		}
+	}
+	if len(c.inputs) == 0 || len(c.inputs) != len(picked) {
+		return nil, fmt.Errorf("%w: inputs %v aren't files of the store", ErrInvalidCompaction, job.Inputs)
+	}
+	c.output = job.OutputLevel
+
+	if c.output == 0 {
+		if c.level != 0 {
+			return nil, fmt.Errorf("%w: files of level %d can't be compacted into level 0", ErrInvalidCompaction, c.level)
+		}
+		for _, f := range c.levels[0] {
+			if !picked[f.number] {
+				c.others = append(c.others, f)
+			}
+		}
+		return c, nil
+	}
+
+	if c.output != c.level+1 || c.output >= numLevels {
+		return nil, fmt.Errorf("%w: files of level %d can't be compacted into level %d", ErrInvalidCompaction, c.level, c.output)
+	}
+	smallest, largest := keyRange(c.inputs)
+	for _, f := range c.inputs {
+		if f.level != c.level && f.level != c.output {
+			return nil, fmt.Errorf("%w: file %d of level %d is neither of level %d nor %d", ErrInvalidCompaction, f.number, f.level, c.level, c.output)
+		}
+	}
+	// Files left out that overlap the inputs would end up out of order
+	// with the output.
+	left := c.levels[c.output]
+	if c.level == 0 {
+		left = append(append([]*sstMeta(nil), c.levels[0]...), left...)
+	}
+	for _, f := range left {
+		if !picked[f.number] && bytes.Compare(f.smallest, largest) <= 0 && bytes.Compare(f.largest, smallest) >= 0 {
+			return nil, fmt.Errorf("%w: file %d of level %d overlaps the inputs", ErrInvalidCompaction, f.number, f.level)
+		}
+	}
+	return c, nil
+}
+
+// CompactionPlan describes the compaction the store would run next, see
+// MemDB.CompactionPlan.
+type CompactionPlan struct {
+	Level       int // Of the first input.
+	OutputLevel int
+	Inputs      []FileInfo // As the manifest lists them.
+	InputBytes  int64
+	// OutputBytes estimates the size of the files written: that of the
+	// inputs, less the share of deletions among their tuples. Versions
+	// shadowed by newer ones are dropped too, which it doesn't foresee.
+	OutputBytes int64
+	Moved       bool   // Whether the inputs would be moved to the output level without being rewritten.
+	Reason      string // Why the picker chose the compaction.
+}
+
+// CompactionPlan returns the compaction that the background compaction would
+// run next, chosen by the picker of the store, or nil if the files need
+// none. Nothing is compacted, and it answers the same while compaction is
+// paused, so that the picker can be tuned against the files of the store.
+func (mem *MemDB) CompactionPlan() (*CompactionPlan, error) {
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	mem.compactMu.Lock()
+	defer mem.compactMu.Unlock()
+
+	c, err := mem.pickCompaction(mem.manifest.current())
+	if c == nil || err != nil {
+		return nil, err
+	}
+	plan := &CompactionPlan{
+		Level:       c.level,
+		OutputLevel: c.output,
+		Moved:       c.trivial() && mem.opts.CompactionFilter == nil,
+		Reason:      c.reason,
+	}
+	for _, f := range c.inputs {
+		plan.Inputs = append(plan.Inputs, fileInfo(f))
+		plan.InputBytes += f.size
+		if plan.Moved {
+			plan.OutputBytes += f.size
+		} else {
+			plan.OutputBytes += int64(float64(f.size) * (1 - deletionRatio(f.deletions, f.entries)))
+		}
+	}
+	return plan, nil
+}
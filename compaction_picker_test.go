@@ -0,0 +1,141 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pickerFunc is a CompactionPicker calling itself.
+type pickerFunc func(state CompactionState) *CompactionJob
+
+func (f pickerFunc) PickCompaction(state CompactionState) *CompactionJob {
+	return f(state)
+}
+
+func TestCompactionPicker(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 10; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprint(round)))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Merge the two oldest files of level 0, leaving the newest one alone.
+	mem.opts.CompactionPicker = pickerFunc(func(state CompactionState) *CompactionJob {
+		files := state.Files[0]
+		if len(files) < 3 || state.Levels[0].Files != len(files) {
+			return nil
+		}
+		return &CompactionJob{Inputs: []int{files[1].Number, files[2].Number}}
+	})
+	if ran, err := mem.compact(); !ran || err != nil {
+		t.Fatalf("Expected the picked compaction to run, got %v (%v)", ran, err)
+	}
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); len(files) != 2 || counts[0] != 2 {
+		t.Fatalf("Expected the newest file and the merged one in level 0, got %v", counts)
+	}
+	if files[0].number != 3 && files[1].number != 3 {
+		t.Errorf("Expected the newest file to be left alone")
+	}
+	if value, err := mem.Get([]byte("key005")); err != nil || string(value) != "2" {
+		t.Errorf("Expected the newest value of key005, got %q (%v)", value, err)
+	}
+
+	// Moving the older file below the newer one it overlaps is refused.
+	mem.opts.CompactionPicker = pickerFunc(func(state CompactionState) *CompactionJob {
+		return &CompactionJob{Inputs: []int{state.Files[0][1].Number}, OutputLevel: 1}
+	})
+	if _, err := mem.compact(); !errors.Is(err, ErrInvalidCompaction) {
+		t.Errorf("Expected the compaction to be refused, got %v", err)
+	}
+	mem.opts.CompactionPicker = pickerFunc(func(state CompactionState) *CompactionJob {
+		return &CompactionJob{Inputs: []int{42}}
+	})
+	if _, err := mem.compact(); !errors.Is(err, ErrInvalidCompaction) {
+		t.Errorf("Expected a compaction of a missing file to be refused, got %v", err)
+	}
+
+	// The built-in pickers sit behind the same interface.
+	mem.opts.CompactionPicker = nil
+	mem.opts.L0CompactionTrigger = 2
+	compactAll(t, mem)
+	if counts := checkLevels(t, mem.manifest.current()); counts[0] != 0 || counts[1] != 1 {
+		t.Errorf("Expected leveled compaction to merge level 0 into level 1, got %v", counts)
+	}
+	if value, err := mem.Get([]byte("key005")); err != nil || string(value) != "2" {
+		t.Errorf("Expected the newest value of key005 after compacting, got %q (%v)", value, err)
+	}
+}
+
+func TestCompactionPlan(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if plan, err := mem.CompactionPlan(); plan != nil || err != nil {
+		t.Fatalf("Expected no plan without files, got %+v (%v)", plan, err)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%d%03d", round, i)), []byte("value"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := mem.manifest.current()
+
+	// Disjoint files of level 0 are moved to level 1 as they are.
+	mem.opts.L0CompactionTrigger = 2
+	if err := mem.PauseCompaction(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := mem.CompactionPlan()
+	if err != nil || plan == nil {
+		t.Fatalf("Expected a plan, got %v", err)
+	}
+	if plan.Level != 0 || plan.OutputLevel != 1 || len(plan.Inputs) != 2 || !plan.Moved {
+		t.Errorf("Expected the files of level 0 to be moved to level 1, got %+v", plan)
+	}
+	if plan.InputBytes != files[0].size+files[1].size || plan.OutputBytes != plan.InputBytes {
+		t.Errorf("Expected %d bytes in and out, got %d and %d", files[0].size+files[1].size, plan.InputBytes, plan.OutputBytes)
+	}
+	if !strings.Contains(plan.Reason, "level 0 has 2 files") {
+		t.Errorf("Unexpected reason %q", plan.Reason)
+	}
+	if after := mem.manifest.current(); len(after) != 2 || after[0].level != 0 || after[1].level != 0 {
+		t.Errorf("Expected the plan to leave the files alone")
+	}
+
+	// The disjoint files form a single sorted run, which size-tiered
+	// compaction merges with an overlapping one.
+	mem.opts.CompactionStrategy = SizeTieredCompaction
+	if plan, err := mem.CompactionPlan(); plan != nil || err != nil {
+		t.Errorf("Expected size-tiered compaction to leave a single run alone, got %+v (%v)", plan, err)
+	}
+	mem.Set([]byte("key0005"), []byte("value"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := mem.CompactionPlan(); err != nil || plan == nil || plan.OutputLevel != 0 || plan.Moved || !strings.Contains(plan.Reason, "similar sizes") {
+		t.Errorf("Expected size-tiered compaction to merge level 0, got %+v (%v)", plan, err)
+	}
+
+	mem.opts.CompactionPicker = pickerFunc(func(state CompactionState) *CompactionJob {
+		return &CompactionJob{Inputs: []int{state.Files[0][1].Number}, OutputLevel: 1, Reason: "picked"}
+	})
+	if plan, err := mem.CompactionPlan(); err != nil || plan == nil || len(plan.Inputs) != 1 || plan.Reason != "picked" {
+		t.Errorf("Expected the job of the picker, got %+v (%v)", plan, err)
+	}
+}
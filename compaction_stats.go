@@ -0,0 +1,194 @@
+package kvstore
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compactionHistory is the number of finished compactions CompactionStats
+// reports.
+const compactionHistory = 10
+
+// CompactionStats describes the compactions of the SST files.
+type CompactionStats struct {
+	// Counters accumulated since the store was opened or ResetStats was last
+	// called, over the compactions that finished.
+	Compactions int64         // Compactions that completed.
+	Failed      int64         // Compactions that failed, those stopped by Close aside.
+	InputFiles  int64         // Files merged.
+	InputBytes  int64         // Size of the files merged.
+	OutputFiles int64         // Files written.
+	OutputBytes int64         // Size of the files written.
+	KeysDropped int64         // Versions left out: shadowed, deleted, expired or filtered out.
+	Total       time.Duration // Time spent compacting.
+	MovedFiles  int64         // Files moved to the next level without being rewritten.
+	MovedBytes  int64         // Size of the files moved.
+
+	Paused  bool             // Whether the background compaction is paused, see MemDB.PauseCompaction.
+	Running []CompactionInfo // Compactions in progress, by start time.
+	Recent  []CompactionInfo // Last compactions that finished, most recent first.
+	Levels  []LevelInfo      // The levels of SST files, from level 0.
+}
+
+// LevelInfo describes a level of SST files, and how much leveled compaction
+// needs to compact it.
+type LevelInfo struct {
+	Level     int
+	Files     int
+	Bytes     int64
+	Entries   int // Tuples of the files, those of files listed by older manifests aside.
+	Deletions int // Among the tuples counted.
+	// Score is the highest of the ratios of the level to the limits it is
+	// compacted from: the number of files of level 0 to L0CompactionTrigger,
+	// the size of the other levels to their size, and their share of
+	// deletions to one half. The level of the highest score reaching 1 is
+	// compacted first.
+	Score float64
+}
+
+// CompactionInfo describes a compaction, in progress or finished.
+type CompactionInfo struct {
+	Level       int // Of the first file merged.
+	OutputLevel int
+	InputFiles  int
+	InputBytes  int64
+	OutputFiles int   // Written so far.
+	OutputBytes int64 // Written so far.
+	BytesRead   int64 // Of the keys and values read so far.
+	KeysDropped int64 // So far.
+	Started     time.Time
+	Duration    time.Duration // So far for a compaction in progress.
+	Err         string        `json:",omitempty"` // Why a finished compaction failed.
+}
+
+// since returns the compactions that finished between prev and s.
+func (s CompactionStats) since(prev CompactionStats) CompactionStats {
+	s.Compactions -= prev.Compactions
+	s.Failed -= prev.Failed
+	s.InputFiles -= prev.InputFiles
+	s.InputBytes -= prev.InputBytes
+	s.OutputFiles -= prev.OutputFiles
+	s.OutputBytes -= prev.OutputBytes
+	s.KeysDropped -= prev.KeysDropped
+	s.Total -= prev.Total
+	s.MovedFiles -= prev.MovedFiles
+	s.MovedBytes -= prev.MovedBytes
+
+	return s
+}
+
+// compactionProgress tracks a compaction in progress, which updates it
+// without locking.
+type compactionProgress struct {
+	info        CompactionInfo // Set at the start, then only read.
+	outputFiles atomic.Int64
+	outputBytes atomic.Int64
+	bytesRead   atomic.Int64
+	keysDropped atomic.Int64
+}
+
+// snapshot returns the state of the compaction at now.
+func (p *compactionProgress) snapshot(now time.Time) CompactionInfo {
+	info := p.info
+	info.OutputFiles = int(p.outputFiles.Load())
+	info.OutputBytes = p.outputBytes.Load()
+	info.BytesRead = p.bytesRead.Load()
+	info.KeysDropped = p.keysDropped.Load()
+	info.Duration = now.Sub(info.Started)
+	return info
+}
+
+// compactionMetrics accumulates CompactionStats and is safe for concurrent
+// use.
+type compactionMetrics struct {
+	mu      sync.Mutex
+	totals  CompactionStats // Counters only.
+	running []*compactionProgress
+	recent  []CompactionInfo // Most recent first.
+}
+
+// start records the start of compaction c.
+func (m *compactionMetrics) start(c *compaction) *compactionProgress {
+	p := &compactionProgress{info: CompactionInfo{Level: c.level, OutputLevel: c.output, InputFiles: len(c.inputs), Started: time.Now()}}
+	for _, f := range c.inputs {
+		p.info.InputBytes += f.size
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = append(m.running, p)
+	return p
+}
+
+// finish records the end of the compaction of p, which err failed if not
+// nil, and returns its final state.
+func (m *compactionMetrics) finish(p *compactionProgress, err error) CompactionInfo {
+	info := p.snapshot(time.Now())
+	if err != nil {
+		info.Err = err.Error()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, q := range m.running {
+		if q == p {
+			m.running = append(m.running[:i], m.running[i+1:]...)
+			break
+		}
+	}
+	m.recent = append([]CompactionInfo{info}, m.recent[:min(len(m.recent), compactionHistory-1)]...)
+
+	switch {
+	case err == nil:
+		m.totals.Compactions++
+		m.totals.InputFiles += int64(info.InputFiles)
+		m.totals.InputBytes += info.InputBytes
+		m.totals.OutputFiles += int64(info.OutputFiles)
+		m.totals.OutputBytes += info.OutputBytes
+		m.totals.KeysDropped += info.KeysDropped
+	case !errors.Is(err, errCompactionStopped):
+		m.totals.Failed++
+	}
+	m.totals.Total += info.Duration
+	return info
+}
+
+// moved records the move of files of size bytes to the next level.
+func (m *compactionMetrics) moved(files int, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.totals.MovedFiles += int64(files)
+	m.totals.MovedBytes += size
+}
+
+func (m *compactionMetrics) snapshot() CompactionStats {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.totals
+	for _, p := range m.running {
+		stats.Running = append(stats.Running, p.snapshot(now))
+	}
+	stats.Recent = append([]CompactionInfo(nil), m.recent...)
+	return stats
+}
+
+// reset sets the counters back to zero. The compactions listed are kept.
+func (m *compactionMetrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.totals = CompactionStats{}
+}
+
+// CompactionStats reports the compactions of the store, those in progress
+// included, and the levels of SST files. Unlike Stats, it is cheap enough to
+// be polled.
+func (mem *MemDB) CompactionStats() CompactionStats {
+	stats := mem.compactionStats.snapshot()
+	stats.Paused = mem.compactionPaused.Load()
+	stats.Levels = mem.levelScores(mem.newCompaction(mem.manifest.current()).levels)
+	return stats
+}
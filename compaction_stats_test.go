@@ -0,0 +1,70 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompactionStats(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, CompactionRateLimit: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", round)))
+		}
+		if round == 1 {
+			mem.Del([]byte("key000"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev, err := mem.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The compaction is throttled enough to be seen in progress.
+	done := make(chan error)
+	go func() { done <- mem.Compact() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mem.CompactionStats().Running) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the compaction to be listed as running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if running := mem.CompactionStats().Running[0]; running.InputFiles != 2 || running.OutputLevel != 1 {
+		t.Errorf("Unexpected running compaction %+v", running)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := mem.StatsSince(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stats.Compactions
+	// The first versions of the keys are shadowed, and key000 is deleted.
+	if c.Compactions != 1 || c.InputFiles != 2 || c.OutputFiles != 1 || c.KeysDropped != 101 || c.InputBytes == 0 || c.OutputBytes == 0 {
+		t.Errorf("Unexpected compaction stats %+v", c)
+	}
+	if len(c.Running) != 0 || len(c.Recent) != 1 || c.Recent[0].KeysDropped != 101 || c.Recent[0].BytesRead == 0 {
+		t.Errorf("Unexpected compactions %+v", c)
+	}
+	// The level written holds the 99 keys left.
+	if len(c.Levels) != numLevels || c.Levels[1].Files != 1 || c.Levels[1].Entries != 99 || c.Levels[1].Deletions != 0 || c.Levels[1].Score == 0 {
+		t.Errorf("Unexpected levels %+v", c.Levels)
+	}
+
+	mem.ResetStats()
+	if c := mem.CompactionStats(); c.Compactions != 0 || len(c.Recent) != 1 {
+		t.Errorf("Expected the counters to be reset and the compaction still listed, got %+v", c)
+	}
+}
@@ -0,0 +1,885 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// compactAll runs compactions until the files of mem need none.
+func compactAll(t *testing.T, mem *MemDB) {
+	t.Helper()
+	for {
+		ran, err := mem.compact()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ran {
+			return
+		}
+	}
+}
+
+// checkLevels checks that from level 1 on, the files of every level hold
+// disjoint ranges of keys, and returns the number of files of every level.
+func checkLevels(t *testing.T, files []*sstMeta) []int {
+	t.Helper()
+	counts := make([]int, numLevels)
+	var prev *sstMeta
+	for _, f := range files {
+		counts[f.level]++
+		if f.level > 0 && prev != nil && prev.level == f.level && bytes.Compare(prev.largest, f.smallest) >= 0 {
+			t.Errorf("Files %s and %s of level %d overlap", prev.name(), f.name(), f.level)
+		}
+		prev = f
+	}
+	return counts
+}
+
+func TestCompaction(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, TargetFileSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for round := 0; round < 4; round++ {
+		for i := round; i < 500; i += 2 {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", round)))
+		}
+		if round == 3 {
+			for i := 0; i < 500; i += 10 {
+				mem.Del([]byte(fmt.Sprintf("key%03d", i)))
+			}
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := mem.manifest.current()
+
+	// An iterator opened before the compaction keeps reading the files it
+	// replaces.
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.opts.L0CompactionTrigger = 4
+	compactAll(t, mem)
+
+	files := mem.manifest.current()
+	counts := checkLevels(t, files)
+	if counts[0] != 0 || counts[1] < 2 {
+		t.Fatalf("Expected the files of level 0 to be merged into several files of level 1, got %v", counts)
+	}
+	for _, f := range before {
+		if _, err := os.Stat(filepath.Join(mem.sstDir, f.name())); err != nil {
+			t.Errorf("Expected %s to be kept while an iterator reads it: %v", f.name(), err)
+		}
+	}
+	if n := len(collect(t, it)); n != 450 {
+		t.Errorf("Expected the iterator to find 450 keys, got %d", n)
+	}
+	for _, f := range before {
+		if _, err := os.Stat(filepath.Join(mem.sstDir, f.name())); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be deleted once unused, got %v", f.name(), err)
+		}
+	}
+
+	// Only the newest versions are kept, and the deletions are dropped with
+	// the versions they shadow.
+	tuples := 0
+	for _, f := range files {
+		it, err := newSSTIterator(filepath.Join(mem.sstDir, f.name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+			tuples++
+			if it.Op() == delOperation {
+				t.Errorf("Expected the deletion of %s to be dropped", it.Key())
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		it.Close()
+	}
+	if tuples != 450 {
+		t.Errorf("Expected 450 tuples, got %d", tuples)
+	}
+	for _, i := range []int{0, 1, 2, 3, 250, 499} {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, err := mem.Get(key)
+		// Keys are last set by round 2 or 3, but for key001, only set by round 1.
+		round := 2 + i%2
+		if i == 1 {
+			round = 1
+		}
+		switch {
+		case i%10 == 0:
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s to be deleted, got %q (%v)", key, value, err)
+			}
+		case err != nil || string(value) != fmt.Sprintf("value%d", round):
+			t.Errorf("Unexpected value of %s: %q (%v)", key, value, err)
+		}
+	}
+}
+
+func TestCompactionLevels(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, TargetFileSize: 1 << 10, LevelSizeBase: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	mem.opts.L0CompactionTrigger = 1
+
+	// Level 1 outgrows its size and is compacted into level 2 a file at a
+	// time. The files flushed overlap, so they are merged rather than moved.
+	for round := 0; round < 2; round++ {
+		for i := round; i < 400; i += 2 {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), bytes.Repeat([]byte("v"), 40))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compactAll(t, mem)
+	counts := checkLevels(t, mem.manifest.current())
+	if counts[0] != 0 || counts[2] < 2 {
+		t.Fatalf("Expected files to reach level 2, got %v", counts)
+	}
+
+	// A deletion compacted into level 1 is kept while level 2 holds the key
+	// it shadows. The files of level 1 are compacted from the first one on.
+	mem.opts.LevelSizeBase = 1 << 30
+	mem.Del([]byte("key000"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	compactAll(t, mem)
+	if _, err := mem.Get([]byte("key000")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected key000 to be deleted, got %v", err)
+	}
+	if value, err := mem.Get([]byte("key001")); err != nil || len(value) != 40 {
+		t.Errorf("Unexpected value of key001: %q (%v)", value, err)
+	}
+}
+
+func TestCompactionScores(t *testing.T) {
+	mem := &MemDB{opts: Options{L0CompactionTrigger: 4, LevelSizeBase: 1000}.withDefaults()}
+	var c compaction
+	c.levels[0] = []*sstMeta{{number: 9, size: 100}, {number: 8, size: 100}}
+	c.levels[1] = []*sstMeta{
+		{level: 1, number: 1, size: 300, entries: 10, deletions: 1, smallest: []byte("a"), largest: []byte("b")},
+		{level: 1, number: 2, size: 300, entries: 10, deletions: 9, smallest: []byte("c"), largest: []byte("d")},
+	}
+	c.levels[2] = []*sstMeta{{level: 2, number: 3, size: 12000, entries: 100, smallest: []byte("a"), largest: []byte("z")}}
+	c.levels[6] = []*sstMeta{{level: 6, number: 4, size: 1 << 40}}
+
+	scores := mem.levelScores(c.levels)
+	for level, want := range map[int]float64{0: 0.5, 1: 1, 2: 1.2, 3: 0, 6: 0} {
+		if scores[level].Score != want {
+			t.Errorf("Expected level %d to score %v, got %+v", level, want, scores[level])
+		}
+	}
+	if info := scores[1]; info.Files != 2 || info.Bytes != 600 || info.Entries != 20 || info.Deletions != 10 {
+		t.Errorf("Unexpected level 1 %+v", info)
+	}
+
+	// Level 2 scores highest.
+	picked := c
+	if p := mem.pickLeveled(&picked); p == nil || p.level != 2 || len(p.inputs) != 1 {
+		t.Fatalf("Expected level 2 to be compacted, got %+v", p)
+	}
+
+	// Then level 1, its deletions making its score. The file holding most
+	// of them goes first.
+	c.levels[2][0].size = 5000
+	picked = c
+	if p := mem.pickLeveled(&picked); p == nil || p.level != 1 || p.inputs[0].number != 2 {
+		t.Fatalf("Expected file 2 of level 1 to be compacted, got %+v", p)
+	}
+
+	c.levels[1][1].deletions = 4
+	picked = c
+	if p := mem.pickLeveled(&picked); p != nil {
+		t.Errorf("Expected no compaction, got that of level %d", p.level)
+	}
+}
+
+func TestSubcompactions(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, TargetFileSize: 1 << 10, MaxSubcompactions: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Ranges split where data blocks start, in sizes as even as they allow.
+	// Files that can't be read count as a single block.
+	c := &compaction{fileSize: 1000, inputs: []*sstMeta{
+		{number: 1, size: 4000, smallest: []byte("a"), largest: []byte("z")},
+		{number: 2, size: 1000, smallest: []byte("c"), largest: []byte("d")},
+		{number: 3, size: 1000, smallest: []byte("m"), largest: []byte("n")},
+		{number: 4, size: 2000, smallest: []byte("p"), largest: []byte("q")},
+	}}
+	if bounds := mem.subcompactionBounds(c); fmt.Sprintf("%s", bounds) != "[c m p]" {
+		t.Errorf("Expected the ranges to split at c, m and p, got %s", bounds)
+	}
+	c.fileSize = 4000
+	if bounds := mem.subcompactionBounds(c); fmt.Sprintf("%s", bounds) != "[c]" {
+		t.Errorf("Expected 2 ranges of at least the file size, split at c, got %s", bounds)
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := round; i < 600; i += 2 {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d-%s", round, bytes.Repeat([]byte("v"), 20))))
+		}
+		if round == 2 {
+			for i := 0; i < 600; i += 10 {
+				mem.Del([]byte(fmt.Sprintf("key%03d", i)))
+			}
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := mem.manifest.current()
+	if bounds := mem.subcompactionBounds(&compaction{fileSize: mem.opts.TargetFileSize, inputs: files}); len(bounds) != 3 {
+		t.Fatalf("Expected the compaction to be split in 4, got bounds %q", bounds)
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files of the ranges stitch together in level 1.
+	if counts := checkLevels(t, mem.manifest.current()); counts[0] != 0 || counts[1] < 3 {
+		t.Fatalf("Expected the files to be merged into level 1, got %v", counts)
+	}
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(collect(t, it)); n != 540 {
+		t.Errorf("Expected 540 keys, got %d", n)
+	}
+	for _, i := range []int{0, 1, 2, 299, 300, 301, 598, 599} {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, err := mem.Get(key)
+		round := 2
+		if i%2 == 1 {
+			round = 1
+		}
+		switch {
+		case i%10 == 0:
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s to be deleted, got %q (%v)", key, value, err)
+			}
+		case err != nil || !bytes.HasPrefix(value, []byte(fmt.Sprintf("value%d-", round))):
+			t.Errorf("Unexpected value of %s: %q (%v)", key, value, err)
+		}
+	}
+}
+
+func TestCompactionLongKeys(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// The header of an output has room for keys twice as long as its first
+	// one, or minOutputKeyRoom: a longer key starts the next file.
+	keys := [][]byte{[]byte("a"), []byte("b"), bytes.Repeat([]byte("c"), 2*minOutputKeyRoom), []byte("d"),
+		bytes.Repeat([]byte("e"), 8*minOutputKeyRoom)}
+	for round := 0; round < 2; round++ {
+		for _, key := range keys {
+			mem.Set(key, []byte(fmt.Sprint(round)))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); counts[0] != 0 || counts[1] != 3 {
+		t.Fatalf("Expected the output to be cut before the long keys, got %v", counts)
+	}
+	for _, f := range files {
+		if err := verifySSTContents(mem.sstDir, f); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, key := range keys {
+		if value, err := mem.Get(key); err != nil || string(value) != "1" {
+			t.Errorf("Expected the newest value of %.8s, got %q (%v)", key, value, err)
+		}
+	}
+}
+
+func TestCompactionBoundary(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, TargetFileSize: 4 << 10,
+		CompactionBoundary: NamespaceBoundary(':')})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Namespaces of about 1.5, 0.5 and 6 quarters of the target size, and
+	// keys of none, which sort last.
+	value := bytes.Repeat([]byte("v"), 90)
+	sizes := map[string]int{"a:": 15, "b:": 5, "c:": 60, "": 10}
+	for round := 0; round < 2; round++ {
+		for ns, n := range sizes {
+			for i := 0; i < n; i++ {
+				mem.Set([]byte(fmt.Sprintf("%skey%03d", ns, i)), value)
+			}
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files end at the edges of namespaces, unless too small: c: follows b:,
+	// whose file would be under a quarter of the target size, and is split
+	// at the target size.
+	var spans []string
+	for _, f := range mem.manifest.current() {
+		boundary := NamespaceBoundary(':')
+		spans = append(spans, fmt.Sprintf("%s-%s", boundary(f.smallest), boundary(f.largest)))
+	}
+	if got := fmt.Sprint(spans); got != "[a:-a: b:-c: c:-c: -]" {
+		t.Errorf("Unexpected namespaces of the files: %s", got)
+	}
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(collect(t, it)); n != 90 {
+		t.Errorf("Expected 90 keys, got %d", n)
+	}
+	if boundary := NamespaceBoundary(':'); boundary([]byte("users")) != nil || string(boundary([]byte("users:42:a"))) != "users:" {
+		t.Errorf("Unexpected namespaces of users and users:42:a")
+	}
+}
+
+func TestTrivialMove(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Sequential inserts flush files of disjoint ranges, which are moved to
+	// level 1 as they are.
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%04d", 100*round+i)), []byte("value"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := mem.manifest.current()
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.opts.L0CompactionTrigger = 4
+	compactAll(t, mem)
+
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); counts[0] != 0 || counts[1] != 4 {
+		t.Fatalf("Expected the 4 files to move to level 1, got %v", counts)
+	}
+	stats := mem.CompactionStats()
+	if stats.Compactions != 0 || stats.MovedFiles != 4 {
+		t.Errorf("Expected 4 files moved without compacting, got %d compactions and %d files moved", stats.Compactions, stats.MovedFiles)
+	}
+	// Level 0 lists the newest file first, level 1 the smallest keys.
+	for i, f := range files {
+		from := before[len(before)-1-i]
+		old, err := os.Stat(filepath.Join(mem.sstDir, from.name()))
+		if err != nil {
+			t.Fatalf("Expected %s to be kept while an iterator reads it: %v", from.name(), err)
+		}
+		moved, err := os.Stat(filepath.Join(mem.sstDir, f.name()))
+		if err != nil || !os.SameFile(old, moved) {
+			t.Errorf("Expected %s to be the file it was moved from (%v)", f.name(), err)
+		}
+	}
+	if n := len(collect(t, it)); n != 400 {
+		t.Errorf("Expected the iterator to find 400 keys, got %d", n)
+	}
+	for _, f := range before {
+		if _, err := os.Stat(filepath.Join(mem.sstDir, f.name())); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be deleted once unused, got %v", f.name(), err)
+		}
+	}
+	if value, err := mem.Get([]byte("key0342")); err != nil || string(value) != "value" {
+		t.Errorf("Unexpected value of key0342: %q (%v)", value, err)
+	}
+}
+
+func TestTrivialMoveWithoutLinks(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	prev := linkFile
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	t.Cleanup(func() { linkFile = prev })
+
+	// Where the filesystem has no hard links, the files are copied.
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%04d", 100*round+i)), []byte("value"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem.opts.L0CompactionTrigger = 2
+	compactAll(t, mem)
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); counts[0] != 0 || counts[1] != 2 || mem.CompactionStats().MovedFiles != 2 {
+		t.Fatalf("Expected the 2 files to move to level 1, got %v", counts)
+	}
+	for _, f := range files {
+		if err := verifySSTContents(mem.sstDir, f); err != nil {
+			t.Error(err)
+		}
+	}
+	if value, err := mem.Get([]byte("key0142")); err != nil || string(value) != "value" {
+		t.Errorf("Unexpected value of key0142: %q (%v)", value, err)
+	}
+
+	// Other errors fail the move.
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if err := linkOrCopy(filepath.Join(mem.sstDir, files[0].name()), filepath.Join(mem.sstDir, "copy.sst")); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected the error of the link, got %v", err)
+	}
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { mem.Close() }()
+
+	for round := 0; round < DefaultL0CompactionTrigger; round++ {
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", round)))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for checkLevels(t, mem.manifest.current())[0] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the files of level 0 to be compacted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The compacted files are what the store reopens with.
+	mem.Close()
+	if mem, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := mem.Get([]byte("key042")); err != nil || string(value) != "value3" {
+		t.Errorf("Unexpected value of key042: %q (%v)", value, err)
+	}
+}
+
+func TestPauseCompaction(t *testing.T) {
+	mem, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	if err := mem.PauseCompaction(); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < DefaultL0CompactionTrigger; round++ {
+		mem.Set([]byte("key"), []byte(fmt.Sprintf("value%d", round)))
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Give the background compaction the time to run, if it wrongly did.
+	time.Sleep(20 * time.Millisecond)
+	if counts := checkLevels(t, mem.manifest.current()); counts[0] != DefaultL0CompactionTrigger {
+		t.Fatalf("Expected the files to stay in level 0 while paused, got %v", counts)
+	}
+	stats, err := mem.Stats()
+	if err != nil || !stats.Compactions.Paused {
+		t.Errorf("Expected the stats to report the pause (%v)", err)
+	}
+
+	if err := mem.ResumeCompaction(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for checkLevels(t, mem.manifest.current())[0] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the files of level 0 to be compacted once resumed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if mem.CompactionStats().Paused {
+		t.Error("Expected the stats to report the compaction resumed")
+	}
+}
+
+func TestSizeTieredCompaction(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), CompactionStrategy: SizeTieredCompaction, L0CompactionTrigger: -1, TargetFileSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	mem.opts.L0CompactionTrigger = 4
+
+	flush := func(round int) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%03d-%d", i, round)), []byte("value"))
+		}
+		if round == 6 {
+			mem.Del([]byte("key000-0"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files of similar sizes are merged into a single sorted run of level 0,
+	// of files of TargetFileSize.
+	for round := 0; round < 4; round++ {
+		flush(round)
+	}
+	compactAll(t, mem)
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); counts[0] != len(files) || len(files) <= 4 || len(sortedRuns(files)) != 1 {
+		t.Fatalf("Expected a single run of more files of level 0, got %d files in %d runs", len(files), len(sortedRuns(files)))
+	}
+	if err := mem.Compact(); err != nil || len(mem.manifest.current()) != len(files) {
+		t.Errorf("Expected Compact to leave the run alone, got %d files (%v)", len(mem.manifest.current()), err)
+	}
+
+	// Smaller files form a tier of their own, merged without the larger file.
+	for round := 4; round < 7; round++ {
+		flush(round)
+	}
+	if ran, err := mem.compact(); ran || err != nil {
+		t.Fatalf("Expected no compaction of a tier of 3 files, got %v (%v)", ran, err)
+	}
+	flush(7)
+	compactAll(t, mem)
+	if runs := sortedRuns(mem.manifest.current()); len(runs) != 2 {
+		t.Fatalf("Expected the smaller files to be merged apart, got %d runs", len(runs))
+	}
+
+	// The deletion is kept as the larger run holds the key it shadows.
+	if _, err := mem.Get([]byte("key000-0")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected key000-0 to be deleted, got %v", err)
+	}
+	if value, err := mem.Get([]byte("key042-5")); err != nil || string(value) != "value" {
+		t.Errorf("Unexpected value of key042-5: %q (%v)", value, err)
+	}
+
+	for _, strategy := range []CompactionStrategy{LeveledCompaction, SizeTieredCompaction} {
+		if parsed, err := ParseCompactionStrategy(strategy.String()); err != nil || parsed != strategy {
+			t.Errorf("ParseCompactionStrategy(%q) = %v, %v", strategy, parsed, err)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	sstDir := filepath.Join(dir, "sstStorage")
+	if err := os.MkdirAll(sstDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// A version 1 file, which spells the operations out, then one of version
+	// 8, named as they were before the manifest.
+	file, err := os.Create(filepath.Join(sstDir, "sst001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&SSTFile{File: file}).writeHeader(SSTFileHeader{Magic: []byte(magicString), EntryCount: 3, SmallestKey: []byte("a"), LongestKey: []byte("c"), Version: 1})
+	writeBinary(file, []byte(setOperation), uint32(1), []byte("a"), uint32(3), []byte("foo"))
+	writeBinary(file, []byte(delOperation), uint32(1), []byte("b"))
+	ttl := encodeTTLValue(now()+int64(time.Hour), []byte("bar"))
+	writeBinary(file, []byte(ttlOperation), uint32(1), []byte("c"), uint32(len(ttl)), ttl)
+	file.Close()
+
+	file, err = os.Create(filepath.Join(sstDir, "sst002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuples := []SSTTuple{set("a", "new"), set("d", "baz")}
+	header := SSTFileHeader{Magic: []byte(magicString), EntryCount: 2, SmallestKey: []byte("a"), LongestKey: []byte("d"), Version: sstPrefixVersion - 1}
+	if err := (&SSTFile{File: file}).writeTable(header, tuples); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Cache the old files, which compaction replaces.
+	if _, err := mem.Get([]byte("c")); err != nil {
+		t.Fatalf("Error reading the old files: %v", err)
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatalf("Error compacting: %v", err)
+	}
+
+	// The files are merged, dropping the deletion of b.
+	files := mem.manifest.current()
+	if len(files) != 1 || files[0].level != 1 {
+		t.Fatalf("Expected the files to be merged into one file of level 1, got %d files", len(files))
+	}
+	file, err = os.Open(filepath.Join(mem.sstDir, files[0].name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err = (&SSTFile{File: file}).readHeader()
+	file.Close()
+	if err != nil || header.Version != sstVersion || header.EntryCount != 3 {
+		t.Errorf("Expected 3 tuples of version %d, got %d of version %d (%v)", sstVersion, header.EntryCount, header.Version, err)
+	}
+	for key, want := range map[string]string{"a": "new", "c": "bar", "d": "baz"} {
+		if value, err := mem.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("Get(%q) = %q, %v, expected %q", key, value, err, want)
+		}
+	}
+	if _, err := mem.Get([]byte("b")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected b to stay deleted, got %v", err)
+	}
+
+	// A minimal set of files is left alone by later compactions.
+	info, err := os.Stat(filepath.Join(mem.sstDir, files[0].name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatalf("Error compacting again: %v", err)
+	}
+	if again, err := os.Stat(filepath.Join(mem.sstDir, files[0].name())); err != nil || !os.SameFile(info, again) {
+		t.Errorf("Expected the file to be kept as it was (%v)", err)
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, TargetFileSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for i := 0; i < 200; i++ {
+		mem.Set([]byte(fmt.Sprintf("a%03d", i)), bytes.Repeat([]byte("v"), 20))
+		mem.Set([]byte(fmt.Sprintf("b%03d", i)), bytes.Repeat([]byte("v"), 20))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	before := mem.manifest.current()
+
+	// The b keys are deleted in bulk, and a few a keys overwritten.
+	for i := 0; i < 200; i++ {
+		mem.Del([]byte(fmt.Sprintf("b%03d", i)))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		mem.Set([]byte(fmt.Sprintf("a%03d", i)), []byte("new"))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mem.CompactRange([]byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	files := mem.manifest.current()
+	if counts := checkLevels(t, files); counts[0] != 1 {
+		t.Errorf("Expected the file of level 0 outside the range to be left, got %v", counts)
+	}
+	for _, f := range files {
+		if f.level == 1 && bytes.Compare(f.largest, []byte("b")) >= 0 {
+			t.Errorf("Expected the b keys to be dropped, got %s holding %s to %s", f.name(), f.smallest, f.largest)
+		}
+	}
+	// The files of level 1 holding a keys only are left as they were.
+	for _, f := range overlapping(before, []byte("a"), []byte("a999")) {
+		if bytes.Compare(f.largest, []byte("b")) < 0 {
+			if _, err := os.Stat(filepath.Join(mem.sstDir, f.name())); err != nil {
+				t.Errorf("Expected %s, outside the range, to be kept: %v", f.name(), err)
+			}
+		}
+	}
+	if _, err := mem.Get([]byte("b042")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected b042 to be deleted, got %v", err)
+	}
+	if value, err := mem.Get([]byte("a001")); err != nil || string(value) != "new" {
+		t.Errorf("Unexpected value of a001: %q (%v)", value, err)
+	}
+}
+
+func TestCompactionRateLimit(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, CompactionRateLimit: 128 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%03d", i)), bytes.Repeat([]byte("v"), 100))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// About 20 KB are read, then 10 KB written.
+	start := time.Now()
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 150*time.Millisecond {
+		t.Errorf("Expected the compaction to be throttled, took %v", took)
+	}
+}
+
+func TestCompactionFilter(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1, LevelSizeBase: 1 << 10, ValueLogThreshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for i := 0; i < 50; i++ {
+		mem.Set([]byte(fmt.Sprintf("gdpr/%03d", i)), bytes.Repeat([]byte("v"), 50))
+		mem.Set([]byte(fmt.Sprintf("user/%03d", i)), bytes.Repeat([]byte("v"), 50))
+	}
+	mem.Set([]byte("upper/big"), bytes.Repeat([]byte("x"), 200))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if counts := checkLevels(t, mem.manifest.current()); counts[2] == 0 {
+		t.Fatalf("Expected the files to be compacted into level 2, got %v", counts)
+	}
+
+	mem.opts.CompactionFilter = func(level int, key, value []byte) (CompactionDecision, []byte) {
+		switch {
+		case bytes.HasPrefix(key, []byte("gdpr/")):
+			return CompactionDrop, nil
+		case bytes.HasPrefix(key, []byte("upper/")):
+			return CompactionChange, bytes.ToUpper(value)
+		}
+		return CompactionKeep, nil
+	}
+	mem.Set([]byte("gdpr/000"), []byte("again"))
+	mem.Set([]byte("gdpr/new"), []byte("new"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	mem.opts.L0CompactionTrigger = 1
+	if _, err := mem.compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropped keys that level 2 may still hold become deletions. Keys not
+	// compacted yet are kept.
+	if ops := sstOps(t, mem, 1); fmt.Sprint(ops) != fmt.Sprint([]string{"DEL gdpr/000", "DEL gdpr/new"}) {
+		t.Errorf("Expected level 1 to hold the deletions of the dropped keys, got %q", ops)
+	}
+	for _, key := range []string{"gdpr/000", "gdpr/new"} {
+		if value, err := mem.Get([]byte(key)); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected %s to be dropped, got %q (%v)", key, value, err)
+		}
+	}
+	if _, err := mem.Get([]byte("gdpr/001")); err != nil {
+		t.Errorf("Expected gdpr/001 to be kept until compacted: %v", err)
+	}
+
+	// Compact passes every key through the filter, values of the value log
+	// included.
+	if err := mem.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sstOps(t, mem, -1)); n != 51 {
+		t.Errorf("Expected 51 tuples once the deletions are dropped, got %d", n)
+	}
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := collect(t, it); len(keys) != 51 {
+		t.Errorf("Expected the 50 user keys and upper/big to be left, got %d keys", len(keys))
+	}
+	if value, err := mem.Get([]byte("upper/big")); err != nil || !bytes.Equal(value, bytes.Repeat([]byte("X"), 200)) {
+		t.Errorf("Expected upper/big to be changed, got %q (%v)", value, err)
+	}
+	if value, err := mem.Get([]byte("user/007")); err != nil || !bytes.Equal(value, bytes.Repeat([]byte("v"), 50)) {
+		t.Errorf("Expected user/007 to be kept, got %q (%v)", value, err)
+	}
+}
+
+// sstOps returns the operations and keys of the SST files of level of mem,
+// or of every level if level is negative.
+func sstOps(t *testing.T, mem *MemDB, level int) []string {
+	t.Helper()
+	var ops []string
+	for _, f := range mem.manifest.current() {
+		if level >= 0 && f.level != level {
+			continue
+		}
+		it, err := newSSTIterator(filepath.Join(mem.sstDir, f.name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+			ops = append(ops, fmt.Sprintf("%s %s", it.Op(), it.Key()))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		it.Close()
+	}
+	return ops
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package kvstore
+
+import "errors"
+
+// diskSpace isn't implemented on this platform, so Health doesn't check the
+// free space there.
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space unavailable on this platform")
+}
@@ -0,0 +1,130 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// StorageEngine is the storage behind the Server and the Repl. MemDB, the
+// LSM engine of this package, is the default one.
+//
+// The Server also uses the Copier, Incrementer, GetOrSetter, RequestTracer,
+// Monitor, CompactionMonitor, CompactionPlanner, RangeCompacter and
+// CompactionPauser interfaces when the engine implements them, and answers 501 Not Implemented to the requests
+// that need them otherwise.
+type StorageEngine interface {
+	DB
+
+	// Flush makes the writes durable in the engine's long-term storage.
+	Flush() error
+	// Compact reclaims the space of overwritten and deleted keys.
+	Compact() error
+	// Close releases the resources of the engine, after which its other
+	// methods fail.
+	Close() error
+}
+
+// Copier is implemented by engines able to copy a value between two keys,
+// keeping its expiration unless opts overrides it.
+type Copier interface {
+	Copy(src, dst []byte, opts CopyOptions) error
+}
+
+// Incrementer is implemented by engines holding integer counters.
+type Incrementer interface {
+	Incr(key []byte, delta int64) (int64, error)
+}
+
+// GetOrSetter is implemented by engines able to store a value only if the key
+// is missing.
+type GetOrSetter interface {
+	GetOrSet(key, value []byte) (actual []byte, loaded bool, err error)
+}
+
+// RequestTracer is implemented by engines tracing the writes of a request:
+// the writes of the DB Traced returns record requestID along with where they
+// went, such as the sequence numbers of their WAL entries. The DB implements
+// Copier, Incrementer and GetOrSetter if the engine does.
+type RequestTracer interface {
+	Traced(requestID string) DB
+}
+
+// Monitor is implemented by engines reporting statistics and health.
+type Monitor interface {
+	Stats() (Stats, error)
+	ResetStats()
+	Health() Health
+}
+
+// CompactionMonitor is implemented by engines reporting their compactions.
+type CompactionMonitor interface {
+	CompactionStats() CompactionStats
+}
+
+// CompactionPlanner is implemented by engines reporting the compaction they
+// would run next.
+type CompactionPlanner interface {
+	CompactionPlan() (*CompactionPlan, error)
+}
+
+// RangeCompacter is implemented by engines able to compact a range of keys
+// only.
+type RangeCompacter interface {
+	CompactRange(start, end []byte) error
+}
+
+// CompactionPauser is implemented by engines whose background compaction
+// can be paused.
+type CompactionPauser interface {
+	PauseCompaction() error
+	ResumeCompaction() error
+}
+
+// EngineOpener opens a storage engine configured by opts.
+type EngineOpener func(opts Options) (StorageEngine, error)
+
+var (
+	enginesMu sync.Mutex
+	engines   = map[string]EngineOpener{
+		"lsm": func(opts Options) (StorageEngine, error) { return OpenWithOptions(opts) },
+	}
+)
+
+// RegisterEngine makes an engine available to OpenEngine under name. It is
+// meant to be called from the init function of the file providing the engine,
+// which build tags can then include in or leave out of the binary.
+func RegisterEngine(name string, open EngineOpener) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if _, ok := engines[name]; ok {
+		panic("kvstore: engine " + name + " registered twice")
+	}
+	engines[name] = open
+}
+
+// Engines returns the names of the registered engines, sorted.
+func Engines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenEngine opens the engine registered under name, "lsm" for MemDB.
+func OpenEngine(name string, opts Options) (StorageEngine, error) {
+	enginesMu.Lock()
+	open, ok := engines[name]
+	enginesMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage engine %q", name)
+	}
+	return open(opts)
+}
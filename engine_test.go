@@ -0,0 +1,217 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var _ StorageEngine = (*MemDB)(nil)
+
+// basicEngine exposes only the StorageEngine methods of a MemDB, like an
+// engine without the optional interfaces.
+type basicEngine struct {
+	DB
+	mem *MemDB
+}
+
+func (e basicEngine) Flush() error   { return e.mem.Flush() }
+func (e basicEngine) Compact() error { return e.mem.Compact() }
+func (e basicEngine) Close() error   { return e.mem.Close() }
+
+func init() {
+	RegisterEngine("basic", func(opts Options) (StorageEngine, error) {
+		mem, err := OpenWithOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return basicEngine{DB: mem, mem: mem}, nil
+	})
+}
+
+func TestOpenEngine(t *testing.T) {
+	engine, err := OpenEngine("basic", Options{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Error opening engine: %v", err)
+	}
+	server := NewServerWithEngine(engine)
+	server.SetupRoutes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+	defer engine.Close()
+
+	resp, err := http.Post(ts.URL+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// The engine can't increment counters.
+	resp, err = http.Post(ts.URL+"/incr", "application/json", bytes.NewBufferString(`{"key": "foo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, resp.StatusCode)
+	}
+
+	if _, err := OpenEngine("missing", Options{}); err == nil {
+		t.Errorf("Expected an error opening an unknown engine")
+	}
+}
+
+// mapEngine is a StorageEngine of its own, keeping the keys in a map, to
+// check that engines other than MemDB can back the Server.
+type mapEngine struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (e *mapEngine) Set(key, value []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (e *mapEngine) Get(key []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.keys[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (e *mapEngine) TryGet(key []byte) ([]byte, bool, error) {
+	value, err := e.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (e *mapEngine) GetOrDefault(key, def []byte) ([]byte, error) {
+	value, found, err := e.TryGet(key)
+	if !found {
+		return def, err
+	}
+	return value, nil
+}
+
+func (e *mapEngine) Del(key []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.keys[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	delete(e.keys, string(key))
+	return value, nil
+}
+
+func (e *mapEngine) Has(key []byte) (bool, error) {
+	_, found, err := e.TryGet(key)
+	return found, err
+}
+
+func (e *mapEngine) CompareAndSwap(key, expected, newValue []byte) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	current, found := e.keys[string(key)]
+	if (expected == nil && found) || (expected != nil && (!found || !bytes.Equal(current, expected))) {
+		return false, nil
+	}
+	e.keys[string(key)] = append([]byte(nil), newValue...)
+	return true, nil
+}
+
+func (e *mapEngine) Scan(start, end []byte) (KeyIterator, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	it := &sliceIterator{pos: -1}
+	for key, value := range e.keys {
+		if (start == nil || key >= string(start)) && (end == nil || key < string(end)) {
+			it.keys = append(it.keys, key)
+			it.values = append(it.values, value)
+		}
+	}
+	sort.Sort(it)
+	return it, nil
+}
+
+func (e *mapEngine) Flush() error   { return nil }
+func (e *mapEngine) Compact() error { return nil }
+func (e *mapEngine) Close() error   { return nil }
+
+// sliceIterator walks keys sorted in a slice.
+type sliceIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func (it *sliceIterator) Len() int           { return len(it.keys) }
+func (it *sliceIterator) Less(i, j int) bool { return it.keys[i] < it.keys[j] }
+func (it *sliceIterator) Swap(i, j int) {
+	it.keys[i], it.keys[j] = it.keys[j], it.keys[i]
+	it.values[i], it.values[j] = it.values[j], it.values[i]
+}
+
+func (it *sliceIterator) Next() bool    { it.pos++; return it.pos < len(it.keys) }
+func (it *sliceIterator) Key() []byte   { return []byte(it.keys[it.pos]) }
+func (it *sliceIterator) Value() []byte { return it.values[it.pos] }
+func (it *sliceIterator) Err() error    { return nil }
+func (it *sliceIterator) Close() error  { return nil }
+
+func TestForeignEngine(t *testing.T) {
+	var engine StorageEngine = &mapEngine{keys: make(map[string][]byte)}
+	server := NewServerWithEngine(engine)
+	server.SetupRoutes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	for _, body := range []string{`{"key": "b", "value": "2"}`, `{"key": "a", "value": "1"}`, `{"key": "c", "value": "3"}`} {
+		resp, err := http.Post(ts.URL+"/set", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/get?key=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "2" {
+		t.Errorf("Expected 2, got %q (status %d)", body, resp.StatusCode)
+	}
+
+	it, err := engine.Scan([]byte("b"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key())+"="+string(it.Value()))
+	}
+	if got := strings.Join(keys, " "); got != "b=2 c=3" || it.Err() != nil {
+		t.Errorf("Expected b=2 c=3, got %q (%v)", got, it.Err())
+	}
+}
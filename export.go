@@ -0,0 +1,195 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ExportFormat selects the format of Export and ExportSST.
+type ExportFormat int
+
+const (
+	// ExportNDJSON writes a JSON object per line. Keys and values that aren't
+	// valid UTF-8 are written in base64, under key_base64 and value_base64
+	// rather than key and value.
+	ExportNDJSON ExportFormat = iota
+	// ExportCSV writes a header row, then a row per key with its bytes as
+	// they are.
+	ExportCSV
+)
+
+// ParseExportFormat returns the format named ndjson or csv.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch name {
+	case "ndjson":
+		return ExportNDJSON, nil
+	case "csv":
+		return ExportCSV, nil
+	}
+	return ExportNDJSON, fmt.Errorf("unknown export format %q", name)
+}
+
+func (f ExportFormat) String() string {
+	switch f {
+	case ExportNDJSON:
+		return "ndjson"
+	case ExportCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("ExportFormat(%d)", int(f))
+}
+
+// exportRecord is a line of an NDJSON export. Op and Seq are only set by
+// ExportSST.
+type exportRecord struct {
+	Key         *string `json:"key,omitempty"`
+	KeyBase64   *string `json:"key_base64,omitempty"`
+	Op          string  `json:"op,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 *string `json:"value_base64,omitempty"`
+	ExpiresAt   int64   `json:"expires_at,omitempty"`
+	Seq         uint64  `json:"seq,omitempty"`
+}
+
+// exporter writes the records of an export to w.
+type exporter struct {
+	format ExportFormat
+	tuples bool // Whether the records are the tuples of an SST file, with their operation and sequence number.
+	w      *bufio.Writer
+	csv    *csv.Writer
+	enc    *json.Encoder
+	count  int
+}
+
+func newExporter(w io.Writer, format ExportFormat, tuples bool) (*exporter, error) {
+	e := &exporter{format: format, tuples: tuples, w: bufio.NewWriter(w)}
+	switch format {
+	case ExportNDJSON:
+		e.enc = json.NewEncoder(e.w)
+		e.enc.SetEscapeHTML(false)
+	case ExportCSV:
+		e.csv = csv.NewWriter(e.w)
+		header := []string{"key", "value", "expires_at"}
+		if tuples {
+			header = []string{"key", "op", "value", "expires_at", "seq"}
+		}
+		if err := e.csv.Write(header); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown export format %d", int(format))
+	}
+	return e, nil
+}
+
+// add writes the record of key. Deletions, which only SST exports hold, have
+// no value.
+func (e *exporter) add(key []byte, op string, value []byte, expiresAt int64, seq uint64) error {
+	e.count++
+	if e.format == ExportCSV {
+		expires := ""
+		if expiresAt != 0 {
+			expires = strconv.FormatInt(expiresAt, 10)
+		}
+		row := []string{string(key), string(value), expires}
+		if e.tuples {
+			row = []string{string(key), op, string(value), expires, strconv.FormatUint(seq, 10)}
+		}
+		return e.csv.Write(row)
+	}
+
+	record := exportRecord{ExpiresAt: expiresAt}
+	record.Key, record.KeyBase64 = exportString(key)
+	if op != delOperation {
+		record.Value, record.ValueBase64 = exportString(value)
+	}
+	if e.tuples {
+		record.Op, record.Seq = op, seq
+	}
+	return e.enc.Encode(record)
+}
+
+// exportString returns b as a JSON string if it is valid UTF-8, or else in
+// base64.
+func exportString(b []byte) (text, encoded *string) {
+	s := string(b)
+	if utf8.ValidString(s) {
+		return &s, nil
+	}
+	s = base64.StdEncoding.EncodeToString(b)
+	return nil, &s
+}
+
+// finish flushes the records written.
+func (e *exporter) finish() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// Export writes every live key of the store to w in format, in key order,
+// with its value and expiration time, for offline analysis or migration to
+// other systems. Keys are merged from the memtable and the SST files as
+// NewIterator merges them, so deleted and expired keys are left out. It
+// returns the number of keys written.
+func (mem *MemDB) Export(w io.Writer, format ExportFormat) (int, error) {
+	e, err := newExporter(w, format, false)
+	if err != nil {
+		return 0, err
+	}
+	it, err := mem.NewIterator()
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if err := e.add(it.Key(), setOperation, it.Value(), it.ExpiresAt(), 0); err != nil {
+			return e.count, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return e.count, err
+	}
+	return e.count, e.finish()
+}
+
+// ExportSST writes every tuple of the SST file at path to w in format, as
+// stored: deletions and expired values included, with their operation and
+// sequence number. Values kept in the value log are read from the vlog files
+// next to the file. It returns the number of tuples written.
+func ExportSST(path string, w io.Writer, format ExportFormat) (int, error) {
+	e, err := newExporter(w, format, true)
+	if err != nil {
+		return 0, err
+	}
+	it, err := newSSTIterator(path)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		value := it.Value()
+		if err := it.Err(); err != nil {
+			return e.count, err
+		}
+		if err := e.add(it.Key(), it.Op(), value, it.ExpiresAt(), it.Seq()); err != nil {
+			return e.count, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return e.count, err
+	}
+	return e.count, e.finish()
+}
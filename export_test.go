@@ -0,0 +1,76 @@
+package kvstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	mem := NewTempDB(t)
+	mem.Set([]byte("a"), []byte("old"))
+	mem.Set([]byte("b"), []byte("deleted"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Del([]byte("b"))
+	mem.Set([]byte("c"), []byte{0xff, 0x00})
+	mem.Set([]byte("d"), []byte(`say "hi", twice`))
+	mem.SetWithTTL([]byte("e"), []byte("expiring"), time.Hour)
+	expiresAt := mem.memtableValue([]byte("e")).ExpiresAt
+
+	var buf bytes.Buffer
+	n, err := mem.Export(&buf, ExportNDJSON)
+	if err != nil || n != 4 {
+		t.Fatalf("Export returned %d, %v", n, err)
+	}
+	expected := `{"key":"a","value":"1"}
+{"key":"c","value_base64":"/wA="}
+{"key":"d","value":"say \"hi\", twice"}
+{"key":"e","value":"expiring","expires_at":` + strconv.FormatInt(expiresAt, 10) + `}
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected NDJSON export:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := mem.Export(&buf, ExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	expected = "key,value,expires_at\na,1,\nc,\xff\x00,\nd,\"say \"\"hi\"\", twice\",\ne,expiring," + strconv.FormatInt(expiresAt, 10) + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV export:\n%q", buf.String())
+	}
+}
+
+func TestExportSST(t *testing.T) {
+	dir := t.TempDir()
+	f := writeTestSST(t, dir, 1, []SSTTuple{set("a", ""), del("b")})
+
+	var buf bytes.Buffer
+	n, err := ExportSST(filepath.Join(dir, f.name()), &buf, ExportNDJSON)
+	if err != nil || n != 2 {
+		t.Fatalf("ExportSST returned %d, %v", n, err)
+	}
+	expected := `{"key":"a","op":"SET","value":""}
+{"key":"b","op":"DEL"}
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected NDJSON export:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := ExportSST(filepath.Join(dir, f.name()), &buf, ExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "key,op,value,expires_at,seq\na,SET,,,0\nb,DEL,,,0\n"; buf.String() != expected {
+		t.Errorf("Unexpected CSV export:\n%q", buf.String())
+	}
+
+	if _, err := ParseExportFormat("xml"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
@@ -3,6 +3,6 @@ module kvstore
 go 1.21.3
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/huandu/skiplist v1.2.0 // indirect
+	github.com/gorilla/mux v1.8.1
+	github.com/huandu/skiplist v1.2.0
 )
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package kvstore
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// hardLinkUnsupported reports whether err, from os.Link, means the
+// filesystem has no hard links. Without the errors of the platform to tell,
+// any failure but a missing source or an existing destination does.
+func hardLinkUnsupported(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrExist)
+}
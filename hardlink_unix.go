@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package kvstore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// hardLinkUnsupported reports whether err, from os.Link, means the
+// filesystem has no hard links, as FAT and some network filesystems don't.
+func hardLinkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,106 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lowDiskSpace is the free space of the data directory's filesystem below
+// which Health reports a problem.
+const lowDiskSpace = 64 << 20
+
+// Health summarizes the state of the store's subsystems for monitoring.
+type Health struct {
+	Healthy  bool     // Whether no problem was found.
+	Problems []string // Descriptions of the problems found, if any.
+	Closed   bool
+
+	WALSync OperationHealth // The fsyncs of the WAL.
+	Flush   OperationHealth // The flushes of the memtable to SST files.
+	Disk    DiskHealth
+
+	Time time.Time // When the report was made.
+}
+
+// OperationHealth reports the outcome of the recurring operations of a
+// subsystem. The times are zero if the operation never succeeded or failed.
+type OperationHealth struct {
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string // The error of the last failure.
+}
+
+// Failing reports whether the last attempt of the operation failed.
+func (h OperationHealth) Failing() bool {
+	return h.LastFailure.After(h.LastSuccess)
+}
+
+// DiskHealth reports the space left on the filesystem of the data directory.
+type DiskHealth struct {
+	Path       string
+	FreeBytes  uint64 // Space available to the store.
+	TotalBytes uint64
+	Error      string // Why the space couldn't be measured, if it couldn't.
+}
+
+// outcomes records the last success and failure of an operation. It is safe
+// for concurrent use.
+type outcomes struct {
+	mu     sync.Mutex
+	health OperationHealth
+}
+
+// record notes the outcome err of an attempt, nil for a success.
+func (o *outcomes) record(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err == nil {
+		o.health.LastSuccess = time.Now()
+		return
+	}
+	o.health.LastFailure = time.Now()
+	o.health.LastError = err.Error()
+}
+
+func (o *outcomes) snapshot() OperationHealth {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.health
+}
+
+// Health checks the subsystems of the store. Unlike the other operations, it
+// also works on a closed store, which it reports as a problem.
+func (mem *MemDB) Health() Health {
+	h := Health{
+		Closed:  mem.closed.Load(),
+		WALSync: mem.wal.syncOutcomes.snapshot(),
+		Flush:   mem.flushes.snapshot(),
+		Disk:    DiskHealth{Path: mem.opts.DataDir},
+		Time:    time.Now(),
+	}
+
+	if h.Closed {
+		h.Problems = append(h.Problems, "store is closed")
+	}
+	if h.WALSync.Failing() {
+		h.Problems = append(h.Problems, "last WAL sync failed: "+h.WALSync.LastError)
+	}
+	if h.Flush.Failing() {
+		h.Problems = append(h.Problems, "last flush failed: "+h.Flush.LastError)
+	}
+
+	free, total, err := diskSpace(h.Disk.Path)
+	if err != nil {
+		h.Disk.Error = err.Error()
+	} else {
+		h.Disk.FreeBytes, h.Disk.TotalBytes = free, total
+		if free < lowDiskSpace {
+			h.Problems = append(h.Problems, fmt.Sprintf("only %d bytes free on the disk of %s", free, h.Disk.Path))
+		}
+	}
+
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
@@ -0,0 +1,51 @@
+package kvstore
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("foo"), []byte("bar"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if err := mem.wal.Sync(); err != nil {
+		t.Fatalf("Error syncing WAL: %v", err)
+	}
+
+	health := mem.Health()
+	if !health.Healthy {
+		t.Fatalf("Expected a healthy store, got problems %v", health.Problems)
+	}
+	if health.Flush.LastSuccess.IsZero() || health.WALSync.LastSuccess.IsZero() {
+		t.Errorf("Expected successful flush and sync times, got %+v and %+v", health.Flush, health.WALSync)
+	}
+	if health.Disk.Error == "" && health.Disk.TotalBytes == 0 {
+		t.Errorf("Expected the size of the disk, got %+v", health.Disk)
+	}
+
+	// Make the next flush fail by putting a file where the SST directory was.
+	if err := os.RemoveAll(mem.sstDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mem.sstDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("baz"), []byte("qux"))
+	if err := mem.FlushToDisk(); err == nil {
+		t.Fatalf("Expected the flush to fail")
+	}
+
+	health = mem.Health()
+	if health.Healthy || !health.Flush.Failing() || health.Flush.LastError == "" {
+		t.Errorf("Expected a failing flush to be reported, got %+v", health)
+	}
+
+	mem.Close()
+	if health = mem.Health(); !health.Closed || health.Healthy {
+		t.Errorf("Expected a closed store to be reported, got %+v", health)
+	}
+}
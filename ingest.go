@@ -0,0 +1,160 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IngestSST adds the SST file at path, built outside the store such as with
+// an SSTWriter, to the SST files of the store, so that bulk imports bypass the
+// WAL and the memtable. The file must pass VerifySST without problems. Its
+// tuples are copied to a new file of level 0, all with a sequence number newer
+// than every write made before the call, so they replace the versions of
+// their keys already in the store. The memtable is flushed first if it holds
+// keys in the range of the file. The tuples become visible to reads at once,
+// when the manifest lists the new file, and the file at path is left as it
+// was.
+//
+// Tuples pointing to a value log are refused: the value log of the file isn't
+// ingested with it.
+func (mem *MemDB) IngestSST(path string) error {
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	report, err := VerifySST(path)
+	if err != nil {
+		return fmt.Errorf("can't ingest SST file %s: %w", path, err)
+	}
+	if !report.OK() {
+		return fmt.Errorf("can't ingest SST file %s: %s", path, strings.Join(report.Problems, "; "))
+	}
+	if report.Entries == 0 {
+		return nil
+	}
+
+	// Flushes would take file numbers and sequence numbers meanwhile.
+	mem.flushMu.Lock()
+	defer mem.flushMu.Unlock()
+
+	seq, err := mem.reserveIngestSeq(report.Smallest, report.Largest)
+	if err != nil {
+		return err
+	}
+	if err := mem.ingest(path, report, seq); err != nil {
+		return err
+	}
+	mem.scheduleCompaction()
+	return nil
+}
+
+// reserveIngestSeq flushes the memtables until they hold no key from smallest
+// to largest, then reserves the sequence number of the tuples of a file of
+// that range. The memtables are read before the SST files, so they must not keep
+// older versions of them; writes that follow get newer sequence numbers.
+// flushMu must be held.
+func (mem *MemDB) reserveIngestSeq(smallest, largest []byte) (uint64, error) {
+	for {
+		mem.mu.Lock()
+		if mem.closed.Load() {
+			mem.mu.Unlock()
+			return 0, ErrClosed
+		}
+		overlaps := false
+		for _, list := range mem.memtables() {
+			if elem := list.Find(smallest); elem != nil && bytes.Compare(elem.Key().([]byte), largest) <= 0 {
+				overlaps = true
+			}
+		}
+		if !overlaps {
+			seq := mem.wal.seq.Add(1)
+			mem.mu.Unlock()
+			return seq, nil
+		}
+		mem.mu.Unlock()
+
+		if err := mem.flush(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// ingest copies the tuples of the SST file at path, as VerifySST reported it,
+// to a new SST file of level 0 with sequence number seq, then adds it to the
+// manifest.
+func (mem *MemDB) ingest(path string, report SSTReport, seq uint64) error {
+	it, err := newSSTIterator(path)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	number := mem.manifest.newFileNumber()
+	name := filepath.Join(mem.sstDir, sstFileName(0, number))
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	deletions, err := mem.copyIngested(it, file, report, seq)
+	if err == nil {
+		err = file.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	if err == nil {
+		err = mem.manifest.apply(manifestEdit{add: []*sstMeta{{
+			level:       0,
+			number:      number,
+			size:        info.Size(),
+			smallest:    report.Smallest,
+			largest:     report.Largest,
+			smallestSeq: seq,
+			largestSeq:  seq,
+			entries:     report.Entries,
+			deletions:   deletions,
+		}}})
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(name)
+		return fmt.Errorf("error ingesting SST file %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyIngested writes the tuples of it to file, in the current format version
+// and with sequence number seq, and returns the number of deletions among
+// them.
+func (mem *MemDB) copyIngested(it *SSTIterator, file *os.File, report SSTReport, seq uint64) (int, error) {
+	header := SSTFileHeader{
+		Magic:       []byte("SSTF"),
+		EntryCount:  uint32(report.Entries),
+		SmallestKey: report.Smallest,
+		LongestKey:  report.Largest,
+		Version:     sstVersion,
+	}
+	w, err := (&SSTFile{File: file, compression: mem.opts.SSTCompression, filterBitsPerKey: mem.opts.FilterBitsPerKey}).NewWriter(header)
+	if err != nil {
+		return 0, err
+	}
+	for it.Next() {
+		tuple := it.Tuple()
+		if tuple.Value.blob != nil {
+			return 0, fmt.Errorf("key %q points to a value log", tuple.Key)
+		}
+		tuple.Value.Seq = seq
+		if err := w.Add(tuple.Key, tuple.Value); err != nil {
+			return 0, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+	return w.deletions, w.Finish()
+}
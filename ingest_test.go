@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIngestSST(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { mem.Close() }()
+
+	mem.Set([]byte("a"), []byte("old"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	// c is in the range of the file, so the memtable is flushed first; z isn't.
+	mem.Set([]byte("c"), []byte("old"))
+	mem.Set([]byte("z"), []byte("old"))
+
+	external := t.TempDir()
+	writeTestSST(t, external, 1, []SSTTuple{set("a", "new"), set("b", "new"), del("c")})
+	if err := mem.IngestSST(filepath.Join(external, sstFileName(0, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(key, expected string) {
+		t.Helper()
+		value, err := mem.Get([]byte(key))
+		if expected == "" {
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s to be deleted, got %q (%v)", key, value, err)
+			}
+		} else if err != nil || string(value) != expected {
+			t.Errorf("Unexpected value of %s: %q (%v)", key, value, err)
+		}
+	}
+	check("a", "new")
+	check("b", "new")
+	check("c", "")
+	check("z", "old")
+	if files := mem.manifest.current(); len(files) != 3 || files[0].largestSeq != mem.wal.seq.Load() {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+
+	// Ingesting the file again leaves a gap of sequence numbers that no WAL
+	// entry records. Writes made after the ingestion are newer, including
+	// after reopening.
+	if err := mem.IngestSST(filepath.Join(external, sstFileName(0, 1))); err != nil {
+		t.Fatal(err)
+	}
+	mem.Close()
+	if mem, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	check("a", "new")
+	check("z", "old")
+	mem.Set([]byte("b"), []byte("newer"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	check("b", "newer")
+
+	// A damaged file is refused and nothing of it is added.
+	path := filepath.Join(external, sstFileName(0, 2))
+	writeTestSST(t, external, 2, []SSTTuple{set("d", "new")})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := len(mem.manifest.current())
+	if err := mem.IngestSST(path); err == nil {
+		t.Error("Expected ingesting a damaged file to fail")
+	}
+	if len(mem.manifest.current()) != files {
+		t.Errorf("Expected the damaged file not to be added")
+	}
+	check("d", "")
+}
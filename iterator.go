@@ -0,0 +1,810 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/huandu/skiplist"
+)
+
+// iteratorSource is a sorted stream of tuples, either the memtable or a single SST file.
+type iteratorSource interface {
+	// current returns the tuple the source is positioned on, or nil once exhausted.
+	current() *SSTTuple
+	// advance moves the source to its next tuple.
+	advance() error
+	// prev moves the source to its previous tuple.
+	prev() error
+	// seekGE positions the source on the first tuple with a key >= key.
+	seekGE(key []byte) error
+	// seekLT positions the source on the last tuple with a key < key, or on the
+	// last tuple if key is nil.
+	seekLT(key []byte) error
+	close() error
+}
+
+const (
+	forward = iota
+	reverse
+)
+
+// Iterator walks the live keys of the store in order, ascending with Next or
+// descending with SeekLast and Prev. It merges the memtable with the SST files,
+// newer versions shadowing older ones and deleted keys being skipped.
+type Iterator struct {
+	sources   []iteratorSource // Ordered from newest to oldest.
+	start     []byte
+	end       []byte
+	direction int
+	key       []byte
+	value     []byte
+	expiresAt int64
+	err       error
+
+	values  *valueLog // Reads the values the SST files keep in the value log, shared with the store.
+	release func()    // Unpins the files the iterator reads, if they are pinned.
+
+	// withTombstones makes the iterator stop on deleted keys too, flagging them in deleted.
+	withTombstones bool
+	deleted        bool
+}
+
+// NewIterator returns an iterator over every live key of the store.
+func (mem *MemDB) NewIterator() (*Iterator, error) {
+	return mem.NewRangeIterator(nil, nil)
+}
+
+// Scan is NewRangeIterator returning a KeyIterator, as DB has it.
+func (mem *MemDB) Scan(start, end []byte) (KeyIterator, error) {
+	it, err := mem.NewRangeIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// NewRangeIterator returns an iterator over the live keys in [start, end). A
+// nil start or end leaves that side of the range open.
+func (mem *MemDB) NewRangeIterator(start, end []byte) (*Iterator, error) {
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	mem.mu.RLock()
+	list := mem.memtableRange(start, end)
+	mem.mu.RUnlock()
+	return mem.scan(list, start, end)
+}
+
+// scan returns an iterator over the live keys in [start, end) of list, the
+// entries of the memtables in the range, and the SST files.
+func (mem *MemDB) scan(list *skiplist.SkipList, start, end []byte) (*Iterator, error) {
+	v := mem.manifest.pin()
+	it, err := newIterator([]*skiplist.SkipList{list}, mem.tables, v.files, start, end)
+	if err != nil {
+		mem.manifest.unpin(v)
+		return nil, err
+	}
+	it.release = func() { mem.manifest.unpin(v) }
+	return it, nil
+}
+
+// memtableRange copies the newest versions held by the memtables of the keys
+// in [start, end) to a new list, which iterators can walk while writes go on.
+// A nil start or end leaves that side of the range open. mu must be held.
+func (mem *MemDB) memtableRange(start, end []byte) *skiplist.SkipList {
+	list := skiplist.New(skiplist.Bytes)
+
+	// Values are replaced rather than modified on every write, so the pointers can be shared.
+	// Copy the oldest memtable first so newer versions overwrite it.
+	memtables := mem.memtables()
+	for i := len(memtables) - 1; i >= 0; i-- {
+		elem := memtables[i].Front()
+		if start != nil {
+			elem = memtables[i].Find(start)
+		}
+		for ; elem != nil; elem = elem.Next() {
+			if end != nil && bytes.Compare(elem.Key().([]byte), end) >= 0 {
+				break
+			}
+			list.Set(elem.Key(), elem.Value)
+		}
+	}
+	return list
+}
+
+// newIterator merges the memtable lists, newest first, with the SST files
+// read through tables, ordered as the manifest lists them. The files of level
+// 0 are all open while the iterator is, but those of the other levels one per
+// level at a time, so that scanning a large store stays within
+// Options.MaxOpenFiles.
+func newIterator(lists []*skiplist.SkipList, tables *tableCache, files []*sstMeta, start, end []byte) (*Iterator, error) {
+	it := &Iterator{start: start, end: end, values: tables.values}
+
+	// The memtables hold the most recent writes.
+	for _, list := range lists {
+		it.sources = append(it.sources, newMemCursor(list, start))
+	}
+
+	for i := 0; i < len(files); {
+		level := disjointRun(files[i:])
+		var src iteratorSource
+		var err error
+		if len(level) > 1 {
+			src, err = newLevelCursor(tables, level, start)
+		} else {
+			src, err = newTableCursor(tables, files[i], start)
+		}
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.sources = append(it.sources, src)
+		i += max(len(level), 1)
+	}
+
+	return it, nil
+}
+
+// disjointRun returns the files at the start of files that are of the same
+// level, from 1 on, sorted by key and holding disjoint ranges of keys, as
+// compaction leaves them. It returns none for files of level 0 or past the
+// last level, which may overlap.
+func disjointRun(files []*sstMeta) []*sstMeta {
+	level := files[0].level
+	if level == 0 || level >= numLevels {
+		return nil
+	}
+	n := 1
+	for n < len(files) && files[n].level == level && bytes.Compare(files[n-1].largest, files[n].smallest) < 0 {
+		n++
+	}
+	return files[:n]
+}
+
+// Next moves the iterator to the next live key. It returns false when the
+// range is exhausted or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.direction == reverse && it.key != nil {
+		// Reposition every source right after the current key.
+		next := append(append([]byte{}, it.key...), 0)
+		for _, src := range it.sources {
+			if err := src.seekGE(next); err != nil {
+				it.err = err
+				return false
+			}
+		}
+	}
+	it.direction = forward
+
+	for it.err == nil {
+		winner := smallestTuple(it.sources)
+		if winner == nil {
+			return false
+		}
+		key, pair := winner.Key, winner.Value
+
+		if it.end != nil && bytes.Compare(key, it.end) >= 0 {
+			return false
+		}
+
+		it.err = advancePast(it.sources, key)
+		if it.position(key, pair) {
+			return it.err == nil
+		}
+	}
+
+	return false
+}
+
+// smallestTuple returns the tuple of the smallest key among the sources, or
+// nil once they are exhausted. The version with the largest sequence number
+// wins ties, then the newest source.
+func smallestTuple(sources []iteratorSource) *SSTTuple {
+	var winner *SSTTuple
+	for _, src := range sources {
+		cur := src.current()
+		if cur == nil {
+			continue
+		}
+		if winner == nil {
+			winner = cur
+		} else if c := bytes.Compare(cur.Key, winner.Key); c < 0 || (c == 0 && cur.Value.Seq > winner.Value.Seq) {
+			winner = cur
+		}
+	}
+	return winner
+}
+
+// advancePast moves every source positioned on key past it, dropping the
+// shadowed versions.
+func advancePast(sources []iteratorSource, key []byte) error {
+	var firstErr error
+	for _, src := range sources {
+		if cur := src.current(); cur != nil && bytes.Equal(cur.Key, key) {
+			if err := src.advance(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// SeekLast moves the iterator to the last live key of the range. It returns
+// false if the range holds no live key or an error occurred.
+func (it *Iterator) SeekLast() bool {
+	for _, src := range it.sources {
+		if err := src.seekLT(it.end); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.direction = reverse
+	it.key = nil
+
+	return it.prevLive()
+}
+
+// Prev moves the iterator to the previous live key. On an iterator that was
+// never positioned it behaves like SeekLast.
+func (it *Iterator) Prev() bool {
+	if it.key == nil {
+		return it.SeekLast()
+	}
+
+	if it.direction == forward {
+		// Reposition every source right before the current key.
+		for _, src := range it.sources {
+			if err := src.seekLT(it.key); err != nil {
+				it.err = err
+				return false
+			}
+		}
+	}
+	it.direction = reverse
+
+	return it.prevLive()
+}
+
+// prevLive steps backwards until a live key is found.
+func (it *Iterator) prevLive() bool {
+	for it.err == nil {
+		// Find the largest key among the sources, the newest version winning ties.
+		var winner *SSTTuple
+		for _, src := range it.sources {
+			cur := src.current()
+			if cur == nil {
+				continue
+			}
+			if winner == nil {
+				winner = cur
+			} else if c := bytes.Compare(cur.Key, winner.Key); c > 0 || (c == 0 && cur.Value.Seq > winner.Value.Seq) {
+				winner = cur
+			}
+		}
+		if winner == nil {
+			return false
+		}
+		key, pair := winner.Key, winner.Value
+
+		if it.start != nil && bytes.Compare(key, it.start) < 0 {
+			return false
+		}
+
+		// Move every source before this key, dropping the shadowed versions.
+		for _, src := range it.sources {
+			if cur := src.current(); cur != nil && bytes.Equal(cur.Key, key) {
+				if err := src.prev(); err != nil {
+					it.err = err
+				}
+			}
+		}
+
+		if it.position(key, pair) {
+			return it.err == nil
+		}
+	}
+
+	return false
+}
+
+// position moves the iterator onto key if pair is live, and reports whether it did.
+func (it *Iterator) position(key []byte, pair SSTPair) bool {
+	// Deleted and expired keys shadow older versions but aren't returned.
+	deleted := pair.Operation == delOperation || expired(pair.ExpiresAt)
+	if deleted && !it.withTombstones {
+		return false
+	}
+
+	if !deleted {
+		// Only the values of the keys returned are read from the value log.
+		var err error
+		if pair, err = it.values.resolve(key, pair); err != nil {
+			it.err = err
+		}
+	}
+	it.key, it.value, it.expiresAt, it.deleted = key, pair.Value, pair.ExpiresAt, deleted
+	return true
+}
+
+// Key returns the key the iterator is positioned on.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value the iterator is positioned on.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// ExpiresAt returns the expiration time of the value in Unix nanoseconds, 0 if
+// it never expires.
+func (it *Iterator) ExpiresAt() int64 {
+	return it.expiresAt
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the SST files held by the iterator.
+func (it *Iterator) Close() error {
+	var firstErr error
+	for _, src := range it.sources {
+		if err := src.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if it.release != nil {
+		it.release()
+		it.release = nil
+	}
+	return firstErr
+}
+
+// memCursor walks the memtable skiplist.
+type memCursor struct {
+	list  *skiplist.SkipList
+	elem  *skiplist.Element
+	tuple SSTTuple
+}
+
+func newMemCursor(list *skiplist.SkipList, start []byte) *memCursor {
+	c := &memCursor{list: list}
+	c.seekGE(start)
+	return c
+}
+
+func (c *memCursor) load() {
+	if c.elem == nil {
+		return
+	}
+	value := c.elem.Value.(*Value)
+	c.tuple = SSTTuple{Key: c.elem.Key().([]byte), Value: SSTPair{Operation: value.Operation, Value: value.Value, ExpiresAt: value.ExpiresAt, Seq: value.Seq}}
+}
+
+func (c *memCursor) current() *SSTTuple {
+	if c.elem == nil {
+		return nil
+	}
+	return &c.tuple
+}
+
+func (c *memCursor) advance() error {
+	c.elem = c.elem.Next()
+	c.load()
+	return nil
+}
+
+func (c *memCursor) prev() error {
+	c.elem = c.elem.Prev()
+	c.load()
+	return nil
+}
+
+func (c *memCursor) seekGE(key []byte) error {
+	if key == nil {
+		c.elem = c.list.Front()
+	} else {
+		c.elem = c.list.Find(key)
+	}
+	c.load()
+	return nil
+}
+
+func (c *memCursor) seekLT(key []byte) error {
+	if key == nil {
+		c.elem = c.list.Back()
+	} else if elem := c.list.Find(key); elem != nil {
+		c.elem = elem.Prev()
+	} else {
+		c.elem = c.list.Back()
+	}
+	c.load()
+	return nil
+}
+
+func (c *memCursor) close() error {
+	return nil
+}
+
+// sstCursor walks the tuples of a single SST file. Forward scans stream the
+// blocks of the file through a read-ahead buffer. Seeking or walking backwards
+// switches the cursor to reading whole blocks through the index of the file,
+// decoding the tuples of one block at a time. Files older than data blocks are
+// a single block, which is then held in memory. The file is only read through
+// ReadAt, so cursors can share the tables of the table cache.
+type sstCursor struct {
+	r       *sstReader
+	release func() error // Closes the file, or gives the table back to the cache.
+	blocks  []blockHandle
+	stream  *readAheadReader // Blocks from the one after block on, nil once the cursor needs random access.
+	data    *tupleReader     // Tuples of block after the current one, while streaming.
+	block   int              // Block of the current tuple.
+	tuples  []SSTTuple       // Tuples of block, once the cursor needs random access.
+	pos     int              // Index of the current tuple in block.
+	tuple   SSTTuple
+	done    bool
+}
+
+// newSSTCursor returns a cursor over the SST file at path from start on,
+// reading the file through its own handle.
+func newSSTCursor(path string, start []byte) (*sstCursor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newSSTReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading header of %s: %w", path, err)
+	}
+	c, err := openSSTCursor(r, file.Close, start)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newTableCursor returns a cursor over SST file f from start on, reading the
+// file through tables, so that it counts against Options.MaxOpenFiles.
+func newTableCursor(tables *tableCache, f *sstMeta, start []byte) (*sstCursor, error) {
+	t, err := tables.get(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading header of %s: %w", f.name(), err)
+	}
+	release := func() error {
+		tables.release(t)
+		return nil
+	}
+	c, err := openSSTCursor(t.reader, release, start)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// openSSTCursor returns a cursor over the file r reads, positioned on the
+// first tuple from start on. release is called once the cursor is closed.
+func openSSTCursor(r *sstReader, release func() error, start []byte) (*sstCursor, error) {
+	blocks, err := r.allBlocks()
+	if err != nil {
+		return nil, err
+	}
+	c := &sstCursor{r: r, release: release, blocks: blocks, done: len(blocks) == 0}
+	if c.done {
+		return c, nil
+	}
+
+	// Start streaming from the block holding the start of the range, as the
+	// blocks of the file are contiguous.
+	first := 0
+	if start != nil {
+		first = max(searchHandles(blocks, start), 0)
+	}
+	c.stream = newReadAheadReader(r.file, blocks[first].offset)
+	if err := c.openBlock(first); err != nil {
+		return nil, err
+	}
+
+	// Skip the tuples before the start of the range.
+	for {
+		if err := c.advance(); err != nil {
+			return nil, err
+		}
+		if c.done || start == nil || bytes.Compare(c.tuple.Key, start) >= 0 {
+			break
+		}
+	}
+
+	return c, nil
+}
+
+// openBlock starts streaming block i, read next from the stream.
+func (c *sstCursor) openBlock(i int) error {
+	h := c.blocks[i]
+	c.block, c.pos = i, -1
+	if c.r.header.Version < sstBlocksVersion {
+		c.data = &tupleReader{r: io.LimitReader(c.stream, h.size)}
+		return nil
+	}
+
+	buf := make([]byte, h.size)
+	if _, err := io.ReadFull(c.stream, buf); err != nil {
+		return fmt.Errorf("error reading %s: %v", c.r.file.Name(), err)
+	}
+	b, err := c.r.decodeBlock(h, buf)
+	c.data = b.reader()
+	return err
+}
+
+func (c *sstCursor) current() *SSTTuple {
+	if c.done {
+		return nil
+	}
+	return &c.tuple
+}
+
+func (c *sstCursor) advance() error {
+	if c.tuples != nil {
+		return c.load(c.block, c.pos+1)
+	}
+
+	for {
+		tuple, err := c.data.next()
+		if err == io.EOF {
+			if c.block+1 < len(c.blocks) {
+				if err := c.openBlock(c.block + 1); err != nil {
+					c.done = true
+					return err
+				}
+				continue
+			}
+			c.done = true
+			c.pos++
+			return nil
+		}
+		if err != nil {
+			c.done = true
+			return fmt.Errorf("error reading %s: %v", c.r.file.Name(), err)
+		}
+		c.tuple = tuple
+		c.pos++
+		return nil
+	}
+}
+
+func (c *sstCursor) prev() error {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	if err := c.loadBlock(c.block); err != nil {
+		return err
+	}
+	return c.load(c.block, c.pos-1)
+}
+
+func (c *sstCursor) seekGE(key []byte) error {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	b := max(searchHandles(c.blocks, key), 0)
+	if err := c.loadBlock(b); err != nil {
+		return err
+	}
+	return c.load(b, c.search(key))
+}
+
+func (c *sstCursor) seekLT(key []byte) error {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	b := len(c.blocks) - 1
+	if key != nil {
+		if b = searchHandles(c.blocks, key); b < 0 {
+			// Every tuple is at or after key.
+			c.pos, c.done = -1, true
+			return c.loadBlock(0)
+		}
+	}
+	if err := c.loadBlock(b); err != nil {
+		return err
+	}
+	pos := len(c.tuples)
+	if key != nil {
+		pos = c.search(key)
+	}
+	return c.load(b, pos-1)
+}
+
+// search returns the index of the first tuple of the block loaded with a key >= key.
+func (c *sstCursor) search(key []byte) int {
+	return sort.Search(len(c.tuples), func(i int) bool { return bytes.Compare(c.tuples[i].Key, key) >= 0 })
+}
+
+// load positions the cursor on tuple pos of block b, which is loaded, moving
+// to the neighbouring block when pos is just past either end of it, or marks
+// the cursor exhausted when there is no such block.
+func (c *sstCursor) load(b, pos int) error {
+	switch {
+	case pos >= len(c.tuples) && b+1 < len(c.blocks):
+		b, pos = b+1, 0
+		if err := c.loadBlock(b); err != nil {
+			return err
+		}
+	case pos < 0 && b > 0:
+		b--
+		if err := c.loadBlock(b); err != nil {
+			return err
+		}
+		pos = len(c.tuples) - 1
+	}
+
+	c.pos = pos
+	if pos < 0 || pos >= len(c.tuples) {
+		c.done = true
+		return nil
+	}
+	c.tuple, c.done = c.tuples[pos], false
+	return nil
+}
+
+// loadBlock decodes the tuples of block b, switching the cursor to random
+// access.
+func (c *sstCursor) loadBlock(b int) error {
+	if c.tuples != nil && c.block == b {
+		return nil
+	}
+	c.stream, c.data = nil, nil
+
+	block, err := c.r.block(c.blocks[b])
+	if err != nil {
+		c.done = true
+		return fmt.Errorf("error reading %s: %w", c.r.file.Name(), err)
+	}
+	tuples := []SSTTuple{}
+	for {
+		tuple, err := block.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.done = true
+			return fmt.Errorf("error reading %s: %v", c.r.file.Name(), err)
+		}
+		tuples = append(tuples, tuple)
+	}
+	c.block, c.tuples = b, tuples
+	return nil
+}
+
+func (c *sstCursor) close() error {
+	return c.release()
+}
+
+// levelCursor walks the files of a level from 1 on, sorted by key and holding
+// disjoint ranges of keys, as a single source. Only the file it is positioned
+// in is open.
+type levelCursor struct {
+	tables *tableCache
+	files  []*sstMeta
+	i      int        // Index of the file of cur.
+	cur    *sstCursor // Nil once the cursor is past either end of the level.
+}
+
+func newLevelCursor(tables *tableCache, files []*sstMeta, start []byte) (*levelCursor, error) {
+	c := &levelCursor{tables: tables, files: files}
+	return c, c.seekGE(start)
+}
+
+// open positions the cursor in file i, from start on, closing the file it
+// was in. An i out of the level leaves the cursor exhausted.
+func (c *levelCursor) open(i int, start []byte) error {
+	err := c.close()
+	c.i = i
+	if i < 0 || i >= len(c.files) {
+		return err
+	}
+	cur, openErr := newTableCursor(c.tables, c.files[i], start)
+	if openErr != nil {
+		return openErr
+	}
+	c.cur = cur
+	return err
+}
+
+func (c *levelCursor) current() *SSTTuple {
+	if c.cur == nil {
+		return nil
+	}
+	return c.cur.current()
+}
+
+func (c *levelCursor) advance() error {
+	if err := c.cur.advance(); err != nil {
+		return err
+	}
+	return c.skipForward()
+}
+
+func (c *levelCursor) prev() error {
+	if err := c.cur.prev(); err != nil {
+		return err
+	}
+	return c.skipBackward()
+}
+
+// skipForward moves the cursor from the end of a file to the first tuple of
+// the next one holding tuples.
+func (c *levelCursor) skipForward() error {
+	for c.cur != nil && c.cur.current() == nil {
+		if err := c.open(c.i+1, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipBackward moves the cursor from the start of a file to the last tuple
+// of the previous one holding tuples.
+func (c *levelCursor) skipBackward() error {
+	for c.cur != nil && c.cur.current() == nil {
+		if err := c.open(c.i-1, nil); err != nil || c.cur == nil {
+			return err
+		}
+		if err := c.cur.seekLT(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *levelCursor) seekGE(key []byte) error {
+	// The first file whose keys reach key.
+	i := 0
+	if key != nil {
+		i = sort.Search(len(c.files), func(i int) bool { return bytes.Compare(c.files[i].largest, key) >= 0 })
+	}
+	var err error
+	if c.cur != nil && c.i == i {
+		err = c.cur.seekGE(key)
+	} else {
+		err = c.open(i, key)
+	}
+	if err != nil {
+		return err
+	}
+	return c.skipForward()
+}
+
+func (c *levelCursor) seekLT(key []byte) error {
+	// The last file holding keys before key.
+	i := len(c.files) - 1
+	if key != nil {
+		i = sort.Search(len(c.files), func(i int) bool { return bytes.Compare(c.files[i].smallest, key) >= 0 }) - 1
+	}
+	if c.cur == nil || c.i != i {
+		if err := c.open(i, nil); err != nil || c.cur == nil {
+			return err
+		}
+	}
+	if err := c.cur.seekLT(key); err != nil {
+		return err
+	}
+	return c.skipBackward()
+}
+
+func (c *levelCursor) close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.close()
+	c.cur = nil
+	return err
+}
@@ -0,0 +1,193 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/huandu/skiplist"
+)
+
+// writeTestSST writes the given tuples, which must be sorted, as SST file
+// number n of level 0 in dir, and returns its metadata.
+func writeTestSST(t *testing.T, dir string, n int, tuples []SSTTuple) *sstMeta {
+	t.Helper()
+	file, err := os.Create(filepath.Join(dir, sstFileName(0, n)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sst := &SSTFile{File: file}
+	header := SSTFileHeader{
+		Magic:       []byte(magicString),
+		EntryCount:  uint32(len(tuples)),
+		SmallestKey: tuples[0].Key,
+		LongestKey:  tuples[len(tuples)-1].Key,
+		Version:     sstVersion,
+	}
+	if err := sst.writeTable(header, tuples); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	f, err := readSSTMeta(dir, 0, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// addTestSST writes the given tuples as SST file number n of the store and
+// adds it to the manifest.
+func addTestSST(t *testing.T, mem *MemDB, n int, tuples []SSTTuple) {
+	t.Helper()
+	f := writeTestSST(t, mem.sstDir, n, tuples)
+	if err := mem.manifest.apply(manifestEdit{add: []*sstMeta{f}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func set(key, value string) SSTTuple {
+	return SSTTuple{Key: []byte(key), Value: SSTPair{Operation: setOperation, Value: []byte(value)}}
+}
+
+func del(key string) SSTTuple {
+	return SSTTuple{Key: []byte(key), Value: SSTPair{Operation: delOperation}}
+}
+
+func collect(t *testing.T, it *Iterator) []string {
+	defer it.Close()
+
+	var res []string
+	for it.Next() {
+		res = append(res, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error iterating: %v", err)
+	}
+	return res
+}
+
+func TestIteratorMergesMemtableAndSSTs(t *testing.T) {
+	dir := t.TempDir()
+
+	// The oldest file, partly shadowed by the newer one and the memtable.
+	f1 := writeTestSST(t, dir, 1, []SSTTuple{set("a", "1"), set("b", "1"), set("c", "1"), set("e", "1")})
+	f2 := writeTestSST(t, dir, 2, []SSTTuple{set("b", "2"), del("c"), set("d", "2")})
+
+	list := skiplist.New(skiplist.Bytes)
+	list.Set([]byte("d"), NewValue("DEL", []byte("2")))
+	list.Set([]byte("f"), NewValue("SET", []byte("3")))
+
+	it, err := newIterator([]*skiplist.SkipList{list}, newTableCache(dir, 0), []*sstMeta{f2, f1}, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	got := collect(t, it)
+	want := []string{"a=1", "b=2", "e=1", "f=3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Bounded scan.
+	it, err = newIterator([]*skiplist.SkipList{list}, newTableCache(dir, 0), []*sstMeta{f2, f1}, []byte("b"), []byte("f"))
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	got = collect(t, it)
+	want = []string{"b=2", "e=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestIteratorReverse(t *testing.T) {
+	dir := t.TempDir()
+
+	f1 := writeTestSST(t, dir, 1, []SSTTuple{set("a", "1"), set("b", "1"), set("c", "1"), set("e", "1")})
+	f2 := writeTestSST(t, dir, 2, []SSTTuple{set("b", "2"), del("c"), set("d", "2")})
+
+	list := skiplist.New(skiplist.Bytes)
+	list.Set([]byte("d"), NewValue("DEL", []byte("2")))
+	list.Set([]byte("f"), NewValue("SET", []byte("3")))
+
+	it, err := newIterator([]*skiplist.SkipList{list}, newTableCache(dir, 0), []*sstMeta{f2, f1}, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	defer it.Close()
+
+	var got []string
+	for ok := it.SeekLast(); ok; ok = it.Prev() {
+		got = append(got, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error iterating: %v", err)
+	}
+	want := []string{"f=3", "e=1", "b=2", "a=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Bounded reverse scan, then switching direction.
+	it, err = newIterator([]*skiplist.SkipList{list}, newTableCache(dir, 0), []*sstMeta{f2, f1}, []byte("b"), []byte("f"))
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	defer it.Close()
+
+	steps := []struct {
+		move func() bool
+		want string
+	}{
+		{it.SeekLast, "e"},
+		{it.Prev, "b"},
+		{it.Next, "e"},
+		{it.Prev, "b"},
+	}
+	for i, step := range steps {
+		if !step.move() || string(it.Key()) != step.want {
+			t.Fatalf("Step %d: expected %s, got %q (%v)", i, step.want, it.Key(), it.Err())
+		}
+	}
+	if it.Prev() {
+		t.Errorf("Expected the range to be exhausted, got %q", it.Key())
+	}
+}
+
+func TestIteratorAcrossBlocks(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 1000; i++ {
+		tuples = append(tuples, set(fmt.Sprintf("key%04d", i), "value"))
+	}
+	f := writeTestSST(t, dir, 1, tuples)
+
+	it, err := newIterator([]*skiplist.SkipList{skiplist.New(skiplist.Bytes)}, newTableCache(dir, 0), []*sstMeta{f}, []byte("key0100"), nil)
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		if want := fmt.Sprintf("key%04d", 100+n); string(it.Key()) != want {
+			t.Fatalf("Expected %s, got %s", want, it.Key())
+		}
+		n++
+	}
+	if it.Err() != nil || n != 900 {
+		t.Errorf("Expected 900 keys, got %d (%v)", n, it.Err())
+	}
+
+	// The reverse scan reads the blocks through the index.
+	n = 0
+	for ok := it.SeekLast(); ok; ok = it.Prev() {
+		n++
+	}
+	if it.Err() != nil || n != 900 {
+		t.Errorf("Expected 900 keys backwards, got %d (%v)", n, it.Err())
+	}
+}
@@ -0,0 +1,42 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned, wrapped, when the directory of a store is already in
+// use by another open instance.
+var ErrLocked = errors.New("store directory is locked")
+
+// dirLock is a lock on the LOCK file of a store directory, held while the store is open.
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir takes the lock of dir, failing if another instance holds it.
+func lockDir(dir string) (*dirLock, error) {
+	path := filepath.Join(dir, "LOCK")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrLocked, path, err)
+	}
+
+	return &dirLock{file: file}, nil
+}
+
+// release gives up the lock. The LOCK file is left in place.
+func (l *dirLock) release() error {
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package kvstore
+
+import "os"
+
+// lockFile is a no-op on platforms without flock or LockFileEx, so nothing
+// prevents two instances from opening the same directory there.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,426 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// manifestName is the file of the SST directory listing the SST files of
+	// the store. Only the files it lists are read.
+	manifestName = "MANIFEST"
+	// manifestMagic starts every manifest.
+	manifestMagic = "KVMF"
+	// manifestVersion is the format version of new manifests. Version 2
+	// adds the number of tuples and deletions of every file.
+	manifestVersion uint16 = 2
+)
+
+// sstMeta describes an SST file of the store, as the manifest lists it. It is
+// never modified once listed.
+type sstMeta struct {
+	level  int
+	number int // Unique among the files of the store, whatever their level.
+	size   int64
+
+	smallest, largest []byte // Range of the keys of the file, bounds included.
+
+	// The range of the sequence numbers of the tuples, 0 if unknown.
+	smallestSeq, largestSeq uint64
+
+	// The number of tuples of the file, and of deletions among them, 0 if
+	// unknown.
+	entries, deletions int
+}
+
+// name returns the name of the file in the SST directory.
+func (f *sstMeta) name() string {
+	return sstFileName(f.level, f.number)
+}
+
+func (f *sstMeta) contains(key []byte) bool {
+	return bytes.Compare(key, f.smallest) >= 0 && bytes.Compare(key, f.largest) <= 0
+}
+
+// sstFileName returns the name of SST file number of level, such as
+// L0-000042.sst.
+func sstFileName(level, number int) string {
+	return fmt.Sprintf("L%d-%06d.sst", level, number)
+}
+
+// parseSSTFileName returns the level and number of an SST file named by
+// sstFileName, or of the sst001 files of stores older than the manifest,
+// which are of level 0. It returns false for other names.
+func parseSSTFileName(name string) (level, number int, ok bool) {
+	if rest, found := strings.CutPrefix(name, "sst"); found {
+		number, ok = parseFileNumber(rest)
+		return 0, number, ok
+	}
+	rest, found := strings.CutPrefix(name, "L")
+	if !found {
+		return 0, 0, false
+	}
+	if rest, found = strings.CutSuffix(rest, ".sst"); !found {
+		return 0, 0, false
+	}
+	l, n, found := strings.Cut(rest, "-")
+	if !found {
+		return 0, 0, false
+	}
+	if level, ok = parseFileNumber(l); !ok {
+		return 0, 0, false
+	}
+	number, ok = parseFileNumber(n)
+	return level, number, ok
+}
+
+// parseFileNumber parses the decimal digits of s.
+func parseFileNumber(s string) (int, bool) {
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// readSSTMeta reads the metadata of SST file number of level from its header
+// and footer.
+func readSSTMeta(dir string, level, number int) (*sstMeta, error) {
+	file, err := os.Open(filepath.Join(dir, sstFileName(level, number)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := newSSTReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SST file %s: %w", file.Name(), err)
+	}
+	return &sstMeta{
+		level:       level,
+		number:      number,
+		size:        info.Size(),
+		smallest:    r.header.SmallestKey,
+		largest:     r.header.LongestKey,
+		smallestSeq: r.smallestSeq,
+		largestSeq:  r.largestSeq,
+		entries:     int(r.header.EntryCount),
+	}, nil
+}
+
+// manifestEdit is a change to the SST files of the store, applied at once.
+type manifestEdit struct {
+	add    []*sstMeta
+	remove []int // Numbers of the files dropped. Files added back by the same edit are replaced in place.
+}
+
+// version is the list of the SST files of the store as an edit left it.
+// Reads pin the version they go through, so that the files later edits drop
+// are only deleted once no read uses them.
+type version struct {
+	files []*sstMeta // Never modified.
+	refs  int        // Pins, plus one while the version is current. Guarded by manifest.mu.
+}
+
+// manifest lists the SST files of the store. Every edit rewrites the file
+// aside and moves it over the previous one, so a crash leaves either list
+// whole. The files are listed in the order reads go through them: level 0
+// from the newest file, then the other levels by key.
+//
+// A manifest holds its magic, version, the next file number and the count of
+// the files, then for each its level, number, size, key range and sequence
+// numbers, keys preceded by their uint32 length, and ends with a CRC32.
+type manifest struct {
+	dir string
+
+	mu         sync.Mutex
+	version    *version // Replaced, never modified, by edits.
+	nextNumber int
+
+	// pinned lists the versions older than the current one that reads still
+	// pin, and obsolete the files edits dropped that some of them list.
+	pinned   []*version
+	obsolete []*sstMeta
+	// deleteFile deletes the files dropped by edits once no version lists
+	// them. Nil keeps them on disk.
+	deleteFile func(f *sstMeta)
+}
+
+// openManifest reads the manifest of the SST directory dir. A store without
+// one, created before manifests or new, gets one listing the SST files found
+// in dir, the older sst001 files being renamed as they are listed.
+func openManifest(dir string) (*manifest, error) {
+	m := &manifest{dir: dir, version: &version{refs: 1}, nextNumber: 1}
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, m.recover()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.decode(data); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", filepath.Join(dir, manifestName), err)
+	}
+	return m, nil
+}
+
+// recover lists the SST files of m.dir in a new manifest.
+func (m *manifest) recover() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	var files []*sstMeta
+	for _, entry := range entries {
+		level, number, ok := parseSSTFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		if name := sstFileName(level, number); entry.Name() != name {
+			if err := os.Rename(filepath.Join(m.dir, entry.Name()), filepath.Join(m.dir, name)); err != nil {
+				return err
+			}
+		}
+		f, err := readSSTMeta(m.dir, level, number)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		m.nextNumber = max(m.nextNumber, number+1)
+	}
+	if len(files) > 0 {
+		Logger.Printf("Listed %d SST files of %s in a new manifest", len(files), m.dir)
+	}
+	return m.apply(manifestEdit{add: files})
+}
+
+// current returns the files of the store. The slice must not be modified.
+// Reads that open the files must pin them instead, or compaction may delete
+// them meanwhile.
+func (m *manifest) current() []*sstMeta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.version.files
+}
+
+// pin returns the current version, whose files are kept until it is
+// unpinned.
+func (m *manifest) pin() *version {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.version.refs++
+	return m.version
+}
+
+// unpin releases a version returned by pin, deleting the files no version
+// lists anymore.
+func (m *manifest) unpin(v *version) {
+	m.mu.Lock()
+	v.refs--
+	unused := m.release()
+	m.mu.Unlock()
+
+	for _, f := range unused {
+		m.deleteFile(f)
+	}
+}
+
+// release forgets the old versions no read pins, and returns the obsolete
+// files that none of the others lists. m.mu must be held.
+func (m *manifest) release() []*sstMeta {
+	pinned := m.pinned[:0]
+	for _, v := range m.pinned {
+		if v.refs > 0 {
+			pinned = append(pinned, v)
+		}
+	}
+	clear(m.pinned[len(pinned):])
+	m.pinned = pinned
+
+	listed := map[int]bool{}
+	for _, v := range m.pinned {
+		for _, f := range v.files {
+			listed[f.number] = true
+		}
+	}
+	var unused []*sstMeta
+	obsolete := m.obsolete[:0]
+	for _, f := range m.obsolete {
+		if listed[f.number] {
+			obsolete = append(obsolete, f)
+		} else if m.deleteFile != nil {
+			unused = append(unused, f)
+		}
+	}
+	clear(m.obsolete[len(obsolete):])
+	m.obsolete = obsolete
+	return unused
+}
+
+// newFileNumber reserves the number of a new SST file. Numbers reserved by
+// files that never make it to the manifest are skipped.
+func (m *manifest) newFileNumber() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextNumber++
+	return m.nextNumber - 1
+}
+
+// apply makes edit durable, then visible to the reads that follow. Reads
+// that pinned the previous version keep it, so the files edit removes are
+// only deleted once they are done.
+func (m *manifest) apply(edit manifestEdit) error {
+	m.mu.Lock()
+	unused, err := m.applyLocked(edit)
+	m.mu.Unlock()
+
+	for _, f := range unused {
+		m.deleteFile(f)
+	}
+	return err
+}
+
+// applyLocked applies edit and returns the files it leaves unused. m.mu must
+// be held.
+func (m *manifest) applyLocked(edit manifestEdit) ([]*sstMeta, error) {
+	removed := make(map[int]bool, len(edit.remove))
+	for _, n := range edit.remove {
+		removed[n] = true
+	}
+	readded := make(map[int]bool, len(edit.add))
+	for _, f := range edit.add {
+		readded[f.number] = true
+	}
+	files := make([]*sstMeta, 0, len(m.version.files)+len(edit.add))
+	var dropped []*sstMeta
+	for _, f := range m.version.files {
+		if !removed[f.number] {
+			files = append(files, f)
+		} else if !readded[f.number] {
+			dropped = append(dropped, f)
+		}
+	}
+	for _, f := range edit.add {
+		files = append(files, f)
+		m.nextNumber = max(m.nextNumber, f.number+1)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.level != b.level {
+			return a.level < b.level
+		}
+		if a.level == 0 {
+			return a.number > b.number
+		}
+		return bytes.Compare(a.smallest, b.smallest) < 0
+	})
+
+	if err := m.write(files); err != nil {
+		return nil, err
+	}
+	old := m.version
+	m.version = &version{files: files, refs: 1}
+	if old.refs--; old.refs > 0 {
+		m.pinned = append(m.pinned, old)
+	}
+	m.obsolete = append(m.obsolete, dropped...)
+	return m.release(), nil
+}
+
+// write replaces the manifest file with one listing files.
+func (m *manifest) write(files []*sstMeta) error {
+	var buf bytes.Buffer
+	writeBinary(&buf, []byte(manifestMagic), manifestVersion, uint64(m.nextNumber), uint32(len(files)))
+	for _, f := range files {
+		writeBinary(&buf, uint32(f.level), uint64(f.number), uint64(f.size),
+			uint32(len(f.smallest)), f.smallest, uint32(len(f.largest)), f.largest,
+			f.smallestSeq, f.largestSeq, uint64(f.entries), uint64(f.deletions))
+	}
+
+	tmp, err := os.CreateTemp(m.dir, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(appendChecksum(buf.Bytes()))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = replaceFile(tmp.Name(), filepath.Join(m.dir, manifestName))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// decode reads the files listed in the manifest data.
+func (m *manifest) decode(data []byte) error {
+	data, err := stripChecksum(data)
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(manifestMagic))
+	var (
+		version    uint16
+		nextNumber uint64
+		count      uint32
+	)
+	if err := readBinary(r, magic, &version, &nextNumber, &count); err != nil {
+		return err
+	}
+	if string(magic) != manifestMagic {
+		return errors.New("not a manifest")
+	}
+	if version < 1 || version > manifestVersion {
+		return fmt.Errorf("unknown manifest version %d", version)
+	}
+
+	// The count comes from disk, so don't preallocate from it.
+	var files []*sstMeta
+	for i := uint32(0); i < count; i++ {
+		var level uint32
+		var number, size uint64
+		if err := readBinary(r, &level, &number, &size); err != nil {
+			return err
+		}
+		f := &sstMeta{level: int(level), number: int(number), size: int64(size)}
+		if f.smallest, err = readKeyValue(r); err != nil {
+			return err
+		}
+		if f.largest, err = readKeyValue(r); err != nil {
+			return err
+		}
+		if err := readBinary(r, &f.smallestSeq, &f.largestSeq); err != nil {
+			return err
+		}
+		if version >= 2 {
+			var entries, deletions uint64
+			if err := readBinary(r, &entries, &deletions); err != nil {
+				return err
+			}
+			f.entries, f.deletions = int(entries), int(deletions)
+		}
+		files = append(files, f)
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	m.version.files, m.nextNumber = files, int(nextNumber)
+	return nil
+}
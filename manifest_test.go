@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSSTFileName(t *testing.T) {
+	cases := []struct {
+		name          string
+		level, number int
+		ok            bool
+	}{
+		{"L0-000042.sst", 0, 42, true},
+		{"L3-1234567.sst", 3, 1234567, true},
+		{"sst007", 0, 7, true},
+		{"sst1000", 0, 1000, true},
+		{"L0-000042", 0, 0, false},
+		{"L-000042.sst", 0, 0, false},
+		{"L0-+42.sst", 0, 0, false},
+		{"sst", 0, 0, false},
+		{"sst-1", 0, 0, false},
+		{manifestName, 0, 0, false},
+		{"vlog001", 0, 0, false},
+	}
+	for _, c := range cases {
+		level, number, ok := parseSSTFileName(c.name)
+		if level != c.level || number != c.number || ok != c.ok {
+			t.Errorf("parseSSTFileName(%q) = %d, %d, %v, expected %d, %d, %v", c.name, level, number, ok, c.level, c.number, c.ok)
+		}
+	}
+	if name := sstFileName(2, 42); name != "L2-000042.sst" {
+		t.Errorf("Unexpected name %s", name)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	sstDir := filepath.Join(dir, "sstStorage")
+	if err := os.MkdirAll(sstDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// A store older than the manifest, whose files are renamed when it opens.
+	writeTestSST(t, sstDir, 1, []SSTTuple{set("a", "1"), set("b", "1")})
+	writeTestSST(t, sstDir, 2, []SSTTuple{set("b", "2")})
+	for n := 1; n <= 2; n++ {
+		if err := os.Rename(filepath.Join(sstDir, sstFileName(0, n)), filepath.Join(sstDir, fmt.Sprintf("sst%03d", n))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := mem.manifest.current()
+	if len(files) != 2 || files[0].number != 2 || files[1].number != 1 || string(files[1].smallest) != "a" || string(files[1].largest) != "b" {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+	for _, name := range []string{"sst001", "sst002"} {
+		if _, err := os.Stat(filepath.Join(sstDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be renamed, got %v", name, err)
+		}
+	}
+	if value, err := mem.Get([]byte("b")); err != nil || string(value) != "2" {
+		t.Errorf("Unexpected value of b: %q (%v)", value, err)
+	}
+
+	mem.Set([]byte("c"), []byte("3"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	mem.Close()
+
+	// Files the manifest doesn't list, such as one left by a crashed flush,
+	// are ignored, then removed. The next flush takes their number.
+	writeTestSST(t, sstDir, 4, []SSTTuple{set("a", "stray")})
+	if mem, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if files := mem.manifest.current(); len(files) != 3 || files[0].name() != "L0-000003.sst" || files[0].entries != 1 {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Unexpected value of a: %q (%v)", value, err)
+	}
+	if _, err := os.Stat(filepath.Join(sstDir, "L0-000004.sst")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stray file to be removed, got %v", err)
+	}
+	if n := mem.manifest.newFileNumber(); n != 4 {
+		t.Errorf("Expected file number 4 next, got %d", n)
+	}
+	mem.Close()
+
+	// A damaged manifest fails the open rather than hiding files.
+	path := filepath.Join(sstDir, manifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if mem, err := Open(dir); err == nil {
+		mem.Close()
+		t.Error("Expected opening a store with a damaged manifest to fail")
+	}
+}
+
+func TestManifestVersion1(t *testing.T) {
+	// Manifests of version 1 don't count the tuples of the files.
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	writeBinary(&buf, []byte(manifestMagic), uint16(1), uint64(8), uint32(1))
+	writeBinary(&buf, uint32(1), uint64(7), uint64(100), uint32(1), []byte("a"), uint32(1), []byte("b"), uint64(1), uint64(2))
+	if err := os.WriteFile(filepath.Join(dir, manifestName), appendChecksum(buf.Bytes()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := openManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := m.current()
+	if len(files) != 1 || files[0].name() != "L1-000007.sst" || files[0].largestSeq != 2 || files[0].entries != 0 {
+		t.Errorf("Unexpected files %+v", files)
+	}
+}
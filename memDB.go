@@ -0,0 +1,1175 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+// MemDB is a key-value store keeping the recent writes in a memtable, logged
+// to the WAL, and the older ones in SST files.
+//
+// A MemDB is safe for concurrent use by multiple goroutines. Writes are
+// serialized, and read-modify-write operations such as CompareAndSwap, Incr
+// and Txn.Commit are atomic. Reads run concurrently with each other and with
+// writes, flushes and compactions, and see every write that returned before
+// they started. Iterators and snapshots see the store as of their creation
+// and, like transactions, are meant for use by a single goroutine.
+type MemDB struct {
+	skiplist       *skiplist.SkipList
+	wal            *WAL
+	opts           Options
+	sstDir         string
+	manifest       *manifest   // Lists the SST files of sstDir.
+	tables         *tableCache // Open SST files of sstDir.
+	lock           *dirLock
+	closed         atomic.Bool
+	size           int64          // Bytes of keys and values allocated by the active memtable, guarded by mu.
+	arena          arena          // Holds the keys and values of the active memtable, guarded by mu.
+	recovery       RecoveryStats  // Of the last Load, guarded by mu.
+	reads          atomic.Int64   // Keys looked up, reported by Stats.
+	resetAt        atomic.Int64   // When ResetStats was last called, in Unix nanoseconds.
+	sstSyncs       syncMetrics    // Syncs of the SST files written by FlushToDisk.
+	stopBackground chan struct{}  // Stops the background WAL flushes and syncs, nil if none run.
+	background     sync.WaitGroup // Waits for them to return.
+	flushes        outcomes       // Outcomes of FlushToDisk, reported by Health.
+
+	replayHooks []ReplayHook
+
+	// immutable is the memtable frozen to be written to an SST file, nil
+	// unless a flush is pending or running. It is never modified. Its flush checkpoints the WAL
+	// segments below immutableSegment, up to immutableSeq. Guarded by mu.
+	immutable        *skiplist.SkipList
+	immutableSize    int64 // Bytes of keys and values allocated by immutable.
+	immutableSegment int
+	immutableSeq     uint64
+	// flushed is closed once the flush of immutable ends, waking up the
+	// writes stalled by Options.WriteBufferSize, nil if none waits. Guarded by mu.
+	flushed     chan struct{}
+	writeStalls atomic.Int64 // Writes stalled, reported by Stats.
+	stallNanos  atomic.Int64 // Time they waited.
+
+	// flushTrigger wakes up the background flush of the memtables frozen by
+	// flushIfFull, which stops once stopFlush is closed; both are nil if it
+	// doesn't run. flushers waits for it to return.
+	flushTrigger chan struct{}
+	stopFlush    chan struct{}
+	flushers     sync.WaitGroup
+
+	// mu guards the memtables. Writes hold it, which also makes
+	// read-modify-write operations atomic, and reads of the memtables share it.
+	mu sync.RWMutex
+	// flushMu serializes flushes.
+	flushMu sync.Mutex
+
+	// compactMu serializes compactions. compactTrigger wakes up the
+	// background compaction, which stops once stopCompaction is closed; both
+	// are nil if it doesn't run. compactions waits for it to return.
+	compactMu       sync.Mutex
+	compactPointers [numLevels][]byte // Largest key compacted last in every level, guarded by compactMu.
+	compactTrigger  chan struct{}
+	stopCompaction  chan struct{}
+	compactions     sync.WaitGroup
+	// compactionPaused stops the background compaction from starting new
+	// compactions, see PauseCompaction.
+	compactionPaused atomic.Bool
+	// compactionLimiter paces the I/O of compactions, nil if unlimited.
+	compactionLimiter *rateLimiter
+	compactionStats   compactionMetrics // Reported by Stats and CompactionStats.
+}
+
+// ErrKeyNotFound is returned, possibly wrapped, when a key is absent or deleted.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrClosed is returned by the operations of a closed store.
+var ErrClosed = errors.New("store is closed")
+
+// ErrWriteStall is returned, wrapped, by the writes that waited
+// Options.WriteStallTimeout for the memtables to be flushed below
+// Options.WriteBufferSize.
+var ErrWriteStall = errors.New("write stalled on full memtables")
+
+// RecoveryStats reports how the entries of the WAL were handled during Load.
+type RecoveryStats struct {
+	Applied        int   // Entries replayed into the memtable.
+	Skipped        int   // Entries already covered by a flush watermark.
+	Discarded      int   // Entries that could not be read or replayed.
+	DiscardedBytes int64 // Bytes of the WAL left unreplayed.
+}
+
+// Logger receives the store's log messages, such as the summary of every WAL
+// recovery. Set its output to io.Discard to silence it.
+var Logger = log.New(os.Stderr, "kvstore: ", log.LstdFlags)
+
+type Value struct {
+	Operation string
+	Value     []byte
+	ExpiresAt int64 // Unix time in nanoseconds, 0 if the value never expires.
+	// Seq is the sequence number of the WAL entry of the write, which orders
+	// the versions of a key. It is 0 for writes from before sequence numbers,
+	// which are older than all others.
+	Seq uint64
+}
+
+func NewValue(operation string, value []byte) *Value {
+	return &Value{
+		Operation: operation,
+		Value:     value,
+	}
+}
+
+// NewMemDB opens the store under DataDir with the default options.
+func NewMemDB() (*MemDB, error) {
+	return OpenWithOptions(Options{})
+}
+
+// For testing
+func NewMemDBtest() (*MemDB, error) {
+	return open(Options{})
+}
+
+// Close makes the store durable and releases it. With Options.FlushOnClose the
+// memtable is flushed first. The WAL is synced and closed and the directory
+// lock released; every later operation returns ErrClosed.
+func (mem *MemDB) Close() error {
+	if mem.opts.FlushOnClose {
+		if err := mem.FlushToDisk(); err != nil {
+			return err
+		}
+	}
+
+	mem.mu.Lock()
+	if mem.closed.Swap(true) {
+		mem.mu.Unlock()
+		return ErrClosed
+	}
+	if mem.stopBackground != nil {
+		close(mem.stopBackground)
+	}
+	if mem.stopFlush != nil {
+		close(mem.stopFlush)
+	}
+	mem.wakeStalledWrites()
+	mem.mu.Unlock()
+
+	// A flush or sync in progress is let to finish, since it uses the WAL.
+	mem.background.Wait()
+	mem.flushers.Wait()
+	mem.flushMu.Lock()
+	defer mem.flushMu.Unlock()
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	// A compaction being run is abandoned before its next file.
+	if mem.stopCompaction != nil {
+		close(mem.stopCompaction)
+		mem.compactions.Wait()
+	}
+
+	err := mem.wal.Sync()
+	if closeErr := mem.wal.Close(); err == nil {
+		err = closeErr
+	}
+	mem.tables.close()
+	if mem.lock != nil {
+		if unlockErr := mem.lock.release(); err == nil {
+			err = unlockErr
+		}
+	}
+
+	return err
+}
+
+func (mem *MemDB) Set(key []byte, value []byte) error {
+	return mem.write("", func() error { return mem.set(key, value) })
+}
+
+// write runs fn, the body of a write, under mu once the memtables have room
+// for it, then syncs the WAL as the SyncPolicy asks. A traced write logs its
+// request ID with the sequence numbers of the WAL entries fn appended, so
+// that they can be found on disk, see Traced.
+func (mem *MemDB) write(requestID string, fn func() error) (err error) {
+	defer mem.syncWrite(&err)
+	defer mem.flushIfFull()
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	if err := mem.waitForRoom(); err != nil {
+		return err
+	}
+
+	before := mem.wal.seq.Load()
+	err = fn()
+	if last := mem.wal.seq.Load(); requestID != "" && last > before {
+		Logger.Printf("write request_id=%q seq=%d-%d", requestID, before+1, last)
+	}
+	return err
+}
+
+func (mem *MemDB) set(key []byte, value []byte) error {
+	mem.put(key, NewValue("SET", value))
+
+	// Write the operation to the WAL
+	err := mem.wal.AppendEntry(WatermarkPlaceholder, "SET", key, value)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the value of key, or ErrKeyNotFound if it is missing or deleted.
+//
+// Reads look the key up in layers, from the newest writes to the oldest: the
+// active memtable, the immutable memtable while it is being flushed, then the
+// SST files. The first layer holding a version of the key answers, even with a
+// tombstone, so a key deleted in a newer layer stays missing whatever the
+// older ones hold, and the SST files are only read when the memtables miss.
+func (mem *MemDB) Get(key []byte) ([]byte, error) {
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	mem.mu.RLock()
+	value := mem.memtableValue(key)
+	mem.mu.RUnlock()
+	return mem.lookup(key, value)
+}
+
+// get is Get for the callers holding mu.
+func (mem *MemDB) get(key []byte) ([]byte, error) {
+	return mem.lookup(key, mem.memtableValue(key))
+}
+
+// lookup returns the live value of key, given value, its newest version in
+// the memtable layers, or nil to go on to the SST files.
+func (mem *MemDB) lookup(key []byte, value *Value) ([]byte, error) {
+	mem.reads.Add(1)
+	if value == nil {
+		v := mem.manifest.pin()
+		defer mem.manifest.unpin(v)
+		return findValueInSSTFiles(mem.tables, key, v.files)
+	}
+	if !value.live() {
+		return nil, ErrKeyNotFound
+	}
+	return value.Value, nil
+}
+
+// TryGet returns the value of key and whether it was found. Unlike Get, a
+// missing key isn't an error: err only reports a failed lookup.
+func (mem *MemDB) TryGet(key []byte) ([]byte, bool, error) {
+	value, err := mem.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// GetOrDefault returns the value of key, or def if the key is missing.
+func (mem *MemDB) GetOrDefault(key, def []byte) ([]byte, error) {
+	value, found, err := mem.TryGet(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return def, nil
+	}
+	return value, nil
+}
+
+// put stores value under key in the active memtable, keeping track of its size.
+// The key and value are copied to the arena of the memtable, so the caller
+// may reuse its buffers. The versions it overwrites stay in the arena until
+// the memtable is dropped, so they still count in its size.
+// A value without a sequence number gets the one of the WAL entry about to be
+// appended for it. The caller must hold mu.
+func (mem *MemDB) put(key []byte, value *Value) {
+	if value.Seq == 0 {
+		value.Seq = mem.wal.seq.Load() + 1
+	}
+	value.Value = mem.arena.copy(value.Value)
+	mem.size += int64(len(value.Value))
+	if elem := mem.skiplist.Get(key); elem != nil {
+		elem.Value = value
+	} else {
+		mem.skiplist.Set(mem.arena.copy(key), value)
+		mem.size += int64(len(key))
+	}
+}
+
+// flushIfFull freezes the memtable once it outgrows Options.MemtableSizeLimit
+// and has the background flush write it to an SST file, so that writes don't
+// wait for the disk. While a frozen memtable is still being written, the
+// active one keeps growing. Write methods defer it before taking mu, so it
+// runs after mu is released.
+func (mem *MemDB) flushIfFull() {
+	if mem.flushTrigger == nil {
+		return
+	}
+
+	mem.mu.Lock()
+	full := !mem.closed.Load() && mem.immutable == nil && mem.size >= mem.opts.MemtableSizeLimit
+	var err error
+	if full {
+		err = mem.freeze()
+	}
+	mem.mu.Unlock()
+	if !full {
+		return
+	}
+	if err != nil {
+		Logger.Printf("automatic flush failed: %v", err)
+		return
+	}
+	mem.scheduleFlush()
+}
+
+// scheduleFlush wakes up the background flush, if it runs.
+func (mem *MemDB) scheduleFlush() {
+	if mem.flushTrigger == nil {
+		return
+	}
+	select {
+	case mem.flushTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// syncWrite makes a successful write durable under SyncAlways before it is
+// acknowledged. The write methods defer it before locking mu, so that the
+// fsync runs once mu is released and other reads and writes go on meanwhile.
+func (mem *MemDB) syncWrite(err *error) {
+	if *err != nil || mem.opts.SyncPolicy != SyncAlways {
+		return
+	}
+	*err = mem.wal.syncTo(mem.wal.seq.Load())
+}
+
+// waitForRoom stalls a write while the memtables hold
+// Options.WriteBufferSize bytes or more, until a flush makes room, the store
+// is closed or Options.WriteStallTimeout passes. mu must be held; it is
+// released while waiting.
+func (mem *MemDB) waitForRoom() error {
+	limit := mem.opts.WriteBufferSize
+	if limit <= 0 || mem.size+mem.immutableSize < limit {
+		return nil
+	}
+	mem.writeStalls.Add(1)
+	start := time.Now()
+	defer func() { mem.stallNanos.Add(int64(time.Since(start))) }()
+
+	var timeout <-chan time.Time
+	if mem.opts.WriteStallTimeout > 0 {
+		timer := time.NewTimer(mem.opts.WriteStallTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for mem.size+mem.immutableSize >= limit {
+		// Make room by flushing the active memtable, unless one already is.
+		if mem.immutable == nil {
+			if err := mem.freeze(); err != nil {
+				return err
+			}
+			mem.scheduleFlush()
+		}
+
+		if mem.flushed == nil {
+			mem.flushed = make(chan struct{})
+		}
+		flushed := mem.flushed
+		mem.mu.Unlock()
+		select {
+		case <-flushed:
+		case <-timeout:
+			mem.mu.Lock()
+			return fmt.Errorf("%w for %v with %d bytes in the memtables", ErrWriteStall, mem.opts.WriteStallTimeout, mem.size+mem.immutableSize)
+		}
+		mem.mu.Lock()
+		if mem.closed.Load() {
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// wakeStalledWrites wakes up the writes waiting in waitForRoom, so that they
+// check the memtables again. mu must be held.
+func (mem *MemDB) wakeStalledWrites() {
+	if mem.flushed != nil {
+		close(mem.flushed)
+		mem.flushed = nil
+	}
+}
+
+// flushInBackground writes the memtables frozen by flushIfFull to SST files,
+// until mem.stopFlush is closed. A failed flush is logged, and retried once a
+// write finds the memtable full again.
+func (mem *MemDB) flushInBackground() {
+	defer mem.flushers.Done()
+	for {
+		select {
+		case <-mem.stopFlush:
+			return
+		case <-mem.flushTrigger:
+		}
+
+		mem.flushMu.Lock()
+		err := mem.flushImmutable()
+		mem.flushMu.Unlock()
+		if err != nil {
+			if !errors.Is(err, ErrClosed) {
+				Logger.Printf("automatic flush failed: %v", err)
+			}
+			continue
+		}
+		// The active memtable may have filled up during the flush.
+		mem.flushIfFull()
+	}
+}
+
+// memtables returns the memtables, the active one first followed by the one
+// being flushed, if any. mu must be held.
+func (mem *MemDB) memtables() []*skiplist.SkipList {
+	if mem.immutable == nil {
+		return []*skiplist.SkipList{mem.skiplist}
+	}
+	return []*skiplist.SkipList{mem.skiplist, mem.immutable}
+}
+
+// memtableValue returns the newest version of key in the memtable layers,
+// tombstones included, or nil if the key has to be looked up in the SST
+// files. mu must be held.
+func (mem *MemDB) memtableValue(key []byte) *Value {
+	for _, list := range mem.memtables() {
+		if elem := list.Get(key); elem != nil {
+			return elem.Value.(*Value)
+		}
+	}
+	return nil
+}
+
+// Has reports whether key holds a live value, without reading the value itself.
+func (mem *MemDB) Has(key []byte) (bool, error) {
+	if mem.closed.Load() {
+		return false, ErrClosed
+	}
+	mem.reads.Add(1)
+
+	mem.mu.RLock()
+	value := mem.memtableValue(key)
+	mem.mu.RUnlock()
+	if value != nil {
+		return value.live(), nil
+	}
+
+	v := mem.manifest.pin()
+	defer mem.manifest.unpin(v)
+	_, n, err := findInSSTFiles(mem.tables, key, v.files)
+	return n == 1, err
+}
+
+// MultiGet retrieves the values of several keys at once. Missing or deleted keys
+// get a nil value. Each SST file is opened and scanned at most once.
+func (mem *MemDB) MultiGet(keys [][]byte) ([][]byte, error) {
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	mem.reads.Add(int64(len(keys)))
+	values := make([][]byte, len(keys))
+
+	// Resolve what the memtable can answer and collect the remaining keys by name.
+	pending := make(map[string][]int)
+	mem.mu.RLock()
+	for i, key := range keys {
+		value := mem.memtableValue(key)
+		if value == nil {
+			pending[string(key)] = append(pending[string(key)], i)
+			continue
+		}
+		if value.live() {
+			values[i] = value.Value
+		}
+	}
+	mem.mu.RUnlock()
+
+	// The newest versions found so far, see findInSSTFiles.
+	found := make(map[string]SSTPair)
+	v := mem.manifest.pin()
+	defer mem.manifest.unpin(v)
+	for _, f := range v.files {
+		if len(pending) == 0 {
+			break
+		}
+
+		// Only look up the keys in the range of the file that it may hold a
+		// newer version of, sorted so the file can be scanned in one pass.
+		var sorted [][]byte
+		for key := range pending {
+			if pair, ok := found[key]; ok && f.largestSeq <= pair.Seq {
+				continue
+			}
+			if f.contains([]byte(key)) {
+				sorted = append(sorted, []byte(key))
+			}
+		}
+		if len(sorted) == 0 {
+			continue
+		}
+		sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+		t, err := mem.tables.get(f)
+		if err != nil {
+			return nil, err
+		}
+		err = t.reader.lookupSorted(sorted, func(i int, pair SSTPair) {
+			key := string(sorted[i])
+			if newest, ok := found[key]; !ok || pair.Seq > newest.Seq {
+				found[key] = pair
+			}
+		})
+		mem.tables.release(t)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SST file %s: %w", f.name(), err)
+		}
+	}
+
+	for key, pair := range found {
+		if pair.Operation == setOperation && !expired(pair.ExpiresAt) {
+			pair, err := mem.tables.values.resolve([]byte(key), pair)
+			if err != nil {
+				return nil, err
+			}
+			for _, idx := range pending[key] {
+				values[idx] = pair.Value
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// CopyOptions configures Copy.
+type CopyOptions struct {
+	// TTL, if positive, makes the copy expire once it elapses. Otherwise the
+	// copy expires with the source, if ever.
+	TTL time.Duration
+}
+
+// Copy duplicates the current value of src under dst, atomically: no write
+// to either key comes in between.
+func (mem *MemDB) Copy(src, dst []byte, opts CopyOptions) error {
+	return mem.write("", func() error { return mem.copy(src, dst, opts) })
+}
+
+// copy is Copy under mu.
+func (mem *MemDB) copy(src, dst []byte, opts CopyOptions) error {
+	if opts.TTL < 0 {
+		return errors.New("TTL must not be negative")
+	}
+	pair, err := mem.getPair(src)
+	if err != nil {
+		return err
+	}
+	expiresAt := pair.ExpiresAt
+	if opts.TTL > 0 {
+		expiresAt = now() + int64(opts.TTL)
+	}
+	return mem.setExpiring(dst, pair.Value, expiresAt)
+}
+
+// getPair is get returning the expiration of the value too. mu must be held.
+func (mem *MemDB) getPair(key []byte) (SSTPair, error) {
+	mem.reads.Add(1)
+	if value := mem.memtableValue(key); value != nil {
+		if !value.live() {
+			return SSTPair{}, ErrKeyNotFound
+		}
+		return SSTPair{Operation: value.Operation, Value: value.Value, ExpiresAt: value.ExpiresAt, Seq: value.Seq}, nil
+	}
+
+	v := mem.manifest.pin()
+	defer mem.manifest.unpin(v)
+	pair, n, err := findInSSTFiles(mem.tables, key, v.files)
+	switch n {
+	case 1:
+		return mem.tables.values.resolve(key, pair)
+	case -1, -2:
+		return SSTPair{}, fmt.Errorf("%w: '%s'", ErrKeyNotFound, key)
+	}
+	return SSTPair{}, err
+}
+
+// CompareAndSwap sets key to newValue only if its current value equals expected.
+// A nil expected value means the key must not exist. It reports whether the swap happened.
+func (mem *MemDB) CompareAndSwap(key, expected, newValue []byte) (swapped bool, err error) {
+	err = mem.write("", func() (err error) {
+		swapped, err = mem.compareAndSwap(key, expected, newValue)
+		return err
+	})
+	return swapped, err
+}
+
+// compareAndSwap is CompareAndSwap under mu.
+func (mem *MemDB) compareAndSwap(key, expected, newValue []byte) (bool, error) {
+	current, err := mem.get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return false, err
+	}
+	found := err == nil
+
+	if expected == nil {
+		if found {
+			return false, nil
+		}
+	} else if !found || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+
+	if err := mem.set(key, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOrSet returns the live value of key if there is one, and otherwise stores
+// value under key. loaded reports whether the existing value was returned.
+func (mem *MemDB) GetOrSet(key, value []byte) (actual []byte, loaded bool, err error) {
+	err = mem.write("", func() (err error) {
+		actual, loaded, err = mem.getOrSet(key, value)
+		return err
+	})
+	return actual, loaded, err
+}
+
+// getOrSet is GetOrSet under mu.
+func (mem *MemDB) getOrSet(key, value []byte) ([]byte, bool, error) {
+	current, err := mem.get(key)
+	if err == nil {
+		return current, true, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, false, err
+	}
+
+	if err := mem.set(key, value); err != nil {
+		return nil, false, err
+	}
+	return value, false, nil
+}
+
+// Incr atomically adds delta to the integer stored under key and returns the new
+// value. A missing key counts as 0. The result is stored without expiration.
+func (mem *MemDB) Incr(key []byte, delta int64) (n int64, err error) {
+	err = mem.write("", func() (err error) {
+		n, err = mem.incr(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// incr is Incr under mu.
+func (mem *MemDB) incr(key []byte, delta int64) (int64, error) {
+	var current int64
+	value, err := mem.get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return 0, err
+	}
+	if err == nil {
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of key '%s' is not an integer", key)
+		}
+	}
+
+	// Refuse to silently wrap around.
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, fmt.Errorf("incrementing key '%s' by %d overflows", key, delta)
+	}
+	current += delta
+
+	if err := mem.set(key, []byte(strconv.FormatInt(current, 10))); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+func (mem *MemDB) Del(key []byte) (value []byte, err error) {
+	err = mem.write("", func() (err error) {
+		value, err = mem.del(key)
+		return err
+	})
+	return value, err
+}
+
+// del is Del under mu.
+func (mem *MemDB) del(key []byte) ([]byte, error) {
+	value, err := mem.get(key)
+	if err != nil {
+		return nil, err
+	}
+	mem.put(key, NewValue("DEL", value))
+
+	// Write the operation to the WAL
+	err = mem.wal.AppendEntry(WatermarkPlaceholder, "DEL", key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Flush writes the memtable to an SST file, like FlushToDisk.
+func (mem *MemDB) Flush() error {
+	return mem.FlushToDisk()
+}
+
+func (mem *MemDB) FlushToDisk() error {
+	mem.flushMu.Lock()
+	defer mem.flushMu.Unlock()
+	return mem.flush()
+}
+
+// flush writes the memtables to SST files, the one frozen by a write first,
+// if any, then the active one. flushMu must be held.
+func (mem *MemDB) flush() error {
+	if err := mem.flushImmutable(); err != nil {
+		return err
+	}
+
+	mem.mu.Lock()
+	if mem.closed.Load() {
+		mem.mu.Unlock()
+		return ErrClosed
+	}
+	if mem.skiplist.Len() == 0 {
+		mem.mu.Unlock()
+		return nil
+	}
+	err := mem.freeze()
+	mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return mem.flushImmutable()
+}
+
+// freeze makes the active memtable the immutable one and starts a new WAL
+// segment, so that the writes that follow go to a fresh memtable and a
+// segment that the flush keeps. mu must be held and immutable be nil.
+func (mem *MemDB) freeze() error {
+	seq := mem.wal.seq.Load()
+	segment, err := mem.wal.Rotate()
+	if err != nil {
+		mem.flushes.record(err)
+		return err
+	}
+	mem.immutable, mem.immutableSize = mem.skiplist, mem.size
+	mem.immutableSegment, mem.immutableSeq = segment, seq
+	mem.skiplist = skiplist.New(skiplist.Bytes)
+	mem.size = 0
+	// The frozen memtable keeps the chunks of its arena until it is dropped.
+	mem.arena = arena{}
+	return nil
+}
+
+// flushImmutable writes the immutable memtable, if any, to an SST file, then
+// checkpoints the WAL segments of its entries. flushMu must be held.
+func (mem *MemDB) flushImmutable() error {
+	mem.mu.Lock()
+	if mem.closed.Load() {
+		mem.mu.Unlock()
+		return ErrClosed
+	}
+	list := mem.immutable
+	mem.mu.Unlock()
+	if list == nil {
+		return nil
+	}
+
+	if err := mem.writeSST(list); err != nil {
+		mem.restoreImmutable()
+		mem.flushes.record(err)
+		return err
+	}
+
+	// Checkpoint the WAL segments of the frozen entries, now that the SST file covers them
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.immutable, mem.immutableSize = nil, 0
+	mem.wakeStalledWrites()
+
+	err := mem.wal.Checkpoint(mem.immutableSegment, mem.immutableSeq)
+	mem.flushes.record(err)
+	mem.scheduleCompaction()
+	return err
+}
+
+// restoreImmutable merges the memtable of a failed flush back into the active
+// one, keeping the newer versions written during the flush.
+func (mem *MemDB) restoreImmutable() {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for elem := mem.immutable.Front(); elem != nil; elem = elem.Next() {
+		// Snapshots may share the value, which put changes.
+		if key := elem.Key().([]byte); mem.skiplist.Get(key) == nil {
+			value := *elem.Value.(*Value)
+			mem.put(key, &value)
+		}
+	}
+	mem.immutable, mem.immutableSize = nil, 0
+	mem.wakeStalledWrites()
+}
+
+// writeSST writes the entries of the memtable list to a new SST file of
+// level 0, then adds it to the manifest. They are streamed to the file as the
+// list is walked, without being copied.
+func (mem *MemDB) writeSST(list *skiplist.SkipList) error {
+	// Create a new SST file
+	number := mem.manifest.newFileNumber()
+	file, err := os.Create(filepath.Join(mem.sstDir, sstFileName(0, number)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sstFile := &SSTFile{File: file, compression: mem.opts.SSTCompression, filterBitsPerKey: mem.opts.FilterBitsPerKey}
+
+	// The list is sorted, so it gives the range of the keys up front.
+	smallestKey, ok := list.Front().Key().([]byte)
+	if !ok {
+		return errors.New("key is not of type []byte")
+	}
+	longestKey, ok := list.Back().Key().([]byte)
+	if !ok {
+		return errors.New("key is not of type []byte")
+	}
+	header := SSTFileHeader{
+		Magic:       []byte("SSTF"),
+		EntryCount:  uint32(list.Len()),
+		SmallestKey: smallestKey,
+		LongestKey:  longestKey,
+		Version:     sstVersion,
+	}
+	w, err := sstFile.NewWriter(header)
+	if err != nil {
+		return err
+	}
+
+	// Large values go to the value log, opened on the first one.
+	var values *valueLogWriter
+	defer func() {
+		if values != nil {
+			values.file.Close()
+		}
+	}()
+
+	for elem := list.Front(); elem != nil; elem = elem.Next() {
+		key, ok := elem.Key().([]byte)
+		if !ok {
+			// Handle the case where the key is not of type []byte
+			return errors.New("key is not of type []byte")
+		}
+		value, ok := elem.Value.(*Value)
+		if !ok {
+			return errors.New("value is not of type *Value")
+		}
+		pair := SSTPair{Operation: value.Operation, Value: value.Value, ExpiresAt: value.ExpiresAt, Seq: value.Seq}
+		if threshold := mem.opts.ValueLogThreshold; threshold > 0 && value.Operation == setOperation && len(value.Value) >= threshold {
+			if values == nil {
+				if values, err = openValueLogWriter(mem.sstDir); err != nil {
+					return err
+				}
+			}
+			ptr, err := values.add(key, value.Value)
+			if err != nil {
+				return err
+			}
+			pair.Value, pair.blob = nil, &ptr
+		}
+		if err := w.Add(key, pair); err != nil {
+			return err
+		}
+	}
+	if err := w.Finish(); err != nil {
+		return err
+	}
+
+	// The values must be durable before the file pointing to them is.
+	if values != nil {
+		err := values.finish()
+		values = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	// Make the SST file durable before the WAL entries it covers are checkpointed
+	start := time.Now()
+	if err := sstFile.File.Sync(); err != nil {
+		return err
+	}
+	mem.sstSyncs.observe(int64(list.Len()), time.Since(start))
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return mem.manifest.apply(manifestEdit{add: []*sstMeta{{
+		level:       0,
+		number:      number,
+		size:        info.Size(),
+		smallest:    smallestKey,
+		largest:     longestKey,
+		smallestSeq: w.smallestSeq,
+		largestSeq:  w.largestSeq,
+		entries:     w.count,
+		deletions:   w.deletions,
+	}}})
+}
+
+func (mem *MemDB) Load() (err error) {
+	stats := RecoveryStats{}
+	defer func() {
+		mem.mu.Lock()
+		mem.recovery = stats
+		mem.mu.Unlock()
+		Logger.Printf("WAL recovery: %d entries applied, %d skipped (checkpointed), %d discarded (%d bytes)",
+			stats.Applied, stats.Skipped, stats.Discarded, stats.DiscardedBytes)
+		for _, hook := range mem.replayHooks {
+			if hook.Done != nil {
+				hook.Done(stats, err)
+			}
+		}
+	}()
+
+	paths, err := mem.wal.segments()
+	if err != nil {
+		return err
+	}
+
+	// Replay the segments oldest first, so later writes win.
+	for _, path := range paths {
+		if err := mem.loadSegment(path, &stats); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minWALEntrySize is the size of the smallest entry: its watermark, opcode,
+// and the lengths of an empty key and value.
+const minWALEntrySize = 4 + 1 + 4 + 4
+
+// tornEntry reports whether err, reading an entry with left bytes before the
+// end of a segment of size bytes, comes from an append cut short: the entry
+// runs past the end of the segment, is too short to be an entry, or is the
+// last one and doesn't match its checksum. next is the offset after the
+// entry, if known.
+func tornEntry(err error, next, left, size int64) bool {
+	var checksumErr *ChecksumError
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &checksumErr):
+		return next == size
+	}
+	return left < minWALEntrySize
+}
+
+// loadSegment replays the entries of a single WAL segment into the memtable.
+func (mem *MemDB) loadSegment(path string, stats *RecoveryStats) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Get the current file size.
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := fileInfo.Size()
+
+	// If the file is empty, there is nothing to load.
+	if fileSize == 0 {
+		return nil
+	}
+
+	// Skip the header of the segment.
+	start, err := readWALHeader(file)
+	if err != nil {
+		return err
+	}
+
+	// Iterate through the entire segment, up to its footer.
+	for offset := start; offset < fileSize && !footerAt(file, offset); {
+		entry, nextOffset, watermark, err := readWALEntryAt(file, offset)
+		if err != nil {
+			// Everything from this offset on can't be replayed.
+			stats.Discarded++
+			stats.DiscardedBytes = fileSize - offset
+			if path != mem.wal.path || !tornEntry(err, nextOffset, fileSize-offset, fileSize) {
+				Logger.Printf("WAL recovery: unreadable entry in %s at offset %d: %v", filepath.Base(path), offset, err)
+				return err
+			}
+
+			// A crash in the middle of an append leaves a torn entry at the
+			// end of the active segment. Drop it so new entries follow the
+			// last good one. Entries damaged before the end are reported
+			// instead, as truncating would drop the good ones after them.
+			Logger.Printf("WAL recovery: warning: truncating %s at offset %d, dropping %d bytes: %v",
+				filepath.Base(path), offset, fileSize-offset, err)
+			return mem.wal.truncate(offset)
+		}
+
+		// Keep numbering new entries after the replayed ones.
+		if entry.Seq > mem.wal.seq.Load() {
+			mem.wal.seq.Store(entry.Seq)
+		}
+
+		// Check if the entry has the watermark placeholder, and wasn't
+		// flushed before the last checkpoint.
+		if watermark == WatermarkPlaceholder && (entry.Seq == 0 || entry.Seq > mem.wal.flushedSeq) {
+			switch entry.Operation {
+			case "SET":
+				mem.replay(entry.Seq, entry.Key, NewValue("SET", entry.Value))
+			case "DEL":
+				mem.replay(entry.Seq, entry.Key, NewValue("DEL", entry.Value))
+			case ttlOperation:
+				expiresAt, value, err := decodeTTLValue(entry.Value)
+				if err != nil {
+					stats.Discarded++
+					stats.DiscardedBytes = fileSize - offset
+					return err
+				}
+				mem.replay(entry.Seq, entry.Key, &Value{Operation: "SET", Value: value, ExpiresAt: expiresAt})
+			case txnOperation:
+				entries, err := decodeTxnBatch(entry.Value)
+				if err != nil {
+					stats.Discarded++
+					stats.DiscardedBytes = fileSize - offset
+					return err
+				}
+				for _, write := range entries {
+					mem.replay(entry.Seq, write.Key, batchValue(write))
+				}
+			default:
+				stats.Discarded++
+				stats.DiscardedBytes = fileSize - offset
+				return errors.New("unknown operation in WAL")
+			}
+			stats.Applied++
+		} else {
+			// Entries behind the watermark or the checkpoint are already flushed to an SST file.
+			stats.Skipped++
+		}
+
+		// Break out of the loop if nextOffset is beyond the file size.
+		if nextOffset >= fileSize {
+			break
+		}
+
+		// Move to the next entry.
+		offset = nextOffset
+	}
+
+	return nil
+}
+
+// RecoveryStats returns what the last Load did with the entries of the WAL.
+// OpenWithOptions runs Load before returning the store, so the stats are
+// final from then on, describing the recovery of the open. A later call of
+// Load replaces them once it is done, never while it runs. Replay hooks get
+// the same stats from ReplayHook.Done, before the store is returned.
+func (mem *MemDB) RecoveryStats() RecoveryStats {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	return mem.recovery
+}
+
+// LastSeq returns the sequence number of the last write, which is
+// incremented by every write appended to the WAL, including across restarts.
+func (mem *MemDB) LastSeq() uint64 {
+	return mem.wal.seq.Load()
+}
+
+// findValueInSSTFiles searches the SST files for a given key.
+func findValueInSSTFiles(tables *tableCache, key []byte, files []*sstMeta) ([]byte, error) {
+	pair, n, err := findInSSTFiles(tables, key, files)
+	switch n {
+	case 1:
+		pair, err = tables.values.resolve(key, pair)
+		return pair.Value, err
+	case -1:
+		return nil, fmt.Errorf("%w: '%s' deleted", ErrKeyNotFound, key)
+	case -2:
+		return nil, fmt.Errorf("%w: '%s' not in any SST file", ErrKeyNotFound, key)
+	}
+	return nil, err
+}
+
+// findInSSTFiles returns the newest version of key in the SST files, ordered
+// as the manifest lists them, with the codes of SSTFile.Get: 1 for a live
+// value, -1 for a deletion or an expired value, -2 if no file holds the key
+// and 0 on errors. Versions are ordered by sequence number, the file listed
+// first winning ties, so once one is found only the files that may hold a
+// newer one are read. Files flushed one after the other never do. With
+// Options.ParallelLookups, several files are probed at once.
+func findInSSTFiles(tables *tableCache, key []byte, files []*sstMeta) (SSTPair, int, error) {
+	if tables.lookupWorkers > 1 {
+		return findInSSTFilesParallel(tables, key, files, tables.lookupWorkers)
+	}
+
+	var (
+		newest SSTPair
+		code   = -2
+	)
+	for _, f := range files {
+		// Files whose range excludes key, or newer versions of it, are skipped
+		// without being opened.
+		if !f.contains(key) || (code != -2 && f.largestSeq <= newest.Seq) {
+			continue
+		}
+		pair, n, err := probeSST(tables, key, f)
+		if n == 0 {
+			return SSTPair{}, 0, err
+		}
+		if n != -2 && (code == -2 || pair.Seq > newest.Seq) {
+			newest, code = pair, n
+		}
+	}
+	return newest, code, nil
+}
+
+// probeSST looks key up in SST file f, with the codes of findInSSTFiles.
+func probeSST(tables *tableCache, key []byte, f *sstMeta) (SSTPair, int, error) {
+	t, err := tables.get(f)
+	if err != nil {
+		return SSTPair{}, 0, fmt.Errorf("error reading SST file %s: %w", f.name(), err)
+	}
+	pair, n, err := t.reader.find(key)
+	tables.release(t)
+	if n == 0 {
+		return SSTPair{}, 0, fmt.Errorf("error reading SST file %s: %w", f.name(), err)
+	}
+	return pair, n, nil
+}
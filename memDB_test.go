@@ -0,0 +1,973 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the files written by the tests out of the user's data directory.
+	dir, err := os.MkdirTemp("", "kvstore_test")
+	if err != nil {
+		fmt.Println("Error creating data directory:", err)
+		os.Exit(1)
+	}
+	DataDir = dir
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestMemDBFlushToDisk(t *testing.T) {
+	// Create a new MemDB
+	mem, err := NewMemDBtest()
+	if err != nil {
+		t.Fatalf("Error creating MemDB: %v", err)
+	}
+	defer mem.Close()
+
+	// Insert some data into the MemDB
+	mem.Set([]byte("apple"), []byte("fruit"))
+	mem.Set([]byte("banana"), []byte("yellow"))
+	mem.Set([]byte("cherry"), []byte("red"))
+
+	// Define the expected content of the SST file
+	header := appendChecksum(append([]byte("SSTF"),
+		byte(0), byte(0), byte(0), byte(3), // Entry count
+		0, 0, 0, 5, // Smallest key length
+		'a', 'p', 'p', 'l', 'e', // Smallest key
+		0, 0, 0, 6, // Longest key length
+		'c', 'h', 'e', 'r', 'r', 'y', // Longest key
+		0, 12, // Version
+	))
+	block := appendChecksum([]byte{
+		byte(opSet), // Operation
+		0, 5,        // Tuple 1 shared prefix and suffix lengths
+		'a', 'p', 'p', 'l', 'e', // Tuple 1 key suffix
+		1,          // Tuple 1 sequence number
+		0, 0, 0, 5, // Tuple 1 value length
+		'f', 'r', 'u', 'i', 't', // Tuple 1 value
+		byte(opSet), // Operation
+		0, 6,        // Tuple 2 shared prefix and suffix lengths
+		'b', 'a', 'n', 'a', 'n', 'a', // Tuple 2 key suffix
+		2,          // Tuple 2 sequence number
+		0, 0, 0, 6, // Tuple 2 value length
+		'y', 'e', 'l', 'l', 'o', 'w', // Tuple 2 value
+		byte(opSet), // Operation
+		0, 6,        // Tuple 3 shared prefix and suffix lengths
+		'c', 'h', 'e', 'r', 'r', 'y', // Tuple 3 key suffix
+		3,          // Tuple 3 sequence number
+		0, 0, 0, 3, // Tuple 3 value length
+		'r', 'e', 'd', // Tuple 3 value
+		0, 0, 0, 0, // Restart point 1
+		0, 0, 0, 1, // Restart point count
+		byte(NoCompression), // Block 1 compression
+	})
+	index := appendChecksum([]byte{
+		indexOfBlocks, // Index kind
+		0, 0, 0, 1,    // Index: block count
+		0, 0, 0, 5, // Block 1 first key length
+		'a', 'p', 'p', 'l', 'e', // Block 1 first key
+		0, 0, 0, 0, 0, 0, 0, 33, // Block 1 offset
+		0, 0, 0, 68, // Block 1 size
+	})
+	// The bloom filter of the keys, a single partition of 64 bits.
+	filter := encodeFilter([]uint64{bloomHash([]byte("apple")), bloomHash([]byte("banana")), bloomHash([]byte("cherry"))}, DefaultFilterBitsPerKey)
+	filterIndex := appendChecksum([]byte{
+		indexOfBlocks, // Index kind
+		0, 0, 0, 1,    // Filter index: partition count
+		0, 0, 0, 5, // Partition 1 first key length
+		'a', 'p', 'p', 'l', 'e', // Partition 1 first key
+		0, 0, 0, 0, 0, 0, 0, 101, // Partition 1 offset
+		0, 0, 0, 13, // Partition 1 size
+	})
+	footer := []byte{
+		0, 0, 0, 0, 0, 0, 0, 144, // Index offset
+		0, 0, 0, 30, // Index size
+		0, 0, 0, 0, 0, 0, 0, 114, // Filter offset
+		0, 0, 0, 30, // Filter size
+		0, 0, 0, 0, 0, 0, 0, 1, // Smallest sequence number
+		0, 0, 0, 0, 0, 0, 0, 3, // Largest sequence number
+		0, 12, // Version
+		'S', 'S', 'T', 'F', 'O', 'O', 'T', 'R', // Footer magic
+	}
+	var expectedContent []byte
+	for _, part := range [][]byte{header, block, filter, filterIndex, index, footer} {
+		expectedContent = append(expectedContent, part...)
+	}
+
+	// Call the flushToDisk function
+	err = mem.FlushToDisk()
+	if err != nil {
+		t.Fatalf("Error flushing MemDB to disk: %v", err)
+	}
+	// Open the SST file the manifest lists
+	files := mem.manifest.current()
+	if len(files) != 1 || files[0].name() != "L0-000001.sst" {
+		t.Fatalf("Expected the manifest to list L0-000001.sst, got %d files", len(files))
+	}
+	file, err := os.Open(filepath.Join(mem.sstDir, files[0].name()))
+	if err != nil {
+		t.Fatalf("Error opening SST file: %v", err)
+	}
+	defer file.Close()
+
+	// Get file size
+	fileInfo, err := file.Stat()
+	if err != nil {
+		t.Fatalf("Error getting file info: %v", err)
+	}
+	fileSize := fileInfo.Size()
+
+	// Read file content
+	fileContent := make([]byte, fileSize)
+	_, err = file.Read(fileContent)
+	if err != nil {
+		t.Fatalf("Error reading SST file: %v", err)
+	}
+
+	// Verify file content
+	if !reflect.DeepEqual(fileContent, expectedContent) {
+		t.Errorf("File content does not match expected content")
+	}
+}
+
+func TestFlushDuringWrites(t *testing.T) {
+	mem := NewTempDB(t)
+
+	const writers, perWriter = 4, 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					key := fmt.Sprintf("w%d-%03d", w, i)
+					if err := mem.Set([]byte(key), []byte(key)); err != nil {
+						t.Errorf("Error setting %s: %v", key, err)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+	}()
+
+	// Flush repeatedly while the writers are running.
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatalf("Error flushing: %v", err)
+		}
+	}
+	mem.Close()
+
+	// Every write must come back exactly once, either from an SST file or from the WAL.
+	reopened, err := Open(mem.opts.DataDir)
+	if err != nil {
+		t.Fatalf("Error reopening MemDB: %v", err)
+	}
+	defer reopened.Close()
+
+	flushed := 0
+	for _, f := range reopened.manifest.current() {
+		file, err := os.Open(filepath.Join(mem.sstDir, f.name()))
+		if err != nil {
+			t.Fatalf("Error opening SST file: %v", err)
+		}
+		header, err := (&SSTFile{File: file}).readHeader()
+		file.Close()
+		if err != nil {
+			t.Fatalf("Error reading SST header: %v", err)
+		}
+		flushed += int(header.EntryCount)
+	}
+	if total := flushed + reopened.RecoveryStats().Applied; total != writers*perWriter {
+		t.Errorf("Expected %d writes, found %d flushed and %d replayed", writers*perWriter, flushed, reopened.RecoveryStats().Applied)
+	}
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			key := fmt.Sprintf("w%d-%03d", w, i)
+			if value, err := reopened.Get([]byte(key)); err != nil || string(value) != key {
+				t.Fatalf("Expected %s to survive, got %q (%v)", key, value, err)
+			}
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MemtableSizeLimit: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Readers, writers and flushes share the store; run with -race.
+	const writers, perWriter = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d-%03d", w, i))
+				if err := mem.Set(key, key); err != nil {
+					t.Errorf("Error setting %s: %v", key, err)
+				}
+				if i%10 == 0 {
+					if _, err := mem.Del(key); err != nil {
+						t.Errorf("Error deleting %s: %v", key, err)
+					}
+				}
+				if _, err := mem.Incr([]byte("counter"), 1); err != nil {
+					t.Errorf("Error incrementing: %v", err)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := []byte(fmt.Sprintf("w%d-%03d", r, i))
+				if value, err := mem.Get(key); err == nil && !bytes.Equal(value, key) {
+					t.Errorf("Expected %s, got %q", key, value)
+				} else if err != nil && !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("Error getting %s: %v", key, err)
+				}
+				if _, err := mem.Has(key); err != nil {
+					t.Errorf("Error checking %s: %v", key, err)
+				}
+				if i%50 == 0 {
+					it, err := mem.Scan([]byte(fmt.Sprintf("w%d-", r)), nil)
+					if err != nil {
+						t.Errorf("Error scanning: %v", err)
+						continue
+					}
+					for it.Next() {
+					}
+					it.Close()
+					if _, err := mem.Stats(); err != nil {
+						t.Errorf("Error computing stats: %v", err)
+					}
+					if err := mem.FlushToDisk(); err != nil {
+						t.Errorf("Error flushing: %v", err)
+					}
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if value, err := mem.Get([]byte("counter")); err != nil || string(value) != fmt.Sprint(writers*perWriter) {
+		t.Errorf("Expected every increment counted, got %q (%v)", value, err)
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			key := []byte(fmt.Sprintf("w%d-%03d", w, i))
+			value, err := mem.Get(key)
+			if i%10 == 0 {
+				if !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("Expected %s deleted, got %q (%v)", key, value, err)
+				}
+			} else if err != nil || !bytes.Equal(value, key) {
+				t.Errorf("Expected %s, got %q (%v)", key, value, err)
+			}
+		}
+	}
+}
+
+func TestLoadRecoveryStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp(".", "wal_test")
+	if err != nil {
+		t.Fatal("Error creating temporary file:", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	wal, err := NewWAL(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	// One flushed entry followed by two live ones.
+	wal.AppendEntry(Watermark, "SET", []byte("old"), []byte("1"))
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("new"), []byte("2"))
+	wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte("new"), []byte("2"))
+
+	mem := &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: wal}
+	if err := mem.Load(); err != nil {
+		t.Fatalf("Error loading WAL: %v", err)
+	}
+
+	stats := mem.RecoveryStats()
+	if stats.Applied != 2 || stats.Skipped != 1 || stats.Discarded != 0 {
+		t.Errorf("Unexpected recovery stats: %+v", stats)
+	}
+
+	// A torn entry at the tail is reported as discarded and truncated away.
+	info, _ := wal.file.Stat()
+	wal.file.Write([]byte{0, 0, 0, 0, 'S', 'E'})
+	mem = &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: wal}
+	if err := mem.Load(); err != nil {
+		t.Fatalf("Error loading a torn WAL: %v", err)
+	}
+	stats = mem.RecoveryStats()
+	if stats.Applied != 2 || stats.Discarded != 1 || stats.DiscardedBytes != 6 {
+		t.Errorf("Unexpected recovery stats: %+v", stats)
+	}
+	if truncated, _ := wal.file.Stat(); truncated.Size() != info.Size() {
+		t.Errorf("Expected the WAL truncated to %d bytes, got %d", info.Size(), truncated.Size())
+	}
+}
+
+func TestRecoveryStatsOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		mem.Set([]byte(fmt.Sprint(i)), []byte("value"))
+	}
+	mem.Close()
+
+	// The stats are final once the store is returned, and those the replay
+	// hooks got.
+	var done RecoveryStats
+	mem, err = OpenWithOptions(Options{DataDir: dir, ReplayHooks: []ReplayHook{{Done: func(stats RecoveryStats, err error) { done = stats }}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if stats := mem.RecoveryStats(); stats.Applied != 5 || stats != done {
+		t.Errorf("Expected 5 entries applied, as the hook was told, got %+v and %+v", stats, done)
+	}
+}
+
+func TestLoadTornTail(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+	path := mem.wal.path
+	mem.Close()
+
+	// Cut the last entry short, as a crash in the middle of an append would.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Error reopening a store with a torn WAL: %v", err)
+	}
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Expected the entry before the torn one, got %q (%v)", value, err)
+	}
+	if _, err := mem.Get([]byte("b")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the torn entry to be dropped, got %v", err)
+	}
+
+	// Entries appended after the recovery are readable again.
+	mem.Set([]byte("c"), []byte("3"))
+	mem.Close()
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Error reopening after recovery: %v", err)
+	}
+	defer mem.Close()
+	if value, err := mem.Get([]byte("c")); err != nil || string(value) != "3" {
+		t.Errorf("Expected the entry written after the recovery, got %q (%v)", value, err)
+	}
+}
+
+func TestLoadCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+	path := mem.wal.path
+	mem.Close()
+
+	// Garble the key length of the last entry, which takes 4+8+1+4+1+4+1+4 = 27 bytes.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte{0x7f, 0xff, 0xff, 0xff}, info.Size()-27+13)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Error reopening a store with a corrupt entry length: %v", err)
+	}
+	defer mem.Close()
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Expected the entry before the corrupt one, got %q (%v)", value, err)
+	}
+	if _, err := mem.Get([]byte("b")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the corrupt entry to be dropped, got %v", err)
+	}
+}
+
+func TestLoadCorruptMiddle(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+	mem.Set([]byte("c"), []byte("3"))
+	path := mem.wal.path
+	mem.Close()
+
+	// Flip the value of the middle entry, which of its 27 bytes comes after
+	// 4+8+1+4+1+4 = 22.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte{'x'}, info.Size()-2*27+22)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries after the damaged one are kept, for the store to be
+	// repaired rather than losing them.
+	var checksumErr *ChecksumError
+	if _, err := Open(dir); !errors.As(err, &checksumErr) {
+		t.Fatalf("Expected a checksum error opening a store with a corrupt entry, got %v", err)
+	}
+	if after, err := os.Stat(path); err != nil || after.Size() != info.Size() {
+		t.Errorf("Expected the WAL left at %d bytes, got %v (%v)", info.Size(), after.Size(), err)
+	}
+}
+
+// newTempMemDB returns an empty MemDB backed by a temporary WAL file.
+func newTempMemDB(t *testing.T) *MemDB {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wal.Close() })
+
+	manifest, err := openManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: wal, sstDir: dir, manifest: manifest, tables: newTableCache(dir, 0)}
+}
+
+func TestWriteStall(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), WriteBufferSize: 200, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if mem.opts.MemtableSizeLimit != 100 {
+		t.Errorf("Expected memtables flushed from half the write buffer, got %d", mem.opts.MemtableSizeLimit)
+	}
+
+	// With the flush held back, the memtables fill up until a write stalls.
+	mem.flushMu.Lock()
+	done := make(chan error)
+	go func() {
+		for i := 0; ; i++ {
+			if mem.writeStalls.Load() > 0 {
+				break
+			}
+			if err := mem.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("0123456789")); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- mem.Set([]byte("last"), []byte("0123456789"))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for mem.writeStalls.Load() == 0 {
+		if time.Now().After(deadline) {
+			mem.flushMu.Unlock()
+			t.Fatal("Expected a write to stall")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the write to wait for the flush, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	mem.mu.RLock()
+	if buffered := mem.size + mem.immutableSize; buffered < 200 {
+		t.Errorf("Expected the memtables to be full, got %d bytes", buffered)
+	}
+	mem.mu.RUnlock()
+
+	// The flush makes room for it.
+	mem.flushMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the stalled writes to go through, got %v", err)
+	}
+	stats, err := mem.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.WriteStalls == 0 || stats.StallTime < 20*time.Millisecond {
+		t.Errorf("Expected the stall in the stats, got %d stalls for %v", stats.WriteStalls, stats.StallTime)
+	}
+	if stats.MemtableBytes > 200 {
+		t.Errorf("Expected at most 200 bytes in the memtables, got %d", stats.MemtableBytes)
+	}
+}
+
+func TestWriteStallTimeout(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), WriteBufferSize: 100, MemtableSizeLimit: 1000, WriteStallTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	mem.flushMu.Lock()
+	for i := 0; i < 8; i++ { // Fills the write buffer.
+		if err := mem.Set([]byte(fmt.Sprintf("key%d", i)), []byte("0123456789")); err != nil {
+			t.Fatalf("Error setting key%d: %v", i, err)
+		}
+	}
+	err = mem.Set([]byte("late"), []byte("0123456789"))
+	mem.flushMu.Unlock()
+	if !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("Expected ErrWriteStall, got %v", err)
+	}
+	if _, err := mem.Get([]byte("late")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the stalled write not applied, got %v", err)
+	}
+
+	// Once flushed, writes go through again.
+	waitForFlush(t, mem)
+	if err := mem.Set([]byte("late"), []byte("0123456789")); err != nil {
+		t.Errorf("Expected the write to go through after the flush, got %v", err)
+	}
+}
+
+func TestReadLayers(t *testing.T) {
+	// The layers hold the writes of k oldest first: every SST write is
+	// flushed to its own file, then the immutable writes are frozen.
+	tests := []struct {
+		name                   string
+		sst, immutable, active []string
+		want                   string // Empty if k is missing.
+	}{
+		{name: "miss everywhere"},
+		{name: "miss in memtables, hit in SST", sst: []string{"1"}, want: "1"},
+		{name: "newer SST file", sst: []string{"1", "2"}, want: "2"},
+		{name: "deleted in newer SST file", sst: []string{"1", "DEL"}},
+		{name: "hit in immutable", sst: []string{"1"}, immutable: []string{"2"}, want: "2"},
+		{name: "deleted in immutable", sst: []string{"1"}, immutable: []string{"DEL"}},
+		{name: "hit in active", sst: []string{"1"}, immutable: []string{"2"}, active: []string{"3"}, want: "3"},
+		{name: "deleted in active", immutable: []string{"2"}, active: []string{"DEL"}},
+		{name: "set again in active", sst: []string{"1"}, immutable: []string{"DEL"}, active: []string{"3"}, want: "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewTempDB(t)
+			write := func(op string) {
+				t.Helper()
+				var err error
+				if op == "DEL" {
+					_, err = mem.Del([]byte("k"))
+				} else {
+					err = mem.Set([]byte("k"), []byte(op))
+				}
+				if err != nil {
+					t.Fatalf("Error writing %s: %v", op, err)
+				}
+			}
+
+			for _, op := range tt.sst {
+				write(op)
+				if err := mem.FlushToDisk(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, op := range tt.immutable {
+				write(op)
+			}
+			if len(tt.immutable) > 0 {
+				mem.mu.Lock()
+				err := mem.freeze()
+				mem.mu.Unlock()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, op := range tt.active {
+				write(op)
+			}
+
+			value, err := mem.Get([]byte("k"))
+			if tt.want == "" {
+				if !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("Expected k missing, got %q (%v)", value, err)
+				}
+			} else if err != nil || string(value) != tt.want {
+				t.Errorf("Expected %s, got %q (%v)", tt.want, value, err)
+			}
+			if found, err := mem.Has([]byte("k")); err != nil || found != (tt.want != "") {
+				t.Errorf("Expected Has to report %v, got %v (%v)", tt.want != "", found, err)
+			}
+			if values, err := mem.MultiGet([][]byte{[]byte("k")}); err != nil || string(values[0]) != tt.want {
+				t.Errorf("Expected MultiGet to return %q, got %q (%v)", tt.want, values, err)
+			}
+			it, err := mem.NewIterator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer it.Close()
+			if it.Next() != (tt.want != "") || (tt.want != "" && string(it.Value()) != tt.want) {
+				t.Errorf("Expected the iterator to agree with Get on %q", tt.want)
+			}
+		})
+	}
+}
+
+// waitForFlush waits for the background flush of the frozen memtable, if any.
+func waitForFlush(t *testing.T, mem *MemDB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mem.mu.Lock()
+		pending := mem.immutable != nil
+		mem.mu.Unlock()
+		if !pending {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the frozen memtable to be flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackgroundFlush(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MemtableSizeLimit: 100, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Hold the flush back: writes must not wait for it.
+	mem.flushMu.Lock()
+	for i := 0; i < 8; i++ { // The last one fills the memtable.
+		mem.Set([]byte(fmt.Sprintf("old%d", i)), []byte("0123456789"))
+	}
+	mem.mu.Lock()
+	frozen := mem.immutable
+	mem.mu.Unlock()
+	if frozen == nil {
+		mem.flushMu.Unlock()
+		t.Fatal("Expected the full memtable to be frozen")
+	}
+	for i := 0; i < 3; i++ {
+		mem.Set([]byte(fmt.Sprintf("new%d", i)), []byte("0123456789"))
+	}
+	if _, err := mem.Del([]byte("old0")); err != nil {
+		t.Errorf("Error deleting a frozen key: %v", err)
+	}
+
+	// Reads go through both memtables while the frozen one waits.
+	for _, key := range []string{"old7", "new2"} {
+		if value, err := mem.Get([]byte(key)); err != nil || string(value) != "0123456789" {
+			t.Errorf("Expected the value of %s during the flush, got %q (%v)", key, value, err)
+		}
+	}
+	if _, err := mem.Get([]byte("old0")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected old0 deleted by the active memtable, got %v", err)
+	}
+	mem.flushMu.Unlock()
+
+	waitForFlush(t, mem)
+	files := mem.manifest.current()
+	if len(files) != 1 || files[0].entries != frozen.Len() {
+		t.Fatalf("Expected the %d frozen entries flushed, got %+v", frozen.Len(), files)
+	}
+	for _, key := range []string{"old7", "new2"} {
+		if value, err := mem.Get([]byte(key)); err != nil || string(value) != "0123456789" {
+			t.Errorf("Expected the value of %s after the flush, got %q (%v)", key, value, err)
+		}
+	}
+	if _, err := mem.Get([]byte("old0")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected old0 to stay deleted, got %v", err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	mem.Set([]byte("foo"), []byte("bar"))
+	if err := mem.Copy([]byte("foo"), []byte("baz"), CopyOptions{}); err != nil {
+		t.Fatalf("Error copying key: %v", err)
+	}
+
+	value, err := mem.Get([]byte("baz"))
+	if err != nil || string(value) != "bar" {
+		t.Errorf("Expected copied value bar, got %q (%v)", value, err)
+	}
+
+	// Copying a deleted key fails and leaves the destination untouched.
+	mem.Del([]byte("foo"))
+	if err := mem.Copy([]byte("foo"), []byte("qux"), CopyOptions{}); err == nil {
+		t.Errorf("Expected an error copying a deleted key")
+	}
+	if elem := mem.skiplist.Get([]byte("qux")); elem != nil {
+		t.Errorf("Destination key should not exist")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	// A nil expected value only succeeds for a missing key.
+	swapped, err := mem.CompareAndSwap([]byte("cas"), nil, []byte("v1"))
+	if err != nil || !swapped {
+		t.Fatalf("Expected swap on absent key, got %v (%v)", swapped, err)
+	}
+	swapped, err = mem.CompareAndSwap([]byte("cas"), nil, []byte("v2"))
+	if err != nil || swapped {
+		t.Fatalf("Expected no swap on existing key, got %v (%v)", swapped, err)
+	}
+
+	// A mismatching expected value leaves the key untouched.
+	swapped, err = mem.CompareAndSwap([]byte("cas"), []byte("other"), []byte("v2"))
+	if err != nil || swapped {
+		t.Fatalf("Expected no swap on mismatch, got %v (%v)", swapped, err)
+	}
+	swapped, err = mem.CompareAndSwap([]byte("cas"), []byte("v1"), []byte("v2"))
+	if err != nil || !swapped {
+		t.Fatalf("Expected swap on match, got %v (%v)", swapped, err)
+	}
+
+	value, _ := mem.Get([]byte("cas"))
+	if string(value) != "v2" {
+		t.Errorf("Expected v2, got %q", value)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	actual, loaded, err := mem.GetOrSet([]byte("gos"), []byte("v1"))
+	if err != nil || loaded || string(actual) != "v1" {
+		t.Fatalf("Expected v1 to be stored, got %q, loaded %v (%v)", actual, loaded, err)
+	}
+
+	actual, loaded, err = mem.GetOrSet([]byte("gos"), []byte("v2"))
+	if err != nil || !loaded || string(actual) != "v1" {
+		t.Fatalf("Expected existing v1, got %q, loaded %v (%v)", actual, loaded, err)
+	}
+
+	// A deleted key counts as absent.
+	mem.Del([]byte("gos"))
+	actual, loaded, err = mem.GetOrSet([]byte("gos"), []byte("v3"))
+	if err != nil || loaded || string(actual) != "v3" {
+		t.Fatalf("Expected v3 to be stored, got %q, loaded %v (%v)", actual, loaded, err)
+	}
+}
+
+func TestMemDBHas(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	mem.Set([]byte("present"), []byte("yes"))
+	mem.Set([]byte("gone"), []byte("soon"))
+	mem.Del([]byte("gone"))
+
+	cases := map[string]bool{"present": true, "gone": false, "never": false}
+	for key, want := range cases {
+		got, err := mem.Has([]byte(key))
+		if err != nil {
+			t.Fatalf("Error checking %q: %v", key, err)
+		}
+		if got != want {
+			t.Errorf("Has(%q) = %v, expected %v", key, got, want)
+		}
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("flushed"), []byte("1"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Set([]byte("present"), []byte("2"))
+	mem.Set([]byte("gone"), []byte("soon"))
+	mem.Del([]byte("gone"))
+
+	for key, want := range map[string]string{"flushed": "1", "present": "2", "gone": "", "never": ""} {
+		value, found, err := mem.TryGet([]byte(key))
+		if err != nil {
+			t.Fatalf("Error looking up %q: %v", key, err)
+		}
+		if found != (want != "") || string(value) != want {
+			t.Errorf("TryGet(%q) = %q, %v, expected %q", key, value, found, want)
+		}
+	}
+
+	if value, err := mem.GetOrDefault([]byte("never"), []byte("def")); err != nil || string(value) != "def" {
+		t.Errorf("Expected the default for a missing key, got %q (%v)", value, err)
+	}
+	if value, err := mem.GetOrDefault([]byte("present"), []byte("def")); err != nil || string(value) != "2" {
+		t.Errorf("Expected the stored value, got %q (%v)", value, err)
+	}
+
+	// Failures are still errors.
+	mem.Close()
+	if _, found, err := mem.TryGet([]byte("present")); !errors.Is(err, ErrClosed) || found {
+		t.Errorf("Expected ErrClosed from a closed store, got %v (found %v)", err, found)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	mem.Set([]byte("m1"), []byte("a"))
+	mem.Set([]byte("m2"), []byte("b"))
+	mem.Set([]byte("m3"), []byte("c"))
+	mem.Del([]byte("m3"))
+
+	values, err := mem.MultiGet([][]byte{[]byte("m2"), []byte("m3"), []byte("missing"), []byte("m1"), []byte("m2")})
+	if err != nil {
+		t.Fatalf("Error in MultiGet: %v", err)
+	}
+
+	want := [][]byte{[]byte("b"), nil, nil, []byte("a"), []byte("b")}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("Expected %q, got %q", want, values)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	// A missing key starts from 0.
+	if v, err := mem.Incr([]byte("counter"), 5); err != nil || v != 5 {
+		t.Fatalf("Expected 5, got %d (%v)", v, err)
+	}
+	if v, err := mem.Incr([]byte("counter"), -7); err != nil || v != -2 {
+		t.Fatalf("Expected -2, got %d (%v)", v, err)
+	}
+	if value, _ := mem.Get([]byte("counter")); string(value) != "-2" {
+		t.Errorf("Expected stored value -2, got %q", value)
+	}
+
+	mem.Set([]byte("text"), []byte("abc"))
+	if _, err := mem.Incr([]byte("text"), 1); err == nil {
+		t.Errorf("Expected an error incrementing a non-integer value")
+	}
+
+	mem.Set([]byte("max"), []byte("9223372036854775807"))
+	if _, err := mem.Incr([]byte("max"), 1); err == nil {
+		t.Errorf("Expected an overflow error")
+	}
+}
+
+func TestVersionsOrderedBySeq(t *testing.T) {
+	mem := NewTempDB(t)
+	if err := os.MkdirAll(mem.sstDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	withSeq := func(tuple SSTTuple, seq uint64) SSTTuple {
+		tuple.Value.Seq = seq
+		return tuple
+	}
+	// The older file holds the newer versions, as compaction can leave them.
+	addTestSST(t, mem, 1, []SSTTuple{withSeq(set("a", "new"), 10), withSeq(del("b"), 11)})
+	addTestSST(t, mem, 2, []SSTTuple{withSeq(del("a"), 5), withSeq(set("b", "old"), 4), withSeq(set("c", "3"), 6)})
+
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "new" {
+		t.Errorf("Expected the newest value of a, got %q (%v)", value, err)
+	}
+	if _, err := mem.Get([]byte("b")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected b to be deleted, got %v", err)
+	}
+	if ok, err := mem.Has([]byte("a")); err != nil || !ok {
+		t.Errorf("Expected a to be present, got %v (%v)", ok, err)
+	}
+	values, err := mem.MultiGet([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil || string(values[0]) != "new" || values[1] != nil || string(values[2]) != "3" {
+		t.Errorf("Unexpected values %q (%v)", values, err)
+	}
+	it, err := mem.NewIterator()
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	if keys := collect(t, it); !reflect.DeepEqual(keys, []string{"a=new", "c=3"}) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
+
+func TestFlushedTombstoneSeq(t *testing.T) {
+	mem := NewTempDB(t)
+	mem.Set([]byte("a"), []byte("1"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if _, err := mem.Del([]byte("a")); err != nil {
+		t.Fatalf("Error deleting: %v", err)
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	it, err := newSSTIterator(filepath.Join(mem.sstDir, sstFileName(0, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if !it.Next() || it.Op() != delOperation || it.Seq() != mem.LastSeq() || it.Seq() < 2 {
+		t.Errorf("Expected the tombstone of a with sequence number %d, got %s %d (%v)", mem.LastSeq(), it.Op(), it.Seq(), it.Err())
+	}
+}
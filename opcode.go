@@ -0,0 +1,93 @@
+package kvstore
+
+import (
+	"fmt"
+	"io"
+)
+
+// opcode is the single byte encoding an operation in WAL entries, SST tuples
+// and transaction records. Files written before opcodes were introduced spell
+// the operation as three ASCII letters instead, such as "SET". Codes stay below
+// 'A' so that the first byte tells the two encodings apart, which lets readers
+// take old and new files alike.
+type opcode byte
+
+const (
+	opSet  opcode = 1
+	opDel  opcode = 2
+	opTTL  opcode = 3
+	opTxn  opcode = 4
+	opBlob opcode = 5
+
+	// maxOpcode is the largest code, below the letters of legacy operations.
+	maxOpcode opcode = 'A' - 1
+)
+
+// The registry of operations, by name and by code. New operations are added
+// with registerOperation; a code must never be reused once files hold it.
+var (
+	opcodes = map[string]opcode{}
+	opNames = map[opcode]string{}
+)
+
+func init() {
+	registerOperation(setOperation, opSet)
+	registerOperation(delOperation, opDel)
+	registerOperation(ttlOperation, opTTL)
+	registerOperation(txnOperation, opTxn)
+	registerOperation(blobOperation, opBlob)
+}
+
+// registerOperation assigns code to the operation name.
+func registerOperation(name string, code opcode) {
+	if code == 0 || code > maxOpcode {
+		panic(fmt.Sprintf("kvstore: opcode %d of %s out of range", code, name))
+	}
+	if other, ok := opNames[code]; ok {
+		panic(fmt.Sprintf("kvstore: opcode %d registered for both %s and %s", code, other, name))
+	}
+	if _, ok := opcodes[name]; ok {
+		panic("kvstore: operation " + name + " registered twice")
+	}
+	opcodes[name] = code
+	opNames[code] = name
+}
+
+// encodeOperation returns the code of the operation name.
+func encodeOperation(name string) (byte, error) {
+	code, ok := opcodes[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported operation: %s", name)
+	}
+	return byte(code), nil
+}
+
+// readOperation reads an operation encoded either as an opcode or, in older
+// files, as three letters. It returns the name of the operation and the
+// number of bytes it took, and io.EOF if r is exhausted before the first byte.
+func readOperation(r io.Reader) (string, int, error) {
+	var buf [3]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return "", 0, err
+	}
+
+	if opcode(buf[0]) <= maxOpcode {
+		name, ok := opNames[opcode(buf[0])]
+		if !ok {
+			return "", 1, fmt.Errorf("unknown opcode %d", buf[0])
+		}
+		return name, 1, nil
+	}
+
+	if _, err := io.ReadFull(r, buf[1:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", 1, err
+	}
+	name := string(buf[:])
+	if _, ok := opcodes[name]; !ok {
+		return "", 3, fmt.Errorf("unsupported operation: %q", name)
+	}
+	return name, 3, nil
+}
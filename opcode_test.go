@@ -0,0 +1,58 @@
+package kvstore
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadOperation(t *testing.T) {
+	cases := []struct {
+		encoded []byte
+		name    string
+		n       int
+	}{
+		{[]byte{byte(opSet)}, setOperation, 1},
+		{[]byte{byte(opDel)}, delOperation, 1},
+		{[]byte{byte(opTxn)}, txnOperation, 1},
+		{[]byte("TTL"), ttlOperation, 3},
+		{[]byte("DEL"), delOperation, 3},
+	}
+	for _, c := range cases {
+		name, n, err := readOperation(bytes.NewReader(c.encoded))
+		if err != nil || name != c.name || n != c.n {
+			t.Errorf("readOperation(%q) = %s, %d, %v, expected %s, %d", c.encoded, name, n, err, c.name, c.n)
+		}
+	}
+
+	for _, encoded := range [][]byte{{0}, {byte(maxOpcode)}, []byte("GET"), []byte("SE")} {
+		if name, _, err := readOperation(bytes.NewReader(encoded)); err == nil {
+			t.Errorf("Expected an error reading %q, got %s", encoded, name)
+		}
+	}
+	if _, _, err := readOperation(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("Expected io.EOF at the end of the input, got %v", err)
+	}
+}
+
+func TestDecodeLegacyTxnBatch(t *testing.T) {
+	var buf bytes.Buffer
+	writeBinary(&buf, uint32(2))
+	writeBinary(&buf, []byte("SET"), uint32(1), []byte("a"), uint32(1), []byte("1"))
+	writeBinary(&buf, []byte("DEL"), uint32(1), []byte("b"), uint32(0), []byte{})
+
+	entries, err := decodeTxnBatch(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Error decoding legacy transaction: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Operation != setOperation || entries[1].Operation != delOperation {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+
+	// A transaction can't nest another one.
+	buf.Reset()
+	writeBinary(&buf, uint32(1), byte(opTxn), uint32(0), uint32(0))
+	if _, err := decodeTxnBatch(buf.Bytes()); err == nil {
+		t.Error("Expected an error decoding a nested transaction")
+	}
+}
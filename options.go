@@ -0,0 +1,378 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+// DefaultWALSegmentSize is the size past which WAL segments are rolled over
+// when Options.WALSegmentSize is unset.
+const DefaultWALSegmentSize = 64 << 20
+
+// DefaultWALBufferSize is the size of the buffer of the WAL writes when
+// Options.WALBufferSize is unset.
+const DefaultWALBufferSize = 64 << 10
+
+// DefaultWriteStallTimeout is the longest a write waits for room in the
+// memtables when Options.WriteStallTimeout is unset.
+const DefaultWriteStallTimeout = 10 * time.Second
+
+// DefaultWALFlushInterval is the period of the background writes of the
+// buffered WAL entries when Options.WALFlushInterval is unset.
+const DefaultWALFlushInterval = 10 * time.Millisecond
+
+// Options configures a store opened with OpenWithOptions. The zero value uses
+// the package defaults.
+type Options struct {
+	// DataDir is the root directory of the store, DataDir if empty. SST files
+	// are kept in its sstStorage subdirectory.
+	DataDir string
+	// WALDir holds the Write-Ahead Log, the walStorage subdirectory of DataDir if empty.
+	WALDir string
+	// DirPerm holds the permission bits of the directories created by Open,
+	// 0755 if zero. The umask still applies.
+	DirPerm os.FileMode
+	// WALSegmentSize is the size in bytes past which the WAL rolls over to a
+	// new segment file, 64 MiB if zero.
+	WALSegmentSize int64
+	// MemtableSizeLimit is the size in bytes of keys and values, overwritten
+	// versions included, past which the memtable is frozen after a write and
+	// flushed to an SST file in the background, a fresh one taking the writes
+	// that follow. Reads go through both until the flush is done. Zero
+	// disables automatic flushes.
+	MemtableSizeLimit int64
+	// WriteBufferSize is the size in bytes of the keys and values all the
+	// memtables hold at most, the one being flushed included. Writes past it
+	// stall until a flush makes room, so that a burst of writes faster than
+	// the disk doesn't exhaust the memory. Unless MemtableSizeLimit is set,
+	// memtables are flushed from half of it. Zero doesn't limit them.
+	WriteBufferSize int64
+	// WriteStallTimeout is the longest a write stalled by WriteBufferSize
+	// waits, after which it fails with ErrWriteStall,
+	// DefaultWriteStallTimeout if zero. A negative timeout waits as long as
+	// it takes.
+	WriteStallTimeout time.Duration
+	// WALPreallocate reserves the disk space of every WAL segment when it is
+	// created, where the platform supports it.
+	WALPreallocate bool
+	// WALRecycleSegments is the number of flushed WAL segments kept to be
+	// reused as new segments, instead of deleting and creating files.
+	WALRecycleSegments int
+	// WALBufferSize is the size in bytes of the buffer the entries appended
+	// to the WAL go through, DefaultWALBufferSize if zero. A negative size
+	// writes every entry to the file as it is appended.
+	WALBufferSize int
+	// WALFlushInterval is the period of the background writes of the buffered
+	// WAL entries to the file, DefaultWALFlushInterval if zero. Under
+	// SyncNever, it bounds the writes lost if the process crashes.
+	WALFlushInterval time.Duration
+	// WALCompression compresses the large values written to the WAL, to cut
+	// the disk writes of compressible data such as text or JSON.
+	WALCompression bool
+	// SSTCompression compresses the data blocks of the SST files written by
+	// flushes, NoCompression by default. Blocks that don't shrink are stored
+	// as they are.
+	SSTCompression Compression
+	// FilterBitsPerKey is the size in bits of the bloom filters of the SST
+	// files written for each of their keys, DefaultFilterBitsPerKey if zero or
+	// less. Larger filters take more memory and disk but let lookups of missing
+	// keys skip more files: 10 bits give about 1% of false positives, 20
+	// about 0.01%. Files keep the size they were written with.
+	FilterBitsPerKey int
+	// ValueLogThreshold is the size in bytes from which flushes keep values
+	// in the value log, the SST files only pointing to them, so that large
+	// values don't bloat the SST files or get rewritten with them. Zero keeps
+	// every value in the SST files.
+	ValueLogThreshold int
+	// TableCacheSize is the number of SST files kept open, with their index
+	// parsed, for reads to share. DefaultTableCacheSize if zero; a negative
+	// size opens the files for every read.
+	TableCacheSize int
+	// MaxOpenFiles bounds the files the store keeps open, to stay clear of the
+	// limit of the process. The table cache evicts its least recently used
+	// tables to keep them, the value log files and a few files reserved for
+	// the WAL, the directory lock, flushes and compactions under the bound.
+	// DefaultMaxOpenFiles if zero; a negative value sets no bound.
+	MaxOpenFiles int
+	// BlockCacheSize is the size in bytes of the cache of the data blocks
+	// read from SST files, decompressed, which the reads of every file
+	// share. DefaultBlockCacheSize if zero; a negative size disables the
+	// cache. Scans don't fill it.
+	BlockCacheSize int64
+	// CompactionStrategy selects how the background compaction merges the
+	// SST files, LeveledCompaction by default.
+	CompactionStrategy CompactionStrategy
+	// CompactionPicker chooses the compactions of the background compaction
+	// in place of CompactionStrategy, for heuristics the built-in strategies
+	// lack, if not nil.
+	CompactionPicker CompactionPicker
+	// L0CompactionTrigger is the number of SST files of level 0 from which
+	// the background compaction merges them into level 1, or under
+	// SizeTieredCompaction the number of sorted runs of a tier merged
+	// together, DefaultL0CompactionTrigger if zero. A negative count disables
+	// the background compaction.
+	L0CompactionTrigger int
+	// LevelSizeBase is the size in bytes of the files of level 1 past which
+	// they are compacted into level 2, DefaultLevelSizeBase if zero. Every
+	// level then holds LevelSizeMultiplier times the size of the previous
+	// one, DefaultLevelSizeMultiplier if zero.
+	LevelSizeBase       int64
+	LevelSizeMultiplier int
+	// TargetFileSize is the size in bytes of the keys and values of the
+	// files compactions write, DefaultTargetFileSize if zero. Under
+	// SizeTieredCompaction, the sorted runs smaller than it form a single
+	// tier.
+	TargetFileSize int64
+	// CompactionBoundary, if set, splits the files compactions write where
+	// it changes between two keys, such as at the edges of namespaces,
+	// besides at TargetFileSize.
+	CompactionBoundary KeyBoundary
+	// CompactionRateLimit is the number of bytes of keys and values per
+	// second compactions read and write at most, so that they leave the disk
+	// to foreground reads and writes. Zero doesn't limit them.
+	CompactionRateLimit int64
+	// CompactionFilter, if set, is asked by compactions whether to keep,
+	// drop or change every live entry they merge.
+	CompactionFilter CompactionFilter
+	// MaxSubcompactions is the number of ranges of keys a large compaction
+	// is split into at most, merged in parallel, so that compacting a whole
+	// level takes less time at the cost of more disk bandwidth. Ranges are
+	// at least TargetFileSize large. Zero or one merges every compaction at
+	// once.
+	MaxSubcompactions int
+	// ParallelLookups is the number of SST files a read probes at once when
+	// several may hold the key, such as with many files of level 0. Once a
+	// version is found, the files that can only hold older ones are no
+	// longer probed. Zero or one probes the files one at a time.
+	ParallelLookups int
+	// VerifyOnOpen selects how much of the SST files OpenWithOptions checks
+	// before returning, VerifyNone by default, so that corruption fails the
+	// open rather than the first read of the damaged block.
+	VerifyOnOpen VerifyLevel
+	// ParanoidChecks is the same as VerifyOnOpen: VerifyChecksums, unless a
+	// higher level is set.
+	ParanoidChecks bool
+	// SyncPolicy selects when writes are fsynced to the WAL, SyncNever by default.
+	SyncPolicy SyncPolicy
+	// SyncInterval is the period of the background syncs of SyncInterval,
+	// DefaultSyncInterval if zero.
+	SyncInterval time.Duration
+	// SyncWrites is the same as SyncPolicy: SyncAlways.
+	SyncWrites bool
+	// ReplayHooks are passed the writes replayed from the WAL when the store
+	// is opened.
+	ReplayHooks []ReplayHook
+	// FlushOnClose makes Close flush the memtable to an SST file, so the next
+	// Open doesn't have to replay the WAL.
+	FlushOnClose bool
+}
+
+// withDefaults fills in the unset options.
+func (o Options) withDefaults() Options {
+	if o.DataDir == "" {
+		o.DataDir = DataDir
+	}
+	if o.DataDir == "" {
+		o.DataDir = DefaultDataDir()
+	}
+	if o.WALDir == "" {
+		o.WALDir = filepath.Join(o.DataDir, "walStorage")
+	}
+	if o.DirPerm == 0 {
+		o.DirPerm = 0755
+	}
+	if o.WALSegmentSize == 0 {
+		o.WALSegmentSize = DefaultWALSegmentSize
+	}
+	if o.WriteBufferSize > 0 && o.MemtableSizeLimit == 0 {
+		o.MemtableSizeLimit = o.WriteBufferSize / 2
+	}
+	if o.WriteStallTimeout == 0 {
+		o.WriteStallTimeout = DefaultWriteStallTimeout
+	}
+	if o.SyncWrites {
+		o.SyncPolicy = SyncAlways
+	}
+	if o.ParanoidChecks && o.VerifyOnOpen < VerifyChecksums {
+		o.VerifyOnOpen = VerifyChecksums
+	}
+	if o.SyncInterval == 0 {
+		o.SyncInterval = DefaultSyncInterval
+	}
+	if o.WALBufferSize == 0 {
+		o.WALBufferSize = DefaultWALBufferSize
+	}
+	if o.FilterBitsPerKey <= 0 {
+		o.FilterBitsPerKey = DefaultFilterBitsPerKey
+	}
+	if o.TableCacheSize == 0 {
+		o.TableCacheSize = DefaultTableCacheSize
+	}
+	if o.MaxOpenFiles == 0 {
+		o.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+	if o.BlockCacheSize == 0 {
+		o.BlockCacheSize = DefaultBlockCacheSize
+	}
+	if o.WALFlushInterval == 0 {
+		o.WALFlushInterval = DefaultWALFlushInterval
+	}
+	if o.L0CompactionTrigger == 0 {
+		o.L0CompactionTrigger = DefaultL0CompactionTrigger
+	}
+	if o.LevelSizeBase == 0 {
+		o.LevelSizeBase = DefaultLevelSizeBase
+	}
+	if o.LevelSizeMultiplier == 0 {
+		o.LevelSizeMultiplier = DefaultLevelSizeMultiplier
+	}
+	if o.TargetFileSize == 0 {
+		o.TargetFileSize = DefaultTargetFileSize
+	}
+	return o
+}
+
+// Open opens the store kept under the directory path, creating it if needed.
+// The WAL and SST files are stored in subdirectories of path.
+func Open(path string) (*MemDB, error) {
+	return OpenWithOptions(Options{DataDir: path})
+}
+
+// OpenWithOptions opens the store described by opts, replaying its WAL.
+func OpenWithOptions(opts Options) (*MemDB, error) {
+	mem, err := open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyOnOpen(mem.sstDir, mem.manifest.current(), mem.opts.VerifyOnOpen); err != nil {
+		mem.wal.Close()
+		mem.lock.release()
+		return nil, err
+	}
+
+	// Load the contents from the WAL
+	if err := mem.Load(); err != nil {
+		mem.wal.Close()
+		mem.lock.release()
+		return nil, err
+	}
+	// Ingested SST files take sequence numbers no WAL entry records.
+	for _, f := range mem.manifest.current() {
+		if f.largestSeq > mem.wal.seq.Load() {
+			mem.wal.seq.Store(f.largestSeq)
+		}
+	}
+
+	// Under SyncAlways, every write flushes the buffer as it syncs.
+	buffered := mem.opts.WALBufferSize > 0 && mem.opts.SyncPolicy != SyncAlways
+	if buffered || mem.opts.SyncPolicy == SyncInterval {
+		mem.stopBackground = make(chan struct{})
+	}
+	if buffered {
+		mem.background.Add(1)
+		go mem.flushPeriodically(mem.opts.WALFlushInterval, mem.stopBackground)
+	}
+	if mem.opts.SyncPolicy == SyncInterval {
+		mem.background.Add(1)
+		go mem.syncPeriodically(mem.opts.SyncInterval, mem.stopBackground)
+	}
+	if mem.opts.MemtableSizeLimit > 0 {
+		mem.flushTrigger = make(chan struct{}, 1)
+		mem.stopFlush = make(chan struct{})
+		mem.flushers.Add(1)
+		go mem.flushInBackground()
+		mem.flushIfFull()
+	}
+	if mem.opts.L0CompactionTrigger > 0 {
+		mem.compactTrigger = make(chan struct{}, 1)
+		mem.stopCompaction = make(chan struct{})
+		mem.compactions.Add(1)
+		go mem.compactInBackground()
+		mem.scheduleCompaction()
+	}
+
+	return mem, nil
+}
+
+// open opens the files of the store described by opts without replaying the WAL.
+func open(opts Options) (*MemDB, error) {
+	opts = opts.withDefaults()
+
+	sstDir := filepath.Join(opts.DataDir, "sstStorage")
+	for _, dir := range []struct{ role, path string }{
+		{"data directory", opts.DataDir},
+		{"WAL directory", opts.WALDir},
+		{"SST directory", sstDir},
+	} {
+		if err := prepareDir(dir.role, dir.path, opts.DirPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only one instance at a time may use the directory.
+	lock, err := lockDir(opts.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := openManifest(sstDir)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+
+	wal, err := OpenWAL(opts.WALDir, opts.WALSegmentSize)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	wal.compress = opts.WALCompression
+	wal.recycle = opts.WALRecycleSegments
+	wal.preallocate = opts.WALPreallocate
+	if opts.SyncPolicy != SyncAlways {
+		wal.setBuffer(opts.WALBufferSize)
+	}
+	if wal.preallocate {
+		preallocate(wal.file, wal.segmentSize)
+	}
+
+	// Stores created before the WAL was segmented keep it in a single wal.bin,
+	// in the WAL directory or in the walStorage directory of the old layout.
+	legacyWALs := []string{filepath.Join(opts.WALDir, "wal.bin")}
+	if path := filepath.Join(opts.DataDir, "walStorage", "wal.bin"); path != legacyWALs[0] {
+		legacyWALs = append(legacyWALs, path)
+	}
+	for _, path := range legacyWALs {
+		if err := migrateLegacyWAL(wal, path); err != nil {
+			wal.Close()
+			lock.release()
+			return nil, err
+		}
+	}
+	removeOrphanFiles(sstDir, opts.WALDir, manifest.current())
+
+	tables := newTableCache(sstDir, opts.TableCacheSize)
+	tables.lookupWorkers = opts.ParallelLookups
+	if opts.MaxOpenFiles > 0 {
+		tables.maxOpen = max(opts.MaxOpenFiles-reservedFiles, 1)
+	}
+	tables.blocks = newBlockCache(opts.BlockCacheSize)
+	manifest.deleteFile = func(f *sstMeta) { deleteSSTFile(tables, sstDir, f) }
+
+	return &MemDB{
+		skiplist:    skiplist.New(skiplist.Bytes),
+		wal:         wal,
+		opts:        opts,
+		sstDir:      sstDir,
+		manifest:    manifest,
+		tables:      tables,
+		lock:        lock,
+		replayHooks: opts.ReplayHooks,
+
+		compactionLimiter: newRateLimiter(opts.CompactionRateLimit),
+	}, nil
+}
@@ -0,0 +1,283 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOpenOptions(t *testing.T) {
+	dataDir, walDir := t.TempDir(), t.TempDir()
+	opts := Options{DataDir: dataDir, WALDir: walDir, MemtableSizeLimit: 10, SyncWrites: true}
+
+	mem, err := OpenWithOptions(opts)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("key"), []byte("value")) // Crosses the memtable size limit.
+	mem.Set([]byte("b"), []byte("2"))
+	waitForFlush(t, mem)
+
+	if segments, err := listSegments(walDir); err != nil || len(segments) == 0 {
+		t.Errorf("Expected the WAL in the configured directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "sstStorage", sstFileName(0, 1))); err != nil {
+		t.Errorf("Expected an automatic flush to the data directory: %v", err)
+	}
+
+	stats, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if stats.WALSyncs.Syncs != 3 {
+		t.Errorf("Expected a WAL sync per write, got %d", stats.WALSyncs.Syncs)
+	}
+	if stats.MemtableKeys != 1 {
+		t.Errorf("Expected only b left in the memtable, got %d keys", stats.MemtableKeys)
+	}
+	mem.Close()
+
+	reopened, err := OpenWithOptions(opts)
+	if err != nil {
+		t.Fatalf("Error reopening store: %v", err)
+	}
+	defer reopened.Close()
+
+	for key, want := range map[string]string{"a": "1", "key": "value", "b": "2"} {
+		if value, err := reopened.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("Expected %s for %s, got %q (%v)", want, key, value, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer mem.Close()
+
+	mem.Set([]byte("foo"), []byte("bar"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "walStorage", "wal-000002.log"), filepath.Join(dir, "sstStorage", sstFileName(0, 1))} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to exist: %v", path, err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+
+	mem, err := OpenWithOptions(Options{DataDir: dir, FlushOnClose: true})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+
+	// The directory can't be opened twice.
+	if _, err := Open(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked opening a directory in use, got %v", err)
+	}
+
+	mem.Set([]byte("foo"), []byte("bar"))
+	if err := mem.Close(); err != nil {
+		t.Fatalf("Error closing store: %v", err)
+	}
+
+	if err := mem.Set([]byte("foo"), []byte("baz")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Set, got %v", err)
+	}
+	if _, err := mem.Get([]byte("foo")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Get, got %v", err)
+	}
+	if err := mem.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed closing twice, got %v", err)
+	}
+
+	// The lock is released and the memtable was flushed.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error reopening store: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.RecoveryStats().Applied != 0 {
+		t.Errorf("Expected nothing to replay after a flushing close, got %+v", reopened.RecoveryStats())
+	}
+	if value, err := reopened.Get([]byte("foo")); err != nil || string(value) != "bar" {
+		t.Errorf("Expected bar, got %q (%v)", value, err)
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	stores := make([]*MemDB, 3)
+	for i := range stores {
+		mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MemtableSizeLimit: int64(50 * (i + 1))})
+		if err != nil {
+			t.Fatalf("Error opening store %d: %v", i, err)
+		}
+		// Parallel subtests run after this function returns, so don't close in a defer.
+		t.Cleanup(func() { mem.Close() })
+		stores[i] = mem
+	}
+
+	for i, mem := range stores {
+		i, mem := i, mem
+		t.Run(fmt.Sprintf("store%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			for n := 0; n < 100; n++ {
+				key := []byte(fmt.Sprintf("key%03d", n))
+				if err := mem.Set(key, []byte(fmt.Sprint(i))); err != nil {
+					t.Fatalf("Error setting %s: %v", key, err)
+				}
+			}
+			for n := 0; n < 100; n++ {
+				key := []byte(fmt.Sprintf("key%03d", n))
+				if value, err := mem.Get(key); err != nil || string(value) != fmt.Sprint(i) {
+					t.Fatalf("Expected %d for %s, got %q (%v)", i, key, value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenCreatesDirs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "store")
+
+	mem, err := OpenWithOptions(Options{DataDir: dir, DirPerm: 0700})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer mem.Close()
+
+	for _, path := range []string{dir, filepath.Join(dir, "walStorage"), filepath.Join(dir, "sstStorage")} {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			t.Fatalf("Expected directory %s: %v", path, err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+			t.Errorf("Expected %s to have mode 0700, got %v", path, info.Mode().Perm())
+		}
+	}
+
+	// The probe files don't stay behind.
+	if probes, _ := filepath.Glob(filepath.Join(dir, "*", ".probe-*")); len(probes) > 0 {
+		t.Errorf("Unexpected probe files: %v", probes)
+	}
+}
+
+func TestOpenBadDir(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	if err := os.WriteFile(walDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenWithOptions(Options{DataDir: filepath.Join(dir, "data"), WALDir: walDir})
+	if err == nil {
+		t.Fatalf("Expected an error opening a store whose WAL directory is a file")
+	}
+	if !strings.Contains(err.Error(), "WAL directory "+walDir) {
+		t.Errorf("Expected the error to name the WAL directory, got %v", err)
+	}
+}
+
+func TestOpenReadOnlyDir(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions aren't enforced")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	_, err := Open(dir)
+	if !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), dir) {
+		t.Errorf("Expected a permission error naming %s, got %v", dir, err)
+	}
+}
+
+func TestDefaultDataDirLegacy(t *testing.T) {
+	t.Setenv("KVSTORE_DATA_DIR", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if dir := DefaultDataDir(); dir == legacyDataDir {
+		t.Errorf("Expected the per-user directory without a store in ./disk, got %s", dir)
+	}
+
+	if err := os.MkdirAll(filepath.Join(legacyDataDir, "walStorage"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if dir := DefaultDataDir(); dir != legacyDataDir {
+		t.Errorf("Expected the store in ./disk to be kept, got %s", dir)
+	}
+
+	t.Setenv("KVSTORE_DATA_DIR", "elsewhere")
+	if dir := DefaultDataDir(); dir != "elsewhere" {
+		t.Errorf("Expected KVSTORE_DATA_DIR to take precedence, got %s", dir)
+	}
+}
+
+func TestDefaultDataDirOnOpen(t *testing.T) {
+	// The default directory is resolved by Open, from the environment of
+	// that time.
+	prev := DataDir
+	DataDir = ""
+	t.Cleanup(func() { DataDir = prev })
+	dir := t.TempDir()
+	t.Setenv("KVSTORE_DATA_DIR", dir)
+
+	mem, err := OpenWithOptions(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if !hasStore(dir) {
+		t.Errorf("Expected the store to be opened under KVSTORE_DATA_DIR %s", dir)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "new.tmp"), filepath.Join(dir, "current")
+	for _, content := range []string{"1", "2"} {
+		if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := replaceFile(src, dst); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dst); err != nil || string(got) != content {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", dst, content, got, err)
+		}
+	}
+	if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected %s to be moved, got %v", src, err)
+	}
+	if err := syncDir(filepath.Join(dir, "missing")); runtime.GOOS != "windows" && !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected an error syncing a missing directory, got %v", err)
+	}
+}
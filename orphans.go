@@ -0,0 +1,59 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Crashes leave files behind that no part of the store refers to: SST files
+// written by a flush, compaction or ingestion that didn't make it to the
+// manifest, and the temporary files of the writes that replace a file
+// atomically. They are only found by listing the directories, so open
+// deletes them before they use up the disk.
+
+// legacyWALTemp is the file older versions rewrote the single-file WAL to
+// when truncating it, before moving it over wal.bin.
+const legacyWALTemp = "new_wal.bin"
+
+// removeOrphanFiles deletes the files of sstDir and walDir that the store
+// doesn't use, logging each: the SST files listed by no manifest, the
+// temporary files of the manifest, WAL checkpoint and directory probes, and
+// the leftover of the truncation of a legacy WAL. Files it doesn't know of
+// are left alone, as are the vlog files, which SST files may point to.
+func removeOrphanFiles(sstDir, walDir string, files []*sstMeta) {
+	listed := make(map[string]bool, len(files))
+	for _, f := range files {
+		listed[f.name()] = true
+	}
+	removeFiles(sstDir, func(name string) bool {
+		if _, _, ok := parseSSTFileName(name); ok {
+			return !listed[name]
+		}
+		return strings.HasPrefix(name, ".manifest-") || strings.HasPrefix(name, ".probe-")
+	})
+	removeFiles(walDir, func(name string) bool {
+		return name == checkpointFile+".tmp" || name == legacyWALTemp || strings.HasPrefix(name, ".probe-")
+	})
+}
+
+// removeFiles deletes the regular files of dir whose names orphan reports,
+// logging each. Errors are logged, since they only leave the files in place.
+func removeFiles(dir string, orphan func(name string) bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		Logger.Printf("error listing %s for orphan files: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !orphan(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			Logger.Printf("error removing orphan file %s: %v", path, err)
+			continue
+		}
+		Logger.Printf("Removed orphan file %s", path)
+	}
+}
@@ -0,0 +1,60 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveOrphanFiles(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	listed := mem.manifest.current()[0].name()
+	mem.Close()
+
+	// What crashes leave behind, along with files the store doesn't know.
+	sstDir, walDir := filepath.Join(dir, "sstStorage"), filepath.Join(dir, "walStorage")
+	orphans := []string{
+		filepath.Join(sstDir, "L0-000007.sst"),
+		filepath.Join(sstDir, "L2-000008.sst"),
+		filepath.Join(sstDir, ".manifest-123"),
+		filepath.Join(walDir, checkpointFile+".tmp"),
+		filepath.Join(walDir, legacyWALTemp),
+	}
+	kept := []string{
+		filepath.Join(sstDir, listed),
+		filepath.Join(sstDir, "vlog001"),
+		filepath.Join(sstDir, "notes.txt"),
+		filepath.Join(walDir, checkpointFile),
+	}
+	for _, path := range append(orphans, kept[1:3]...) {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if mem, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	for _, path := range orphans {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Unexpected value of a: %q (%v)", value, err)
+	}
+}
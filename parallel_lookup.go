@@ -0,0 +1,90 @@
+package kvstore
+
+import "sync"
+
+// findInSSTFilesParallel is findInSSTFiles probing up to workers of the files
+// that may hold key at once. Probes are started in the order of files, and
+// once a version is found, the files that can only hold older ones are no
+// longer probed. An error stops the probes not yet started.
+func findInSSTFilesParallel(tables *tableCache, key []byte, files []*sstMeta, workers int) (SSTPair, int, error) {
+	var candidates []*sstMeta
+	for _, f := range files {
+		if f.contains(key) {
+			candidates = append(candidates, f)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return SSTPair{}, -2, nil
+	case 1:
+		return probeSST(tables, key, candidates[0])
+	}
+
+	type probe struct {
+		pair SSTPair
+		code int
+		err  error
+		done bool
+	}
+	probes := make([]probe, len(candidates))
+
+	var (
+		mu      sync.Mutex
+		next    int // Next candidate to probe.
+		found   bool
+		bestSeq uint64 // Of the newest version found.
+	)
+	// claim returns the next candidate worth probing, -1 once there is none.
+	claim := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		for next < len(candidates) {
+			i := next
+			next++
+			if !found || candidates[i].largestSeq > bestSeq {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(candidates)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := claim(); i >= 0; i = claim() {
+				pair, n, err := probeSST(tables, key, candidates[i])
+				mu.Lock()
+				probes[i] = probe{pair: pair, code: n, err: err, done: true}
+				if n == 0 {
+					next = len(candidates)
+				} else if n != -2 && (!found || pair.Seq > bestSeq) {
+					found, bestSeq = true, pair.Seq
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Pick the newest version as findInSSTFiles does, the first file winning
+	// ties.
+	var (
+		newest SSTPair
+		code   = -2
+	)
+	for _, p := range probes {
+		if !p.done {
+			continue
+		}
+		if p.code == 0 {
+			return SSTPair{}, 0, p.err
+		}
+		if p.code != -2 && (code == -2 || p.pair.Seq > newest.Seq) {
+			newest, code = p.pair, p.code
+		}
+	}
+	return newest, code, nil
+}
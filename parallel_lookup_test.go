@@ -0,0 +1,59 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParallelLookups(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), ParallelLookups: 3, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	// Overlapping files whose order doesn't match the sequence numbers of
+	// their versions, as compactions can leave them.
+	for n := 1; n <= 8; n++ {
+		var tuples []SSTTuple
+		for k := 0; k < 10; k++ {
+			tuple := set(fmt.Sprintf("key%d", k), fmt.Sprintf("file%d", n))
+			if (n+k)%5 == 0 {
+				tuple = del(string(tuple.Key))
+			}
+			if (n*k)%4 != 3 {
+				tuple.Value.Seq = uint64((n*7+k*3)%11 + 1)
+				tuples = append(tuples, tuple)
+			}
+		}
+		addTestSST(t, mem, n, tuples)
+	}
+
+	for k := 0; k < 11; k++ {
+		key := []byte(fmt.Sprintf("key%d", k))
+		mem.tables.lookupWorkers = 1
+		want, wantCode, err := findInSSTFiles(mem.tables, key, mem.manifest.current())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mem.tables.lookupWorkers = 3
+		for i := 0; i < 20; i++ {
+			pair, code, err := findInSSTFiles(mem.tables, key, mem.manifest.current())
+			if err != nil || code != wantCode || string(pair.Value) != string(want.Value) || pair.Seq != want.Seq {
+				t.Fatalf("Parallel lookup of %s = %q, %d, %d (%v), expected %q, %d, %d", key, pair.Value, pair.Seq, code, err, want.Value, want.Seq, wantCode)
+			}
+		}
+	}
+
+	// A file that must be read fails the lookup.
+	if err := os.WriteFile(filepath.Join(mem.sstDir, sstFileName(0, 8)), []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mem.tables.evict(8)
+	if _, err := mem.Get([]byte("key1")); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected reading the damaged file to fail, got %v", err)
+	}
+}
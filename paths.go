@@ -0,0 +1,134 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// legacyDataDir is the data directory of older versions, relative to the
+// working directory.
+const legacyDataDir = "disk"
+
+// DataDir is the directory of the stores opened without an explicit one, as
+// with NewMemDB or a zero Options.DataDir, DefaultDataDir if empty. The default
+// is only resolved when such a store is opened, so that importing the package
+// neither reads the environment nor looks for a store. Stores opened
+// elsewhere don't use it.
+var DataDir string
+
+// DefaultDataDir returns the data directory used when none is configured. The
+// KVSTORE_DATA_DIR environment variable takes precedence, then a store left in
+// ./disk by older versions, then the usual per-user data location of the
+// current platform.
+func DefaultDataDir() string {
+	if dir := os.Getenv("KVSTORE_DATA_DIR"); dir != "" {
+		return dir
+	}
+
+	dir, err := userDataDir()
+	if err != nil {
+		// Fall back to the working directory.
+		return legacyDataDir
+	}
+	if hasStore(legacyDataDir) {
+		Logger.Printf("Using the data directory %s of older versions; move it to %s or set KVSTORE_DATA_DIR", legacyDataDir, dir)
+		return legacyDataDir
+	}
+	return dir
+}
+
+// hasStore reports whether dir holds the WAL or the SST files of a store.
+func hasStore(dir string) bool {
+	for _, sub := range []string{"walStorage", "sstStorage"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// userDataDir returns the per-user data location of the current platform.
+func userDataDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "kvstore"), nil
+		}
+		return filepath.Join(home, "AppData", "Local", "kvstore"), nil
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "kvstore"), nil
+	default:
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return filepath.Join(dir, "kvstore"), nil
+		}
+		return filepath.Join(home, ".local", "share", "kvstore"), nil
+	}
+}
+
+// prepareDir creates the directory path with perm, along with its parents,
+// and checks that files can be created in it. The error names the directory
+// by its role in the store, such as "WAL directory".
+func prepareDir(role, path string, perm os.FileMode) error {
+	if err := os.MkdirAll(path, perm); err != nil {
+		return fmt.Errorf("%s %s can't be created: %w", role, path, err)
+	}
+
+	probe, err := os.CreateTemp(path, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("%s %s isn't writable: %w", role, path, err)
+	}
+	_, err = probe.Write([]byte{0})
+	if closeErr := probe.Close(); err == nil {
+		err = closeErr
+	}
+	if rmErr := os.Remove(probe.Name()); err == nil {
+		err = rmErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s isn't writable: %w", role, path, err)
+	}
+
+	return nil
+}
+
+// replaceFile atomically moves src over dst, then syncs the directory of dst
+// so that the rename survives a crash. Both files must be closed, since
+// Windows refuses to rename files that are still open.
+func replaceFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err != nil && runtime.GOOS == "windows" {
+		// Older Windows filesystems may refuse to replace an existing file.
+		if rmErr := os.Remove(dst); rmErr == nil || os.IsNotExist(rmErr) {
+			err = os.Rename(src, dst)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+// syncDir makes the entries of directory dir, such as a file renamed into it,
+// durable. Windows can't sync directories, whose entries NTFS journals, so
+// nothing is done there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
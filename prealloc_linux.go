@@ -0,0 +1,17 @@
+//go:build linux
+
+package kvstore
+
+import (
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 1 // FALLOC_FL_KEEP_SIZE
+
+// preallocate reserves size bytes of disk space for file without changing its
+// apparent size, so appends don't have to allocate blocks. It is only an
+// optimization, so errors such as filesystems without fallocate are ignored.
+func preallocate(file *os.File, size int64) {
+	syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
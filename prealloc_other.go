@@ -0,0 +1,8 @@
+//go:build !linux
+
+package kvstore
+
+import "os"
+
+// preallocate is a no-op on platforms without fallocate.
+func preallocate(file *os.File, size int64) {}
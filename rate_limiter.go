@@ -0,0 +1,57 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiterSlack is the delay below which rateLimiter lets bytes through
+// without sleeping, so that small amounts don't sleep for a few microseconds
+// each. It is made up for by the next wait.
+const rateLimiterSlack = 10 * time.Millisecond
+
+// rateLimiter paces the bytes of a stream of I/O to rate bytes per second.
+// A nil rateLimiter doesn't limit.
+type rateLimiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time // When the bytes granted so far are paid for.
+}
+
+// newRateLimiter returns a limiter to rate bytes per second, nil if rate
+// isn't positive.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// wait waits until n more bytes fit in the rate. It returns false if stop was
+// closed meanwhile.
+func (l *rateLimiter) wait(n int, stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	if delay < rateLimiterSlack {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatal("Expected a rate of 0 not to limit")
+	}
+	var unlimited *rateLimiter
+	if !unlimited.wait(1<<30, nil) {
+		t.Fatal("Expected a nil limiter not to wait")
+	}
+
+	// 10 KB/s lets 2 KB through in about 200ms, small waits included.
+	l := newRateLimiter(10 << 10)
+	start := time.Now()
+	for i := 0; i < 2<<10; i += 64 {
+		l.wait(64, nil)
+	}
+	if took := time.Since(start); took < 150*time.Millisecond || took > time.Second {
+		t.Errorf("Expected about 200ms, took %v", took)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	start = time.Now()
+	if l.wait(100<<10, stop) || time.Since(start) > time.Second {
+		t.Errorf("Expected the wait to stop")
+	}
+}
@@ -0,0 +1,67 @@
+package kvstore
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	minReadAhead = 4 << 10   // Window used until the access pattern looks sequential.
+	maxReadAhead = 256 << 10 // Largest window the prefetcher grows to.
+)
+
+// readAheadReader reads a file through a prefetch window that doubles every
+// time the previous window was consumed entirely, so long sequential scans
+// issue few large reads while short scans don't read much past what they use.
+// Once the window reaches its maximum the OS is also told to read ahead. The
+// file is read through ReadAt, so readers can share it.
+type readAheadReader struct {
+	file   *os.File
+	offset int64 // Of the next window.
+	buf    []byte
+	pos    int
+	window int
+	advise bool // Whether the OS was already advised of sequential access.
+}
+
+// newReadAheadReader returns a reader of file from offset on.
+func newReadAheadReader(file *os.File, offset int64) *readAheadReader {
+	return &readAheadReader{file: file, offset: offset, window: minReadAhead}
+}
+
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	if r.pos == len(r.buf) {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// fill reads the next window from the file, growing it when the previous one was used up.
+func (r *readAheadReader) fill() error {
+	if r.buf != nil && r.window < maxReadAhead {
+		r.window *= 2
+	}
+	if r.window == maxReadAhead && !r.advise {
+		adviseSequential(r.file)
+		r.advise = true
+	}
+
+	if cap(r.buf) < r.window {
+		r.buf = make([]byte, r.window)
+	}
+	n, err := r.file.ReadAt(r.buf[:r.window], r.offset)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	r.buf, r.pos = r.buf[:n], 0
+	r.offset += int64(n)
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build linux && (amd64 || arm64)
+
+package kvstore
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadviseSequential = 2 // POSIX_FADV_SEQUENTIAL
+
+// adviseSequential asks the kernel for aggressive read-ahead on file. It is only a hint, so errors are ignored.
+func adviseSequential(file *os.File) {
+	syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, fadviseSequential, 0, 0)
+}
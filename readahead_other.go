@@ -0,0 +1,8 @@
+//go:build !(linux && (amd64 || arm64))
+
+package kvstore
+
+import "os"
+
+// adviseSequential is a no-op on platforms without posix_fadvise.
+func adviseSequential(file *os.File) {}
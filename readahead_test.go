@@ -0,0 +1,49 @@
+package kvstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadAheadReaderGrowsWindow(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r := newReadAheadReader(file, 0)
+
+	// A short read only fetches the initial window.
+	small := make([]byte, 100)
+	if _, err := io.ReadFull(r, small); err != nil {
+		t.Fatal(err)
+	}
+	if r.window != minReadAhead {
+		t.Errorf("Expected window of %d after a short read, got %d", minReadAhead, r.window)
+	}
+
+	// Reading on sequentially grows the window to its maximum.
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.window != maxReadAhead {
+		t.Errorf("Expected window of %d after a long scan, got %d", maxReadAhead, r.window)
+	}
+	if !bytes.Equal(append(small, rest...), data) {
+		t.Errorf("Read data does not match the file")
+	}
+}
@@ -1,4 +1,4 @@
-package util
+package kvstore
 
 import (
 	"bufio"
@@ -15,6 +15,7 @@ const (
 	Del
 	Ext
 	Unk
+	Compact
 )
 
 type Error int
@@ -47,6 +48,8 @@ func (re *Repl) parseCmd(buf []byte) (Cmd, []string, error) {
 		return Set, elements[1:], nil
 	case "del":
 		return Del, elements[1:], nil
+	case "compact":
+		return Compact, elements[1:], nil
 	case "exit":
 		return Ext, nil, nil
 	default:
@@ -100,6 +103,47 @@ func (re *Repl) Start() {
 				continue
 			}
 			fmt.Fprintln(re.Out, string(v))
+		case Compact:
+			var err error
+			switch len(elements) {
+			case 0:
+				engine, ok := re.Db.(StorageEngine)
+				if !ok {
+					fmt.Fprintln(re.Out, "Compaction not supported by the storage engine")
+					continue
+				}
+				err = engine.Compact()
+			case 1:
+				pauser, ok := re.Db.(CompactionPauser)
+				if !ok {
+					fmt.Fprintln(re.Out, "Pausing compaction not supported by the storage engine")
+					continue
+				}
+				switch elements[0] {
+				case "pause":
+					err = pauser.PauseCompaction()
+				case "resume":
+					err = pauser.ResumeCompaction()
+				default:
+					fmt.Fprintf(re.Out, "Expected pause or resume, received: %s\n", elements[0])
+					continue
+				}
+			case 2:
+				compacter, ok := re.Db.(RangeCompacter)
+				if !ok {
+					fmt.Fprintln(re.Out, "Range compaction not supported by the storage engine")
+					continue
+				}
+				err = compacter.CompactRange([]byte(elements[0]), []byte(elements[1]))
+			default:
+				fmt.Fprintf(re.Out, "Expected 0 to 2 arguments, received: %d\n", len(elements))
+				continue
+			}
+			if err != nil {
+				fmt.Fprintln(re.Out, err.Error())
+				continue
+			}
+			fmt.Fprintln(re.Out, "OK")
 		case Ext:
 			fmt.Fprintln(re.Out, "Bye!")
 			return
@@ -0,0 +1,32 @@
+package kvstore
+
+// ReplayHook receives the writes replayed from the WAL by Load, so that
+// applications can rebuild caches or other state derived from the store
+// during startup. Either function may be nil.
+type ReplayHook struct {
+	// Entry is called for every write replayed into the memtable, in the
+	// order of the WAL. A transaction calls it once for each of its writes,
+	// with the sequence number of the transaction. Deletions have a value
+	// whose Operation is DEL.
+	Entry func(seq uint64, key []byte, value *Value)
+	// Done is called once at the end of the replay, with its outcome.
+	Done func(stats RecoveryStats, err error)
+}
+
+// AddReplayHook registers hook for the next calls to Load. Stores opened with
+// Open have already been loaded, so their hooks go in Options.ReplayHooks.
+func (mem *MemDB) AddReplayHook(hook ReplayHook) {
+	mem.replayHooks = append(mem.replayHooks, hook)
+}
+
+// replay stores a write read from the WAL in the memtable and passes it to
+// the replay hooks.
+func (mem *MemDB) replay(seq uint64, key []byte, value *Value) {
+	value.Seq = seq
+	mem.put(key, value)
+	for _, hook := range mem.replayHooks {
+		if hook.Entry != nil {
+			hook.Entry(seq, key, value)
+		}
+	}
+}
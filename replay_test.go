@@ -0,0 +1,54 @@
+package kvstore
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayHooks(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.SetWithTTL([]byte("b"), []byte("2"), time.Hour)
+	tx := mem.Begin()
+	tx.Set([]byte("c"), []byte("3"))
+	tx.Del([]byte("a"))
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	mem.Close()
+
+	var (
+		replayed []string
+		done     int
+	)
+	hook := ReplayHook{
+		Entry: func(seq uint64, key []byte, value *Value) {
+			replayed = append(replayed, fmt.Sprintf("%d %s %s=%s", seq, value.Operation, key, value.Value))
+		},
+		Done: func(stats RecoveryStats, err error) {
+			done++
+			if err != nil || stats.Applied != 3 {
+				t.Errorf("Unexpected end of replay: %+v (%v)", stats, err)
+			}
+		},
+	}
+	mem, err = OpenWithOptions(Options{DataDir: dir, ReplayHooks: []ReplayHook{hook}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	want := []string{"1 SET a=1", "2 SET b=2", "3 DEL a=", "3 SET c=3"}
+	if !reflect.DeepEqual(replayed, want) {
+		t.Errorf("Expected replayed writes %q, got %q", want, replayed)
+	}
+	if done != 1 {
+		t.Errorf("Expected Done to be called once, got %d", done)
+	}
+}
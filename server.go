@@ -0,0 +1,566 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Server represents the key-value store server.
+type Server struct {
+	Router *mux.Router
+	db     StorageEngine
+
+	// SlowRequestThreshold is the duration above which requests are logged as slow, 0 to disable.
+	SlowRequestThreshold time.Duration
+
+	// Debug enables the /debug/faults endpoints, which inject latency and errors
+	// into the requests. It must be set before SetupRoutes.
+	Debug  bool
+	faults faultInjector
+
+	httpServer *http.Server
+
+	// drainMu guards draining and the registration of in-flight writes.
+	drainMu  sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+}
+
+// NewServer creates a new instance of the server.
+func NewServer() (*Server, error) {
+	return NewServerWithOptions(Options{})
+}
+
+// NewServerWithOptions creates a server backed by the store described by opts.
+func NewServerWithOptions(opts Options) (*Server, error) {
+	mem, err := OpenWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServerWithEngine(mem), nil
+}
+
+// NewServerWithEngine creates a server backed by engine, which it closes on Shutdown.
+func NewServerWithEngine(engine StorageEngine) *Server {
+	return &Server{
+		Router:               mux.NewRouter(),
+		db:                   engine,
+		SlowRequestThreshold: 100 * time.Millisecond,
+	}
+}
+
+// SetupRoutes configures the server routes.
+func (s *Server) SetupRoutes() {
+	s.Router.Use(s.traceRequests)
+	if s.Debug {
+		s.Router.Use(s.injectFaults)
+		s.Router.HandleFunc("/debug/faults", s.FaultsHandler).Methods("GET")
+		s.Router.HandleFunc("/debug/faults", s.SetFaultsHandler).Methods("PUT")
+		s.Router.HandleFunc("/debug/faults", s.ClearFaultsHandler).Methods("DELETE")
+	}
+
+	s.Router.HandleFunc("/get", s.GetHandler).Methods("GET")
+	s.Router.HandleFunc("/set", s.admitWrite(s.SetHandler)).Methods("POST")
+	s.Router.HandleFunc("/del", s.admitWrite(s.DeleteHandler)).Methods("DELETE")
+	s.Router.HandleFunc("/copy", s.admitWrite(s.CopyHandler)).Methods("POST")
+	s.Router.HandleFunc("/cas", s.admitWrite(s.CompareAndSwapHandler)).Methods("POST")
+	s.Router.HandleFunc("/incr", s.admitWrite(s.IncrHandler)).Methods("POST")
+	s.Router.HandleFunc("/getorset", s.admitWrite(s.GetOrSetHandler)).Methods("POST")
+	s.Router.HandleFunc("/stats", s.StatsHandler).Methods("GET")
+	s.Router.HandleFunc("/status", s.StatusHandler).Methods("GET")
+	s.Router.HandleFunc("/stats/reset", s.ResetStatsHandler).Methods("POST")
+	s.Router.HandleFunc("/admin/compact", s.admitWrite(s.CompactHandler)).Methods("POST")
+	s.Router.HandleFunc("/admin/compactions", s.CompactionsHandler).Methods("GET")
+	s.Router.HandleFunc("/admin/compactions/plan", s.CompactionPlanHandler).Methods("GET")
+	s.Router.HandleFunc("/admin/compactions/pause", s.PauseCompactionHandler).Methods("POST")
+	s.Router.HandleFunc("/admin/compactions/resume", s.ResumeCompactionHandler).Methods("POST")
+}
+
+// admitWrite wraps a write handler so that it is tracked as in flight, and
+// rejected once the server started draining.
+func (s *Server) admitWrite(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.drainMu.Lock()
+		if s.draining {
+			s.drainMu.Unlock()
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		s.inflight.Add(1)
+		s.drainMu.Unlock()
+		defer s.inflight.Done()
+
+		next(w, r)
+	}
+}
+
+// writeStallRetryAfter is the delay, in seconds, clients are asked to retry a
+// write stalled on full memtables after.
+const writeStallRetryAfter = "1"
+
+// writeError answers a write that failed with err: 503 Service Unavailable
+// while the memtables are full or once the store is closed, so that clients
+// retry elsewhere or later, and status otherwise.
+func writeError(w http.ResponseWriter, err error, status int) {
+	switch {
+	case errors.Is(err, ErrWriteStall):
+		w.Header().Set("Retry-After", writeStallRetryAfter)
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrClosed):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// ListenAndServe serves the routes on addr until Shutdown is called.
+func (s *Server) ListenAndServe(addr string) error {
+	s.httpServer = &http.Server{Addr: addr, Handler: s.Router}
+
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown drains the server: new writes are refused, in-flight writes are
+// waited for, the WAL is synced and the memtable is flushed so that the WAL
+// ends with a checkpoint. It returns how long draining took.
+func (s *Server) Shutdown(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+
+	// Stop admitting new writes.
+	s.drainMu.Lock()
+	s.draining = true
+	s.drainMu.Unlock()
+
+	// Stop accepting connections and wait for the active requests.
+	if s.httpServer != nil {
+		if err := s.httpServer.Shutdown(ctx); err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	// Wait for the writes still in flight, in case the router is served some other way.
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return time.Since(start), ctx.Err()
+	}
+
+	if err := s.db.Flush(); err != nil {
+		return time.Since(start), err
+	}
+	if err := s.db.Close(); err != nil {
+		return time.Since(start), err
+	}
+
+	return time.Since(start), nil
+}
+
+// GetHandler handles GET requests and retrieves the value for a given key.
+func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "Key not provided", http.StatusBadRequest)
+		return
+	}
+
+	value, found, err := s.db.TryGet([]byte(key))
+	if err != nil {
+		http.Error(w, "Error reading key", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(value)
+}
+
+// SetHandler handles POST requests and inserts a key-value pair into the MemTable.
+func (s *Server) SetHandler(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+
+	// Use json.NewDecoder directly to decode the JSON payload from the request body
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, ok := data["key"]
+	if !ok || key == "" {
+		http.Error(w, "Invalid or missing 'key' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	value, ok := data["value"]
+	if !ok {
+		http.Error(w, "Invalid or missing 'value' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.dbFor(r).Set([]byte(key), []byte(value)); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DeleteHandler handles DELETE requests and deletes a key from the MemTable, returning the existing value.
+func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "Key not provided", http.StatusBadRequest)
+		return
+	}
+
+	existingValue, found, err := s.db.TryGet([]byte(key))
+	if err != nil {
+		http.Error(w, "Error reading key", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := s.dbFor(r).Del([]byte(key)); errors.Is(err, ErrKeyNotFound) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(existingValue)
+}
+
+// CopyHandler handles POST requests and copies the value of one key to another.
+// An optional 'ttl' duration makes the copy expire once it elapses, instead of
+// with the source.
+func (s *Server) CopyHandler(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	src, ok := data["src"]
+	if !ok || src == "" {
+		http.Error(w, "Invalid or missing 'src' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	dst, ok := data["dst"]
+	if !ok || dst == "" {
+		http.Error(w, "Invalid or missing 'dst' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	copier, ok := s.dbFor(r).(Copier)
+	if !ok {
+		http.Error(w, "Copy not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	var opts CopyOptions
+	if ttl, ok := data["ttl"]; ok {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid 'ttl' in JSON", http.StatusBadRequest)
+			return
+		}
+		opts.TTL = d
+	}
+	if err := copier.Copy([]byte(src), []byte(dst), opts); errors.Is(err, ErrKeyNotFound) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// CompareAndSwapHandler handles POST requests and sets a key only if its current value matches
+// the expected one. Omitting 'expected' requires the key to be absent.
+func (s *Server) CompareAndSwapHandler(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, ok := data["key"]
+	if !ok || key == "" {
+		http.Error(w, "Invalid or missing 'key' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	value, ok := data["value"]
+	if !ok {
+		http.Error(w, "Invalid or missing 'value' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	var expected []byte
+	if e, ok := data["expected"]; ok {
+		expected = []byte(e)
+	}
+
+	swapped, err := s.dbFor(r).CompareAndSwap([]byte(key), expected, []byte(value))
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !swapped {
+		http.Error(w, "Current value does not match", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// IncrHandler handles POST requests and atomically adds a delta to an integer value,
+// returning the new value.
+func (s *Server) IncrHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Key   string `json:"key"`
+		Delta *int64 `json:"delta"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	if data.Key == "" {
+		http.Error(w, "Invalid or missing 'key' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	// The delta defaults to 1.
+	delta := int64(1)
+	if data.Delta != nil {
+		delta = *data.Delta
+	}
+
+	incrementer, ok := s.dbFor(r).(Incrementer)
+	if !ok {
+		http.Error(w, "Incr not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	value, err := incrementer.Incr([]byte(data.Key), delta)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int64{"value": value})
+}
+
+func (s *Server) GetOrSetHandler(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, ok := data["key"]
+	if !ok || key == "" {
+		http.Error(w, "Invalid or missing 'key' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	value, ok := data["value"]
+	if !ok {
+		http.Error(w, "Invalid or missing 'value' in JSON", http.StatusBadRequest)
+		return
+	}
+
+	getOrSetter, ok := s.dbFor(r).(GetOrSetter)
+	if !ok {
+		http.Error(w, "GetOrSet not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	actual, loaded, err := getOrSetter.GetOrSet([]byte(key), []byte(value))
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 200 when the key already existed, 201 when the value was stored.
+	status := http.StatusCreated
+	if loaded {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(actual)
+}
+
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	monitor, ok := s.db.(Monitor)
+	if !ok {
+		http.Error(w, "Stats not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	stats, err := monitor.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *Server) ResetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	monitor, ok := s.db.(Monitor)
+	if !ok {
+		http.Error(w, "Stats not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	monitor.ResetStats()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CompactHandler handles POST requests to compact the store, see
+// MemDB.Compact, or only the keys in [start, end) if either parameter is
+// given, see MemDB.CompactRange. It answers once the compaction is done.
+func (s *Server) CompactHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	compact := s.db.Compact
+	if query.Has("start") || query.Has("end") {
+		compacter, ok := s.db.(RangeCompacter)
+		if !ok {
+			http.Error(w, "Range compaction not supported by the storage engine", http.StatusNotImplemented)
+			return
+		}
+		start, end := rangeBound(query.Get("start")), rangeBound(query.Get("end"))
+		compact = func() error { return compacter.CompactRange(start, end) }
+	}
+	if err := compact(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rangeBound returns the bound of a range of keys given as bound, nil for an
+// open side if it is empty.
+func rangeBound(bound string) []byte {
+	if bound == "" {
+		return nil
+	}
+	return []byte(bound)
+}
+
+// CompactionsHandler handles GET requests for the compactions in progress
+// and the last ones that finished.
+func (s *Server) CompactionsHandler(w http.ResponseWriter, r *http.Request) {
+	monitor, ok := s.db.(CompactionMonitor)
+	if !ok {
+		http.Error(w, "Compaction stats not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(monitor.CompactionStats())
+}
+
+// CompactionPlanHandler handles GET requests for the compaction the store
+// would run next, see MemDB.CompactionPlan. It answers 204 No Content when the
+// files need none.
+func (s *Server) CompactionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	planner, ok := s.db.(CompactionPlanner)
+	if !ok {
+		http.Error(w, "Compaction plans not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	plan, err := planner.CompactionPlan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if plan == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(plan)
+}
+
+// StatusHandler handles GET requests for the health of the store. It answers
+// 503 when a problem was found, so that it can back a health check.
+func (s *Server) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	monitor, ok := s.db.(Monitor)
+	if !ok {
+		http.Error(w, "Health not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	health := monitor.Health()
+
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// PauseCompactionHandler handles POST requests to pause the background
+// compaction, see MemDB.PauseCompaction. It answers once the compaction in
+// progress is done.
+func (s *Server) PauseCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.controlCompaction(w, CompactionPauser.PauseCompaction)
+}
+
+// ResumeCompactionHandler handles POST requests to resume the background
+// compaction.
+func (s *Server) ResumeCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.controlCompaction(w, CompactionPauser.ResumeCompaction)
+}
+
+// controlCompaction answers a request to pause or resume the background
+// compaction with control.
+func (s *Server) controlCompaction(w http.ResponseWriter, control func(CompactionPauser) error) {
+	pauser, ok := s.db.(CompactionPauser)
+	if !ok {
+		http.Error(w, "Pausing compaction not supported by the storage engine", http.StatusNotImplemented)
+		return
+	}
+	if err := control(pauser); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,398 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestServerSetGetDel(t *testing.T) {
+	_, url := NewTestServer(t)
+
+	resp, err := http.Post(url+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err = http.Get(url + "/get?key=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "bar" {
+		t.Fatalf("Expected bar, got %q (status %d)", body, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url+"/del?key=foo", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(url + "/get?key=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d after delete, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerCompareAndSwap(t *testing.T) {
+	_, url := NewTestServer(t)
+
+	post := func(body string) int {
+		resp, err := http.Post(url+"/cas", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(`{"key": "foo", "value": "v1"}`); code != http.StatusOK {
+		t.Errorf("Expected swap on absent key, got status %d", code)
+	}
+	if code := post(`{"key": "foo", "expected": "nope", "value": "v2"}`); code != http.StatusConflict {
+		t.Errorf("Expected conflict, got status %d", code)
+	}
+	if code := post(`{"key": "foo", "expected": "v1", "value": "v2"}`); code != http.StatusOK {
+		t.Errorf("Expected swap on match, got status %d", code)
+	}
+}
+
+func TestServerShutdownRefusesWrites(t *testing.T) {
+	server, url := NewTestServer(t)
+
+	http.Post(url+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "bar"}`))
+
+	if _, err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Error shutting down: %v", err)
+	}
+
+	// The memtable was flushed to disk.
+	if len(server.db.(*MemDB).manifest.current()) != 1 {
+		t.Errorf("Expected the memtable to be flushed to an SST file")
+	}
+
+	resp, err := http.Post(url+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "baz"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d while draining, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestServerRequestID(t *testing.T) {
+	_, url := NewTestServer(t)
+
+	// A client-provided ID is echoed back.
+	req, _ := http.NewRequest(http.MethodGet, url+"/get?key=foo", nil)
+	req.Header.Set(RequestIDHeader, "trace-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(RequestIDHeader); id != "trace-123" {
+		t.Errorf("Expected request ID trace-123, got %q", id)
+	}
+
+	// Otherwise one is generated.
+	resp, err = http.Get(url + "/get?key=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(RequestIDHeader); id == "" {
+		t.Errorf("Expected a generated request ID")
+	}
+
+	// So is one that could forge log lines, or of excessive length.
+	for _, id := range []string{"a%0D%0Aslow+request_id=forged", strings.Repeat("a", maxRequestIDLength+1)} {
+		resp, err = http.Get(url + "/get?key=foo&request_id=" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(RequestIDHeader); !validRequestID(got) || len(got) != 16 {
+			t.Errorf("Expected a generated request ID in place of %.16q, got %q", id, got)
+		}
+	}
+
+	// Writes log the ID with the sequence numbers of their WAL entries.
+	var logged bytes.Buffer
+	Logger.SetOutput(&logged)
+	req, _ = http.NewRequest(http.MethodPost, url+"/set", strings.NewReader(`{"key": "foo", "value": "bar"}`))
+	req.Header.Set(RequestIDHeader, "trace-456")
+	resp, err = http.DefaultClient.Do(req)
+	Logger.SetOutput(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(logged.String(), `write request_id="trace-456" seq=1-1`) {
+		t.Errorf("Expected the write to be traced, got %q", logged.String())
+	}
+}
+
+func TestServerInjectFaults(t *testing.T) {
+	server := &Server{Router: mux.NewRouter(), db: NewTempDB(t), Debug: true}
+	server.SetupRoutes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/debug/faults", bytes.NewBufferString(`{"read_latency": "50ms", "write_error_rate": 1}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	// Every write fails.
+	resp, err = http.Post(ts.URL+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected status %d for an injected fault, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+
+	// Reads are delayed.
+	start := time.Now()
+	resp, err = http.Get(ts.URL + "/get?key=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if took := time.Since(start); took < 50*time.Millisecond {
+		t.Errorf("Expected a read of at least 50ms, took %v", took)
+	}
+
+	// Clearing the faults lets writes through again.
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/debug/faults", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(ts.URL+"/set", "application/json", bytes.NewBufferString(`{"key": "foo", "value": "bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status %d once faults are cleared, got %d", http.StatusCreated, resp.StatusCode)
+	}
+}
+
+func TestServerFaultsNeedDebug(t *testing.T) {
+	_, url := NewTestServer(t)
+
+	resp, err := http.Get(url + "/debug/faults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the debug endpoints to be disabled, got status %d", resp.StatusCode)
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	server, url := NewTestServer(t)
+
+	resp, err := http.Get(url + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health Health
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || !health.Healthy {
+		t.Fatalf("Expected a healthy status, got %+v (status %d, %v)", health, resp.StatusCode, err)
+	}
+
+	server.db.Close()
+	resp, err = http.Get(url + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d for a closed store, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestServerCompact(t *testing.T) {
+	server, url := NewTestServer(t)
+	mem := server.db.(*MemDB)
+	for i := 0; i < 2; i++ {
+		mem.Set([]byte("foo"), []byte("bar"))
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Post(url+"/admin/compact", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if files := mem.manifest.current(); len(files) != 1 || files[0].level == 0 {
+		t.Errorf("Expected the files to be merged into one, got %d files", len(files))
+	}
+
+	// A range compaction rewrites the files of the range even if merged.
+	resp, err = http.Post(url+"/admin/compact?start=f&end=g", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, err = http.Get(url + "/admin/compactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats CompactionStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if err != nil || stats.Compactions != 2 || len(stats.Recent) != 2 || stats.Recent[1].InputFiles != 2 {
+		t.Errorf("Unexpected compactions %+v (%v)", stats, err)
+	}
+
+	for _, action := range []string{"pause", "resume"} {
+		resp, err = http.Post(url+"/admin/compactions/"+action, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected status %d to %s, got %d", http.StatusNoContent, action, resp.StatusCode)
+		}
+		if paused := mem.CompactionStats().Paused; paused != (action == "pause") {
+			t.Errorf("Expected the compaction paused to be %v after %s", !paused, action)
+		}
+	}
+}
+
+func TestServerCompactionPlan(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	server := NewServerWithEngine(mem)
+	server.SetupRoutes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/compactions/plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d without files, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	for i := 0; i < 2; i++ {
+		mem.Set([]byte("foo"), []byte("bar"))
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem.compactMu.Lock()
+	mem.opts.L0CompactionTrigger = 2
+	mem.compactMu.Unlock()
+	resp, err = http.Get(ts.URL + "/admin/compactions/plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan CompactionPlan
+	err = json.NewDecoder(resp.Body).Decode(&plan)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(plan.Inputs) != 2 || plan.OutputLevel != 1 || plan.Reason == "" {
+		t.Errorf("Unexpected plan %+v (status %d, %v)", plan, resp.StatusCode, err)
+	}
+}
+
+func TestServerWriteErrors(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), WriteBufferSize: 100, MemtableSizeLimit: 1000, WriteStallTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServerWithEngine(mem)
+	server.SetupRoutes()
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+	set := func() *http.Response {
+		resp, err := http.Post(ts.URL+"/set", "application/json", bytes.NewBufferString(`{"key": "key", "value": "0123456789"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// With the flush held back, the memtables fill up and writes stall.
+	mem.flushMu.Lock()
+	for i := 0; i < 8; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%d", i)), []byte("0123456789"))
+	}
+	resp := set()
+	mem.flushMu.Unlock()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After on a stalled write, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if _, err := mem.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the stalled write not applied, got %v", err)
+	}
+
+	mem.Close()
+	if resp := set(); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the store is closed, got %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/del?key=key0", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("Expected the delete on a closed store to fail, got %d", resp.StatusCode)
+	}
+}
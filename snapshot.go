@@ -0,0 +1,61 @@
+package kvstore
+
+import (
+	"github.com/huandu/skiplist"
+)
+
+// Snapshot is an immutable, point-in-time view of the store. Writes made
+// after the snapshot was taken are not visible through it.
+//
+// SST files are never modified once written, so a snapshot only needs its own
+// copy of the memtable and the list of SST files at the time it was taken.
+// It pins the files, which compaction keeps until the snapshot is released.
+type Snapshot struct {
+	skiplist *skiplist.SkipList
+	tables   *tableCache
+	files    []*sstMeta
+	manifest *manifest
+	version  *version // Pinned until Release, nil once released.
+}
+
+// Snapshot captures the current state of the store.
+func (mem *MemDB) Snapshot() *Snapshot {
+	mem.mu.RLock()
+	list := mem.memtableRange(nil, nil)
+	v := mem.manifest.pin()
+	mem.mu.RUnlock()
+
+	return &Snapshot{
+		skiplist: list,
+		tables:   mem.tables,
+		files:    v.files,
+		manifest: mem.manifest,
+		version:  v,
+	}
+}
+
+// Release lets compaction delete the SST files the snapshot reads. The
+// snapshot must not be used afterwards.
+func (s *Snapshot) Release() {
+	if s.version != nil {
+		s.manifest.unpin(s.version)
+		s.version = nil
+	}
+}
+
+// Get retrieves the value of key as of the time the snapshot was taken.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	elem := s.skiplist.Get(key)
+	if elem == nil {
+		return findValueInSSTFiles(s.tables, key, s.files)
+	}
+	if !elem.Value.(*Value).live() {
+		return nil, ErrKeyNotFound
+	}
+	return elem.Value.(*Value).Value, nil
+}
+
+// Scan returns an iterator over the live keys of the snapshot in [start, end).
+func (s *Snapshot) Scan(start, end []byte) (*Iterator, error) {
+	return newIterator([]*skiplist.SkipList{s.skiplist}, s.tables, s.files, start, end)
+}
@@ -0,0 +1,48 @@
+package kvstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	mem.Set([]byte("k1"), []byte("v1"))
+	mem.Set([]byte("k2"), []byte("v2"))
+
+	snap := mem.Snapshot()
+
+	// Writes after the snapshot must not be visible through it.
+	mem.Set([]byte("k1"), []byte("changed"))
+	mem.Del([]byte("k2"))
+	mem.Set([]byte("k3"), []byte("v3"))
+
+	value, err := snap.Get([]byte("k1"))
+	if err != nil || string(value) != "v1" {
+		t.Errorf("Expected v1, got %q (%v)", value, err)
+	}
+	value, err = snap.Get([]byte("k2"))
+	if err != nil || string(value) != "v2" {
+		t.Errorf("Expected v2, got %q (%v)", value, err)
+	}
+	if _, err := snap.Get([]byte("k3")); err == nil {
+		t.Errorf("Key written after the snapshot should not be found")
+	}
+
+	it, err := snap.Scan([]byte("k"), []byte("l"))
+	if err != nil {
+		t.Fatalf("Error scanning snapshot: %v", err)
+	}
+	got := collect(t, it)
+	want := []string{"k1=v1", "k2=v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// The live store sees the new state.
+	value, err = mem.Get([]byte("k1"))
+	if err != nil || string(value) != "changed" {
+		t.Errorf("Expected changed, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,462 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	magicString   = "SSTF"
+	getOperatuon  = "GET"
+	setOperation  = "SET"
+	delOperation  = "DEL"
+	ttlOperation  = "TTL" // A SET whose value is prefixed by its expiration timestamp.
+	blobOperation = "BLB" // A SET whose value is kept in the value log, see encodeBlobValue.
+)
+
+// SSTFile represents an SST (Sorted String Table) file.
+type SSTFile struct {
+	File *os.File
+
+	// compression is applied to the data blocks written by writeTable.
+	compression Compression
+	// filterBitsPerKey is the size of the bloom filters written for each
+	// key, DefaultFilterBitsPerKey if 0.
+	filterBitsPerKey int
+	// values reads the values Get finds in the value log, keeping the vlog
+	// files open until Close. Nil until Get needs it.
+	values *valueLog
+}
+
+// sstVersion is the format version of new SST files:
+//   - 12 adds bloom filters of the keys, partitioned for large files;
+//   - 11 adds tuples pointing to their value in the value log;
+//   - 10 adds sequence numbers to the tuples and their range to the footer;
+//   - 9 stores keys as the length of the prefix shared with the previous key
+//     of the block and the rest;
+//   - 8 starts index blocks with their kind, to partition large indexes;
+//   - 7 ends every data block with restart points, to binary search it;
+//   - 6 adds checksums to the header, the data blocks and the index;
+//   - 5 ends the file with a footer locating its metadata;
+//   - 4 ends every data block with a trailer telling how it is compressed;
+//   - 3 groups the tuples in data blocks indexed at the end of the file;
+//   - 2 encodes the operations of the tuples as opcodes;
+//   - 1 encodes them as three letters.
+//
+// Files of every version from sstMinVersion on can be read, and files of
+// other versions, such as those written by a later release, are rejected with
+// ErrUnknownSSTVersion. Compactions write files in this version, see
+// MemDB.Compact.
+const sstVersion uint16 = 12
+
+// sstMinVersion is the oldest version of SST files that can be read.
+const sstMinVersion uint16 = 1
+
+type SSTFileHeader struct {
+	Magic       []byte
+	EntryCount  uint32
+	SmallestKey []byte
+	LongestKey  []byte
+	Version     uint16
+}
+
+type SSTPair struct {
+	Operation string
+	Value     []byte
+	ExpiresAt int64  // Unix time in nanoseconds, 0 if the value never expires.
+	Seq       uint64 // Sequence number of the write, 0 if unknown, see Value.Seq.
+
+	// blob locates the value in the value log when it is kept there, Value
+	// being nil until it is read.
+	blob *valuePointer
+}
+type SSTTuple struct {
+	Key   []byte
+	Value SSTPair
+}
+
+// findLastSSTNumber finds the number of the latest SST file of dir, 0 if
+// there is none and -1 if dir can't be listed. The store itself takes the
+// numbers of its files from the manifest.
+func findLastSSTNumber(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return -1
+	}
+
+	res := 0
+	for _, entry := range entries {
+		if _, n, ok := parseSSTFileName(entry.Name()); ok {
+			res = max(res, n)
+		}
+	}
+	return res
+}
+
+// NewSSTFile creates the next SST file of level 0 in dir, such as to build a
+// file outside of a store.
+func NewSSTFile(dir string) (*SSTFile, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// Find the last SST file number to create a new one
+	lastSST := findLastSSTNumber(dir)
+	if lastSST == -1 {
+		return nil, errors.New("Error finding last SST")
+	}
+
+	// Create the new SST file
+	file, err := os.Create(filepath.Join(dir, sstFileName(0, lastSST+1)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSTFile{File: file}, nil
+}
+
+func (s *SSTFile) Close() error {
+	if s.values != nil {
+		s.values.close()
+	}
+	return s.File.Close()
+}
+
+// writeBinary writes multiple values into the binary file.
+func writeBinary(w io.Writer, values ...interface{}) error {
+	for _, value := range values {
+		if err := binary.Write(w, binary.BigEndian, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBinary reads multiple values from the binary file.
+func readBinary(r io.Reader, values ...interface{}) error {
+	for _, value := range values {
+		if err := binary.Read(r, binary.BigEndian, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBytes reads a specified number of bytes from the binary file.
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	bytes := make([]byte, n)
+	_, err := io.ReadFull(r, bytes)
+	return bytes, err
+}
+
+// readKeyValue reads a key or value from the binary file.
+func readKeyValue(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	return readBytes(r, int(length))
+}
+
+// readHeader reads the SST file header.
+func (s *SSTFile) readHeader() (SSTFileHeader, error) {
+	var (
+		header SSTFileHeader
+		err    error
+	)
+
+	header.Magic, err = readBytes(s.File, len(magicString))
+	if err != nil {
+		return SSTFileHeader{}, err
+	}
+	// Don't trust the lengths that follow the magic of a foreign file.
+	if string(header.Magic) != magicString {
+		return SSTFileHeader{}, ErrNotSST
+	}
+	err = readBinary(s.File, &header.EntryCount)
+	if err != nil {
+		return SSTFileHeader{}, err
+	}
+	header.SmallestKey, err = readKeyValue(s.File)
+	if err != nil {
+		return SSTFileHeader{}, err
+	}
+	header.LongestKey, err = readKeyValue(s.File)
+	if err != nil {
+		return SSTFileHeader{}, err
+	}
+	err = readBinary(s.File, &header.Version)
+	if err != nil {
+		return SSTFileHeader{}, err
+	}
+	// The rest of the file can't be parsed without knowing its version.
+	if header.Version < sstMinVersion || header.Version > sstVersion {
+		return SSTFileHeader{}, fmt.Errorf("%w %d in %s, versions %d to %d are supported", ErrUnknownSSTVersion, header.Version, s.File.Name(), sstMinVersion, sstVersion)
+	}
+	if header.Version >= sstChecksumVersion {
+		var sum uint32
+		if err := readBinary(s.File, &sum); err != nil {
+			return SSTFileHeader{}, err
+		}
+		if headerChecksum(header) != sum {
+			return SSTFileHeader{}, &ChecksumError{Path: s.File.Name(), Part: "header"}
+		}
+	}
+
+	return header, nil
+}
+
+// writeHeader writes the SST file header.
+func (s *SSTFile) writeHeader(header SSTFileHeader) error {
+	return writeSSTHeader(s.File, header)
+}
+
+// writeSSTHeader writes header to w, followed by its checksum from version 6 on.
+func writeSSTHeader(w io.Writer, header SSTFileHeader) error {
+	if err := encodeHeader(w, header); err != nil {
+		return err
+	}
+	if header.Version >= sstChecksumVersion {
+		return writeBinary(w, headerChecksum(header))
+	}
+	return nil
+}
+
+// encodeHeader writes the fields of header to w.
+func encodeHeader(w io.Writer, header SSTFileHeader) error {
+	return writeBinary(w, header.Magic, header.EntryCount, uint32(len(header.SmallestKey)), header.SmallestKey, uint32(len(header.LongestKey)), header.LongestKey, header.Version)
+}
+
+// headerChecksum returns the CRC32 of the encoded header.
+func headerChecksum(header SSTFileHeader) uint32 {
+	crc := crc32.NewIEEE()
+	encodeHeader(crc, header)
+	return crc.Sum32()
+}
+
+// writeTuple writes a key-value pair of an SST file to w.
+func writeTuple(w io.Writer, entry SSTTuple) error {
+	op, value, err := encodeTupleValue(entry)
+	if err != nil {
+		return err
+	}
+	if op == opDel {
+		return writeBinary(w, byte(op), uint32(len(entry.Key)), entry.Key)
+	}
+	return writeBinary(w, byte(op), uint32(len(entry.Key)), entry.Key, uint32(len(value)), value)
+}
+
+// encodeTupleValue returns the opcode of entry and the value stored after its
+// key, nil for a DEL.
+func encodeTupleValue(entry SSTTuple) (opcode, []byte, error) {
+	switch entry.Value.Operation {
+	case setOperation:
+		if entry.Value.blob != nil {
+			return opBlob, encodeBlobValue(entry.Value.ExpiresAt, *entry.Value.blob), nil
+		}
+		if entry.Value.ExpiresAt != 0 {
+			return opTTL, encodeTTLValue(entry.Value.ExpiresAt, entry.Value.Value), nil
+		}
+		return opSet, entry.Value.Value, nil
+	case delOperation:
+		return opDel, nil, nil
+	default:
+		return 0, nil, fmt.Errorf("unsupported operation: %s", entry.Value.Operation)
+	}
+}
+
+// readTuple reads the next tuple from the SST file, returning io.EOF after the last one.
+func readTuple(r io.Reader) (SSTTuple, error) {
+	var tuple SSTTuple
+
+	var err error
+	tuple.Value.Operation, _, err = readOperation(r)
+	if err != nil {
+		return tuple, err
+	}
+
+	tuple.Key, err = readKeyValue(r)
+	if err != nil {
+		return tuple, err
+	}
+
+	tuple.Value, err = readTupleValue(r, tuple.Value.Operation)
+	return tuple, err
+}
+
+// readTupleValue reads the value stored after the key of a tuple of the given
+// operation.
+func readTupleValue(r io.Reader, operation string) (SSTPair, error) {
+	pair := SSTPair{Operation: operation}
+	switch operation {
+	case setOperation:
+		value, err := readKeyValue(r)
+		if err != nil {
+			return pair, err
+		}
+		pair.Value = value
+	case ttlOperation:
+		buf, err := readKeyValue(r)
+		if err != nil {
+			return pair, err
+		}
+		pair.Operation = setOperation
+		pair.ExpiresAt, pair.Value, err = decodeTTLValue(buf)
+		if err != nil {
+			return pair, err
+		}
+	case blobOperation:
+		buf, err := readKeyValue(r)
+		if err != nil {
+			return pair, err
+		}
+		var ptr valuePointer
+		pair.Operation = setOperation
+		if pair.ExpiresAt, ptr, err = decodeBlobValue(buf); err != nil {
+			return pair, err
+		}
+		pair.blob = &ptr
+	case delOperation:
+	default:
+		return pair, fmt.Errorf("unsupported operation: %s", operation)
+	}
+	return pair, nil
+}
+
+// Get retrieves the value for a given key in the SST file. It returns 1 if
+// present, -1 if deleted, -2 if absent and 0 on read errors. Values kept in
+// the value log are read from the vlog files next to the SST file, which stay
+// open until Close rather than being opened for every Get.
+func (s *SSTFile) Get(key []byte) ([]byte, int) {
+	pair, n, _ := s.lookup(key)
+	if n != 1 {
+		return nil, n
+	}
+	if pair.blob != nil {
+		if s.values == nil {
+			s.values = newValueLog(filepath.Dir(s.File.Name()))
+		}
+		var err error
+		if pair, err = s.values.resolve(key, pair); err != nil {
+			return nil, 0
+		}
+	}
+	return pair.Value, n
+}
+
+// Has reports whether the SST file holds key. It returns the same codes as Get.
+func (s *SSTFile) Has(key []byte) int {
+	_, n, _ := s.lookup(key)
+	return n
+}
+
+// lookup finds key in the SST file, returning the same codes as Get, and the
+// error behind code 0, such as a ChecksumError.
+func (s *SSTFile) lookup(key []byte) (SSTPair, int, error) {
+	r, err := newSSTReader(s.File)
+	if err != nil {
+		return SSTPair{}, 0, err
+	}
+	return r.find(key)
+}
+
+// lookupSorted looks up every key of the sorted keys slice in the SST file,
+// calling found with the index of each key present in it. Each block holding
+// some of the keys is read once.
+func (s *SSTFile) lookupSorted(keys [][]byte, found func(i int, pair SSTPair)) error {
+	r, err := newSSTReader(s.File)
+	if err != nil {
+		return err
+	}
+	return r.lookupSorted(keys, found)
+}
+
+// lookupSorted is SSTFile.lookupSorted. With a two-level index, each
+// partition listing blocks of some of the keys is read once too.
+func (r *sstReader) lookupSorted(keys [][]byte, found func(i int, pair SSTPair)) error {
+	// Skip the keys smaller than the first key of the file, and past the last one.
+	i := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], r.header.SmallestKey) >= 0 })
+	end := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], r.header.LongestKey) > 0 })
+	if r.partitions == nil {
+		return r.lookupInBlocks(r.blocks, keys[:end], i, found)
+	}
+
+	for i < end {
+		p := searchHandles(r.partitions, keys[i])
+		if p < 0 {
+			i++
+			continue
+		}
+		blocks, err := r.readPartition(p)
+		if err != nil {
+			return err
+		}
+
+		// The keys before the first key of the next partition can only be in this one.
+		next := end
+		if p+1 < len(r.partitions) {
+			next = searchKeys(keys, i, end, r.partitions[p+1].firstKey)
+		}
+		if err := r.lookupInBlocks(blocks, keys[:next], i, found); err != nil {
+			return err
+		}
+		i = next
+	}
+	return nil
+}
+
+// searchKeys returns the index of the first of keys[i:end] at or after key.
+func searchKeys(keys [][]byte, i, end int, key []byte) int {
+	return i + sort.Search(end-i, func(j int) bool { return bytes.Compare(keys[i+j], key) >= 0 })
+}
+
+// lookupInBlocks looks up the keys from i on in blocks, reading each block
+// holding some of them once.
+func (r *sstReader) lookupInBlocks(blocks []blockHandle, keys [][]byte, i int, found func(i int, pair SSTPair)) error {
+	for i < len(keys) {
+		b := searchHandles(blocks, keys[i])
+		if b < 0 {
+			i++
+			continue
+		}
+		block, err := r.block(blocks[b])
+		if err != nil {
+			return err
+		}
+
+		// The keys before the first key of the next block can only be in this one.
+		end := len(keys)
+		if b+1 < len(blocks) {
+			end = searchKeys(keys, i, end, blocks[b+1].firstKey)
+		}
+
+		for i < end {
+			tuple, err := block.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			// Both the tuples and the keys are sorted, so advance the keys up to the tuple.
+			for i < end && bytes.Compare(keys[i], tuple.Key) < 0 {
+				i++
+			}
+			if i < end && bytes.Equal(keys[i], tuple.Key) {
+				found(i, tuple.Value)
+				i++
+			}
+		}
+		i = end
+	}
+
+	return nil
+}
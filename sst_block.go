@@ -0,0 +1,572 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// sstBlockSize is the size past which a data block of an SST file is
+	// closed. Blocks end on a tuple boundary, so they can be slightly larger.
+	sstBlockSize = 4 << 10
+	// sstIndexFooterSize is the size of the footer of SST files of versions 3
+	// and 4: the offset and size of the index block.
+	sstIndexFooterSize = 12
+	// sstFooterSize is the size of the footer of versions 5 to 9: the offsets
+	// and sizes of the index and filter blocks, the version and sstFooterMagic.
+	sstFooterSize = 8 + 4 + 8 + 4 + 2 + 8
+	// sstSeqFooterSize is the size of the footer of later versions, which
+	// adds the smallest and largest sequence numbers of the tuples before the
+	// version.
+	sstSeqFooterSize = sstFooterSize + 8 + 8
+	// sstFooterMagic ends every SST file of version 5 or later.
+	sstFooterMagic = "SSTFOOTR"
+
+	// sstBlocksVersion is the first version of SST files made of data blocks.
+	sstBlocksVersion = 3
+	// sstTrailerVersion is the first version whose data blocks end with a
+	// trailer byte holding their Compression.
+	sstTrailerVersion = 4
+	// sstFooterVersion is the first version ending with a footer of sstFooterSize.
+	sstFooterVersion = 5
+	// sstChecksumVersion is the first version whose header, data blocks and
+	// index end with a CRC32 of their contents.
+	sstChecksumVersion = 6
+	// sstRestartsVersion is the first version whose data blocks end with
+	// restart points, the offsets of every sstRestartInterval-th tuple.
+	sstRestartsVersion = 7
+	// sstSeqVersion is the first version whose tuples hold the sequence
+	// number of their write, and whose footer holds the range of them.
+	sstSeqVersion = 10
+
+	// sstRestartInterval is the number of tuples between restart points.
+	sstRestartInterval = 16
+)
+
+// ErrNotSST is returned when reading a file that isn't an SST file, or
+// whose metadata is damaged beyond recognition.
+var ErrNotSST = errors.New("not an SST file")
+
+// ErrUnknownSSTVersion is returned when reading an SST file of a version this
+// release can't read, such as one written by a later release.
+var ErrUnknownSSTVersion = errors.New("unknown SST file version")
+
+// ChecksumError reports a part of an SST file or an entry of a WAL segment
+// that doesn't match its checksum, as left by disk corruption.
+type ChecksumError struct {
+	Path   string
+	Part   string // The header, the index or a data block, or walEntryPart.
+	Offset int64  // Offset of the part in the file.
+}
+
+// walEntryPart is the Part of the ChecksumErrors of WAL entries.
+const walEntryPart = "entry"
+
+func (e *ChecksumError) Error() string {
+	if e.Part == walEntryPart {
+		return fmt.Sprintf("checksum mismatch in WAL entry of %s at offset %d", e.Path, e.Offset)
+	}
+	return fmt.Sprintf("checksum mismatch in %s of SST file %s at offset %d", e.Part, e.Path, e.Offset)
+}
+
+// errChecksum is returned by the decoders, which don't know where their data
+// comes from, for their callers to turn into a ChecksumError.
+var errChecksum = errors.New("checksum mismatch")
+
+// appendChecksum appends the CRC32 of data to it.
+func appendChecksum(data []byte) []byte {
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// stripChecksum checks and removes the CRC32 ending data.
+func stripChecksum(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errChecksum
+	}
+	data, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, errChecksum
+	}
+	return data, nil
+}
+
+// Compression selects how the data blocks of new SST files are compressed.
+type Compression uint8
+
+const (
+	// NoCompression stores the data blocks as they are.
+	NoCompression Compression = iota
+	// DeflateCompression compresses the data blocks with DEFLATE.
+	DeflateCompression
+)
+
+// blockHandle locates a data block of an SST file, and holds its first key as
+// recorded by the index.
+type blockHandle struct {
+	firstKey []byte
+	offset   int64
+	size     int64
+}
+
+// writeTable writes the header, then tuples grouped in data blocks, then the
+// index of the blocks and the footer pointing at it, see SSTWriter.
+func (s *SSTFile) writeTable(header SSTFileHeader, tuples []SSTTuple) error {
+	w, err := s.NewWriter(header)
+	if err != nil {
+		return err
+	}
+	for _, tuple := range tuples {
+		if err := w.Add(tuple.Key, tuple.Value); err != nil {
+			return err
+		}
+	}
+	return w.Finish()
+}
+
+// encodeBlock returns the data block holding the tuples of block, compressed
+// if that makes it smaller, followed by its trailer: the Compression of the
+// block and the CRC32 of the block up to there.
+func (s *SSTFile) encodeBlock(block []byte) ([]byte, error) {
+	if s.compression == DeflateCompression {
+		compressed, err := compressValue(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(block) {
+			return appendChecksum(append(compressed, byte(DeflateCompression))), nil
+		}
+	}
+	return appendChecksum(append(block, byte(NoCompression))), nil
+}
+
+// decodeBlock returns the tuples held by the data block of a file of the given
+// version, as written by encodeBlock. It returns errChecksum if the block is
+// corrupt.
+func decodeBlock(data []byte, version uint16) ([]byte, error) {
+	if version < sstTrailerVersion {
+		return data, nil
+	}
+	if version >= sstChecksumVersion {
+		var err error
+		if data, err = stripChecksum(data); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, errors.New("data block without trailer")
+	}
+
+	data, compression := data[:len(data)-1], Compression(data[len(data)-1])
+	switch compression {
+	case NoCompression:
+		return data, nil
+	case DeflateCompression:
+		return decompressValue(data)
+	default:
+		return nil, fmt.Errorf("unknown compression %d of data block", compression)
+	}
+}
+
+// sstFooter locates the metadata blocks of an SST file of version 5 or later.
+// A filter block, if any, sits between the data blocks and the index.
+type sstFooter struct {
+	indexOffset  int64
+	indexSize    int64
+	filterOffset int64 // 0 with filterSize when the file has no filter block.
+	filterSize   int64
+	// The range of the sequence numbers of the tuples, from version 10 on.
+	smallestSeq uint64
+	largestSeq  uint64
+	version     uint16
+}
+
+// footerSize returns the size of the footer of files of version.
+func footerSize(version uint16) int64 {
+	if version >= sstSeqVersion {
+		return sstSeqFooterSize
+	}
+	return sstFooterSize
+}
+
+// write writes the footer to w.
+func (f sstFooter) write(w io.Writer) error {
+	if err := writeBinary(w, uint64(f.indexOffset), uint32(f.indexSize), uint64(f.filterOffset), uint32(f.filterSize)); err != nil {
+		return err
+	}
+	if f.version >= sstSeqVersion {
+		if err := writeBinary(w, f.smallestSeq, f.largestSeq); err != nil {
+			return err
+		}
+	}
+	return writeBinary(w, f.version, []byte(sstFooterMagic))
+}
+
+// decodeFooter decodes the footer b of an SST file, of sstFooterSize or
+// sstSeqFooterSize bytes.
+func decodeFooter(b []byte) (sstFooter, error) {
+	if (len(b) != sstFooterSize && len(b) != sstSeqFooterSize) || string(b[len(b)-len(sstFooterMagic):]) != sstFooterMagic {
+		return sstFooter{}, ErrNotSST
+	}
+	f := sstFooter{
+		indexOffset:  int64(binary.BigEndian.Uint64(b[0:])),
+		indexSize:    int64(binary.BigEndian.Uint32(b[8:])),
+		filterOffset: int64(binary.BigEndian.Uint64(b[12:])),
+		filterSize:   int64(binary.BigEndian.Uint32(b[20:])),
+		version:      binary.BigEndian.Uint16(b[len(b)-len(sstFooterMagic)-2:]),
+	}
+	if len(b) == sstSeqFooterSize {
+		f.smallestSeq = binary.BigEndian.Uint64(b[24:])
+		f.largestSeq = binary.BigEndian.Uint64(b[32:])
+	}
+	return f, nil
+}
+
+// dataBlock is the decoded contents of a data block.
+type dataBlock struct {
+	tuples   []byte
+	restarts []uint32 // Offsets in tuples of every sstRestartInterval-th tuple, none before version 7.
+	version  uint16
+}
+
+// parseBlock splits the contents of a data block of a file of the given
+// version, as returned by decodeBlock, into its tuples and restart points.
+func parseBlock(data []byte, version uint16) (dataBlock, error) {
+	if version < sstRestartsVersion {
+		return dataBlock{tuples: data, version: version}, nil
+	}
+	if len(data) < 4 {
+		return dataBlock{}, errors.New("data block too short for its restart points")
+	}
+	count := int64(binary.BigEndian.Uint32(data[len(data)-4:]))
+	if count == 0 || 4*count+4 > int64(len(data)) {
+		return dataBlock{}, fmt.Errorf("data block with %d restart points", count)
+	}
+
+	end := len(data) - 4 - 4*int(count)
+	b := dataBlock{tuples: data[:end], restarts: make([]uint32, count), version: version}
+	for i := range b.restarts {
+		b.restarts[i] = binary.BigEndian.Uint32(data[end+4*i:])
+		if b.restarts[i] >= uint32(end) || (i > 0 && b.restarts[i] <= b.restarts[i-1]) {
+			return dataBlock{}, fmt.Errorf("restart point %d of data block out of order", i)
+		}
+	}
+	return b, nil
+}
+
+func (b dataBlock) cacheSize() int64 {
+	return int64(len(b.tuples) + 4*len(b.restarts))
+}
+
+// reader returns a reader over the tuples of the block.
+func (b dataBlock) reader() *tupleReader {
+	return newTupleReader(b.tuples, b.version)
+}
+
+// seek returns a reader over the tuples of the block from the last restart
+// point before or at key, found by binary search. Without restart points, it
+// reads from the first tuple.
+func (b dataBlock) seek(key []byte) (*tupleReader, error) {
+	var searchErr error
+	i := sort.Search(len(b.restarts), func(i int) bool {
+		tuple, err := newTupleReader(b.tuples[b.restarts[i]:], b.version).next()
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return bytes.Compare(tuple.Key, key) > 0
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	var start uint32
+	if i > 0 {
+		start = b.restarts[i-1]
+	}
+	return newTupleReader(b.tuples[start:], b.version), nil
+}
+
+// headerSize returns the size of header in the file.
+func headerSize(header SSTFileHeader) int64 {
+	size := int64(len(magicString) + 4 + 4 + len(header.SmallestKey) + 4 + len(header.LongestKey) + 2)
+	if header.Version >= sstChecksumVersion {
+		size += 4
+	}
+	return size
+}
+
+// sstReader reads the tuples of an SST file through its index. Files written
+// before version 3 have no blocks, in which case all their tuples are read
+// as a single block.
+type sstReader struct {
+	file      *os.File
+	header    SSTFileHeader
+	dataStart int64 // Offset of the first tuple.
+	dataEnd   int64 // Offset after the last tuple.
+
+	// The index is either blocks, or for a two-level index the partitions
+	// holding the handles of the blocks, read as needed.
+	blocks     []blockHandle
+	partitions []blockHandle
+
+	// filters lists the partitions of the bloom filter, read as needed. Nil
+	// before version 12, or for files without tuples.
+	filters []blockHandle
+
+	// The range of the sequence numbers of the tuples, 0 before version 10.
+	smallestSeq uint64
+	largestSeq  uint64
+
+	// cache keeps the data blocks read, under the file number, for the
+	// readers of the table cache. Nil for the others.
+	cache  *blockCache
+	number int
+}
+
+// newSSTReader reads the header and the index of file.
+func newSSTReader(file *os.File) (*sstReader, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header, err := (&SSTFile{File: file}).readHeader()
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &sstReader{file: file, header: header, dataStart: headerSize(header), dataEnd: info.Size()}
+	if header.Version < sstBlocksVersion {
+		r.blocks = []blockHandle{{firstKey: header.SmallestKey, offset: r.dataStart, size: r.dataEnd - r.dataStart}}
+		return r, nil
+	}
+
+	var indexOffset, indexSize int64
+	if header.Version < sstFooterVersion {
+		// The footer only points at the index, which starts right after the data.
+		var footer [sstIndexFooterSize]byte
+		if info.Size() < r.dataStart+sstIndexFooterSize {
+			return nil, fmt.Errorf("SST file %s too short for its footer", file.Name())
+		}
+		if _, err := file.ReadAt(footer[:], info.Size()-sstIndexFooterSize); err != nil {
+			return nil, err
+		}
+		indexOffset = int64(binary.BigEndian.Uint64(footer[:8]))
+		indexSize = int64(binary.BigEndian.Uint32(footer[8:]))
+		if indexOffset < r.dataStart || indexOffset+indexSize != info.Size()-sstIndexFooterSize {
+			return nil, fmt.Errorf("SST file %s has a corrupt footer", file.Name())
+		}
+		r.dataEnd = indexOffset
+	} else {
+		footer, err := r.readFooter(info.Size())
+		if err != nil {
+			return nil, err
+		}
+		indexOffset, indexSize = footer.indexOffset, footer.indexSize
+		r.smallestSeq, r.largestSeq = footer.smallestSeq, footer.largestSeq
+		r.dataEnd = indexOffset
+		if footer.filterSize > 0 {
+			r.dataEnd = footer.filterOffset
+		}
+		if footer.filterSize > 0 && header.Version >= sstFilterVersion {
+			// The filter partitions follow the data blocks, and the index
+			// partitions if any.
+			if err := r.readFilterIndex(footer.filterOffset, footer.filterSize); err != nil {
+				return nil, err
+			}
+			r.dataEnd = r.filters[0].offset
+		}
+	}
+
+	index := make([]byte, indexSize)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	kind, handles, err := r.decodeIndex(index, indexOffset, r.dataEnd)
+	if err != nil {
+		return nil, err
+	}
+	if kind == indexOfPartitions {
+		// The partitions follow the data blocks.
+		r.partitions = handles
+		if len(handles) > 0 {
+			r.dataEnd = handles[0].offset
+		}
+	} else {
+		r.blocks = handles
+	}
+	return r, nil
+}
+
+// readFooter reads and checks the footer of a file of the given size, which
+// must agree with the header.
+func (r *sstReader) readFooter(size int64) (sstFooter, error) {
+	name := r.file.Name()
+	footerLen := footerSize(r.header.Version)
+	if size < r.dataStart+footerLen {
+		return sstFooter{}, fmt.Errorf("%w: %s too short for its footer", ErrNotSST, name)
+	}
+	b := make([]byte, footerLen)
+	if _, err := r.file.ReadAt(b, size-footerLen); err != nil {
+		return sstFooter{}, err
+	}
+	footer, err := decodeFooter(b)
+	if err != nil {
+		return sstFooter{}, fmt.Errorf("%w: %s has no SST footer", ErrNotSST, name)
+	}
+
+	if footer.version != r.header.Version {
+		return sstFooter{}, fmt.Errorf("SST file %s has version %d in its header but %d in its footer", name, r.header.Version, footer.version)
+	}
+	end := size - footerLen
+	if footer.indexOffset < r.dataStart || footer.indexOffset+footer.indexSize != end {
+		return sstFooter{}, fmt.Errorf("SST file %s has a corrupt footer: index at %d+%d", name, footer.indexOffset, footer.indexSize)
+	}
+	if footer.filterSize > 0 && (footer.filterOffset < r.dataStart || footer.filterOffset+footer.filterSize != footer.indexOffset) {
+		return sstFooter{}, fmt.Errorf("SST file %s has a corrupt footer: filter at %d+%d", name, footer.filterOffset, footer.filterSize)
+	}
+	return footer, nil
+}
+
+// block returns a reader over the tuples of the block of h.
+func (r *sstReader) block(h blockHandle) (*tupleReader, error) {
+	if r.header.Version < sstBlocksVersion {
+		// The whole file is one block, too large to hold in memory.
+		return &tupleReader{r: bufio.NewReader(io.NewSectionReader(r.file, h.offset, h.size))}, nil
+	}
+
+	b, err := r.readBlock(h)
+	if err != nil {
+		return nil, err
+	}
+	return b.reader(), nil
+}
+
+// readBlock reads the block of h, in a file of version 3 or later, through
+// the block cache if r has one.
+func (r *sstReader) readBlock(h blockHandle) (dataBlock, error) {
+	key := blockKey{file: r.number, offset: h.offset}
+	if b, ok := r.cache.get(key); ok {
+		return b.(dataBlock), nil
+	}
+
+	buf := make([]byte, h.size)
+	if _, err := r.file.ReadAt(buf, h.offset); err != nil {
+		return dataBlock{}, err
+	}
+	b, err := r.decodeBlock(h, buf)
+	if err == nil {
+		r.cache.add(key, b)
+	}
+	return b, err
+}
+
+// decodeBlock decodes the block of h, given its data.
+func (r *sstReader) decodeBlock(h blockHandle, data []byte) (dataBlock, error) {
+	tuples, err := decodeBlock(data, r.header.Version)
+	if err == errChecksum {
+		return dataBlock{}, &ChecksumError{Path: r.file.Name(), Part: "data block", Offset: h.offset}
+	}
+	if err != nil {
+		return dataBlock{}, fmt.Errorf("block at %d of %s: %v", h.offset, r.file.Name(), err)
+	}
+	b, err := parseBlock(tuples, r.header.Version)
+	if err != nil {
+		return dataBlock{}, fmt.Errorf("block at %d of %s: %v", h.offset, r.file.Name(), err)
+	}
+	return b, nil
+}
+
+// find looks up key in the file, returning 1 and its pair if present, -1 if
+// deleted or expired, -2 if absent and 0 with the error on read errors, like
+// SSTFile.Get.
+func (r *sstReader) find(key []byte) (SSTPair, int, error) {
+	// Keys outside the range of the file can't be in it.
+	if bytes.Compare(key, r.header.SmallestKey) < 0 || bytes.Compare(key, r.header.LongestKey) > 0 {
+		return SSTPair{}, -2, nil
+	}
+	// Nor can keys the filter rules out.
+	if ok, err := r.mayContain(key); !ok {
+		if err != nil {
+			return SSTPair{}, 0, err
+		}
+		return SSTPair{}, -2, nil
+	}
+	h, ok, err := r.blockFor(key)
+	if err != nil {
+		return SSTPair{}, 0, err
+	}
+	if !ok {
+		return SSTPair{}, -2, nil
+	}
+
+	var block *tupleReader
+	if r.header.Version < sstBlocksVersion {
+		block, err = r.block(h)
+	} else {
+		var b dataBlock
+		if b, err = r.readBlock(h); err == nil {
+			block, err = b.seek(key)
+		}
+	}
+	if err != nil {
+		return SSTPair{}, 0, err
+	}
+	for {
+		tuple, err := block.next()
+		if err == io.EOF {
+			return SSTPair{}, -2, nil
+		}
+		if err != nil {
+			return SSTPair{}, 0, err
+		}
+
+		switch c := bytes.Compare(tuple.Key, key); {
+		case c > 0:
+			// Tuples are sorted, so key isn't in the file.
+			return SSTPair{}, -2, nil
+		case c == 0:
+			// An expired value hides older versions like a tombstone does.
+			if tuple.Value.Operation == delOperation || expired(tuple.Value.ExpiresAt) {
+				return tuple.Value, -1, nil
+			}
+			return tuple.Value, 1, nil
+		}
+	}
+}
+
+// verifySSTFiles verifies the SST files of dir, as VerifyChecksums
+// requests, reading all their tuples to check the blocks holding them, and
+// their filter partitions.
+func verifySSTFiles(dir string, files []*sstMeta) error {
+	for _, f := range files {
+		it, err := newSSTIterator(filepath.Join(dir, f.name()))
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+		}
+		err = it.Err()
+		for _, h := range it.cursor.r.filters {
+			if err != nil {
+				break
+			}
+			_, err = it.cursor.r.readFilter(h)
+		}
+		it.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// sstFilterVersion is the first version whose files hold bloom filters of
+	// their keys, so that looking up a key absent from a file seldom reads
+	// its data blocks.
+	sstFilterVersion = 12
+
+	// sstFilterPartitionSize is the size of the filter past which it is
+	// split into partitions, each covering the keys of consecutive data
+	// blocks. The filter index then only lists the partitions, read as lookups
+	// need them through the block cache, so a large file doesn't need its
+	// whole filter in memory.
+	sstFilterPartitionSize = 4 << 10
+)
+
+// DefaultFilterBitsPerKey is the size of the bloom filters for each key when
+// Options.FilterBitsPerKey is unset, which gives about 1% of false positives.
+const DefaultFilterBitsPerKey = 10
+
+// filterHashes returns the number of bits set for each key in a filter of
+// bitsPerKey bits per key: the one giving the fewest false positives, within
+// what decodeFilter accepts.
+func filterHashes(bitsPerKey int) int {
+	return min(max(int(math.Round(float64(bitsPerKey)*math.Ln2)), 1), 30)
+}
+
+// The filter of an SST file is made of partitions following the index
+// partitions, if any, then of the filter index listing them, which the footer
+// points at and the top-level index block follows. The filter index is encoded like an index block of the first key
+// of every partition. A partition holds the bits of a bloom filter, the
+// number of hashes and a CRC32.
+
+// bloomFilter is a decoded filter partition.
+type bloomFilter struct {
+	bits   []byte
+	hashes int
+}
+
+func (f bloomFilter) cacheSize() int64 {
+	return int64(len(f.bits))
+}
+
+// bloomHash returns the hash of key from which the bits of the filters are
+// derived: FNV-1a, with the finalizer of MurmurHash3 to mix its bits.
+func bloomHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	return h ^ h>>33
+}
+
+// encodeFilter returns the filter partition of bitsPerKey bits per key of
+// the keys whose bloomHash are hashes.
+func encodeFilter(hashes []uint64, bitsPerKey int) []byte {
+	k := filterHashes(bitsPerKey)
+	bits := max(64, len(hashes)*bitsPerKey)
+	data := make([]byte, (bits+7)/8, (bits+7)/8+1+4)
+	bits = len(data) * 8
+	for _, h := range hashes {
+		// Derive the bits from the two halves of the hash.
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := 0; i < k; i++ {
+			bit := (h1 + uint32(i)*h2) % uint32(bits)
+			data[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return appendChecksum(append(data, byte(k)))
+}
+
+// decodeFilter decodes a filter partition encoded by encodeFilter.
+func decodeFilter(data []byte) (bloomFilter, error) {
+	data, err := stripChecksum(data)
+	if err != nil {
+		return bloomFilter{}, err
+	}
+	if len(data) < 2 {
+		return bloomFilter{}, fmt.Errorf("filter partition of %d bytes", len(data))
+	}
+	f := bloomFilter{bits: data[:len(data)-1], hashes: int(data[len(data)-1])}
+	if f.hashes == 0 || f.hashes > 30 {
+		return bloomFilter{}, fmt.Errorf("filter partition with %d hashes", f.hashes)
+	}
+	return f, nil
+}
+
+// mayContain reports whether the key of hash h may be in the filter. It is
+// false only for keys that were not added.
+func (f bloomFilter) mayContain(h uint64) bool {
+	bits := uint32(len(f.bits) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint32(i)*h2) % bits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterWriter builds the filter of an SST file as its tuples are added.
+// Partitions are cut after the data block that fills them, and kept encoded
+// until the file is finished.
+type filterWriter struct {
+	bitsPerKey int
+	hashes     []uint64 // Of the keys of the partition being filled.
+	first      []byte   // First key of the partition being filled.
+	data       bytes.Buffer
+	partitions []blockHandle // Offsets relative to the start of data.
+}
+
+// add adds key to the partition being filled.
+func (fw *filterWriter) add(key []byte) {
+	if len(fw.hashes) == 0 {
+		fw.first = append([]byte(nil), key...)
+	}
+	fw.hashes = append(fw.hashes, bloomHash(key))
+}
+
+// blockDone is called after every data block, to cut the partition being
+// filled once it is full.
+func (fw *filterWriter) blockDone() {
+	if len(fw.hashes)*fw.bitsPerKey >= 8*sstFilterPartitionSize {
+		fw.cut()
+	}
+}
+
+// cut ends the partition being filled.
+func (fw *filterWriter) cut() {
+	if len(fw.hashes) == 0 {
+		return
+	}
+	data := encodeFilter(fw.hashes, fw.bitsPerKey)
+	fw.partitions = append(fw.partitions, blockHandle{firstKey: fw.first, offset: int64(fw.data.Len()), size: int64(len(data))})
+	fw.data.Write(data)
+	fw.hashes = fw.hashes[:0]
+}
+
+// finish writes the partitions, then the filter index, to w at offset in the
+// file, and returns the offset and size of the filter index.
+func (fw *filterWriter) finish(w io.Writer, offset int64) (int64, int64, error) {
+	fw.cut()
+	if _, err := w.Write(fw.data.Bytes()); err != nil {
+		return 0, 0, err
+	}
+	partitions := make([]blockHandle, len(fw.partitions))
+	for i, h := range fw.partitions {
+		h.offset += offset
+		partitions[i] = h
+	}
+	index := encodeIndex(indexOfBlocks, partitions)
+	_, err := w.Write(index)
+	return offset + int64(fw.data.Len()), int64(len(index)), err
+}
+
+// readFilterIndex reads the filter index of the file, at offset with size,
+// whose partitions lie after the data blocks and before the index.
+func (r *sstReader) readFilterIndex(offset, size int64) error {
+	data := make([]byte, size)
+	if _, err := r.file.ReadAt(data, offset); err != nil {
+		return err
+	}
+	kind, partitions, err := r.decodeIndex(data, offset, offset)
+	if err != nil {
+		return err
+	}
+	if kind != indexOfBlocks || len(partitions) == 0 {
+		return fmt.Errorf("SST file %s: filter index at %d of kind %d with %d partitions", r.file.Name(), offset, kind, len(partitions))
+	}
+	r.filters = partitions
+	return nil
+}
+
+// readFilter reads the filter partition of h, through the block cache if r
+// has one.
+func (r *sstReader) readFilter(h blockHandle) (bloomFilter, error) {
+	key := blockKey{file: r.number, offset: h.offset}
+	if f, ok := r.cache.get(key); ok {
+		return f.(bloomFilter), nil
+	}
+
+	data := make([]byte, h.size)
+	if _, err := r.file.ReadAt(data, h.offset); err != nil {
+		return bloomFilter{}, err
+	}
+	f, err := decodeFilter(data)
+	if err == errChecksum {
+		return bloomFilter{}, &ChecksumError{Path: r.file.Name(), Part: "filter partition", Offset: h.offset}
+	}
+	if err != nil {
+		return bloomFilter{}, fmt.Errorf("filter partition at %d of %s: %v", h.offset, r.file.Name(), err)
+	}
+	r.cache.add(key, f)
+	return f, nil
+}
+
+// mayContain reports whether the filter of the file lets key be in it,
+// reading the partition covering key. Files without a filter may hold any
+// key.
+func (r *sstReader) mayContain(key []byte) (bool, error) {
+	if r.filters == nil {
+		return true, nil
+	}
+	p := searchHandles(r.filters, key)
+	if p < 0 {
+		return false, nil
+	}
+	f, err := r.readFilter(r.filters[p])
+	if err != nil {
+		return false, err
+	}
+	return f.mayContain(bloomHash(key)), nil
+}
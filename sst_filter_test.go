@@ -0,0 +1,171 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 10000; i++ {
+		hashes = append(hashes, bloomHash([]byte(fmt.Sprintf("key%05d", i))))
+	}
+	f, err := decodeFilter(encodeFilter(hashes, DefaultFilterBitsPerKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range hashes {
+		if !f.mayContain(h) {
+			t.Fatalf("Expected key%05d to be in the filter", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if f.mayContain(bloomHash([]byte(fmt.Sprintf("other%05d", i)))) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("Expected about 1%% of false positives, got %d in 10000", positives)
+	}
+
+	corrupt := encodeFilter(hashes, DefaultFilterBitsPerKey)
+	corrupt[0]++
+	if _, err := decodeFilter(corrupt); err != errChecksum {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestPartitionedFilter(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 20000; i++ {
+		tuples = append(tuples, set(fmt.Sprintf("key%05d", 2*i), "value"))
+	}
+	f := writeTestSST(t, dir, 1, tuples)
+	path := filepath.Join(dir, f.name())
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := newSSTReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.filters) < 4 {
+		t.Fatalf("Expected the filter to be partitioned, got %d partitions", len(r.filters))
+	}
+	r.cache, r.number = newBlockCache(1<<20), 1
+
+	// Partitions are read as lookups need them.
+	if _, n, err := r.find([]byte("key00001")); n != -2 || err != nil {
+		t.Fatalf("Expected key00001 to be absent, got %d (%v)", n, err)
+	}
+	if r.cache.lru.Len() != 1 {
+		t.Errorf("Expected only the first partition to be read, got %d blocks", r.cache.lru.Len())
+	}
+
+	// Absent keys seldom get past the filter to the data blocks.
+	positives := 0
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key%05d", 2*i+1))
+		ok, err := r.mayContain(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			positives++
+		}
+		if _, n, err := r.find(key); n != -2 || err != nil {
+			t.Fatalf("Expected %s to be absent, got %d (%v)", key, n, err)
+		}
+	}
+	if positives > 600 {
+		t.Errorf("Expected about 1%% of false positives, got %d in 20000", positives)
+	}
+	for i := 0; i < 20000; i += 997 {
+		if _, n, err := r.find([]byte(fmt.Sprintf("key%05d", 2*i))); n != 1 || err != nil {
+			t.Fatalf("Expected key%05d to be found, got %d (%v)", 2*i, n, err)
+		}
+	}
+
+	// A partition that lost its keys, whose checksum still matches, is
+	// reported by VerifySST.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.filters[1]
+	cleared := appendChecksum(append(make([]byte, h.size-5), byte(filterHashes(DefaultFilterBitsPerKey))))
+	copy(data[h.offset:], cleared)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifySST(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "missing from the bloom filter") {
+		t.Errorf("Expected the missing keys to be reported, got %q", report.Problems)
+	}
+}
+
+func TestFilterBitsPerKey(t *testing.T) {
+	if k := filterHashes(DefaultFilterBitsPerKey); k != 7 {
+		t.Errorf("Expected 7 hashes for the default filter, got %d", k)
+	}
+
+	// A store writes filters of the size it was opened with, and those with
+	// more bits per key give fewer false positives.
+	var rates []int
+	for _, bits := range []int{4, 20} {
+		mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), FilterBitsPerKey: bits})
+		if err != nil {
+			t.Fatalf("Error opening store: %v", err)
+		}
+		for i := 0; i < 5000; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+		files := mem.manifest.current()
+		mem.Close()
+
+		file, err := os.Open(filepath.Join(mem.sstDir, files[0].name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := newSSTReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var size int64
+		positives := 0
+		for i := range r.filters {
+			size += r.filters[i].size
+		}
+		for i := 0; i < 10000; i++ {
+			key := []byte(fmt.Sprintf("other%05d", i))
+			if ok, err := r.mayContain(key); err != nil {
+				t.Fatal(err)
+			} else if ok {
+				positives++
+			}
+		}
+		file.Close()
+
+		if want := int64(5000 * bits / 8); size < want || size > want+int64(len(r.filters))*64 {
+			t.Errorf("Expected about %d bytes of filter with %d bits per key, got %d", want, bits, size)
+		}
+		rates = append(rates, positives)
+	}
+	if rates[1]*10 > rates[0] {
+		t.Errorf("Expected larger filters to cut the false positives, got %d then %d in 10000", rates[0], rates[1])
+	}
+}
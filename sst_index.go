@@ -0,0 +1,197 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// sstPartitionedVersion is the first version whose index blocks start
+	// with their kind, so that the index of a large file can be partitioned.
+	sstPartitionedVersion = 8
+
+	// sstIndexPartitionSize is the size of the index past which it is split
+	// into partitions, and the size partitions are filled up to. The index
+	// then only lists the partitions, which keeps it small enough to stay in
+	// the table cache whatever the size of the file.
+	sstIndexPartitionSize = 4 << 10
+)
+
+// The kinds of index blocks.
+const (
+	indexOfBlocks     byte = 0 // Locates data blocks.
+	indexOfPartitions byte = 1 // Locates partitions, which are indexes of data blocks.
+)
+
+// searchHandles returns the index of the last of handles whose first key is at
+// most key, or -1 if key sorts before all of them.
+func searchHandles(handles []blockHandle, key []byte) int {
+	return sort.Search(len(handles), func(i int) bool { return bytes.Compare(handles[i].firstKey, key) > 0 }) - 1
+}
+
+// encodeIndex encodes an index block of the given kind, listing handles.
+func encodeIndex(kind byte, handles []blockHandle) []byte {
+	var index bytes.Buffer
+	index.WriteByte(kind)
+	writeBinary(&index, uint32(len(handles)))
+	for _, h := range handles {
+		writeBinary(&index, uint32(len(h.firstKey)), h.firstKey, uint64(h.offset), uint32(h.size))
+	}
+	return appendChecksum(index.Bytes())
+}
+
+// indexEntrySize returns the size of the entry of h in an index block.
+func indexEntrySize(h blockHandle) int {
+	return 4 + len(h.firstKey) + 8 + 4
+}
+
+// writeIndexPartitions writes the index of blocks to w, at offset in the
+// file, up to its top-level block, which it returns encoded for the caller to
+// write, with the offset following what it wrote. An index larger than
+// sstIndexPartitionSize is written as partitions, which the top-level block
+// lists; a smaller one is only the top-level block.
+func writeIndexPartitions(w io.Writer, blocks []blockHandle, offset int64) ([]byte, int64, error) {
+	size := 0
+	for _, h := range blocks {
+		size += indexEntrySize(h)
+	}
+	if size <= sstIndexPartitionSize {
+		return encodeIndex(indexOfBlocks, blocks), offset, nil
+	}
+
+	var partitions []blockHandle
+	for start := 0; start < len(blocks); {
+		end, size := start, 0
+		for end < len(blocks) && size < sstIndexPartitionSize {
+			size += indexEntrySize(blocks[end])
+			end++
+		}
+		data := encodeIndex(indexOfBlocks, blocks[start:end])
+		if _, err := w.Write(data); err != nil {
+			return nil, 0, err
+		}
+		partitions = append(partitions, blockHandle{firstKey: blocks[start].firstKey, offset: offset, size: int64(len(data))})
+		offset += int64(len(data))
+		start = end
+	}
+	return encodeIndex(indexOfPartitions, partitions), offset, nil
+}
+
+// decodeIndex decodes the index block data found at offset, checking that the
+// blocks it lists lie within [r.dataStart, end).
+func (r *sstReader) decodeIndex(data []byte, offset, end int64) (byte, []blockHandle, error) {
+	if r.header.Version >= sstChecksumVersion {
+		var err error
+		if data, err = stripChecksum(data); err != nil {
+			return 0, nil, &ChecksumError{Path: r.file.Name(), Part: "index", Offset: offset}
+		}
+	}
+	kind := indexOfBlocks
+	if r.header.Version >= sstPartitionedVersion {
+		if len(data) == 0 {
+			return 0, nil, fmt.Errorf("SST file %s: empty index block at %d", r.file.Name(), offset)
+		}
+		kind, data = data[0], data[1:]
+	}
+	if kind != indexOfBlocks && kind != indexOfPartitions {
+		return 0, nil, fmt.Errorf("SST file %s: index block at %d of unknown kind %d", r.file.Name(), offset, kind)
+	}
+
+	handles, err := decodeHandles(data, r.dataStart, end)
+	if err != nil {
+		return 0, nil, fmt.Errorf("SST file %s: index block at %d: %v", r.file.Name(), offset, err)
+	}
+	return kind, handles, nil
+}
+
+// decodeHandles decodes the entries of an index block, checking that they lie
+// within [start, end).
+func decodeHandles(index []byte, start, end int64) ([]blockHandle, error) {
+	r := bytes.NewReader(index)
+	var count uint32
+	if err := readBinary(r, &count); err != nil {
+		return nil, err
+	}
+
+	// The count comes from disk, so don't preallocate from it.
+	var handles []blockHandle
+	for i := uint32(0); i < count; i++ {
+		key, err := readKeyValue(r)
+		if err != nil {
+			return nil, err
+		}
+		var offset uint64
+		var size uint32
+		if err := readBinary(r, &offset, &size); err != nil {
+			return nil, err
+		}
+		h := blockHandle{firstKey: key, offset: int64(offset), size: int64(size)}
+		if h.offset < start || h.offset+h.size > end {
+			return nil, fmt.Errorf("entry %d out of the blocks it indexes", i)
+		}
+		handles = append(handles, h)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	return handles, nil
+}
+
+// readPartition reads partition p of a two-level index.
+func (r *sstReader) readPartition(p int) ([]blockHandle, error) {
+	h := r.partitions[p]
+	data := make([]byte, h.size)
+	if _, err := r.file.ReadAt(data, h.offset); err != nil {
+		return nil, err
+	}
+	kind, blocks, err := r.decodeIndex(data, h.offset, r.dataEnd)
+	if err != nil {
+		return nil, err
+	}
+	if kind != indexOfBlocks {
+		return nil, fmt.Errorf("SST file %s: nested index partition at %d", r.file.Name(), h.offset)
+	}
+	return blocks, nil
+}
+
+// blockFor returns the handle of the block that may hold key, and false if key
+// sorts before every block. With a two-level index, it reads the partition
+// listing the block.
+func (r *sstReader) blockFor(key []byte) (blockHandle, bool, error) {
+	blocks := r.blocks
+	if r.partitions != nil {
+		p := searchHandles(r.partitions, key)
+		if p < 0 {
+			return blockHandle{}, false, nil
+		}
+		var err error
+		if blocks, err = r.readPartition(p); err != nil {
+			return blockHandle{}, false, err
+		}
+	}
+
+	i := searchHandles(blocks, key)
+	if i < 0 {
+		return blockHandle{}, false, nil
+	}
+	return blocks[i], true, nil
+}
+
+// allBlocks returns the handles of every block of the file, reading all the
+// partitions of a two-level index.
+func (r *sstReader) allBlocks() ([]blockHandle, error) {
+	if r.partitions == nil {
+		return r.blocks, nil
+	}
+	var blocks []blockHandle
+	for p := range r.partitions {
+		partition, err := r.readPartition(p)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, partition...)
+	}
+	return blocks, nil
+}
@@ -0,0 +1,112 @@
+package kvstore
+
+import "path/filepath"
+
+// SSTIterator walks the tuples of a single SST file in key order, deletions
+// included, such as to merge files or export them. Unlike Iterator, it doesn't
+// hide deleted or expired keys: every tuple of the file is yielded as stored.
+type SSTIterator struct {
+	cursor  *sstCursor
+	values  *valueLog // Of the directory of the file.
+	started bool
+	tuple   SSTTuple
+	err     error
+}
+
+// Iterator returns an iterator over the tuples of the file. It reads the file
+// through its own handle, so the SSTFile can still be used meanwhile, and
+// checks the checksums of the blocks it reads.
+func (s *SSTFile) Iterator() (*SSTIterator, error) {
+	return newSSTIterator(s.File.Name())
+}
+
+// newSSTIterator returns an iterator over the tuples of the SST file at path.
+func newSSTIterator(path string) (*SSTIterator, error) {
+	cursor, err := newSSTCursor(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SSTIterator{cursor: cursor, values: newValueLog(filepath.Dir(path))}, nil
+}
+
+// Next moves the iterator to the next tuple. It returns false when the file
+// is exhausted or an error occurred.
+func (it *SSTIterator) Next() bool {
+	if it.err != nil || it.cursor == nil {
+		return false
+	}
+	if it.started {
+		if err := it.cursor.advance(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.started = true
+
+	cur := it.cursor.current()
+	if cur == nil {
+		return false
+	}
+	it.tuple = *cur
+	return true
+}
+
+// Tuple returns the tuple the iterator is positioned on. A value kept in the
+// value log isn't read, so that writing the tuple to another file keeps
+// pointing to it.
+func (it *SSTIterator) Tuple() SSTTuple {
+	return it.tuple
+}
+
+// Key returns the key of the tuple.
+func (it *SSTIterator) Key() []byte {
+	return it.tuple.Key
+}
+
+// Op returns the operation of the tuple, SET or DEL.
+func (it *SSTIterator) Op() string {
+	return it.tuple.Value.Operation
+}
+
+// Value returns the value of the tuple, nil for a DEL. A value kept in the
+// value log is read from the vlog files next to the SST file. If that fails,
+// Value returns nil and the error stops the iteration.
+func (it *SSTIterator) Value() []byte {
+	if it.tuple.Value.blob != nil && it.err == nil {
+		pair, err := it.values.resolve(it.tuple.Key, it.tuple.Value)
+		if err != nil {
+			it.err = err
+		}
+		return pair.Value
+	}
+	return it.tuple.Value.Value
+}
+
+// ExpiresAt returns the expiration time of the value in Unix nanoseconds, 0 if
+// it never expires.
+func (it *SSTIterator) ExpiresAt() int64 {
+	return it.tuple.Value.ExpiresAt
+}
+
+// Seq returns the sequence number of the write of the tuple, 0 if unknown.
+func (it *SSTIterator) Seq() uint64 {
+	return it.tuple.Value.Seq
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *SSTIterator) Err() error {
+	return it.err
+}
+
+// Close releases the file handle of the iterator.
+func (it *SSTIterator) Close() error {
+	if it.cursor == nil {
+		return nil
+	}
+	err := it.cursor.close()
+	if closeErr := it.values.close(); err == nil {
+		err = closeErr
+	}
+	it.cursor = nil
+	return err
+}
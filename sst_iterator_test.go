@@ -0,0 +1,58 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSSTIterator(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 1000; i++ {
+		tuples = append(tuples, set(fmt.Sprintf("key%04d", i), fmt.Sprintf("value%d", i)))
+	}
+	tuples[3] = del("key0003")
+	tuples[5].Value.ExpiresAt = 1 // Long expired.
+	writeTestSST(t, dir, 1, tuples)
+
+	file, err := os.Open(filepath.Join(dir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sst := &SSTFile{File: file}
+
+	it, err := sst.Iterator()
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	defer it.Close()
+
+	n := 0
+	for it.Next() {
+		want := tuples[n]
+		if string(it.Key()) != string(want.Key) || it.Op() != want.Value.Operation ||
+			string(it.Value()) != string(want.Value.Value) || it.ExpiresAt() != want.Value.ExpiresAt {
+			t.Fatalf("Expected tuple %d to be %+v, got %+v", n, want, it.Tuple())
+		}
+
+		// The file can still be read while iterating.
+		if n == 500 {
+			if value, found := sst.Get(want.Key); found != 1 || string(value) != "value500" {
+				t.Errorf("Unexpected Get during iteration: %q, %d", value, found)
+			}
+		}
+		n++
+	}
+	if it.Err() != nil || n != len(tuples) {
+		t.Errorf("Expected %d tuples, got %d (%v)", len(tuples), n, it.Err())
+	}
+	if it.Next() {
+		t.Error("Expected the iterator to stay exhausted")
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("Error closing iterator: %v", err)
+	}
+}
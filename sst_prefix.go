@@ -0,0 +1,124 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// sstPrefixVersion is the first version whose data blocks store each key as
+// the length of the prefix it shares with the previous key and the rest of
+// it. Restart points store their key whole, so that reads can start there.
+const sstPrefixVersion = 9
+
+// sharedPrefixLen returns the length of the common prefix of a and b.
+func sharedPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// writePrefixedTuple writes entry to w with its key prefix compressed against
+// prevKey, nil at restart points: the opcode, the uvarint lengths of the
+// shared prefix and of the rest of the key, the rest of the key, the uvarint
+// sequence number of the tuple from version 10 on, then the value as
+// writeTuple writes it.
+func writePrefixedTuple(w *bytes.Buffer, entry SSTTuple, prevKey []byte, version uint16) error {
+	op, value, err := encodeTupleValue(entry)
+	if err != nil {
+		return err
+	}
+	shared := sharedPrefixLen(prevKey, entry.Key)
+
+	w.WriteByte(byte(op))
+	var lengths [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lengths[:], uint64(shared))
+	n += binary.PutUvarint(lengths[n:], uint64(len(entry.Key)-shared))
+	w.Write(lengths[:n])
+	w.Write(entry.Key[shared:])
+	if version >= sstSeqVersion {
+		n = binary.PutUvarint(lengths[:], entry.Value.Seq)
+		w.Write(lengths[:n])
+	}
+	if op == opDel {
+		return nil
+	}
+	return writeBinary(w, uint32(len(value)), value)
+}
+
+// tupleReader reads the tuples of a data block in order, returning io.EOF
+// after the last one.
+type tupleReader struct {
+	r io.Reader
+	// block is set instead of r for blocks whose keys are prefix
+	// compressed, which are always held in memory.
+	block   *bytes.Reader
+	seqs    bool // Tuples of block hold their sequence number.
+	prevKey []byte
+}
+
+// newTupleReader returns a reader over the tuples of data, which is a data
+// block of a file of the given version or, from version 9 on, the end of one
+// from a restart point.
+func newTupleReader(data []byte, version uint16) *tupleReader {
+	if version >= sstPrefixVersion {
+		return &tupleReader{block: bytes.NewReader(data), seqs: version >= sstSeqVersion}
+	}
+	return &tupleReader{r: bytes.NewReader(data)}
+}
+
+// next returns the next tuple.
+func (t *tupleReader) next() (SSTTuple, error) {
+	if t.block == nil {
+		return readTuple(t.r)
+	}
+
+	var tuple SSTTuple
+	operation, _, err := readOperation(t.block)
+	if err != nil {
+		return tuple, err
+	}
+	shared, err := binary.ReadUvarint(t.block)
+	if err != nil {
+		return tuple, unexpectedEOF(err)
+	}
+	suffix, err := binary.ReadUvarint(t.block)
+	if err != nil {
+		return tuple, unexpectedEOF(err)
+	}
+	if shared > uint64(len(t.prevKey)) || suffix > uint64(t.block.Len()) {
+		return tuple, fmt.Errorf("key sharing %d bytes with the previous one followed by %d more", shared, suffix)
+	}
+
+	// The key is a new slice, since the tuple may outlive the reader.
+	tuple.Key = make([]byte, int(shared)+int(suffix))
+	copy(tuple.Key, t.prevKey[:shared])
+	if _, err := io.ReadFull(t.block, tuple.Key[shared:]); err != nil {
+		return tuple, unexpectedEOF(err)
+	}
+	t.prevKey = tuple.Key
+
+	var seq uint64
+	if t.seqs {
+		if seq, err = binary.ReadUvarint(t.block); err != nil {
+			return tuple, unexpectedEOF(err)
+		}
+	}
+	tuple.Value, err = readTupleValue(t.block, operation)
+	tuple.Value.Seq = seq
+	return tuple, err
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF for io.EOF, which only means
+// there are no tuples left when no byte of the tuple was read.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
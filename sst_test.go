@@ -0,0 +1,721 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+func TestNewSSTFile(t *testing.T) {
+	res, err := NewSSTFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating the file: %s", err)
+	}
+	res.Close()
+	if err := os.Remove(res.File.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWriteBinary(t *testing.T) {
+	sst, err := NewSSTFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating the file: %s", err)
+	}
+
+	var (
+		var1 []byte = []byte{72, 101, 108, 108, 111}
+		var2 uint32 = 123456
+		var3 uint16 = 12345
+		res1 []byte
+		res2 uint32
+		res3 uint16
+	)
+
+	err = writeBinary(sst.File, var1, var2, var3)
+	if err != nil {
+		t.Fatalf("Error writing the variables: %s", err)
+	}
+	sst.File.Seek(0, 0)
+
+	res1, err = readBytes(sst.File, 5)
+	if err != nil {
+		t.Fatalf("Error reading fixed number of bytes: %s", err)
+	}
+
+	err = readBinary(sst.File, &res2, &res3)
+	if !bytes.Equal(res1, var1) || res2 != var2 || res3 != var3 {
+		t.Fatalf("Read unexpected values: %s", err)
+	}
+
+	sst.Close()
+	if err := os.Remove(sst.File.Name()); err != nil {
+		t.Log(err)
+	}
+}
+
+func TestReadWriteHeader(t *testing.T) {
+	var h, res SSTFileHeader
+	h.Magic = []byte(magicString)
+	h.EntryCount = 2
+	h.LongestKey = []byte("fooo")
+	h.SmallestKey = []byte("foo")
+	h.Version = 3
+
+	sst, err := NewSSTFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating the file: %s", err)
+	}
+
+	err = sst.writeHeader(h)
+	if err != nil {
+		t.Fatalf("Error writing the header: %s", err)
+	}
+
+	sst.File.Seek(0, 0)
+
+	res, err = sst.readHeader()
+	if err != nil {
+		t.Fatalf("Error reading the header: %s", err)
+	}
+
+	if !reflect.DeepEqual(h, res) {
+		t.Fatalf("Error: written and read headers are not equal")
+	}
+
+	sst.Close()
+	if err := os.Remove(sst.File.Name()); err != nil {
+		t.Log(err)
+	}
+}
+
+func TestUnknownSSTVersion(t *testing.T) {
+	for _, version := range []uint16{0, sstVersion + 1} {
+		sst, err := NewSSTFile(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sst.Close()
+		sst.writeHeader(SSTFileHeader{Magic: []byte(magicString), EntryCount: 1, SmallestKey: []byte("a"), LongestKey: []byte("a"), Version: version})
+
+		sst.File.Seek(0, 0)
+		if _, err := sst.readHeader(); !errors.Is(err, ErrUnknownSSTVersion) {
+			t.Errorf("Expected version %d to be rejected, got %v", version, err)
+		}
+		if _, n := sst.Get([]byte("a")); n != 0 {
+			t.Errorf("Expected reading version %d to fail, got %d", version, n)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	sst, err := NewSSTFile(t.TempDir())
+	if err != nil {
+		t.Errorf("Error creating the file: %s", err)
+	}
+
+	var key = []byte("foo")
+
+	var p SSTPair
+	p.Operation = setOperation
+	p.Value = []byte("bar")
+
+	var entry SSTTuple
+	entry.Key = key
+	entry.Value = p
+
+	var h SSTFileHeader
+	h.Magic = []byte(magicString)
+	h.EntryCount = 1
+	h.LongestKey = entry.Key
+	h.SmallestKey = entry.Key
+	h.Version = sstVersion
+
+	sst.writeTable(h, []SSTTuple{entry})
+
+	sst.File.Seek(0, 0)
+
+	res, n := sst.Get(entry.Key)
+	if n != 1 {
+		t.Fatalf("Error finding key")
+	}
+	if !bytes.Equal(res, entry.Value.Value) {
+		t.Fatalf("Error: retrieved unexpected value")
+	}
+
+	sst.Close()
+	if err := os.Remove(sst.File.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSTHas(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sst := &SSTFile{File: file}
+
+	tuples := []SSTTuple{
+		{Key: []byte("b"), Value: SSTPair{Operation: setOperation, Value: []byte("bar")}},
+		{Key: []byte("c"), Value: SSTPair{Operation: delOperation}},
+		{Key: []byte("d"), Value: SSTPair{Operation: setOperation, Value: []byte("baz")}},
+	}
+	sst.writeTable(SSTFileHeader{
+		Magic:       []byte(magicString),
+		EntryCount:  uint32(len(tuples)),
+		SmallestKey: []byte("b"),
+		LongestKey:  []byte("d"),
+		Version:     sstVersion,
+	}, tuples)
+
+	cases := map[string]int{"a": -2, "b": 1, "c": -1, "d": 1, "bb": -2, "e": -2}
+	for key, want := range cases {
+		sst.File.Seek(0, 0)
+		if n := sst.Has([]byte(key)); n != want {
+			t.Errorf("Has(%q) = %d, expected %d", key, n, want)
+		}
+	}
+}
+
+func TestLookupSorted(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sst := &SSTFile{File: file}
+
+	tuples := []SSTTuple{
+		{Key: []byte("b"), Value: SSTPair{Operation: setOperation, Value: []byte("1")}},
+		{Key: []byte("d"), Value: SSTPair{Operation: delOperation}},
+		{Key: []byte("f"), Value: SSTPair{Operation: setOperation, Value: []byte("3")}},
+	}
+	sst.writeTable(SSTFileHeader{
+		Magic:       []byte(magicString),
+		EntryCount:  uint32(len(tuples)),
+		SmallestKey: []byte("b"),
+		LongestKey:  []byte("f"),
+		Version:     sstVersion,
+	}, tuples)
+	sst.File.Seek(0, 0)
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("f"), []byte("g")}
+	got := map[string]string{}
+	err = sst.lookupSorted(keys, func(i int, pair SSTPair) {
+		got[string(keys[i])] = pair.Operation + ":" + string(pair.Value)
+	})
+	if err != nil {
+		t.Fatalf("Error looking up keys: %v", err)
+	}
+
+	want := map[string]string{"b": "SET:1", "d": "DEL:", "f": "SET:3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestLegacySSTOperations(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sst := &SSTFile{File: file}
+
+	// Version 1 files spell the operations out.
+	sst.writeHeader(SSTFileHeader{Magic: []byte(magicString), EntryCount: 3, SmallestKey: []byte("a"), LongestKey: []byte("c"), Version: 1})
+	writeBinary(file, []byte(setOperation), uint32(1), []byte("a"), uint32(3), []byte("foo"))
+	writeBinary(file, []byte(delOperation), uint32(1), []byte("b"))
+	ttl := encodeTTLValue(now()+int64(time.Hour), []byte("bar"))
+	writeBinary(file, []byte(ttlOperation), uint32(1), []byte("c"), uint32(len(ttl)), ttl)
+
+	cases := map[string]int{"a": 1, "b": -1, "c": 1, "d": -2}
+	for key, want := range cases {
+		file.Seek(0, 0)
+		if _, n := sst.Get([]byte(key)); n != want {
+			t.Errorf("Get(%q) = %d, expected %d", key, n, want)
+		}
+		file.Seek(0, 0)
+		if n := sst.Has([]byte(key)); n != want {
+			t.Errorf("Has(%q) = %d, expected %d", key, n, want)
+		}
+	}
+
+	file.Seek(0, 0)
+	if _, err := sst.readHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for {
+		tuple, err := readTuple(file)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading legacy tuple: %v", err)
+		}
+		ops = append(ops, tuple.Value.Operation)
+	}
+	if !reflect.DeepEqual(ops, []string{setOperation, delOperation, setOperation}) {
+		t.Errorf("Unexpected legacy operations: %v", ops)
+	}
+}
+
+func TestSSTBlocks(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 1000; i += 2 {
+		tuples = append(tuples, set(fmt.Sprintf("key%04d", i), fmt.Sprintf("value%d", i)))
+	}
+	tuples[10].Value = SSTPair{Operation: delOperation}
+	writeTestSST(t, dir, 1, tuples)
+
+	file, err := os.Open(filepath.Join(dir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := newSSTReader(file)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	if len(r.blocks) < 2 {
+		t.Fatalf("Expected several blocks, got %d", len(r.blocks))
+	}
+
+	sst := &SSTFile{File: file}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		value, n := sst.Get(key)
+		switch {
+		case i == 20:
+			if n != -1 {
+				t.Errorf("Expected %s to be deleted, got %d", key, n)
+			}
+		case i%2 == 1:
+			if n != -2 {
+				t.Errorf("Expected %s to be absent, got %d", key, n)
+			}
+		case n != 1 || string(value) != fmt.Sprintf("value%d", i):
+			t.Errorf("Expected the value of %s, got %q (%d)", key, value, n)
+		}
+	}
+
+	// Look up the first and last key of every block, and the keys around them.
+	var keys [][]byte
+	for _, h := range r.blocks {
+		keys = append(keys, h.firstKey, append(append([]byte{}, h.firstKey...), '!'))
+	}
+	keys = append(keys, []byte("key0998"), []byte("key9999"))
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	found := map[string]bool{}
+	err = sst.lookupSorted(keys, func(i int, pair SSTPair) { found[string(keys[i])] = true })
+	if err != nil {
+		t.Fatalf("Error looking up keys: %v", err)
+	}
+	for _, key := range keys {
+		_, n := sst.Get(key)
+		if found[string(key)] != (n == 1 || n == -1) {
+			t.Errorf("lookupSorted and Get disagree on %s: %v, %d", key, found[string(key)], n)
+		}
+	}
+	if !found["key0998"] || found["key9999"] {
+		t.Errorf("Unexpected lookups: %v", found)
+	}
+}
+
+func TestSSTCompression(t *testing.T) {
+	sizes := map[Compression]int64{}
+	for _, compression := range []Compression{NoCompression, DeflateCompression} {
+		mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), SSTCompression: compression})
+		if err != nil {
+			t.Fatalf("Error opening store: %v", err)
+		}
+		defer mem.Close()
+
+		for i := 0; i < 1000; i++ {
+			mem.Set([]byte(fmt.Sprintf("key%04d", i)), bytes.Repeat([]byte{byte('a' + i%26)}, 100))
+		}
+		if err := mem.FlushToDisk(); err != nil {
+			t.Fatalf("Error flushing: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(mem.sstDir, sstFileName(0, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[compression] = info.Size()
+
+		for _, i := range []int{0, 499, 999} {
+			value, err := mem.Get([]byte(fmt.Sprintf("key%04d", i)))
+			if err != nil || !bytes.Equal(value, bytes.Repeat([]byte{byte('a' + i%26)}, 100)) {
+				t.Errorf("Unexpected value of key%04d: %q (%v)", i, value, err)
+			}
+		}
+
+		// Walk the blocks forwards from the middle, then backwards.
+		it, err := mem.NewRangeIterator([]byte("key0500"), nil)
+		if err != nil {
+			t.Fatalf("Error creating iterator: %v", err)
+		}
+		n := 0
+		for it.Next() {
+			n++
+		}
+		for ok := it.SeekLast(); ok; ok = it.Prev() {
+			n++
+		}
+		if err := it.Close(); err != nil || n != 1000 {
+			t.Errorf("Expected 500 keys each way, got %d (%v)", n, err)
+		}
+	}
+
+	if sizes[DeflateCompression] >= sizes[NoCompression]/2 {
+		t.Errorf("Expected compression to shrink the file, got %d bytes from %d", sizes[DeflateCompression], sizes[NoCompression])
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	// Blocks of version 3 have no trailer.
+	if data, err := decodeBlock([]byte("tuples"), 3); err != nil || string(data) != "tuples" {
+		t.Errorf("Expected the block as it is, got %q (%v)", data, err)
+	}
+	// Those of version 5 end with their compression.
+	if data, err := decodeBlock([]byte("tuples\x00"), 5); err != nil || string(data) != "tuples" {
+		t.Errorf("Expected the trailer to be dropped, got %q (%v)", data, err)
+	}
+	if data, err := decodeBlock(appendChecksum([]byte("tuples\x00")), sstVersion); err != nil || string(data) != "tuples" {
+		t.Errorf("Expected the trailer to be dropped, got %q (%v)", data, err)
+	}
+	for _, data := range [][]byte{appendChecksum(nil), appendChecksum([]byte("tuples\x09")), appendChecksum([]byte("tuples\x01"))} {
+		if _, err := decodeBlock(data, sstVersion); err == nil || err == errChecksum {
+			t.Errorf("Expected an error decoding %q", data)
+		}
+	}
+	corrupt := appendChecksum([]byte("tuples\x00"))
+	corrupt[0]++
+	if _, err := decodeBlock(corrupt, sstVersion); err != errChecksum {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestSSTFooter(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSST(t, dir, 1, []SSTTuple{set("a", "1"), set("b", "2")})
+	data, err := os.ReadFile(filepath.Join(dir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer, err := decodeFooter(data[len(data)-int(footerSize(sstVersion)):])
+	if err != nil || footer.version != sstVersion || footer.filterSize == 0 || footer.filterOffset+footer.filterSize != footer.indexOffset {
+		t.Fatalf("Unexpected footer %+v (%v)", footer, err)
+	}
+
+	read := func(data []byte) ([]byte, int, error) {
+		path := filepath.Join(dir, sstFileName(0, 2))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := newSSTReader(file); err != nil {
+			return nil, 0, err
+		}
+		value, n := (&SSTFile{File: file}).Get([]byte("b"))
+		return value, n, nil
+	}
+
+	// Version 4 files have no checksums, and end with the offset and size of
+	// the index only.
+	header := SSTFileHeader{Magic: []byte(magicString), EntryCount: 1, SmallestKey: []byte("b"), LongestKey: []byte("b"), Version: 4}
+	var v4 bytes.Buffer
+	writeSSTHeader(&v4, header)
+	writeTuple(&v4, set("b", "2"))
+	v4.WriteByte(byte(NoCompression))
+	indexOffset := v4.Len()
+	writeBinary(&v4, uint32(1), uint32(1), []byte("b"), uint64(headerSize(header)), uint32(indexOffset)-uint32(headerSize(header)))
+	writeBinary(&v4, uint64(indexOffset), uint32(v4.Len()-indexOffset))
+	if value, n, err := read(v4.Bytes()); err != nil || n != 1 || string(value) != "2" {
+		t.Errorf("Expected to read version 4 files, got %q, %d (%v)", value, n, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"foreign":   []byte("not an SST file, though long enough to hold a footer"),
+		"truncated": data[:len(data)-1],
+	} {
+		if _, _, err := read(data); !errors.Is(err, ErrNotSST) {
+			t.Errorf("Expected %s file to be rejected with ErrNotSST, got %v", name, err)
+		}
+	}
+
+	// A footer disagreeing with the header is corrupt.
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-len(sstFooterMagic)-1]++
+	if _, _, err := read(corrupt); err == nil {
+		t.Error("Expected an error on mismatching versions")
+	}
+}
+
+func TestSSTChecksums(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value"))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Close()
+
+	path := filepath.Join(dir, "sstStorage", sstFileName(0, 1))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newSSTReader(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	footer, err := decodeFooter(data[len(data)-sstSeqFooterSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		part   string
+		offset int64
+	}{
+		{"header", 14}, // In the smallest key.
+		{"data block", r.blocks[1].offset + 10},
+		{"filter partition", r.filters[0].offset + 10},
+		{"index", footer.indexOffset + 10},
+	} {
+		corrupt := append([]byte{}, data...)
+		corrupt[tc.offset]++
+		if err := os.WriteFile(path, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// The damaged part surfaces when it is read.
+		mem, err := OpenWithOptions(Options{DataDir: dir})
+		if err != nil {
+			t.Fatalf("Error opening store: %v", err)
+		}
+		_, err = mem.Get(r.blocks[1].firstKey)
+		mem.Close()
+		var checksumErr *ChecksumError
+		if !errors.As(err, &checksumErr) || checksumErr.Part != tc.part {
+			t.Errorf("Expected a checksum error in the %s, got %v", tc.part, err)
+		}
+
+		// Paranoid checks catch it first.
+		if mem, err := OpenWithOptions(Options{DataDir: dir, ParanoidChecks: true}); !errors.As(err, &checksumErr) {
+			t.Errorf("Expected the open to fail on the corrupt %s, got %v", tc.part, err)
+			if err == nil {
+				mem.Close()
+			}
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mem, err = OpenWithOptions(Options{DataDir: dir, ParanoidChecks: true})
+	if err != nil {
+		t.Fatalf("Error opening intact store: %v", err)
+	}
+	mem.Close()
+}
+
+func TestBlockRestarts(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 100; i += 2 {
+		tuples = append(tuples, set(fmt.Sprintf("key%03d", i), "value"))
+	}
+	writeTestSST(t, dir, 1, tuples)
+
+	file, err := os.Open(filepath.Join(dir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := newSSTReader(file)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	b, err := r.readBlock(r.blocks[0])
+	if err != nil {
+		t.Fatalf("Error reading block: %v", err)
+	}
+	if len(r.blocks) != 1 || len(b.restarts) != 4 || b.restarts[0] != 0 {
+		t.Fatalf("Expected 1 block with 4 restart points, got %d and %v", len(r.blocks), b.restarts)
+	}
+
+	// Seeking lands on the restart point at or before the key, at most an
+	// interval away from it.
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		it, err := b.seek(key)
+		if err != nil {
+			t.Fatalf("Error seeking %s: %v", key, err)
+		}
+		tuple, err := it.next()
+		if err != nil {
+			t.Fatalf("Error reading tuple: %v", err)
+		}
+		if want := fmt.Sprintf("key%03d", i/2/sstRestartInterval*sstRestartInterval*2); string(tuple.Key) != want {
+			t.Errorf("Expected seeking %s to land on %s, got %s", key, want, tuple.Key)
+		}
+	}
+
+	for _, data := range [][]byte{{}, {0, 0, 0, 0}, {0, 0, 0, 9}, {0, 0, 0, 5, 0, 0, 0, 1}} {
+		if _, err := parseBlock(data, sstVersion); err == nil {
+			t.Errorf("Expected an error parsing %v", data)
+		}
+	}
+}
+
+func TestKeyPrefixCompression(t *testing.T) {
+	var tuples []SSTTuple
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user:%05d:profile", i)
+		switch i % 3 {
+		case 0:
+			tuples = append(tuples, set(key, "v"))
+		case 1:
+			tuples = append(tuples, del(key))
+		case 2:
+			tuple := set(key, "v")
+			tuple.Value.ExpiresAt = time.Now().Add(time.Hour).UnixNano()
+			tuples = append(tuples, tuple)
+		}
+	}
+
+	sizes := map[uint16]int64{}
+	for _, version := range []uint16{sstPrefixVersion - 1, sstPrefixVersion} {
+		file, err := os.Create(filepath.Join(t.TempDir(), sstFileName(0, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		sst := &SSTFile{File: file}
+		header := SSTFileHeader{Magic: []byte(magicString), EntryCount: uint32(len(tuples)), SmallestKey: tuples[0].Key, LongestKey: tuples[len(tuples)-1].Key, Version: version}
+		if err := sst.writeTable(header, tuples); err != nil {
+			t.Fatalf("Error writing version %d: %v", version, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[version] = info.Size()
+
+		var keys [][]byte
+		for i := 0; i < len(tuples); i += 7 {
+			keys = append(keys, tuples[i].Key)
+			if _, n := sst.Get(tuples[i].Key); n != 1-2*(i%3%2) {
+				t.Errorf("Version %d: unexpected lookup of %s: %d", version, tuples[i].Key, n)
+			}
+		}
+		found := 0
+		if err := sst.lookupSorted(keys, func(i int, pair SSTPair) { found++ }); err != nil || found != len(keys) {
+			t.Errorf("Version %d: expected lookupSorted to find %d keys, found %d (%v)", version, len(keys), found, err)
+		}
+
+		it, err := sst.Iterator()
+		if err != nil {
+			t.Fatalf("Error creating iterator: %v", err)
+		}
+		var read []SSTTuple
+		for it.Next() {
+			read = append(read, it.Tuple())
+		}
+		if err := it.Close(); err != nil || it.Err() != nil {
+			t.Fatalf("Error iterating version %d: %v", version, it.Err())
+		}
+		if !reflect.DeepEqual(read, tuples) {
+			t.Errorf("Version %d: tuples read back differ from those written", version)
+		}
+	}
+
+	if sizes[sstPrefixVersion] >= sizes[sstPrefixVersion-1]*2/3 {
+		t.Errorf("Expected prefix compression to shrink the file, got %d bytes from %d", sizes[sstPrefixVersion], sizes[sstPrefixVersion-1])
+	}
+}
+
+func TestTwoLevelIndex(t *testing.T) {
+	dir := t.TempDir()
+	var tuples []SSTTuple
+	for i := 0; i < 240000; i += 2 {
+		tuples = append(tuples, set(fmt.Sprintf("key%06d", i), "value"))
+	}
+	f := writeTestSST(t, dir, 1, tuples)
+
+	file, err := os.Open(filepath.Join(dir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := newSSTReader(file)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	if len(r.partitions) < 3 || r.blocks != nil {
+		t.Fatalf("Expected a partitioned index, got %d partitions", len(r.partitions))
+	}
+	blocks, err := r.allBlocks()
+	if err != nil || blocks[0].offset != r.dataStart || blocks[len(blocks)-1].offset+blocks[len(blocks)-1].size != r.dataEnd {
+		t.Fatalf("Expected the blocks to cover the data, got %d blocks (%v)", len(blocks), err)
+	}
+
+	sst := &SSTFile{File: file}
+	var keys [][]byte
+	for i := 0; i < 240000; i += 997 {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		keys = append(keys, key)
+		if _, n := sst.Get(key); n != 1-3*(i%2) {
+			t.Errorf("Unexpected lookup of %s: %d", key, n)
+		}
+	}
+	found := 0
+	if err := sst.lookupSorted(keys, func(i int, pair SSTPair) { found++ }); err != nil {
+		t.Fatalf("Error looking up keys: %v", err)
+	}
+	if want := (len(keys) + 1) / 2; found != want {
+		t.Errorf("Expected lookupSorted to find %d keys, found %d", want, found)
+	}
+
+	it, err := newIterator([]*skiplist.SkipList{skiplist.New(skiplist.Bytes)}, newTableCache(dir, 0), []*sstMeta{f}, []byte("key100001"), nil)
+	if err != nil {
+		t.Fatalf("Error creating iterator: %v", err)
+	}
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if it.Err() != nil || n != 69999 {
+		t.Errorf("Expected 69999 keys after key100001, got %d (%v)", n, it.Err())
+	}
+}
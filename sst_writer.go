@@ -0,0 +1,210 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+)
+
+// SSTWriter writes an SST file one tuple at a time, as they come in key order,
+// so that writing a file takes the memory of a data block rather than of all
+// its tuples. Only the index of the blocks grows with the file. An SSTWriter
+// can't be used anymore once a method failed.
+type SSTWriter struct {
+	s      *SSTFile
+	w      *bufio.Writer
+	header SSTFileHeader
+	offset int64 // Offset of the end of the last block written.
+
+	block    bytes.Buffer
+	restarts []uint32 // Of the block being filled.
+	blocks   []blockHandle
+
+	count     int
+	deletions int // Of the tuples added.
+	first     []byte
+	last      []byte // Key of the last tuple added.
+
+	// The range of the sequence numbers of the tuples added, 0 for none.
+	smallestSeq uint64
+	largestSeq  uint64
+
+	tuplesSinceRestart int
+
+	filter filterWriter // From version 12 on.
+
+	// keyRoom is the length of the keys the header left room for by
+	// newStreamWriter can hold, 0 if the header was given up front.
+	keyRoom int
+}
+
+// NewWriter writes the header of the file and returns a writer of the tuples
+// that follow. The header must give their number and the range of their keys,
+// which Finish checks.
+func (s *SSTFile) NewWriter(header SSTFileHeader) (*SSTWriter, error) {
+	if header.Version < sstPartitionedVersion || header.Version > sstVersion {
+		return nil, fmt.Errorf("%w %d, SST files can only be written in versions %d to %d", ErrUnknownSSTVersion, header.Version, sstPartitionedVersion, sstVersion)
+	}
+	w := bufio.NewWriter(s.File)
+	if err := writeSSTHeader(w, header); err != nil {
+		return nil, err
+	}
+	bitsPerKey := s.filterBitsPerKey
+	if bitsPerKey <= 0 {
+		bitsPerKey = DefaultFilterBitsPerKey
+	}
+	return &SSTWriter{s: s, w: w, header: header, offset: headerSize(header), filter: filterWriter{bitsPerKey: bitsPerKey}}, nil
+}
+
+// newStreamWriter returns a writer of tuples whose number and range of keys
+// are only known once they are all added, such as those a compaction merges.
+// The start of the file is left as room for a header of keys of up to
+// keyRoom bytes, which Finish writes there. The data blocks follow the room
+// left, readers locating them through the index.
+func (s *SSTFile) newStreamWriter(keyRoom int) (*SSTWriter, error) {
+	header := SSTFileHeader{Magic: []byte(magicString), Version: sstVersion}
+	room := headerSize(header) + 2*int64(keyRoom)
+	w := bufio.NewWriter(s.File)
+	if _, err := w.Write(make([]byte, room)); err != nil {
+		return nil, err
+	}
+	bitsPerKey := s.filterBitsPerKey
+	if bitsPerKey <= 0 {
+		bitsPerKey = DefaultFilterBitsPerKey
+	}
+	return &SSTWriter{s: s, w: w, header: header, offset: room, filter: filterWriter{bitsPerKey: bitsPerKey}, keyRoom: keyRoom}, nil
+}
+
+// fits reports whether key can be added, which it can't when it is longer
+// than the room a newStreamWriter left for it in the header.
+func (sw *SSTWriter) fits(key []byte) bool {
+	return sw.keyRoom == 0 || len(key) <= sw.keyRoom
+}
+
+// Add appends the tuple of key and value to the file. Keys must be added in
+// increasing order. Add keeps copies of the keys it needs, so the caller can
+// reuse key afterwards.
+func (sw *SSTWriter) Add(key []byte, value SSTPair) error {
+	if sw.count > 0 && bytes.Compare(key, sw.last) <= 0 {
+		return fmt.Errorf("SST key %q added after %q", key, sw.last)
+	}
+	if !sw.fits(key) {
+		return fmt.Errorf("SST key of %d bytes longer than the %d the header has room for", len(key), sw.keyRoom)
+	}
+	if value.blob != nil && sw.header.Version < sstValueLogVersion {
+		return fmt.Errorf("SST files of version %d can't point to the value log", sw.header.Version)
+	}
+	if sw.count == 0 {
+		sw.first = append([]byte(nil), key...)
+	}
+
+	if sw.block.Len() == 0 {
+		sw.blocks = append(sw.blocks, blockHandle{firstKey: append([]byte(nil), key...), offset: sw.offset})
+	}
+	prevKey := sw.last
+	if len(sw.restarts) == 0 || sw.tuplesSinceRestart == sstRestartInterval {
+		sw.restarts = append(sw.restarts, uint32(sw.block.Len()))
+		sw.tuplesSinceRestart = 0
+		prevKey = nil
+	}
+	sw.tuplesSinceRestart++
+
+	tuple := SSTTuple{Key: key, Value: value}
+	var err error
+	if sw.header.Version >= sstPrefixVersion {
+		err = writePrefixedTuple(&sw.block, tuple, prevKey, sw.header.Version)
+	} else {
+		err = writeTuple(&sw.block, tuple)
+	}
+	if err != nil {
+		return err
+	}
+	sw.last = append(sw.last[:0], key...)
+	sw.count++
+	if value.Operation == delOperation {
+		sw.deletions++
+	}
+	if sw.header.Version >= sstFilterVersion {
+		sw.filter.add(key)
+	}
+	if value.Seq != 0 && (sw.smallestSeq == 0 || value.Seq < sw.smallestSeq) {
+		sw.smallestSeq = value.Seq
+	}
+	sw.largestSeq = max(sw.largestSeq, value.Seq)
+
+	if sw.block.Len() >= sstBlockSize {
+		return sw.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock writes the block being filled, if any.
+func (sw *SSTWriter) flushBlock() error {
+	if sw.block.Len() == 0 {
+		return nil
+	}
+	writeBinary(&sw.block, sw.restarts, uint32(len(sw.restarts)))
+	sw.restarts = sw.restarts[:0]
+
+	data, err := sw.s.encodeBlock(sw.block.Bytes())
+	if err != nil {
+		return err
+	}
+	sw.blocks[len(sw.blocks)-1].size = int64(len(data))
+	n, err := sw.w.Write(data)
+	sw.offset += int64(n)
+	sw.block.Reset()
+	sw.filter.blockDone()
+	return err
+}
+
+// Finish writes the last block, then the index of the blocks, the filter of
+// the keys and the footer pointing at them. The file isn't synced. It fails if the tuples added don't
+// match the header, or writes the header if it was left for later by
+// newStreamWriter.
+func (sw *SSTWriter) Finish() error {
+	if sw.keyRoom > 0 {
+		sw.header.EntryCount, sw.header.SmallestKey, sw.header.LongestKey = uint32(sw.count), sw.first, sw.last
+	}
+	if sw.count != int(sw.header.EntryCount) {
+		return fmt.Errorf("SST header counts %d entries, %d were added", sw.header.EntryCount, sw.count)
+	}
+	if sw.count > 0 && (!bytes.Equal(sw.first, sw.header.SmallestKey) || !bytes.Equal(sw.last, sw.header.LongestKey)) {
+		return fmt.Errorf("SST header gives keys %q to %q, %q to %q were added", sw.header.SmallestKey, sw.header.LongestKey, sw.first, sw.last)
+	}
+	if err := sw.flushBlock(); err != nil {
+		return err
+	}
+
+	index, offset, err := writeIndexPartitions(sw.w, sw.blocks, sw.offset)
+	if err != nil {
+		return err
+	}
+	// The filter follows the index partitions, ending where the top-level
+	// index block starts. Files of earlier versions have none, which the
+	// footer records as empty.
+	footer := sstFooter{smallestSeq: sw.smallestSeq, largestSeq: sw.largestSeq, version: sw.header.Version}
+	if sw.header.Version >= sstFilterVersion && sw.count > 0 {
+		if footer.filterOffset, footer.filterSize, err = sw.filter.finish(sw.w, offset); err != nil {
+			return err
+		}
+		offset = footer.filterOffset + footer.filterSize
+	}
+	if _, err := sw.w.Write(index); err != nil {
+		return err
+	}
+	footer.indexOffset, footer.indexSize = offset, int64(len(index))
+	if err := footer.write(sw.w); err != nil {
+		return err
+	}
+
+	if err := sw.w.Flush(); err != nil || sw.keyRoom == 0 {
+		return err
+	}
+	var header bytes.Buffer
+	if err := writeSSTHeader(&header, sw.header); err != nil {
+		return err
+	}
+	_, err = sw.s.File.WriteAt(header.Bytes(), 0)
+	return err
+}
@@ -0,0 +1,81 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSSTWriter(t *testing.T) {
+	sst, err := NewSSTFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sst.Close()
+
+	const count = 10000
+	header := SSTFileHeader{Magic: []byte(magicString), EntryCount: count, SmallestKey: []byte("key00000"), LongestKey: []byte("key09999"), Version: sstVersion}
+	w, err := sst.NewWriter(header)
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	// The writer copies the keys it keeps, so the buffer can be reused.
+	key := make([]byte, 0, 8)
+	for i := 0; i < count; i++ {
+		key = fmt.Appendf(key[:0], "key%05d", i)
+		if err := w.Add(key, SSTPair{Operation: setOperation, Value: []byte("value")}); err != nil {
+			t.Fatalf("Error adding %s: %v", key, err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatalf("Error finishing: %v", err)
+	}
+
+	report, err := VerifySST(sst.File.Name())
+	if err != nil || !report.OK() || report.Entries != count || report.Blocks < 2 {
+		t.Errorf("Expected a sound file of %d entries in several blocks, got %+v (%v)", count, report, err)
+	}
+	if value, n := sst.Get([]byte("key04321")); n != 1 || string(value) != "value" {
+		t.Errorf("Unexpected lookup of key04321: %q, %d", value, n)
+	}
+}
+
+func TestSSTWriterChecks(t *testing.T) {
+	create := func(header SSTFileHeader) (*SSTWriter, error) {
+		file, err := os.Create(filepath.Join(t.TempDir(), "sst001"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { file.Close() })
+		header.Magic = []byte(magicString)
+		return (&SSTFile{File: file}).NewWriter(header)
+	}
+	value := SSTPair{Operation: setOperation, Value: []byte("v")}
+
+	if _, err := create(SSTFileHeader{Version: sstVersion + 1}); !errors.Is(err, ErrUnknownSSTVersion) {
+		t.Errorf("Expected an unknown version to be refused, got %v", err)
+	}
+
+	w, err := create(SSTFileHeader{EntryCount: 2, SmallestKey: []byte("a"), LongestKey: []byte("b"), Version: sstVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Add([]byte("b"), value)
+	if err := w.Add([]byte("a"), value); err == nil {
+		t.Error("Expected adding keys out of order to fail")
+	}
+	if err := w.Finish(); err == nil {
+		t.Error("Expected finishing with fewer entries than the header counts to fail")
+	}
+
+	w, err = create(SSTFileHeader{EntryCount: 1, SmallestKey: []byte("a"), LongestKey: []byte("a"), Version: sstVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Add([]byte("b"), value)
+	if err := w.Finish(); err == nil {
+		t.Error("Expected finishing with keys out of the range of the header to fail")
+	}
+}
@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SSTReport describes an SST file checked by VerifySST.
+type SSTReport struct {
+	Path     string
+	Size     int64 // Size of the file.
+	Version  uint16
+	Entries  int // Readable tuples.
+	Blocks   int
+	Smallest []byte   // Smallest key read.
+	Largest  []byte   // Largest key read.
+	Problems []string // What is wrong with the file, none when it is sound.
+}
+
+// OK reports whether no problem was found in the file.
+func (r SSTReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *SSTReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// VerifySST checks the SST file at path, such as a restored backup, before it
+// is trusted: the checksums of its header, index, filter and data blocks, the
+// order of its keys, and that its header, index, filter and footer match the
+// tuples it holds. The problems found are listed in the report. The error is
+// only for files whose header or index can't be read, which leave nothing to
+// check.
+func VerifySST(path string) (SSTReport, error) {
+	report := SSTReport{Path: path}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+	report.Size = info.Size()
+
+	r, err := newSSTReader(file)
+	if err != nil {
+		return report, err
+	}
+	report.Version = r.header.Version
+	blocks, err := r.allBlocks()
+	if err != nil {
+		return report, err
+	}
+	report.Blocks = len(blocks)
+
+	// A partition that can't be read is reported, and lets every key through.
+	filters := make([]bloomFilter, len(r.filters))
+	for i, h := range r.filters {
+		if filters[i], err = r.readFilter(h); err != nil {
+			report.problem("filter partition at offset %d: %v", h.offset, err)
+		}
+	}
+
+	var last []byte
+	seqOutOfRange, unfiltered := false, false
+	for _, h := range blocks {
+		block, err := r.block(h)
+		if err != nil {
+			report.problem("data block at offset %d: %v", h.offset, err)
+			continue
+		}
+		unsorted := false
+		for first := true; ; first = false {
+			tuple, err := block.next()
+			if err == io.EOF {
+				if first {
+					report.problem("data block at offset %d is empty", h.offset)
+				}
+				break
+			}
+			if err != nil {
+				report.problem("data block at offset %d, after %d tuples: %v", h.offset, report.Entries, err)
+				break
+			}
+
+			if first && !bytes.Equal(tuple.Key, h.firstKey) {
+				report.problem("data block at offset %d starts with %q, indexed as %q", h.offset, tuple.Key, h.firstKey)
+			}
+			// One unsorted key per block is enough to tell.
+			if last != nil && bytes.Compare(tuple.Key, last) <= 0 && !unsorted {
+				report.problem("key %q comes after %q in data block at offset %d", tuple.Key, last, h.offset)
+				unsorted = true
+			}
+			if seq := tuple.Value.Seq; seq != 0 && (seq < r.smallestSeq || seq > r.largestSeq) && !seqOutOfRange {
+				report.problem("key %q has sequence number %d, out of the range %d to %d of the footer", tuple.Key, seq, r.smallestSeq, r.largestSeq)
+				seqOutOfRange = true
+			}
+			if p := searchHandles(r.filters, tuple.Key); len(filters) > 0 && (p < 0 || !filters[p].mayContain(bloomHash(tuple.Key))) && !unfiltered {
+				report.problem("key %q is missing from the bloom filter", tuple.Key)
+				unfiltered = true
+			}
+			if report.Smallest == nil || bytes.Compare(tuple.Key, report.Smallest) < 0 {
+				report.Smallest = tuple.Key
+			}
+			if report.Largest == nil || bytes.Compare(tuple.Key, report.Largest) > 0 {
+				report.Largest = tuple.Key
+			}
+			last = tuple.Key
+			report.Entries++
+		}
+	}
+
+	header := r.header
+	if int(header.EntryCount) != report.Entries {
+		report.problem("header counts %d entries, found %d", header.EntryCount, report.Entries)
+	}
+	if report.Entries > 0 && !bytes.Equal(header.SmallestKey, report.Smallest) {
+		report.problem("header gives %q as the smallest key, found %q", header.SmallestKey, report.Smallest)
+	}
+	if report.Entries > 0 && !bytes.Equal(header.LongestKey, report.Largest) {
+		report.problem("header gives %q as the largest key, found %q", header.LongestKey, report.Largest)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,68 @@
+package kvstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifySST(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSST(t, dir, 1, []SSTTuple{set("a", "1"), del("b"), set("c", "3")})
+	path := filepath.Join(dir, sstFileName(0, 1))
+
+	report, err := VerifySST(path)
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	if !report.OK() || report.Entries != 3 || report.Blocks != 1 || report.Version != sstVersion || string(report.Smallest) != "a" || string(report.Largest) != "c" {
+		t.Errorf("Expected a sound file of 3 entries, got %+v", report)
+	}
+
+	// A file whose header doesn't match its unsorted tuples, which only a
+	// hand-written file of version 2, without blocks, can have.
+	file, err := os.Create(filepath.Join(dir, sstFileName(0, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&SSTFile{File: file}).writeHeader(SSTFileHeader{Magic: []byte(magicString), EntryCount: 5, SmallestKey: []byte("a"), LongestKey: []byte("z"), Version: 2})
+	for _, tuple := range []SSTTuple{set("a", "1"), set("c", "3"), set("b", "2")} {
+		writeTuple(file, tuple)
+	}
+	file.Close()
+	report, err = VerifySST(file.Name())
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	problems := strings.Join(report.Problems, "\n")
+	if len(report.Problems) != 3 || !strings.Contains(problems, `"b" comes after "c"`) || !strings.Contains(problems, "5 entries") || !strings.Contains(problems, `"z"`) {
+		t.Errorf("Expected the order, count and largest key to be reported, got %q", report.Problems)
+	}
+
+	// A corrupt data block.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := headerSize(SSTFileHeader{SmallestKey: []byte("a"), LongestKey: []byte("c"), Version: sstVersion})
+	data[start+3] ^= 0xff // The key of the first tuple, after its opcode and lengths.
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = VerifySST(path)
+	if err != nil {
+		t.Fatalf("Error verifying: %v", err)
+	}
+	if report.OK() || !strings.Contains(report.Problems[0], "checksum mismatch in data block") {
+		t.Errorf("Expected the checksum of the block to fail, got %q", report.Problems)
+	}
+
+	if err := os.WriteFile(path, []byte("not an SST file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifySST(path); !errors.Is(err, ErrNotSST) {
+		t.Errorf("Expected ErrNotSST, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats describes the contents and footprint of the store.
+type Stats struct {
+	LiveKeys      int   // Keys whose latest version holds a value.
+	Tombstones    int   // Keys whose latest version is a deletion or an expired value.
+	MemtableKeys  int   // Entries in the memtables, tombstones included.
+	MemtableBytes int64 // Bytes of keys and values allocated by the memtables, overwritten versions included, see Options.WriteBufferSize.
+	SSTFiles      int   // Number of SST files.
+	SSTBytes      int64 // Total size of the SST files.
+	WALBytes      int64 // Size of the Write-Ahead Log.
+	DiskBytes     int64 // Total size of the files on disk.
+	// OpenFiles is the number of files kept open: the SST files of the table
+	// cache and those being read, the value log files, the active WAL segment
+	// and the directory lock, see Options.MaxOpenFiles.
+	OpenFiles int
+
+	// Counters accumulated since the store was opened or ResetStats was last called.
+	Reads        int64         // Keys looked up by Get, Has and MultiGet.
+	Writes       int64         // Records appended to the WAL.
+	BytesWritten int64         // Bytes appended to the WAL.
+	WriteStalls  int64         // Writes that waited for a flush, see Options.WriteBufferSize.
+	StallTime    time.Duration // Time they waited.
+	WALAppends   AppendStats
+	WALSyncs     SyncStats
+	SSTSyncs     SyncStats
+	// Compactions also lists the compactions in progress and the last ones
+	// that finished, whatever ResetStats.
+	Compactions CompactionStats
+
+	ResetAt  time.Time     // When the counters were last reset, zero if they never were.
+	Time     time.Time     // When the stats were taken.
+	Interval time.Duration // Window covered by the counters of a StatsSince result, zero otherwise.
+}
+
+// Stats reports key counts and storage sizes. Counting keys walks the whole
+// keyspace, so this is meant for monitoring rather than the request path.
+func (mem *MemDB) Stats() (Stats, error) {
+	if mem.closed.Load() {
+		return Stats{}, ErrClosed
+	}
+	stats := Stats{
+		Reads:        mem.reads.Load(),
+		Writes:       mem.wal.appended.Load(),
+		BytesWritten: mem.wal.appendedBytes.Load(),
+		WriteStalls:  mem.writeStalls.Load(),
+		StallTime:    time.Duration(mem.stallNanos.Load()),
+		WALAppends:   mem.wal.appends.snapshot(),
+		WALSyncs:     mem.wal.syncs.snapshot(),
+		SSTSyncs:     mem.sstSyncs.snapshot(),
+		Compactions:  mem.CompactionStats(),
+		OpenFiles:    mem.tables.openFiles() + 1,
+		Time:         time.Now(),
+	}
+	if mem.lock != nil {
+		stats.OpenFiles++
+	}
+	if resetAt := mem.resetAt.Load(); resetAt != 0 {
+		stats.ResetAt = time.Unix(0, resetAt)
+	}
+
+	// Memtable figures.
+	mem.mu.RLock()
+	for _, list := range mem.memtables() {
+		stats.MemtableKeys += list.Len()
+	}
+	stats.MemtableBytes = mem.size + mem.immutableSize
+	mem.mu.RUnlock()
+
+	// File sizes.
+	for _, f := range mem.manifest.current() {
+		stats.SSTFiles++
+		stats.SSTBytes += f.size
+	}
+
+	walBytes, err := mem.wal.size()
+	if err != nil {
+		return stats, err
+	}
+	stats.WALBytes = walBytes
+	stats.DiskBytes = stats.SSTBytes + stats.WALBytes
+
+	// Key counts, from the merged view of the memtable and SST files.
+	it, err := mem.NewIterator()
+	if err != nil {
+		return stats, err
+	}
+	defer it.Close()
+
+	it.withTombstones = true
+	for it.Next() {
+		if it.deleted {
+			stats.Tombstones++
+		} else {
+			stats.LiveKeys++
+		}
+	}
+
+	return stats, it.Err()
+}
+
+// StatsSince returns the current stats with the counters reduced to what
+// happened since prev was taken, so callers can derive rates over Interval.
+// A ResetStats call in between makes the window start at the reset instead.
+func (mem *MemDB) StatsSince(prev Stats) (Stats, error) {
+	stats, err := mem.Stats()
+	if err != nil {
+		return stats, err
+	}
+
+	// Counters reset after prev was taken already cover the window since the reset.
+	if stats.ResetAt.After(prev.Time) {
+		stats.Interval = stats.Time.Sub(stats.ResetAt)
+		return stats, nil
+	}
+
+	stats.Reads -= prev.Reads
+	stats.Writes -= prev.Writes
+	stats.BytesWritten -= prev.BytesWritten
+	stats.WriteStalls -= prev.WriteStalls
+	stats.StallTime -= prev.StallTime
+	stats.WALAppends = stats.WALAppends.since(prev.WALAppends)
+	stats.WALSyncs = stats.WALSyncs.since(prev.WALSyncs)
+	stats.SSTSyncs = stats.SSTSyncs.since(prev.SSTSyncs)
+	stats.Compactions = stats.Compactions.since(prev.Compactions)
+	stats.Interval = stats.Time.Sub(prev.Time)
+
+	return stats, nil
+}
+
+// ResetStats sets the counters reported by Stats back to zero.
+func (mem *MemDB) ResetStats() {
+	mem.reads.Store(0)
+	mem.wal.appended.Store(0)
+	mem.wal.appendedBytes.Store(0)
+	mem.writeStalls.Store(0)
+	mem.stallNanos.Store(0)
+	mem.wal.appends.reset()
+	mem.wal.syncs.reset()
+	mem.sstSyncs.reset()
+	mem.compactionStats.reset()
+	mem.resetAt.Store(time.Now().UnixNano())
+}
+
+// ApproximateSize estimates the bytes of data stored for the keys in
+// [start, end). A nil start or end leaves that side of the range open. SST
+// files only contribute the share of their data that the range covers, as
+// guessed from the key range recorded in their header, so the result is meant
+// for decisions like picking shard boundaries rather than exact accounting.
+func (mem *MemDB) ApproximateSize(start, end []byte) (int64, error) {
+	if mem.closed.Load() {
+		return 0, ErrClosed
+	}
+	var size int64
+
+	// Memtable entries in the range.
+	mem.mu.RLock()
+	for _, list := range mem.memtables() {
+		elem := list.Front()
+		if start != nil {
+			elem = list.Find(start)
+		}
+		for ; elem != nil; elem = elem.Next() {
+			key := elem.Key().([]byte)
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				break
+			}
+			size += int64(len(key) + len(elem.Value.(*Value).Value))
+		}
+	}
+	mem.mu.RUnlock()
+
+	// SST files overlapping the range.
+	for _, f := range mem.manifest.current() {
+		n, err := approximateSSTSize(filepath.Join(mem.sstDir, f.name()), start, end)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+
+	return size, nil
+}
+
+// approximateSSTSize estimates the bytes of the SST file holding keys in [start, end).
+func approximateSSTSize(fileName string, start, end []byte) (int64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r, err := newSSTReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("error reading header of %s: %v", filepath.Base(fileName), err)
+	}
+	header, dataSize := r.header, r.dataEnd-r.dataStart
+
+	lo, hi := header.SmallestKey, header.LongestKey
+	if (end != nil && bytes.Compare(end, lo) <= 0) || (start != nil && bytes.Compare(start, hi) > 0) {
+		return 0, nil
+	}
+
+	// Clamp the range to the keys of the file.
+	from, to := lo, hi
+	if start != nil && bytes.Compare(start, lo) > 0 {
+		from = start
+	}
+	if end != nil && bytes.Compare(end, hi) < 0 {
+		to = end
+	}
+	if bytes.Equal(from, lo) && bytes.Equal(to, hi) {
+		return dataSize, nil
+	}
+
+	// Assume the keys are spread evenly between the smallest and largest key,
+	// which share a prefix that every key in between also starts with.
+	prefix := 0
+	for prefix < len(lo) && prefix < len(hi) && lo[prefix] == hi[prefix] {
+		prefix++
+	}
+	span := keyPosition(hi, prefix) - keyPosition(lo, prefix)
+	if span <= 0 {
+		return dataSize, nil
+	}
+	share := (keyPosition(to, prefix) - keyPosition(from, prefix)) / span
+	return int64(share * float64(dataSize)), nil
+}
+
+// keyPosition maps key to a number that preserves the order of keys sharing
+// its first prefix bytes, using the 8 bytes that follow them.
+func keyPosition(key []byte, prefix int) float64 {
+	var pos float64
+	for i := 0; i < 8; i++ {
+		pos *= 256
+		if prefix+i < len(key) {
+			pos += float64(key[prefix+i])
+		}
+	}
+	return pos
+}
@@ -0,0 +1,189 @@
+package kvstore
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("22"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Del([]byte("b"))
+	mem.Set([]byte("c"), []byte("333"))
+
+	stats, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+
+	if stats.LiveKeys != 2 || stats.Tombstones != 1 {
+		t.Errorf("Expected 2 live keys and 1 tombstone, got %+v", stats)
+	}
+	// The flush emptied the memtable, which now holds the tombstone of b and c.
+	if stats.MemtableKeys != 2 || stats.MemtableBytes != int64(len("b22")+len("c333")) {
+		t.Errorf("Unexpected memtable figures: %+v", stats)
+	}
+	if stats.SSTFiles != 1 || stats.SSTBytes == 0 || stats.WALBytes == 0 {
+		t.Errorf("Unexpected file figures: %+v", stats)
+	}
+	if stats.DiskBytes != stats.SSTBytes+stats.WALBytes {
+		t.Errorf("Expected disk size to add up, got %+v", stats)
+	}
+}
+
+func TestApproximateSize(t *testing.T) {
+	mem := NewTempDB(t)
+
+	addTestSST(t, mem, 1, []SSTTuple{set("a", "1111"), set("b", "2222"), set("c", "3333"), set("d", "4444")})
+	mem.Set([]byte("x"), []byte("123456789"))
+
+	total, err := mem.ApproximateSize(nil, nil)
+	if err != nil {
+		t.Fatalf("Error estimating size: %v", err)
+	}
+	// 4 SET tuples of 1+1+1+1+1+4+4 bytes, the 4+4 bytes of the restart point
+	// and the 1+4 bytes of the block trailer, plus the memtable entry.
+	if total != 4*13+8+5+10 {
+		t.Errorf("Expected a total of %d bytes, got %d", 4*13+8+5+10, total)
+	}
+
+	half, err := mem.ApproximateSize([]byte("a"), []byte("c"))
+	if err != nil {
+		t.Fatalf("Error estimating size: %v", err)
+	}
+	if half <= 0 || half >= 4*14 {
+		t.Errorf("Expected a part of the SST file, got %d bytes", half)
+	}
+
+	none, err := mem.ApproximateSize([]byte("e"), []byte("w"))
+	if err != nil {
+		t.Fatalf("Error estimating size: %v", err)
+	}
+	if none != 0 {
+		t.Errorf("Expected no data in [e, w), got %d bytes", none)
+	}
+}
+
+func TestStatsSince(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Get([]byte("a"))
+	prev, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if prev.Reads != 1 || prev.Writes != 1 {
+		t.Errorf("Expected 1 read and 1 write, got %+v", prev)
+	}
+
+	mem.Set([]byte("b"), []byte("2"))
+	mem.Set([]byte("c"), []byte("3"))
+	mem.Has([]byte("b"))
+	delta, err := mem.StatsSince(prev)
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if delta.Reads != 1 || delta.Writes != 2 || delta.Interval <= 0 {
+		t.Errorf("Expected 1 read and 2 writes over a positive interval, got %+v", delta)
+	}
+	if delta.LiveKeys != 3 {
+		t.Errorf("Expected gauges to stay absolute, got %d live keys", delta.LiveKeys)
+	}
+
+	// After a reset the window starts at the reset.
+	mem.ResetStats()
+	mem.Set([]byte("d"), []byte("4"))
+	delta, err = mem.StatsSince(prev)
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if delta.Reads != 0 || delta.Writes != 1 {
+		t.Errorf("Expected 0 reads and 1 write after reset, got %+v", delta)
+	}
+}
+
+func TestSyncStats(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+	if err := mem.wal.Sync(); err != nil {
+		t.Fatalf("Error syncing WAL: %v", err)
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	prev, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if prev.WALSyncs.Syncs != 1 || prev.WALSyncs.RecordsPerSync() != 2 {
+		t.Errorf("Expected 1 WAL sync of 2 records, got %+v", prev.WALSyncs)
+	}
+	if prev.SSTSyncs.Syncs != 1 || prev.SSTSyncs.Records != 2 {
+		t.Errorf("Expected 1 SST sync of 2 records, got %+v", prev.SSTSyncs)
+	}
+
+	var counted int64
+	for _, bucket := range prev.WALSyncs.Latency {
+		counted += bucket.Count
+	}
+	if counted != 1 {
+		t.Errorf("Expected the sync in one latency bucket, got %+v", prev.WALSyncs.Latency)
+	}
+
+	mem.Set([]byte("c"), []byte("3"))
+	if err := mem.wal.Sync(); err != nil {
+		t.Fatalf("Error syncing WAL: %v", err)
+	}
+	delta, err := mem.StatsSince(prev)
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if delta.WALSyncs.Syncs != 1 || delta.WALSyncs.Records != 1 || delta.SSTSyncs.Syncs != 0 {
+		t.Errorf("Expected a single WAL sync of 1 record in the window, got %+v and %+v", delta.WALSyncs, delta.SSTSyncs)
+	}
+}
+
+func TestAppendStats(t *testing.T) {
+	mem := NewTempDB(t)
+
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+
+	prev, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	var counted int64
+	for _, bucket := range prev.WALAppends.Latency {
+		counted += bucket.Count
+	}
+	if counted != 2 || prev.WALAppends.Total <= 0 || prev.WALAppends.Slowest <= 0 || prev.WALAppends.Slowest > prev.WALAppends.Total {
+		t.Errorf("Expected 2 timed appends, got %+v", prev.WALAppends)
+	}
+
+	mem.Set([]byte("c"), []byte("3"))
+	delta, err := mem.StatsSince(prev)
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	counted = 0
+	for _, bucket := range delta.WALAppends.Latency {
+		counted += bucket.Count
+	}
+	if counted != 1 || delta.WALAppends.Total <= 0 {
+		t.Errorf("Expected a single append in the window, got %+v", delta.WALAppends)
+	}
+
+	mem.ResetStats()
+	if stats, _ := mem.Stats(); stats.WALAppends.Total != 0 || stats.WALAppends.Slowest != 0 {
+		t.Errorf("Expected the append stats to be reset, got %+v", stats.WALAppends)
+	}
+}
@@ -0,0 +1,92 @@
+package kvstore
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy selects when the writes appended to the WAL are fsynced.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing the WAL to the operating system. Writes
+	// acknowledged since the last sync can be lost on power failure, and
+	// those still buffered if the process crashes.
+	SyncNever SyncPolicy = iota
+	// SyncAlways fsyncs the WAL after every write, before it is acknowledged.
+	SyncAlways
+	// SyncInterval fsyncs the WAL in the background every Options.SyncInterval,
+	// bounding the writes lost on power failure to that window.
+	SyncInterval
+)
+
+// DefaultSyncInterval is the period of the background syncs of SyncInterval
+// when Options.SyncInterval is unset.
+const DefaultSyncInterval = 100 * time.Millisecond
+
+// ParseSyncPolicy returns the policy named always, interval or never.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return SyncNever, fmt.Errorf("unknown sync policy %q", name)
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// syncPeriodically fsyncs the WAL every interval until the store is closed.
+func (mem *MemDB) syncPeriodically(interval time.Duration, stop <-chan struct{}) {
+	defer mem.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// The WAL has its own lock, so that writes and reads go on during the sync.
+		if mem.wal.unsynced.Load() > 0 {
+			if err := mem.wal.Sync(); err != nil {
+				Logger.Printf("Error syncing WAL: %v", err)
+			}
+		}
+	}
+}
+
+// flushPeriodically writes the buffered WAL entries to the file every interval
+// until the store is closed.
+func (mem *MemDB) flushPeriodically(interval time.Duration, stop <-chan struct{}) {
+	defer mem.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := mem.wal.Flush(); err != nil {
+			Logger.Printf("Error flushing WAL: %v", err)
+		}
+	}
+}
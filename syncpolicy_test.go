@@ -0,0 +1,124 @@
+package kvstore
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		parsed, err := ParseSyncPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("Expected to parse %s back, got %s (%v)", policy, parsed, err)
+		}
+	}
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Errorf("Expected an error parsing an unknown policy")
+	}
+}
+
+func TestSyncPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy  SyncPolicy
+		records int64 // Of the three writes, those expected to be synced.
+	}{
+		{SyncNever, 0},
+		{SyncAlways, 3},
+		{SyncInterval, 3},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), SyncPolicy: test.policy, SyncInterval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("Error opening store: %v", err)
+			}
+			defer mem.Close()
+
+			mem.Set([]byte("a"), []byte("1"))
+			mem.Set([]byte("b"), []byte("2"))
+			mem.Set([]byte("c"), []byte("3"))
+
+			// The background syncs catch up with the writes.
+			deadline := time.Now().Add(time.Second)
+			var stats Stats
+			for {
+				if stats, err = mem.Stats(); err != nil {
+					t.Fatalf("Error computing stats: %v", err)
+				}
+				if stats.WALSyncs.Records >= test.records || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if stats.WALSyncs.Records != test.records {
+				t.Errorf("Expected %d synced writes, got %d", test.records, stats.WALSyncs.Records)
+			}
+			if test.policy == SyncAlways && stats.WALSyncs.Syncs != 3 {
+				t.Errorf("Expected a WAL sync per write, got %d", stats.WALSyncs.Syncs)
+			}
+		})
+	}
+}
+
+func TestReadDuringSync(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval} {
+		t.Run(policy.String(), func(t *testing.T) {
+			mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), SyncPolicy: policy, SyncInterval: 5 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("Error opening store: %v", err)
+			}
+			defer mem.Close()
+			if err := mem.Set([]byte("a"), []byte("1")); err != nil {
+				t.Fatal(err)
+			}
+
+			// Hold the next sync up until the end of the test.
+			syncing, release := make(chan struct{}, 1), make(chan struct{})
+			prev := fsync
+			fsync = func(file *os.File) error {
+				select {
+				case syncing <- struct{}{}:
+				default:
+				}
+				<-release
+				return prev(file)
+			}
+			defer func() { fsync = prev }()
+			defer close(release)
+
+			written := make(chan error, 1)
+			go func() { written <- mem.Set([]byte("b"), []byte("2")) }()
+			select {
+			case <-syncing:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the WAL to be synced")
+			}
+
+			read := make(chan error, 1)
+			go func() {
+				_, err := mem.Get([]byte("a"))
+				read <- err
+			}()
+			select {
+			case err := <-read:
+				if err != nil {
+					t.Errorf("Error reading during the sync: %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the read to complete while the WAL is being synced")
+			}
+
+			if policy == SyncInterval {
+				// Writes that don't wait for the sync go on too.
+				select {
+				case err := <-written:
+					if err != nil {
+						t.Errorf("Error writing during the sync: %v", err)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Expected the write to complete while the WAL is being synced")
+				}
+			}
+		})
+	}
+}
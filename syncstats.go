@@ -0,0 +1,188 @@
+package kvstore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the buckets of a latency histogram. A
+// last bucket catches the slower operations.
+type latencyBounds [5]time.Duration
+
+// syncLatencyBounds are the bounds of the fsync latency histograms.
+var syncLatencyBounds = latencyBounds{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// appendLatencyBounds are the bounds of the WAL append latency histogram.
+// Appends that don't sync take microseconds.
+var appendLatencyBounds = latencyBounds{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// SyncStats describes the fsync calls made on one kind of file.
+type SyncStats struct {
+	Syncs   int64           // Number of fsync calls.
+	Records int64           // Records made durable by those calls.
+	Latency []LatencyBucket // Histogram of the fsync durations.
+	Total   time.Duration   // Time spent in fsync.
+}
+
+// LatencyBucket counts the durations up to UpperBound that weren't counted in a
+// previous bucket. The last bucket has no upper bound and reports zero.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// RecordsPerSync returns the average number of records made durable by an fsync.
+func (s SyncStats) RecordsPerSync() float64 {
+	if s.Syncs == 0 {
+		return 0
+	}
+	return float64(s.Records) / float64(s.Syncs)
+}
+
+// since returns the syncs that happened between prev and s.
+func (s SyncStats) since(prev SyncStats) SyncStats {
+	s.Syncs -= prev.Syncs
+	s.Records -= prev.Records
+	s.Total -= prev.Total
+	s.Latency = latencySince(s.Latency, prev.Latency)
+
+	return s
+}
+
+// AppendStats describes the time spent appending entries to the WAL. The
+// number of entries and their bytes are Stats.Writes and Stats.BytesWritten.
+type AppendStats struct {
+	Latency []LatencyBucket // Histogram of the append durations, syncs and segment rotations included.
+	Total   time.Duration   // Time spent appending.
+	Slowest time.Duration   // Longest append.
+}
+
+// since returns the appends that happened between prev and s. Slowest still
+// covers every append since the reset, as it can't be windowed.
+func (s AppendStats) since(prev AppendStats) AppendStats {
+	s.Total -= prev.Total
+	s.Latency = latencySince(s.Latency, prev.Latency)
+
+	return s
+}
+
+// latencySince returns the durations counted in the latency histogram but
+// not in prev.
+func latencySince(latency, prev []LatencyBucket) []LatencyBucket {
+	since := make([]LatencyBucket, len(latency))
+	for i, bucket := range latency {
+		if i < len(prev) {
+			bucket.Count -= prev[i].Count
+		}
+		since[i] = bucket
+	}
+	return since
+}
+
+// latencyHistogram counts durations in buckets, and is safe for concurrent use.
+type latencyHistogram struct {
+	nanos   atomic.Int64
+	max     atomic.Int64
+	buckets [len(latencyBounds{}) + 1]atomic.Int64
+}
+
+// observe counts d in the bucket of bounds it falls in.
+func (h *latencyHistogram) observe(bounds *latencyBounds, d time.Duration) {
+	h.nanos.Add(int64(d))
+	for {
+		max := h.max.Load()
+		if int64(d) <= max || h.max.CompareAndSwap(max, int64(d)) {
+			break
+		}
+	}
+
+	i := 0
+	for i < len(bounds) && d > bounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+}
+
+// snapshot returns the buckets of the histogram, which counted durations with bounds.
+func (h *latencyHistogram) snapshot(bounds *latencyBounds) []LatencyBucket {
+	latency := make([]LatencyBucket, 0, len(h.buckets))
+	for i := range h.buckets {
+		var bound time.Duration
+		if i < len(bounds) {
+			bound = bounds[i]
+		}
+		latency = append(latency, LatencyBucket{UpperBound: bound, Count: h.buckets[i].Load()})
+	}
+	return latency
+}
+
+func (h *latencyHistogram) reset() {
+	h.nanos.Store(0)
+	h.max.Store(0)
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+}
+
+// syncMetrics accumulates SyncStats and is safe for concurrent use.
+type syncMetrics struct {
+	syncs   atomic.Int64
+	records atomic.Int64
+	latency latencyHistogram
+}
+
+// observe records an fsync that made records durable and took d.
+func (m *syncMetrics) observe(records int64, d time.Duration) {
+	m.syncs.Add(1)
+	m.records.Add(records)
+	m.latency.observe(&syncLatencyBounds, d)
+}
+
+func (m *syncMetrics) snapshot() SyncStats {
+	return SyncStats{
+		Syncs:   m.syncs.Load(),
+		Records: m.records.Load(),
+		Latency: m.latency.snapshot(&syncLatencyBounds),
+		Total:   time.Duration(m.latency.nanos.Load()),
+	}
+}
+
+func (m *syncMetrics) reset() {
+	m.syncs.Store(0)
+	m.records.Store(0)
+	m.latency.reset()
+}
+
+// appendMetrics accumulates AppendStats and is safe for concurrent use.
+type appendMetrics struct {
+	latency latencyHistogram
+}
+
+// observe records an append that took d.
+func (m *appendMetrics) observe(d time.Duration) {
+	m.latency.observe(&appendLatencyBounds, d)
+}
+
+func (m *appendMetrics) snapshot() AppendStats {
+	return AppendStats{
+		Latency: m.latency.snapshot(&appendLatencyBounds),
+		Total:   time.Duration(m.latency.nanos.Load()),
+		Slowest: time.Duration(m.latency.max.Load()),
+	}
+}
+
+func (m *appendMetrics) reset() {
+	m.latency.reset()
+}
@@ -0,0 +1,188 @@
+package kvstore
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultTableCacheSize is the number of SST files kept open when
+// Options.TableCacheSize is unset.
+const DefaultTableCacheSize = 128
+
+// DefaultMaxOpenFiles is the number of files a store keeps open at most when
+// Options.MaxOpenFiles is unset.
+const DefaultMaxOpenFiles = 1000
+
+// reservedFiles are the files of Options.MaxOpenFiles left out of the budget
+// of the table cache: the active WAL segment, the directory lock, and the
+// files a flush and a compaction write.
+const reservedFiles = 4
+
+// table is an open SST file, with its header and index parsed.
+type table struct {
+	number int
+	reader *sstReader
+	refs   int // Reads using the table, plus one while it is cached.
+}
+
+// tableCache keeps the most recently used SST files of a directory open, so
+// that reads don't reopen them and parse their header and index every time.
+// Tables are reference counted: an evicted table stays open until the reads
+// using it release it. Tables only read through ReadAt, so concurrent reads
+// can share them.
+type tableCache struct {
+	dir      string
+	capacity int // Tables kept open, 0 to open a table for every read.
+
+	mu     sync.Mutex
+	lru    *list.List // Of *table, most recently used first.
+	tables map[int]*list.Element
+	closed bool // Set by close, after which tables are no longer cached.
+
+	// values reads the values the tables keep in the value log of dir.
+	values *valueLog
+	// blocks caches the data blocks the tables read, nil if disabled.
+	blocks *blockCache
+
+	// lookupWorkers is the number of tables findInSSTFiles probes at once,
+	// see Options.ParallelLookups.
+	lookupWorkers int
+
+	// maxOpen bounds the tables open at once, cached or being read, along
+	// with the files of the value log; 0 for no bound. open counts the tables.
+	maxOpen int
+	open    atomic.Int64
+}
+
+func newTableCache(dir string, capacity int) *tableCache {
+	return &tableCache{dir: dir, capacity: capacity, lru: list.New(), tables: map[int]*list.Element{}, values: newValueLog(dir)}
+}
+
+// get returns SST file f, opening it if it isn't cached. The table must be
+// released once done with.
+func (c *tableCache) get(f *sstMeta) (*table, error) {
+	n := f.number
+	c.mu.Lock()
+	if t := c.lookup(n); t != nil {
+		c.mu.Unlock()
+		return t, nil
+	}
+	c.mu.Unlock()
+
+	t, err := openTable(c.dir, f)
+	if err != nil {
+		return nil, err
+	}
+	c.open.Add(1)
+	t.reader.cache, t.reader.number = c.blocks, f.number
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.capacity <= 0 {
+		return t, nil
+	}
+	// Another read may have opened the file meanwhile.
+	if other := c.lookup(n); other != nil {
+		c.closeTable(t)
+		return other, nil
+	}
+	t.refs++
+	c.tables[n] = c.lru.PushFront(t)
+	// Tables evicted while being read stay open until released, so the
+	// bound can be exceeded until then.
+	for c.lru.Len() > c.capacity || (c.overBudget() && c.lru.Len() > 1) {
+		c.remove(c.lru.Back())
+	}
+	return t, nil
+}
+
+// overBudget reports whether more files are open than maxOpen allows.
+func (c *tableCache) overBudget() bool {
+	return c.maxOpen > 0 && c.openFiles() > c.maxOpen
+}
+
+// openFiles returns the number of tables and value log files open.
+func (c *tableCache) openFiles() int {
+	return int(c.open.Load()) + c.values.openFiles()
+}
+
+// lookup returns table n if it is cached, acquiring it. c.mu must be held.
+func (c *tableCache) lookup(n int) *table {
+	elem, ok := c.tables[n]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	t := elem.Value.(*table)
+	t.refs++
+	return t
+}
+
+// openTable opens SST file f of dir.
+func openTable(dir string, f *sstMeta) (*table, error) {
+	file, err := os.Open(filepath.Join(dir, f.name()))
+	if err != nil {
+		return nil, err
+	}
+	r, err := newSSTReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &table{number: f.number, reader: r, refs: 1}, nil
+}
+
+// release ends a use of t, closing it if it was evicted meanwhile.
+func (c *tableCache) release(t *table) {
+	c.mu.Lock()
+	t.refs--
+	unused := t.refs == 0
+	c.mu.Unlock()
+
+	if unused {
+		c.closeTable(t)
+	}
+}
+
+// closeTable closes the file of t once nothing uses it.
+func (c *tableCache) closeTable(t *table) {
+	t.reader.file.Close()
+	c.open.Add(-1)
+}
+
+// remove evicts the table of elem. c.mu must be held.
+func (c *tableCache) remove(elem *list.Element) {
+	t := c.lru.Remove(elem).(*table)
+	delete(c.tables, t.number)
+	if t.refs--; t.refs == 0 {
+		c.closeTable(t)
+	}
+}
+
+// evict drops table n and its blocks from the cache, once its file is
+// replaced or removed. Reads using the table keep it open until they release
+// it.
+func (c *tableCache) evict(n int) {
+	c.blocks.evictFile(n)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.tables[n]; ok {
+		c.remove(elem)
+	}
+}
+
+// close evicts every table and closes the value log, once the store is
+// closed. Snapshots can still read the files afterwards, opening them for
+// every read.
+func (c *tableCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	c.values.close()
+}
@@ -0,0 +1,182 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTableCache(t *testing.T) {
+	dir := t.TempDir()
+	for n := 1; n <= 3; n++ {
+		writeTestSST(t, dir, n, []SSTTuple{set("a", "1")})
+	}
+	c := newTableCache(dir, 2)
+
+	get := func(n int) *table {
+		t.Helper()
+		tbl, err := c.get(&sstMeta{number: n})
+		if err != nil {
+			t.Fatalf("Error opening table %d: %v", n, err)
+		}
+		return tbl
+	}
+
+	first := get(1)
+	c.release(first)
+	if again := get(1); again != first {
+		t.Error("Expected the cached table to be reused")
+	} else {
+		c.release(again)
+	}
+
+	// Opening a third table evicts the least recently used one, which stays
+	// open for the read still using it.
+	held := get(1)
+	c.release(get(2))
+	c.release(get(3))
+	if _, ok := c.tables[1]; ok || c.lru.Len() != 2 {
+		t.Fatalf("Expected table 1 to be evicted, got %d tables", c.lru.Len())
+	}
+	if _, n, err := held.reader.find([]byte("a")); n != 1 {
+		t.Errorf("Expected the evicted table to stay readable, got %d (%v)", n, err)
+	}
+	c.release(held)
+	if _, err := held.reader.file.Stat(); err == nil {
+		t.Error("Expected the evicted table to be closed once released")
+	}
+
+	if _, err := c.get(&sstMeta{number: 4}); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing file to fail with ErrNotExist, got %v", err)
+	}
+
+	// A closed cache no longer keeps tables open.
+	c.close()
+	tbl := get(2)
+	c.release(tbl)
+	if c.lru.Len() != 0 {
+		t.Errorf("Expected no cached tables after close, got %d", c.lru.Len())
+	}
+	if _, err := tbl.reader.file.Stat(); err == nil {
+		t.Error("Expected the table to be closed once released")
+	}
+}
+
+func TestKeyRangePruning(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), TableCacheSize: -1})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer mem.Close()
+	addTestSST(t, mem, 1, []SSTTuple{set("a", "1"), set("c", "3")})
+	addTestSST(t, mem, 2, []SSTTuple{set("m", "13"), set("z", "26")})
+
+	if value, err := mem.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Fatalf("Unexpected value of a: %q (%v)", value, err)
+	}
+
+	// File 2 isn't opened for keys out of the range the manifest gives it.
+	if err := os.WriteFile(filepath.Join(mem.sstDir, sstFileName(0, 2)), []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := mem.Get([]byte("c")); err != nil || string(value) != "3" {
+		t.Errorf("Unexpected value of c: %q (%v)", value, err)
+	}
+	if ok, err := mem.Has([]byte("b")); err != nil || ok {
+		t.Errorf("Expected b to be absent, got %v (%v)", ok, err)
+	}
+	if values, err := mem.MultiGet([][]byte{[]byte("c"), []byte("a")}); err != nil || string(values[0]) != "3" || string(values[1]) != "1" {
+		t.Errorf("Unexpected values: %q (%v)", values, err)
+	}
+	if _, err := mem.Get([]byte("n")); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected reading the damaged file to fail, got %v", err)
+	}
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MaxOpenFiles: reservedFiles + 2, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer mem.Close()
+	for n := 1; n <= 5; n++ {
+		key := string(rune('a' + n))
+		addTestSST(t, mem, n, []SSTTuple{set(key, key)})
+	}
+
+	// Reading every file keeps only as many open as the limit leaves to the
+	// table cache.
+	for n := 1; n <= 5; n++ {
+		key := string(rune('a' + n))
+		if value, err := mem.Get([]byte(key)); err != nil || string(value) != key {
+			t.Fatalf("Unexpected value of %s: %q (%v)", key, value, err)
+		}
+	}
+	if n := mem.tables.lru.Len(); n != 2 {
+		t.Errorf("Expected 2 cached tables, got %d", n)
+	}
+	stats, err := mem.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.OpenFiles != 4 {
+		t.Errorf("Expected 4 open files, the tables, the WAL and the lock, got %d", stats.OpenFiles)
+	}
+}
+
+func TestScanMaxOpenFiles(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), MaxOpenFiles: reservedFiles + 2, L0CompactionTrigger: -1})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	defer mem.Close()
+	// Disjoint files of level 1, and a file of level 0 overlapping them.
+	var files []*sstMeta
+	for n := 1; n <= 20; n++ {
+		var tuples []SSTTuple
+		for i := 0; i < 10; i++ {
+			tuples = append(tuples, set(fmt.Sprintf("key%02d%d", n, i), "1"))
+		}
+		f := writeTestSST(t, mem.sstDir, n, tuples)
+		if err := os.Rename(filepath.Join(mem.sstDir, f.name()), filepath.Join(mem.sstDir, sstFileName(1, n))); err != nil {
+			t.Fatal(err)
+		}
+		f.level = 1
+		files = append(files, f)
+	}
+	files = append(files, writeTestSST(t, mem.sstDir, 21, []SSTTuple{set("key050", "0"), set("key150", "0")}))
+	if err := mem.manifest.apply(manifestEdit{add: files}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A scan keeps only a file of every level open, within the limit the
+	// table cache has.
+	walk := func(name string, first func(*Iterator) bool, next func(*Iterator) bool) {
+		t.Helper()
+		it, err := mem.Scan(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		n, peak := 0, 0
+		for ok := first(it.(*Iterator)); ok; ok = next(it.(*Iterator)) {
+			n++
+			peak = max(peak, mem.tables.openFiles())
+		}
+		if err := it.(*Iterator).Err(); err != nil || n != 200 {
+			t.Errorf("Expected 200 keys %s, got %d (%v)", name, n, err)
+		}
+		if peak > 2 {
+			t.Errorf("Expected 2 open files at most %s, got %d", name, peak)
+		}
+	}
+	walk("forward", (*Iterator).Next, (*Iterator).Next)
+	walk("backwards", (*Iterator).SeekLast, (*Iterator).Prev)
+
+	value, err := mem.Get([]byte("key050"))
+	if err != nil || string(value) != "0" {
+		t.Errorf("Expected the value of level 0 to shadow that of level 1, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,39 @@
+package kvstore
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// NewTempDB returns a MemDB whose WAL and SST files live in a temporary
+// directory removed at the end of the test.
+func NewTempDB(t testing.TB) *MemDB {
+	t.Helper()
+
+	mem, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating MemDB: %v", err)
+	}
+	t.Cleanup(func() { mem.Close() })
+
+	return mem
+}
+
+// NewTestServer starts a server backed by NewTempDB on a random local port and
+// returns it together with its base URL. The server is shut down at the end of the test.
+func NewTestServer(t testing.TB) (*Server, string) {
+	t.Helper()
+
+	server := &Server{
+		Router: mux.NewRouter(),
+		db:     NewTempDB(t),
+	}
+	server.SetupRoutes()
+
+	ts := httptest.NewServer(server.Router)
+	t.Cleanup(ts.Close)
+
+	return server, ts.URL
+}
@@ -0,0 +1,158 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// maxRequestIDLength is the length of the request IDs clients bring at most.
+const maxRequestIDLength = 64
+
+// RequestIDHeader carries the tracing ID of a request and its response.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID returns the tracing ID attached to the context of a request, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id, brought by a client, is at most
+// maxRequestIDLength long and made of letters, digits and "-_.:" only, which
+// keeps it whole in headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random tracing ID for requests that don't bring their own.
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// traceRequests attaches a tracing ID to every request, taken from the
+// X-Request-ID header or the request_id query parameter, or generated if
+// there is none or it isn't valid, see validRequestID. The ID is echoed in
+// the response and included in the audit log of writes and in the slow
+// request log. The write handlers trace their writes under it, see
+// Server.dbFor.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = r.URL.Query().Get("request_id")
+		}
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		took := time.Since(start)
+
+		if r.Method != http.MethodGet {
+			Logger.Printf("audit request_id=%q %s %q status=%d", id, r.Method, r.URL.RequestURI(), rec.status)
+		}
+		if s.SlowRequestThreshold > 0 && took >= s.SlowRequestThreshold {
+			Logger.Printf("slow request_id=%q %s %q took %v", id, r.Method, r.URL.RequestURI(), took)
+		}
+	})
+}
+
+// dbFor returns the engine the handlers of r write to, which traces the
+// writes under the request ID of r if it implements RequestTracer.
+func (s *Server) dbFor(r *http.Request) DB {
+	if tracer, ok := s.db.(RequestTracer); ok {
+		if id := RequestID(r.Context()); id != "" {
+			return tracer.Traced(id)
+		}
+	}
+	return s.db
+}
+
+// tracedDB is a MemDB whose writes log the ID of the request they are made
+// for, see Traced.
+type tracedDB struct {
+	*MemDB
+	requestID string
+}
+
+// Traced returns mem as a DB whose writes log requestID with the sequence
+// numbers of their WAL entries, so that the writes of a request can be
+// traced from the client to disk.
+func (mem *MemDB) Traced(requestID string) DB {
+	return &tracedDB{MemDB: mem, requestID: requestID}
+}
+
+func (t *tracedDB) Set(key, value []byte) error {
+	return t.write(t.requestID, func() error { return t.set(key, value) })
+}
+
+func (t *tracedDB) Del(key []byte) (value []byte, err error) {
+	err = t.write(t.requestID, func() (err error) {
+		value, err = t.del(key)
+		return err
+	})
+	return value, err
+}
+
+func (t *tracedDB) Copy(src, dst []byte, opts CopyOptions) error {
+	return t.write(t.requestID, func() error { return t.copy(src, dst, opts) })
+}
+
+func (t *tracedDB) CompareAndSwap(key, expected, newValue []byte) (swapped bool, err error) {
+	err = t.write(t.requestID, func() (err error) {
+		swapped, err = t.compareAndSwap(key, expected, newValue)
+		return err
+	})
+	return swapped, err
+}
+
+func (t *tracedDB) GetOrSet(key, value []byte) (actual []byte, loaded bool, err error) {
+	err = t.write(t.requestID, func() (err error) {
+		actual, loaded, err = t.getOrSet(key, value)
+		return err
+	})
+	return actual, loaded, err
+}
+
+func (t *tracedDB) Incr(key []byte, delta int64) (n int64, err error) {
+	err = t.write(t.requestID, func() (err error) {
+		n, err = t.incr(key, delta)
+		return err
+	})
+	return n, err
+}
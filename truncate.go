@@ -0,0 +1,148 @@
+package kvstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TruncateOptions controls how TruncatePrefix paces its deletions.
+type TruncateOptions struct {
+	BatchSize  int     // Keys deleted per batch, 1000 if zero.
+	KeysPerSec float64 // Upper bound on the deletion rate, unlimited if zero.
+}
+
+// DeleteSweep is a prefix deletion running in the background.
+type DeleteSweep struct {
+	deleted atomic.Int64
+	batches atomic.Int64
+	stop    chan struct{}
+	once    sync.Once
+	done    chan struct{}
+	err     error
+}
+
+// TruncatePrefix deletes every key starting with prefix on a background
+// goroutine, in batches paced by opts so foreground operations keep a stable latency.
+func (mem *MemDB) TruncatePrefix(prefix []byte, opts TruncateOptions) *DeleteSweep {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	sweep := &DeleteSweep{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(sweep.done)
+		sweep.err = mem.truncatePrefix(prefix, opts, sweep)
+	}()
+
+	return sweep
+}
+
+func (mem *MemDB) truncatePrefix(prefix []byte, opts TruncateOptions, sweep *DeleteSweep) error {
+	start, end := prefix, prefixEnd(prefix)
+
+	for {
+		started := time.Now()
+
+		keys, err := mem.deleteBatch(start, end, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		sweep.deleted.Add(int64(len(keys)))
+		sweep.batches.Add(1)
+
+		if len(keys) < opts.BatchSize {
+			return nil
+		}
+		// Resume right after the last deleted key.
+		start = append(append([]byte{}, keys[len(keys)-1]...), 0)
+
+		// Wait long enough to stay under the configured rate.
+		var pause time.Duration
+		if opts.KeysPerSec > 0 {
+			pause = time.Duration(float64(len(keys))/opts.KeysPerSec*float64(time.Second)) - time.Since(started)
+		}
+		select {
+		case <-sweep.stop:
+			return nil
+		case <-time.After(pause):
+		}
+	}
+}
+
+// deleteBatch writes tombstones for up to n live keys in [start, end) and
+// returns them. The keys are found by an iterator, which holds no lock of the
+// store, and only the tombstones are written under mu: a key written
+// meanwhile is deleted all the same, as are the keys of the prefix written
+// during the sweep that it reaches.
+func (mem *MemDB) deleteBatch(start, end []byte, n int) (keys [][]byte, err error) {
+	it, err := mem.NewRangeIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	for len(keys) < n && it.Next() {
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	defer mem.syncWrite(&err)
+	defer mem.flushIfFull()
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	if err := mem.waitForRoom(); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		mem.put(key, NewValue("DEL", nil))
+
+		// Write the operation to the WAL
+		if err := mem.wal.AppendEntry(WatermarkPlaceholder, "DEL", key, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Deleted returns the number of keys deleted so far.
+func (s *DeleteSweep) Deleted() int64 {
+	return s.deleted.Load()
+}
+
+// Batches returns the number of batches processed so far.
+func (s *DeleteSweep) Batches() int64 {
+	return s.batches.Load()
+}
+
+// Cancel stops the sweep after the current batch.
+func (s *DeleteSweep) Cancel() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// Wait blocks until the sweep finished or was cancelled, and returns its error.
+func (s *DeleteSweep) Wait() error {
+	<-s.done
+	return s.err
+}
@@ -0,0 +1,49 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTruncatePrefix(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	for i := 0; i < 25; i++ {
+		mem.Set([]byte(fmt.Sprintf("tmp:%02d", i)), []byte("x"))
+	}
+	mem.Set([]byte("keep"), []byte("x"))
+	mem.Set([]byte("tmq"), []byte("x"))
+
+	sweep := mem.TruncatePrefix([]byte("tmp:"), TruncateOptions{BatchSize: 10, KeysPerSec: 10000})
+	if err := sweep.Wait(); err != nil {
+		t.Fatalf("Error truncating prefix: %v", err)
+	}
+	if sweep.Deleted() != 25 || sweep.Batches() != 3 {
+		t.Errorf("Expected 25 keys in 3 batches, got %d in %d", sweep.Deleted(), sweep.Batches())
+	}
+
+	for _, key := range []string{"tmp:00", "tmp:24"} {
+		if ok, _ := mem.Has([]byte(key)); ok {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+	for _, key := range []string{"keep", "tmq"} {
+		if ok, _ := mem.Has([]byte(key)); !ok {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	cases := map[string][]byte{
+		"abc":      []byte("abd"),
+		"a\xff":    []byte("b"),
+		"\xff\xff": nil,
+	}
+	for prefix, want := range cases {
+		if got := prefixEnd([]byte(prefix)); !bytes.Equal(got, want) {
+			t.Errorf("prefixEnd(%q) = %q, expected %q", prefix, got, want)
+		}
+	}
+}
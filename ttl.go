@@ -0,0 +1,136 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// now returns the current time in Unix nanoseconds, against which the
+// expiration timestamps are compared. It is a variable so tests can control
+// the clock.
+var now = newMonotonicClock()
+
+// wallNow reads the wall clock; tests replace it to step the clock.
+var wallNow = time.Now
+
+// newMonotonicClock returns a clock reading the wall clock once, then adding
+// the time elapsed since as measured by the monotonic clock. Steps of the wall
+// clock while the process runs, such as an NTP correction, don't move it: a
+// step forward doesn't expire every key at once, nor does a step backward make
+// keys outlive their TTL. Expirations stay absolute timestamps, so those set
+// before a restart are compared with the wall clock of the next run.
+func newMonotonicClock() func() int64 {
+	start := time.Now()
+	wall := wallNow().UnixNano()
+	return func() int64 { return wall + int64(time.Since(start)) }
+}
+
+// expired reports whether an expiration timestamp is set and has passed.
+func expired(expiresAt int64) bool {
+	return expiresAt != 0 && expiresAt <= now()
+}
+
+// live reports whether the value is neither deleted nor expired.
+func (v *Value) live() bool {
+	return v.Operation != "DEL" && !expired(v.ExpiresAt)
+}
+
+// encodeTTLValue prefixes value with its expiration timestamp, as stored for TTL operations.
+func encodeTTLValue(expiresAt int64, value []byte) []byte {
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(expiresAt))
+	copy(buf[8:], value)
+	return buf
+}
+
+// decodeTTLValue splits a TTL operation payload into its expiration timestamp and value.
+func decodeTTLValue(buf []byte) (int64, []byte, error) {
+	if len(buf) < 8 {
+		return 0, nil, errors.New("TTL value too short")
+	}
+	return int64(binary.BigEndian.Uint64(buf)), buf[8:], nil
+}
+
+// SetWithTTL stores value under key until ttl elapses, after which the key reads as deleted.
+func (mem *MemDB) SetWithTTL(key []byte, value []byte, ttl time.Duration) (err error) {
+	if ttl <= 0 {
+		return errors.New("TTL must be positive")
+	}
+
+	defer mem.syncWrite(&err)
+	defer mem.flushIfFull()
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if mem.closed.Load() {
+		return ErrClosed
+	}
+	if err := mem.waitForRoom(); err != nil {
+		return err
+	}
+
+	return mem.setExpiring(key, value, now()+int64(ttl))
+}
+
+// setExpiring stores value under key until expiresAt, or without expiration
+// if expiresAt is 0. mu must be held.
+func (mem *MemDB) setExpiring(key, value []byte, expiresAt int64) error {
+	if expiresAt == 0 {
+		return mem.set(key, value)
+	}
+	mem.put(key, &Value{Operation: "SET", Value: value, ExpiresAt: expiresAt})
+
+	// Write the operation to the WAL
+	return mem.wal.AppendEntry(WatermarkPlaceholder, ttlOperation, key, encodeTTLValue(expiresAt, value))
+}
+
+// SweepExpired turns the expired entries of the memtable into tombstones and
+// returns how many were swept.
+func (mem *MemDB) SweepExpired() (swept int, err error) {
+	defer mem.syncWrite(&err)
+	defer mem.flushIfFull()
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if mem.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	for elem := mem.skiplist.Front(); elem != nil; elem = elem.Next() {
+		value := elem.Value.(*Value)
+		if value.Operation == "DEL" || !expired(value.ExpiresAt) {
+			continue
+		}
+
+		key := elem.Key().([]byte)
+		mem.put(key, NewValue("DEL", value.Value))
+		if err := mem.wal.AppendEntry(WatermarkPlaceholder, "DEL", key, value.Value); err != nil {
+			return swept, err
+		}
+		swept++
+	}
+
+	return swept, nil
+}
+
+// StartSweeper runs SweepExpired every interval on a background goroutine until
+// the returned stop function is called.
+func (mem *MemDB) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := mem.SweepExpired(); err != nil {
+					Logger.Printf("TTL sweep failed: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
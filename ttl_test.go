@@ -0,0 +1,192 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/huandu/skiplist"
+)
+
+// setClock pins the clock used for expirations for the duration of the test.
+func setClock(t *testing.T, ts *int64) {
+	prev := now
+	now = func() int64 { return *ts }
+	t.Cleanup(func() { now = prev })
+}
+
+func TestSetWithTTL(t *testing.T) {
+	clock := time.Now().UnixNano()
+	setClock(t, &clock)
+
+	mem := newTempMemDB(t)
+	if err := mem.SetWithTTL([]byte("session"), []byte("token"), time.Minute); err != nil {
+		t.Fatalf("Error setting key with TTL: %v", err)
+	}
+
+	value, err := mem.Get([]byte("session"))
+	if err != nil || string(value) != "token" {
+		t.Fatalf("Expected token, got %q (%v)", value, err)
+	}
+
+	// Once the TTL elapsed the key reads as deleted.
+	clock += int64(time.Minute)
+	if _, err := mem.Get([]byte("session")); err == nil {
+		t.Errorf("Expected expired key to be missing")
+	}
+	if ok, _ := mem.Has([]byte("session")); ok {
+		t.Errorf("Expected Has to report expired key as missing")
+	}
+
+	// The expiration survives a replay of the WAL.
+	replayed := &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: mem.wal}
+	if err := replayed.Load(); err != nil {
+		t.Fatalf("Error loading WAL: %v", err)
+	}
+	elem := replayed.skiplist.Get([]byte("session"))
+	if elem == nil || elem.Value.(*Value).ExpiresAt != clock {
+		t.Errorf("Expected replayed key to expire at %d", clock)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	clock := time.Now().UnixNano()
+	setClock(t, &clock)
+
+	mem := newTempMemDB(t)
+	mem.SetWithTTL([]byte("short"), []byte("1"), time.Second)
+	mem.SetWithTTL([]byte("long"), []byte("2"), time.Hour)
+	mem.Set([]byte("forever"), []byte("3"))
+
+	clock += int64(time.Minute)
+	swept, err := mem.SweepExpired()
+	if err != nil {
+		t.Fatalf("Error sweeping: %v", err)
+	}
+	if swept != 1 {
+		t.Errorf("Expected 1 swept key, got %d", swept)
+	}
+	if op := mem.skiplist.Get([]byte("short")).Value.(*Value).Operation; op != "DEL" {
+		t.Errorf("Expected expired key to become a tombstone, got %s", op)
+	}
+}
+
+func TestSSTTupleWithTTL(t *testing.T) {
+	clock := time.Now().UnixNano()
+	setClock(t, &clock)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "sst001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sst := &SSTFile{File: file}
+
+	entry := SSTTuple{Key: []byte("k"), Value: SSTPair{Operation: setOperation, Value: []byte("v"), ExpiresAt: clock + 10}}
+	sst.writeHeader(SSTFileHeader{Magic: []byte(magicString), EntryCount: 1, SmallestKey: entry.Key, LongestKey: entry.Key, Version: 1})
+	if err := writeTuple(sst.File, entry); err != nil {
+		t.Fatalf("Error writing tuple: %v", err)
+	}
+
+	sst.File.Seek(0, 0)
+	sst.readHeader()
+	tuple, err := readTuple(sst.File)
+	if err != nil {
+		t.Fatalf("Error reading tuple: %v", err)
+	}
+	if tuple.Value.Operation != setOperation || tuple.Value.ExpiresAt != clock+10 || !bytes.Equal(tuple.Value.Value, []byte("v")) {
+		t.Errorf("Unexpected tuple: %+v", tuple)
+	}
+
+	sst.File.Seek(0, 0)
+	if value, n := sst.Get(entry.Key); n != 1 || string(value) != "v" {
+		t.Errorf("Expected live value, got %q (%d)", value, n)
+	}
+
+	// After expiry the key reads as deleted.
+	clock += 10
+	sst.File.Seek(0, 0)
+	if _, n := sst.Get(entry.Key); n != -1 {
+		t.Errorf("Expected expired key to read as deleted, got %d", n)
+	}
+	sst.File.Seek(0, 0)
+	if n := sst.Has(entry.Key); n != -1 {
+		t.Errorf("Expected Has to report expired key as deleted, got %d", n)
+	}
+}
+
+func TestCopyKeepsTTL(t *testing.T) {
+	clock := time.Now().UnixNano()
+	setClock(t, &clock)
+
+	mem := NewTempDB(t)
+	if err := mem.SetWithTTL([]byte("session"), []byte("token"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// The source is read from an SST file for the second copy.
+	if err := mem.Copy([]byte("session"), []byte("same"), CopyOptions{}); err != nil {
+		t.Fatalf("Error copying: %v", err)
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Copy([]byte("session"), []byte("longer"), CopyOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("Error copying with a TTL: %v", err)
+	}
+	if err := mem.Copy([]byte("session"), []byte("bad"), CopyOptions{TTL: -time.Second}); err == nil {
+		t.Error("Expected a negative TTL to be refused")
+	}
+
+	clock += int64(time.Minute)
+	if _, err := mem.Get([]byte("same")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the copy to expire with the source, got %v", err)
+	}
+	if value, err := mem.Get([]byte("longer")); err != nil || string(value) != "token" {
+		t.Errorf("Expected the copy to outlive the source, got %q (%v)", value, err)
+	}
+	if err := mem.Copy([]byte("session"), []byte("late"), CopyOptions{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected copying an expired key to fail, got %v", err)
+	}
+
+	// The expiration survives a restart.
+	mem.Close()
+	reopened, err := Open(mem.opts.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Get([]byte("same")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the copy to stay expired after a restart, got %v", err)
+	}
+}
+
+func TestClockSteps(t *testing.T) {
+	start := time.Now()
+	wall := start
+	prevWall := wallNow
+	wallNow = func() time.Time { return wall }
+	defer func() { wallNow = prevWall }()
+
+	prev := now
+	now = newMonotonicClock()
+	defer func() { now = prev }()
+
+	mem := newTempMemDB(t)
+	if err := mem.SetWithTTL([]byte("session"), []byte("token"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps of the wall clock either way leave the TTL running.
+	for _, step := range []time.Duration{time.Hour, -time.Hour} {
+		wall = wall.Add(step)
+		if value, err := mem.Get([]byte("session")); err != nil || string(value) != "token" {
+			t.Errorf("Expected the key to outlive a wall-clock step of %v, got %q (%v)", step, value, err)
+		}
+		if elapsed := time.Duration(now() - start.UnixNano()); elapsed < 0 || elapsed > time.Second {
+			t.Errorf("Expected the clock to ignore a step of %v, got %v since it started", step, elapsed)
+		}
+	}
+}
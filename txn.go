@@ -0,0 +1,197 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const txnOperation = "TXN"
+
+// ErrTxnDone is returned when using a transaction that was already committed or rolled back.
+var ErrTxnDone = errors.New("transaction already committed or rolled back")
+
+// Txn buffers writes and applies them to the store all at once on Commit. Reads
+// see the transaction's own writes and otherwise the latest committed state
+// (read committed).
+type Txn struct {
+	db     *MemDB
+	writes map[string]*Value
+	done   bool
+}
+
+// Begin starts a new transaction.
+func (mem *MemDB) Begin() *Txn {
+	return &Txn{db: mem, writes: make(map[string]*Value)}
+}
+
+// Get returns the value of key as seen by the transaction.
+func (tx *Txn) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxnDone
+	}
+
+	if value, ok := tx.writes[string(key)]; ok {
+		if value.Operation == "DEL" {
+			return nil, ErrKeyNotFound
+		}
+		return value.Value, nil
+	}
+
+	return tx.db.Get(key)
+}
+
+// Set buffers a write of key.
+func (tx *Txn) Set(key, value []byte) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+
+	tx.writes[string(key)] = NewValue("SET", value)
+	return nil
+}
+
+// Del buffers the deletion of key.
+func (tx *Txn) Del(key []byte) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+
+	tx.writes[string(key)] = NewValue("DEL", nil)
+	return nil
+}
+
+// Commit writes the buffered operations to the WAL as a single record, then
+// applies them to the memtable.
+func (tx *Txn) Commit() (err error) {
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.done = true
+
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	// Sort the keys so the record is deterministic.
+	keys := make([]string, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]WALEntry, 0, len(keys))
+	for _, key := range keys {
+		value := tx.writes[key]
+		entries = append(entries, WALEntry{Operation: value.Operation, Key: []byte(key), Value: value.Value})
+	}
+
+	defer tx.db.syncWrite(&err)
+	defer tx.db.flushIfFull()
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.db.closed.Load() {
+		return ErrClosed
+	}
+	if err := tx.db.waitForRoom(); err != nil {
+		return err
+	}
+
+	// Write the operation to the WAL
+	if err := tx.db.wal.AppendEntry(WatermarkPlaceholder, txnOperation, nil, encodeTxnBatch(entries)); err != nil {
+		return err
+	}
+
+	tx.db.applyBatch(entries)
+	return nil
+}
+
+// Rollback discards the buffered operations.
+func (tx *Txn) Rollback() error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.done = true
+	tx.writes = nil
+
+	return nil
+}
+
+// applyBatch applies the entries of a transaction to the memtable, once
+// appended to the WAL: they all get the sequence number of the transaction.
+func (mem *MemDB) applyBatch(entries []WALEntry) {
+	seq := mem.wal.seq.Load()
+	for _, entry := range entries {
+		value := batchValue(entry)
+		value.Seq = seq
+		mem.put(entry.Key, value)
+	}
+}
+
+// batchValue returns the memtable value of an entry of a transaction, whose
+// TTL entries decodeTxnBatch checked.
+func batchValue(entry WALEntry) *Value {
+	if entry.Operation == ttlOperation {
+		expiresAt, value, _ := decodeTTLValue(entry.Value)
+		return &Value{Operation: "SET", Value: value, ExpiresAt: expiresAt}
+	}
+	return NewValue(entry.Operation, entry.Value)
+}
+
+// encodeTxnBatch encodes the entries of a transaction as the value of a TXN record.
+func encodeTxnBatch(entries []WALEntry) []byte {
+	var buf bytes.Buffer
+
+	writeBinary(&buf, uint32(len(entries)))
+	for _, entry := range entries {
+		op, _ := encodeOperation(entry.Operation) // Only SET, DEL and TTL get here.
+		writeBinary(&buf, op, uint32(len(entry.Key)), entry.Key, uint32(len(entry.Value)), entry.Value)
+	}
+
+	return buf.Bytes()
+}
+
+// decodeTxnBatch decodes the value of a TXN record.
+func decodeTxnBatch(buf []byte) ([]WALEntry, error) {
+	r := bytes.NewReader(buf)
+
+	var count uint32
+	if err := readBinary(r, &count); err != nil {
+		return nil, err
+	}
+
+	// The count comes from disk, so don't preallocate from it.
+	var entries []WALEntry
+	for i := uint32(0); i < count; i++ {
+		var entry WALEntry
+
+		var err error
+		if entry.Operation, _, err = readOperation(r); err != nil {
+			return nil, err
+		}
+		if entry.Operation != "SET" && entry.Operation != "DEL" && entry.Operation != ttlOperation {
+			return nil, fmt.Errorf("unsupported operation in transaction: %s", entry.Operation)
+		}
+
+		if entry.Key, err = readKeyValue(r); err != nil {
+			return nil, err
+		}
+		if entry.Value, err = readKeyValue(r); err != nil {
+			return nil, err
+		}
+		if entry.Operation == ttlOperation {
+			if _, _, err := decodeTTLValue(entry.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes in transaction record", r.Len())
+	}
+
+	return entries, nil
+}
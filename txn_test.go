@@ -0,0 +1,73 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/huandu/skiplist"
+)
+
+func TestTxnCommit(t *testing.T) {
+	mem := newTempMemDB(t)
+	mem.Set([]byte("a"), []byte("old"))
+	mem.Set([]byte("b"), []byte("old"))
+
+	tx := mem.Begin()
+	tx.Set([]byte("a"), []byte("new"))
+	tx.Del([]byte("b"))
+	tx.Set([]byte("c"), []byte("new"))
+
+	// The transaction sees its own writes, the store doesn't until commit.
+	if value, _ := tx.Get([]byte("a")); string(value) != "new" {
+		t.Errorf("Expected the transaction to read its own write, got %q", value)
+	}
+	if _, err := tx.Get([]byte("b")); err == nil {
+		t.Errorf("Expected the transaction to see its own delete")
+	}
+	if value, _ := mem.Get([]byte("a")); string(value) != "old" {
+		t.Errorf("Expected uncommitted write to be invisible, got %q", value)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	if err := tx.Commit(); err != ErrTxnDone {
+		t.Errorf("Expected ErrTxnDone on second commit, got %v", err)
+	}
+
+	// The writes are visible, and replayed from the single WAL record.
+	replayed := &MemDB{skiplist: skiplist.New(skiplist.Bytes), wal: mem.wal}
+	if err := replayed.Load(); err != nil {
+		t.Fatalf("Error loading WAL: %v", err)
+	}
+	for _, db := range []*MemDB{mem, replayed} {
+		if value, _ := db.Get([]byte("a")); string(value) != "new" {
+			t.Errorf("Expected a=new, got %q", value)
+		}
+		if _, err := db.Get([]byte("b")); err == nil {
+			t.Errorf("Expected b to be deleted")
+		}
+		if value, _ := db.Get([]byte("c")); string(value) != "new" {
+			t.Errorf("Expected c=new, got %q", value)
+		}
+	}
+	if stats := replayed.RecoveryStats(); stats.Applied != 3 {
+		t.Errorf("Expected 2 sets and 1 transaction record applied, got %d", stats.Applied)
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	mem := newTempMemDB(t)
+
+	tx := mem.Begin()
+	tx.Set([]byte("a"), []byte("1"))
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+
+	if elem := mem.skiplist.Get([]byte("a")); elem != nil {
+		t.Errorf("Expected rolled back write to be discarded")
+	}
+	if err := tx.Set([]byte("a"), []byte("2")); err != ErrTxnDone {
+		t.Errorf("Expected ErrTxnDone after rollback, got %v", err)
+	}
+}
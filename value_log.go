@@ -0,0 +1,256 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// sstValueLogVersion is the first version whose tuples may point to
+	// their value in the value log rather than hold it.
+	sstValueLogVersion = 11
+
+	// valueLogFileSize is the size past which flushes append to a new value
+	// log file rather than the last one.
+	valueLogFileSize = 256 << 20
+
+	// blobValueSize is the size of the value of a BLB tuple: the expiration
+	// time of the value, then its valuePointer.
+	blobValueSize = 8 + 4 + 8 + 4
+)
+
+// The value log keeps the values of Options.ValueLogThreshold bytes or more
+// apart from the SST files, in the append-only vlog files of the SST
+// directory. The tuples of the SST files only point to them, so large values
+// aren't copied into the data blocks, and rewriting a file doesn't rewrite
+// its values. A record of the log is the key, the value, each preceded by its
+// uint32 length, and the CRC32 of the three.
+
+// valuePointer locates a record of the value log.
+type valuePointer struct {
+	file   uint32 // Number of the vlog file.
+	offset int64
+	size   uint32 // Of the whole record.
+}
+
+// encodeBlobValue encodes the value of a BLB tuple.
+func encodeBlobValue(expiresAt int64, ptr valuePointer) []byte {
+	buf := make([]byte, 0, blobValueSize)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiresAt))
+	buf = binary.BigEndian.AppendUint32(buf, ptr.file)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(ptr.offset))
+	return binary.BigEndian.AppendUint32(buf, ptr.size)
+}
+
+// decodeBlobValue decodes the value of a BLB tuple.
+func decodeBlobValue(buf []byte) (int64, valuePointer, error) {
+	if len(buf) != blobValueSize {
+		return 0, valuePointer{}, fmt.Errorf("value pointer of %d bytes", len(buf))
+	}
+	ptr := valuePointer{
+		file:   binary.BigEndian.Uint32(buf[8:]),
+		offset: int64(binary.BigEndian.Uint64(buf[12:])),
+		size:   binary.BigEndian.Uint32(buf[20:]),
+	}
+	return int64(binary.BigEndian.Uint64(buf)), ptr, nil
+}
+
+// valueLogPath returns the path of vlog file n of dir.
+func valueLogPath(dir string, n uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("vlog%03d", n))
+}
+
+// findLastValueLogNumber returns the number of the latest vlog file of dir, 0
+// if there is none.
+func findLastValueLogNumber(dir string) (uint32, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "vlog*"))
+	if err != nil {
+		return 0, err
+	}
+	var res, num uint32
+	for _, file := range files {
+		if _, err := fmt.Sscanf(filepath.Base(file), "vlog%03d", &num); err == nil && num > res {
+			res = num
+		}
+	}
+	return res, nil
+}
+
+// valueLogWriter appends the large values of a flush to the value log.
+type valueLogWriter struct {
+	file   *os.File
+	w      *bufio.Writer
+	number uint32
+	offset int64 // Of the end of the file.
+}
+
+// openValueLogWriter opens the last vlog file of dir for appending, or
+// creates the next one if there is none or it is full. Records left
+// unfinished by a crash at the end of the file are never pointed to, so
+// appending after them is safe.
+func openValueLogWriter(dir string) (*valueLogWriter, error) {
+	n, err := findLastValueLogNumber(dir)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		n = 1
+	}
+	if info, err := os.Stat(valueLogPath(dir, n)); err == nil && info.Size() >= valueLogFileSize {
+		n++
+	}
+
+	file, err := os.OpenFile(valueLogPath(dir, n), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &valueLogWriter{file: file, w: bufio.NewWriter(file), number: n, offset: info.Size()}, nil
+}
+
+// add appends the record of key and value and returns where it is.
+func (v *valueLogWriter) add(key, value []byte) (valuePointer, error) {
+	var record bytes.Buffer
+	writeBinary(&record, uint32(len(key)), key, uint32(len(value)), value)
+	data := appendChecksum(record.Bytes())
+
+	ptr := valuePointer{file: v.number, offset: v.offset, size: uint32(len(data))}
+	n, err := v.w.Write(data)
+	v.offset += int64(n)
+	return ptr, err
+}
+
+// finish makes the records added durable and closes the file. They must be
+// before the SST files pointing to them are.
+func (v *valueLogWriter) finish() error {
+	err := v.w.Flush()
+	if err == nil {
+		err = v.file.Sync()
+	}
+	if closeErr := v.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// valueLog reads the values kept in the vlog files of a directory, keeping
+// the files open for the reads that follow.
+type valueLog struct {
+	dir string
+
+	mu     sync.RWMutex
+	files  map[uint32]*os.File
+	closed bool // Set by close, after which files are opened for every read.
+}
+
+func newValueLog(dir string) *valueLog {
+	return &valueLog{dir: dir, files: map[uint32]*os.File{}}
+}
+
+// read returns the value of key that ptr points to.
+func (v *valueLog) read(key []byte, ptr valuePointer) ([]byte, error) {
+	// The read lock keeps the file open until the read is done.
+	v.mu.RLock()
+	file, ok := v.files[ptr.file]
+	if !ok && !v.closed {
+		v.mu.RUnlock()
+		if err := v.open(ptr.file); err != nil {
+			return nil, err
+		}
+		v.mu.RLock()
+		file, ok = v.files[ptr.file]
+	}
+	defer v.mu.RUnlock()
+	if !ok {
+		var err error
+		if file, err = os.Open(valueLogPath(v.dir, ptr.file)); err != nil {
+			return nil, err
+		}
+		defer file.Close()
+	}
+
+	data := make([]byte, ptr.size)
+	if _, err := file.ReadAt(data, ptr.offset); err != nil {
+		return nil, fmt.Errorf("error reading value of %q from %s: %w", key, file.Name(), err)
+	}
+	data, err := stripChecksum(data)
+	if err != nil {
+		return nil, fmt.Errorf("value of %q in %s at offset %d: %w", key, file.Name(), ptr.offset, err)
+	}
+
+	r := bytes.NewReader(data)
+	recordKey, err := readKeyValue(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(recordKey, key) {
+		return nil, fmt.Errorf("value of %q in %s at offset %d belongs to %q", key, file.Name(), ptr.offset, recordKey)
+	}
+	value, err := readKeyValue(r)
+	if err == nil && r.Len() != 0 {
+		err = errors.New("trailing bytes after value")
+	}
+	return value, err
+}
+
+// open opens vlog file n to keep it for the reads that follow, unless v is
+// closed.
+func (v *valueLog) open(n uint32) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.files[n]; ok || v.closed {
+		return nil
+	}
+	file, err := os.Open(valueLogPath(v.dir, n))
+	if err != nil {
+		return err
+	}
+	v.files[n] = file
+	return nil
+}
+
+// openFiles returns the number of value log files kept open for reads.
+func (v *valueLog) openFiles() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.files)
+}
+
+// close closes the files kept open.
+func (v *valueLog) close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.closed = true
+	var err error
+	for n, file := range v.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		delete(v.files, n)
+	}
+	return err
+}
+
+// resolve returns pair with its value read from the value log if it is kept
+// there.
+func (v *valueLog) resolve(key []byte, pair SSTPair) (SSTPair, error) {
+	if pair.blob == nil {
+		return pair, nil
+	}
+	value, err := v.read(key, *pair.blob)
+	if err != nil {
+		return pair, err
+	}
+	pair.Value, pair.blob = value, nil
+	return pair, nil
+}
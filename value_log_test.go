@@ -0,0 +1,119 @@
+package kvstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValueLog(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir, ValueLogThreshold: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { mem.Close() }()
+
+	large := bytes.Repeat([]byte("0123456789"), 10<<10)
+	mem.Set([]byte("large"), large)
+	mem.Set([]byte("small"), []byte("value"))
+	mem.SetWithTTL([]byte("expiring"), large, time.Hour)
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the small value is copied into the SST file.
+	info, err := os.Stat(filepath.Join(mem.sstDir, sstFileName(0, 1)))
+	if err != nil || info.Size() > 1<<10 {
+		t.Fatalf("Expected a small SST file, got %v (%v)", info.Size(), err)
+	}
+	if info, err := os.Stat(filepath.Join(mem.sstDir, "vlog001")); err != nil || info.Size() < 2*int64(len(large)) {
+		t.Fatalf("Expected the large values in vlog001, got %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		for _, key := range []string{"large", "expiring"} {
+			if value, err := mem.Get([]byte(key)); err != nil || !bytes.Equal(value, large) {
+				t.Errorf("Expected the large value of %s, got %d bytes (%v)", key, len(value), err)
+			}
+		}
+		values, err := mem.MultiGet([][]byte{[]byte("large"), []byte("small")})
+		if err != nil || !bytes.Equal(values[0], large) || string(values[1]) != "value" {
+			t.Errorf("Unexpected MultiGet result: %v", err)
+		}
+		it, err := mem.NewIterator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		for it.Next() {
+			if string(it.Key()) != "small" && !bytes.Equal(it.Value(), large) {
+				t.Errorf("Expected the large value of %s, got %d bytes", it.Key(), len(it.Value()))
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+		}
+	}
+	check()
+
+	// A second flush appends to the same vlog file.
+	mem.Set([]byte("other"), large)
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := mem.Get([]byte("other")); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("Expected the large value of other, got %d bytes (%v)", len(value), err)
+	}
+	if _, err := os.Stat(filepath.Join(mem.sstDir, "vlog002")); !os.IsNotExist(err) {
+		t.Errorf("Expected flushes to share vlog001, got %v", err)
+	}
+
+	mem.Close()
+	if mem, err = OpenWithOptions(Options{DataDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// The SST iterator keeps the pointer in the tuple and reads the value.
+	it, err := newSSTIterator(filepath.Join(mem.sstDir, sstFileName(0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if !it.Next() || it.Tuple().Value.blob == nil || it.Tuple().Value.ExpiresAt == 0 || !bytes.Equal(it.Value(), large) {
+		t.Errorf("Expected expiring to point to its value, got %+v", it.Tuple())
+	}
+}
+
+func TestCorruptValueLog(t *testing.T) {
+	mem, err := OpenWithOptions(Options{DataDir: t.TempDir(), ValueLogThreshold: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	mem.Set([]byte("key"), []byte("large value"))
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(mem.sstDir, "vlog001")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Get([]byte("key")); err == nil {
+		t.Error("Expected reading a corrupt value to fail")
+	}
+	// Has doesn't read the value.
+	if ok, err := mem.Has([]byte("key")); !ok || err != nil {
+		t.Errorf("Expected key to be found, got %v, %v", ok, err)
+	}
+}
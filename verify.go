@@ -0,0 +1,120 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// VerifyLevel selects how much of the SST files OpenWithOptions checks before
+// the store serves reads, trading startup time for confidence that the files
+// survived an unclean shutdown.
+type VerifyLevel int
+
+const (
+	// VerifyNone opens the store without checking its files. Damage shows
+	// on the first read of the damaged part.
+	VerifyNone VerifyLevel = iota
+	// VerifyManifest checks that every file the manifest lists exists with
+	// the recorded size, and that its header, footer and index parse.
+	VerifyManifest
+	// VerifyChecksums also reads every block and filter partition of the
+	// files and checks its checksum.
+	VerifyChecksums
+	// VerifyFull also checks the contents of the files: keys sorted and in
+	// the range the manifest gives, entry counts, and bloom filters holding
+	// every key, as VerifySST does.
+	VerifyFull
+)
+
+// ParseVerifyLevel returns the level named none, manifest, checksums or full.
+func ParseVerifyLevel(name string) (VerifyLevel, error) {
+	switch name {
+	case "none":
+		return VerifyNone, nil
+	case "manifest":
+		return VerifyManifest, nil
+	case "checksums":
+		return VerifyChecksums, nil
+	case "full":
+		return VerifyFull, nil
+	}
+	return VerifyNone, fmt.Errorf("unknown verify level %q", name)
+}
+
+func (l VerifyLevel) String() string {
+	switch l {
+	case VerifyNone:
+		return "none"
+	case VerifyManifest:
+		return "manifest"
+	case VerifyChecksums:
+		return "checksums"
+	case VerifyFull:
+		return "full"
+	}
+	return fmt.Sprintf("VerifyLevel(%d)", int(l))
+}
+
+// verifyOnOpen checks the SST files of dir listed by the manifest, as level
+// requests.
+func verifyOnOpen(dir string, files []*sstMeta, level VerifyLevel) error {
+	if level <= VerifyNone {
+		return nil
+	}
+	for _, f := range files {
+		if err := verifyManifestEntry(dir, f); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case level == VerifyChecksums:
+		return verifySSTFiles(dir, files)
+	case level >= VerifyFull:
+		for _, f := range files {
+			if err := verifySSTContents(dir, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyManifestEntry checks that SST file f exists with the size the
+// manifest records, and that its header, footer and index parse.
+func verifyManifestEntry(dir string, f *sstMeta) error {
+	file, err := os.Open(filepath.Join(dir, f.name()))
+	if err != nil {
+		return fmt.Errorf("SST file listed by the manifest: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if f.size != 0 && info.Size() != f.size {
+		return fmt.Errorf("SST file %s has %d bytes, the manifest records %d", file.Name(), info.Size(), f.size)
+	}
+	_, err = newSSTReader(file)
+	return err
+}
+
+// verifySSTContents checks every tuple of SST file f, failing on the first
+// problem VerifySST reports or on keys out of the range of the manifest.
+func verifySSTContents(dir string, f *sstMeta) error {
+	report, err := VerifySST(filepath.Join(dir, f.name()))
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("SST file %s: %s", report.Path, report.Problems[0])
+	}
+	if report.Entries > 0 && f.smallest != nil && (bytes.Compare(report.Smallest, f.smallest) < 0 || bytes.Compare(report.Largest, f.largest) > 0) {
+		return fmt.Errorf("SST file %s holds keys %q to %q, out of the range %q to %q of the manifest",
+			report.Path, report.Smallest, report.Largest, f.smallest, f.largest)
+	}
+	return nil
+}
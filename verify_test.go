@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseVerifyLevel(t *testing.T) {
+	for _, level := range []VerifyLevel{VerifyNone, VerifyManifest, VerifyChecksums, VerifyFull} {
+		parsed, err := ParseVerifyLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("Expected to parse %s back, got %s (%v)", level, parsed, err)
+		}
+	}
+	if _, err := ParseVerifyLevel("some"); err == nil {
+		t.Errorf("Expected an error parsing an unknown level")
+	}
+}
+
+func TestVerifyOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value"))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	// A file whose keys go past the range the manifest records for it.
+	f := writeTestSST(t, mem.sstDir, 2, []SSTTuple{set("x", "1"), set("z", "2")})
+	f.largest = []byte("y")
+	if err := mem.manifest.apply(manifestEdit{add: []*sstMeta{f}}); err != nil {
+		t.Fatal(err)
+	}
+	mem.Close()
+
+	path := filepath.Join(dir, "sstStorage", sstFileName(0, 1))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newSSTReader(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expect opens the store at every level, expecting it to fail from
+	// level failFrom on.
+	expect := func(damage string, failFrom VerifyLevel) {
+		t.Helper()
+		for _, level := range []VerifyLevel{VerifyNone, VerifyManifest, VerifyChecksums, VerifyFull} {
+			mem, err := OpenWithOptions(Options{DataDir: dir, VerifyOnOpen: level})
+			if err == nil {
+				mem.Close()
+			}
+			if fails := err != nil; fails != (level >= failFrom) {
+				t.Errorf("With %s, verifying %s: got %v", damage, level, err)
+			}
+		}
+	}
+
+	expect("a key out of the range of the manifest", VerifyFull)
+
+	corrupt := append([]byte{}, data...)
+	corrupt[r.blocks[1].offset+10]++
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("a corrupt data block", VerifyChecksums)
+	var checksumErr *ChecksumError
+	if _, err := OpenWithOptions(Options{DataDir: dir, VerifyOnOpen: VerifyChecksums}); !errors.As(err, &checksumErr) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+
+	if err := os.WriteFile(path, append(data, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect("a file of the wrong size", VerifyManifest)
+}
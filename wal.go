@@ -0,0 +1,1032 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Watermark            uint32 = 0xDEAD
+	WatermarkPlaceholder uint32 = 0
+
+	// checksumFlag is set in the watermark of entries ending with a CRC32 of
+	// the operation, key and value. Entries written before checksums were
+	// introduced don't have it.
+	checksumFlag uint32 = 1 << 31
+	// seqFlag is set in the watermark of entries starting with their 64-bit
+	// sequence number, which the checksum covers.
+	seqFlag uint32 = 1 << 30
+	// compressedFlag is set in the watermark of entries whose value is
+	// compressed with DEFLATE. The checksum covers the compressed bytes.
+	compressedFlag uint32 = 1 << 29
+
+	// minCompressedValue is the size below which values aren't worth compressing.
+	minCompressedValue = 128
+	// maxScratch is the largest encoding buffer AppendEntry keeps for reuse.
+	maxScratch = 1 << 20
+
+	// walVersion is the format version written in the header of new WAL files.
+	walVersion uint32 = 1
+	// walHeaderSize is the size of the header: the "WALF" magic and the version.
+	walHeaderSize = 8
+)
+
+// walMagic starts the header of WAL files. Files written before headers were
+// introduced start with an entry, whose watermark can't be mistaken for it.
+var walMagic = [4]byte{'W', 'A', 'L', 'F'}
+
+// walFooterMagic starts the footer written at the end of a segment when it is
+// rotated out, followed by the offset of its last entry. No watermark starts
+// with a 'W' either.
+var walFooterMagic = [4]byte{'W', 'F', 'T', 'R'}
+
+// walFooterSize is the size of a segment footer.
+const walFooterSize = 12
+
+// WALEntry represents an entry in the Write-Ahead Log.
+type WALEntry struct {
+	Seq       uint64 // Sequence number, 0 for entries written before they had one.
+	Operation string
+	Key       []byte
+	Value     []byte
+}
+
+// WAL represents the Write-Ahead Log. It is made of numbered segment files
+// in its directory, of which only the last one is appended to. Segments are
+// deleted once the memtable holding their entries has been flushed.
+type WAL struct {
+	// mu guards the active segment, its buffer and the offsets into it,
+	// since the background syncs and flushes don't hold the store's lock.
+	// syncMu serializes the fsyncs with the closing of the active segment;
+	// it is taken before mu, which isn't held during the fsync itself.
+	mu     sync.Mutex
+	syncMu sync.Mutex
+
+	file *os.File // The active segment.
+	path string
+
+	dir         string
+	segment     int   // Number of the active segment, 0 for a WAL opened with NewWAL.
+	segmentSize int64 // Size past which the active segment is rolled over, 0 to never roll over.
+	written     int64 // Bytes in the active segment.
+	start       int64 // Offset of the first entry of the active segment, after its header.
+	checkpoint  int   // Segments numbered below checkpoint are obsolete.
+
+	// last is the offset of the last entry of the active segment, if lastKnown.
+	last      int64
+	lastKnown bool
+
+	// compress makes AppendEntry compress the values of at least
+	// minCompressedValue bytes, when that makes them smaller.
+	compress bool
+	// preallocate makes new segments reserve segmentSize bytes on disk.
+	// recycle is the number of obsolete segments kept as spares, to be
+	// renamed into new segments instead of creating files.
+	preallocate bool
+	recycle     int
+
+	// Counters of the records and bytes appended, reported by MemDB.Stats.
+	appended      atomic.Int64
+	appendedBytes atomic.Int64
+	unsynced      atomic.Int64  // Records appended since the last Sync.
+	syncedSeq     atomic.Uint64 // Sequence number of the last entry made durable by a sync.
+	appends       appendMetrics
+	syncs         syncMetrics
+	syncOutcomes  outcomes // Reported by MemDB.Health.
+
+	// seq is the sequence number of the last entry appended, or replayed
+	// by MemDB.Load. flushedSeq is the one recorded by the last checkpoint.
+	seq        atomic.Uint64
+	flushedSeq uint64
+
+	// buf buffers the entries appended to file, if not nil. scratch is
+	// reused to encode them, under mu.
+	buf     *bufio.Writer
+	scratch []byte
+
+	tails tailState
+}
+
+// NewWAL opens a WAL stored in the single file filename, which is never rolled over.
+func NewWAL(filename string) (*WAL, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening/creating WAL file: %v", err)
+	}
+
+	w := &WAL{file: file, path: filename, dir: filepath.Dir(filename)}
+	if err := w.initFile(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// initFile writes the header of the active file if it is new, and checks it
+// otherwise.
+func (w *WAL) initFile() error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		if err := writeWALHeader(w.file); err != nil {
+			return err
+		}
+		w.written, w.start, w.lastKnown = walHeaderSize, walHeaderSize, false
+		w.publish()
+		return nil
+	}
+
+	start, err := readWALHeader(w.file)
+	if err != nil {
+		return err
+	}
+	// The last entry of a reopened file is found by LastOperation when needed.
+	w.written, w.start, w.lastKnown = info.Size(), start, false
+	w.publish()
+	return nil
+}
+
+// writeWALHeader writes the header of a new WAL file.
+func writeWALHeader(file *os.File) error {
+	var header [walHeaderSize]byte
+	copy(header[:], walMagic[:])
+	binary.BigEndian.PutUint32(header[4:], walVersion)
+
+	_, err := file.Write(header[:])
+	return err
+}
+
+// readWALHeader checks the header of a WAL file and returns the offset of its
+// first entry. Files without a header are read from their start.
+func readWALHeader(file *os.File) (int64, error) {
+	var header [walHeaderSize]byte
+	n, err := file.ReadAt(header[:], 0)
+	if n < len(walMagic) || [4]byte(header[:4]) != walMagic {
+		// A file without a header, or too short to tell: all of it is entries.
+		return 0, nil
+	}
+	if n < walHeaderSize {
+		return 0, fmt.Errorf("truncated header in WAL file %s: %v", filepath.Base(file.Name()), err)
+	}
+
+	if version := binary.BigEndian.Uint32(header[4:]); version > walVersion {
+		return 0, fmt.Errorf("WAL file %s has format version %d, this build reads up to %d",
+			filepath.Base(file.Name()), version, walVersion)
+	}
+	return walHeaderSize, nil
+}
+
+// OpenWAL opens the segmented WAL of dir, appending to its last segment. The
+// active segment is rolled over once it reaches segmentSize bytes.
+func OpenWAL(dir string, segmentSize int64) (*WAL, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint, flushedSeq, err := readCheckpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, segmentSize: segmentSize, segment: 1, checkpoint: 1, flushedSeq: flushedSeq}
+	w.seq.Store(flushedSeq)
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+	if checkpoint > w.segment {
+		w.segment = checkpoint
+	}
+	if checkpoint > w.checkpoint {
+		w.checkpoint = checkpoint
+	}
+	if err := w.openSegment(w.segment); err != nil {
+		return nil, err
+	}
+
+	// Delete the segments left behind by a flush interrupted after its checkpoint.
+	if err := w.RemoveBefore(w.checkpoint); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// checkpointFile is the name of the file recording the first WAL segment
+// whose entries aren't all flushed to SST files, and the sequence number of
+// the last flushed entry.
+const checkpointFile = "CHECKPOINT"
+
+// readCheckpoint returns the segment and sequence number recorded in the
+// checkpoint file of dir, zeros if there is none.
+func readCheckpoint(dir string) (int, uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Checkpoints written before sequence numbers only have the segment.
+	var (
+		n   int
+		seq uint64
+	)
+	if _, err := fmt.Sscanf(string(data), "%d %d", &n, &seq); err != nil && n == 0 {
+		return 0, 0, fmt.Errorf("invalid WAL checkpoint: %v", err)
+	}
+	return n, seq, nil
+}
+
+// Checkpoint records that every entry of the segments numbered below n, up to
+// sequence number seq, is flushed to an SST file, then deletes those
+// segments. The record makes it safe to crash before they are all deleted:
+// they are not replayed again.
+func (w *WAL) Checkpoint(n int, seq uint64) error {
+	if n > w.segment {
+		return fmt.Errorf("can't checkpoint the active WAL segment %d", w.segment)
+	}
+
+	path := filepath.Join(w.dir, checkpointFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%d %d\n", n, seq)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = replaceFile(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if seq > w.flushedSeq {
+		w.flushedSeq = seq
+	}
+
+	return w.RemoveBefore(n)
+}
+
+// segmentPath returns the path of segment n of dir.
+func segmentPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%06d.log", n))
+}
+
+// listSegments returns the numbers of the WAL segments of dir in ascending order.
+func listSegments(dir string) ([]int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int
+	for _, file := range files {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(file), "wal-%06d.log", &n); err == nil && n > 0 {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+// openSegment makes segment n the active one, creating it if needed.
+func (w *WAL) openSegment(n int) error {
+	path := segmentPath(w.dir, n)
+	if err := w.reuseSpare(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening/creating WAL segment: %v", err)
+	}
+
+	w.file, w.path, w.segment = file, path, n
+	if w.buf != nil {
+		w.buf.Reset(file)
+	}
+	if err := w.initFile(); err != nil {
+		file.Close()
+		return err
+	}
+	if w.preallocate && w.segmentSize > 0 {
+		preallocate(file, w.segmentSize)
+	}
+	return nil
+}
+
+// spares returns the paths of the spare segments of the WAL. Their names don't
+// end in .log, so they are never mistaken for segments.
+func (w *WAL) spares() ([]string, error) {
+	return filepath.Glob(filepath.Join(w.dir, "wal-spare-*"))
+}
+
+// reuseSpare renames a spare segment to path, if path doesn't exist yet and
+// there is a spare.
+func (w *WAL) reuseSpare(path string) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	spares, err := w.spares()
+	if err != nil || len(spares) == 0 {
+		return err
+	}
+
+	if err := os.Rename(spares[0], path); err != nil {
+		return err
+	}
+	// Spares are emptied when retired, but a crash may have left one full.
+	return os.Truncate(path, 0)
+}
+
+// retire removes the obsolete segment n, keeping it as a spare if there are
+// fewer than w.recycle of them.
+func (w *WAL) retire(n int) error {
+	path := segmentPath(w.dir, n)
+	if w.recycle > 0 {
+		spares, err := w.spares()
+		if err != nil {
+			return err
+		}
+		if len(spares) < w.recycle {
+			spare := filepath.Join(w.dir, fmt.Sprintf("wal-spare-%06d", n))
+			if err := os.Rename(path, spare); err != nil {
+				return err
+			}
+			return os.Truncate(spare, 0)
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rotate starts a new segment, so that every entry appended so far is in a
+// segment numbered below the returned one. Nothing happens if the active
+// segment is still empty.
+func (w *WAL) Rotate() (int, error) {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.segment == 0 {
+		return 0, errors.New("single-file WAL can't be rotated")
+	}
+	if w.written == w.start {
+		return w.segment, nil
+	}
+
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+
+	// Let readers of the segment jump to its last entry.
+	if w.lastKnown {
+		var footer [walFooterSize]byte
+		copy(footer[:], walFooterMagic[:])
+		binary.BigEndian.PutUint64(footer[4:], uint64(w.last))
+		if _, err := w.file.Write(footer[:]); err != nil {
+			return 0, err
+		}
+	}
+
+	// The entries of the segment must be durable before it is closed.
+	if w.unsynced.Load() > 0 {
+		if err := w.syncFile(w.file, w.seq.Load(), w.unsynced.Swap(0)); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.file.Close(); err != nil {
+		return 0, err
+	}
+	if err := w.openSegment(w.segment + 1); err != nil {
+		return 0, err
+	}
+
+	return w.segment, nil
+}
+
+// RemoveBefore deletes the segments numbered below n, whose entries are all
+// flushed to SST files. Unlike Checkpoint, it doesn't record n, so a crash
+// before the deletion is done replays the remaining segments again.
+func (w *WAL) RemoveBefore(n int) error {
+	if n > w.segment {
+		return fmt.Errorf("can't remove the active WAL segment %d", w.segment)
+	}
+	if n > w.checkpoint {
+		w.checkpoint = n
+	}
+
+	segments, err := listSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment >= n {
+			break
+		}
+		if err := w.retire(segment); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segments returns the paths of the files making up the WAL, oldest first.
+func (w *WAL) segments() ([]string, error) {
+	if w.segment == 0 {
+		return []string{w.path}, nil
+	}
+
+	numbers, err := listSegments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(numbers))
+	for i, n := range numbers {
+		paths[i] = segmentPath(w.dir, n)
+	}
+	return paths, nil
+}
+
+// AppendEntry appends a new entry to the Write-Ahead Log.
+func (w *WAL) AppendEntry(watermark uint32, operation string, key, value []byte) error {
+	start := time.Now()
+	entry := WALEntry{
+		Operation: operation,
+		Key:       key,
+		Value:     value,
+	}
+	op, err := encodeOperation(operation)
+	if err != nil {
+		return err
+	}
+
+	flags := checksumFlag | seqFlag
+	if w.compress && len(value) >= minCompressedValue {
+		if compressed, err := compressValue(value); err == nil && len(compressed) < len(value) {
+			entry.Value = compressed
+			flags |= compressedFlag
+		}
+	}
+
+	// Encode the entry, so that it takes a single write. Everything after
+	// the watermark is covered by the checksum. The sequence number and the
+	// scratch buffer are taken under mu, lest concurrent appends share them.
+	w.mu.Lock()
+	entry.Seq = w.seq.Load() + 1
+	record := w.scratch[:0]
+	record = binary.BigEndian.AppendUint32(record, watermark|flags)
+	record = binary.BigEndian.AppendUint64(record, entry.Seq)
+	record = append(record, op)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(entry.Key)))
+	record = append(record, entry.Key...)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(entry.Value)))
+	record = append(record, entry.Value...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record[4:]))
+	if cap(record) <= maxScratch {
+		w.scratch = record
+	}
+
+	if _, err := w.writer().Write(record); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+
+	w.seq.Store(entry.Seq)
+	w.last, w.lastKnown = w.written, true
+	w.appended.Add(1)
+	w.unsynced.Add(1)
+	size := int64(len(record))
+	w.appendedBytes.Add(size)
+	w.written += size
+	if w.buf == nil {
+		w.publish()
+	}
+	full := w.segmentSize > 0 && w.written >= w.segmentSize
+	w.mu.Unlock()
+
+	// Roll over to a new segment once the active one is full.
+	if full {
+		if _, err := w.Rotate(); err != nil {
+			return err
+		}
+	}
+
+	w.appends.observe(time.Since(start))
+	return nil
+}
+
+// truncate cuts the active segment down to size bytes.
+func (w *WAL) truncate(size int64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	w.written, w.lastKnown = size, false
+	w.publish()
+	return w.file.Sync()
+}
+
+// Flush writes the buffered entries to the file, where readers such as tails
+// and iterators see them. It doesn't sync them to stable storage.
+func (w *WAL) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// flush is Flush for the callers holding mu.
+func (w *WAL) flush() error {
+	if w.buf == nil || w.buf.Buffered() == 0 {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	w.publish()
+	return nil
+}
+
+// writer returns where the entries are appended: the buffer if there is one,
+// or the active segment itself.
+func (w *WAL) writer() io.Writer {
+	if w.buf != nil {
+		return w.buf
+	}
+	return w.file
+}
+
+// setBuffer makes the WAL buffer up to size bytes of entries in memory until
+// the next Flush or Sync, or the buffer fills up. A size of 0 or less writes
+// the entries to the file directly.
+func (w *WAL) setBuffer(size int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if size <= 0 {
+		w.buf = nil
+		return nil
+	}
+	w.buf = bufio.NewWriterSize(w.file, size)
+	return nil
+}
+
+// Sync commits the appended records to stable storage. Entries can be
+// appended while the file is being synced.
+func (w *WAL) Sync() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	return w.sync()
+}
+
+// sync is Sync for the callers holding syncMu.
+func (w *WAL) sync() error {
+	w.mu.Lock()
+	err := w.flush()
+	file, seq, records := w.file, w.seq.Load(), w.unsynced.Swap(0)
+	w.mu.Unlock()
+	if err != nil {
+		w.unsynced.Add(records)
+		w.syncOutcomes.record(err)
+		return err
+	}
+	return w.syncFile(file, seq, records)
+}
+
+// syncTo makes the entries up to seq durable, unless a sync since they were
+// appended already did. Appenders waiting on the same sync share a single
+// fsync.
+func (w *WAL) syncTo(seq uint64) error {
+	if w.syncedSeq.Load() >= seq {
+		return nil
+	}
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if w.syncedSeq.Load() >= seq {
+		return nil
+	}
+	return w.sync()
+}
+
+// fsync commits file to stable storage; tests replace it to hold syncs up.
+var fsync = func(file *os.File) error { return file.Sync() }
+
+// syncFile fsyncs file, the active segment holding records unsynced entries
+// up to seq. syncMu must be held.
+func (w *WAL) syncFile(file *os.File, seq uint64, records int64) error {
+	start := time.Now()
+	if err := fsync(file); err != nil {
+		w.unsynced.Add(records)
+		w.syncOutcomes.record(err)
+		return err
+	}
+	w.syncOutcomes.record(nil)
+	w.syncs.observe(records, time.Since(start))
+	w.syncedSeq.Store(seq)
+
+	return nil
+}
+
+// Close closes the Write-Ahead Log.
+func (w *WAL) Close() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.flush()
+	w.closeTails()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readWALEntryAt reads the entry at offset of file, returning it with the
+// offset of the next entry and its watermark. An entry that doesn't match its
+// checksum is reported by a ChecksumError, still with the offset after it.
+func readWALEntryAt(file *os.File, offset int64) (WALEntry, int64, uint32, error) {
+	var entry WALEntry
+
+	// The lengths read from the entry are checked against the size of the
+	// file before anything is allocated for them.
+	info, err := file.Stat()
+	if err != nil {
+		return entry, 0, 1, err
+	}
+	size := info.Size()
+
+	// Seek to the specified offset in the file.
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return entry, 0, 1, err
+	}
+
+	// Use bufio.Reader to read the file.
+	buffered := bufio.NewReader(file)
+
+	// Read the watermark value from the WAL.
+	var watermark_ uint32
+	if err := binary.Read(buffered, binary.BigEndian, &watermark_); err != nil {
+		return entry, 0, 1, err
+	}
+	checksummed := watermark_&checksumFlag != 0
+	sequenced := watermark_&seqFlag != 0
+	compressed := watermark_&compressedFlag != 0
+	watermark_ &^= checksumFlag | seqFlag | compressedFlag
+
+	// Hash the rest of the entry while reading it.
+	crc := crc32.NewIEEE()
+	reader := io.TeeReader(buffered, crc)
+
+	// Check if the watermark value is valid.
+	if watermark_ != WatermarkPlaceholder && watermark_ != Watermark {
+		return entry, 0, 1, fmt.Errorf("Invalid watermark value")
+	}
+
+	// Read the sequence number.
+	seqLen := int64(0)
+	if sequenced {
+		if err := binary.Read(reader, binary.BigEndian, &entry.Seq); err != nil {
+			return entry, 0, 1, err
+		}
+		seqLen = 8
+	}
+
+	// Read the operation from the WAL, an opcode or the three letters of older entries.
+	operation, opLen, err := readOperation(reader)
+	if err != nil {
+		return entry, 0, 1, err
+	}
+	entry.Operation = operation
+
+	// Read the key length from the WAL.
+	var keyLen uint32
+	if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
+		return entry, 0, 1, err
+	}
+
+	// Read the key from the WAL.
+	pos := offset + 4 + seqLen + int64(opLen) + 4
+	if int64(keyLen) > size-pos {
+		return entry, 0, 1, badEntryLength("key", keyLen, offset, size-pos)
+	}
+	keyBuf := make([]byte, keyLen)
+	n, err := io.ReadFull(reader, keyBuf)
+	if err != nil {
+		return entry, 0, 1, err
+	}
+	if n != int(keyLen) {
+		return entry, 0, 1, fmt.Errorf("unexpected number of bytes read for key: expected %d, got %d", keyLen, n)
+	}
+	entry.Key = keyBuf
+
+	// Read the value length from the WAL.
+	var valLen uint32
+	if err := binary.Read(reader, binary.BigEndian, &valLen); err != nil {
+		return entry, 0, 1, err
+	}
+
+	// Read the value from the WAL.
+	pos += int64(keyLen) + 4
+	if int64(valLen) > size-pos {
+		return entry, 0, 1, badEntryLength("value", valLen, offset, size-pos)
+	}
+	valBuf := make([]byte, valLen)
+	if n, err := io.ReadFull(reader, valBuf); err != nil {
+		return entry, 0, 1, err
+	} else if n != int(valLen) {
+		return entry, 0, 1, fmt.Errorf("unexpected number of bytes read for value: expected %d, got %d", valLen, n)
+	}
+	entry.Value = valBuf
+
+	// Get the current position in the file after reading the entry.
+	currentPos := pos + int64(valLen)
+
+	// Verify the checksum of the entry.
+	if checksummed {
+		var sum uint32
+		if err := binary.Read(buffered, binary.BigEndian, &sum); err != nil {
+			return entry, 0, 1, err
+		}
+		currentPos += 4
+		if sum != crc.Sum32() {
+			return entry, currentPos, 1, &ChecksumError{Path: file.Name(), Part: walEntryPart, Offset: offset}
+		}
+	}
+
+	if compressed {
+		if entry.Value, err = decompressValue(valBuf); err != nil {
+			return entry, 0, 1, fmt.Errorf("error decompressing WAL entry at offset %d: %v", offset, err)
+		}
+	}
+
+	return entry, currentPos, watermark_, nil
+}
+
+// badEntryLength reports the length of the key or value of the entry at
+// offset running past the left bytes of the file. A torn append or a
+// corrupted length leaves such an entry, which reads as truncated.
+func badEntryLength(field string, length uint32, offset, left int64) error {
+	return fmt.Errorf("torn or corrupt WAL entry at offset %d: %s length %d exceeds the %d bytes left: %w",
+		offset, field, length, left, io.ErrUnexpectedEOF)
+}
+
+// compressValue compresses value with DEFLATE.
+func compressValue(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(value); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressValue reverses compressValue.
+func decompressValue(compressed []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(compressed))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+// Helper function to compare two byte slices.
+func bytesEqual(a, b []byte) bool {
+	return string(a) == string(b)
+}
+
+// LastOperation returns the last operation from the WAL. It reads the entry
+// directly when its offset is known: from memory for the entries appended
+// since the active segment was opened, or from the footer of older segments.
+func (w *WAL) LastOperation() (*WALEntry, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if w.lastKnown {
+		entry, _, _, err := readWALEntryAt(w.file, w.last)
+		if err != nil {
+			return nil, err
+		}
+		return &entry, nil
+	}
+
+	paths, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	// The last operation is in the newest segment that isn't empty.
+	for i := len(paths) - 1; i >= 0; i-- {
+		file, err := os.Open(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := lastEntryOffset(file)
+		if err == nil && offset >= 0 && paths[i] == w.path {
+			w.last, w.lastKnown = offset, true
+		}
+		var entry WALEntry
+		if err == nil && offset >= 0 {
+			entry, _, _, err = readWALEntryAt(file, offset)
+		}
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if offset >= 0 {
+			return &entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// lastEntryOffset returns the offset of the last entry of a WAL file, or -1
+// if it has none. Files without a footer are scanned from their start.
+func lastEntryOffset(file *os.File) (int64, error) {
+	// Get the current file size.
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	fileSize := fileInfo.Size()
+
+	start, err := readWALHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	if last, ok := readWALFooter(file, start, fileSize); ok {
+		// Make sure the footer isn't the end of a value, in a file that has none.
+		if _, next, _, err := readWALEntryAt(file, last); err == nil && next == fileSize-walFooterSize {
+			return last, nil
+		}
+	}
+
+	// Iterate through the entire WAL file.
+	last := int64(-1)
+	for offset := start; offset < fileSize && !footerAt(file, offset); {
+		_, nextOffset, _, err := readWALEntryAt(file, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		// Update the last entry, and move to the next one.
+		last, offset = offset, nextOffset
+	}
+
+	return last, nil
+}
+
+// readWALFooter returns the offset of the last entry recorded in the footer
+// of a segment whose entries start at start, if it has one.
+func readWALFooter(file *os.File, start, size int64) (int64, bool) {
+	if size-start < walFooterSize {
+		return 0, false
+	}
+
+	var footer [walFooterSize]byte
+	if _, err := file.ReadAt(footer[:], size-walFooterSize); err != nil {
+		return 0, false
+	}
+	if [4]byte(footer[:4]) != walFooterMagic {
+		return 0, false
+	}
+	last := int64(binary.BigEndian.Uint64(footer[4:]))
+	if last < start || last >= size-walFooterSize {
+		return 0, false
+	}
+	return last, true
+}
+
+// footerAt reports whether the entries of file end at offset with a footer.
+// A footer cut short by a crash counts too, since it follows entries that are
+// all complete.
+func footerAt(file *os.File, offset int64) bool {
+	var magic [4]byte
+	n, _ := file.ReadAt(magic[:], offset)
+	return n > 0 && bytes.Equal(magic[:n], walFooterMagic[:n])
+}
+
+// UpdateWatermark marks every entry of the WAL as flushed to an SST file, by
+// rolling over to a new segment and checkpointing the previous ones.
+func (w *WAL) UpdateWatermark() error {
+	n, err := w.Rotate()
+	if err != nil {
+		return err
+	}
+	return w.Checkpoint(n, w.seq.Load())
+}
+
+// Clear deletes the segments made obsolete by a previous checkpoint whose
+// deletion failed.
+func (w *WAL) Clear() error {
+	if w.segment == 0 {
+		return nil
+	}
+	return w.RemoveBefore(w.checkpoint)
+}
+
+// migrateLegacyWAL copies the entries of the single-file WAL at path that are
+// not flushed yet into w, then renames the file to path.legacy. Nothing
+// happens if there is no such file.
+func migrateLegacyWAL(w *WAL, path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	start, err := readWALHeader(file)
+	if err != nil {
+		return err
+	}
+	for offset := start; offset < info.Size(); {
+		entry, nextOffset, watermark, err := readWALEntryAt(file, offset)
+		if err != nil {
+			return fmt.Errorf("error migrating %s: %v", path, err)
+		}
+		if watermark == WatermarkPlaceholder {
+			if err := w.AppendEntry(WatermarkPlaceholder, entry.Operation, entry.Key, entry.Value); err != nil {
+				return err
+			}
+		}
+		offset = nextOffset
+	}
+
+	// The copied entries must be durable before the original is put aside.
+	if err := w.Sync(); err != nil {
+		return err
+	}
+	Logger.Printf("Migrated %s to WAL segments", path)
+
+	return replaceFile(path, path+".legacy")
+}
+
+// size returns the total size of the files of the WAL.
+func (w *WAL) size() (int64, error) {
+	paths, err := w.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
@@ -0,0 +1,244 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WALIterator walks the entries of a WAL in the order they were appended,
+// oldest segment first, or newest first for a reverse iterator. It reads the
+// segment files as they are on disk when it reaches them, so it sees the
+// entries appended in the meantime, and skips the segments deleted by a
+// checkpoint before it got to them.
+type WALIterator struct {
+	paths []string // Segments left to read, in the order of the iteration.
+
+	file      *os.File // Segment being read.
+	size      int64
+	offset    int64 // Offset of the next entry in file.
+	entry     WALEntry
+	watermark uint32
+	err       error
+
+	// A reverse iterator reads the offsets of the entries of a segment
+	// first, then pops them. tailErr stopped that scan, and is reported once
+	// the entries before it are done.
+	reverse bool
+	offsets []int64
+	tailErr error
+}
+
+// Iterator returns an iterator over the entries of the WAL, after writing the
+// buffered ones to the file.
+func (w *WAL) Iterator() (*WALIterator, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	paths, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	return &WALIterator{paths: paths}, nil
+}
+
+// ReverseIterator returns an iterator over the entries of the WAL, newest
+// first. It yields the readable entries of a segment with a torn or corrupt
+// tail before Err reports the problem, which helps inspecting a crashed store.
+func (w *WAL) ReverseIterator() (*WALIterator, error) {
+	it, err := w.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	it.setReverse()
+	return it, nil
+}
+
+// setReverse makes it iterate from the last entry of the last segment.
+func (it *WALIterator) setReverse() {
+	it.reverse = true
+	for i, j := 0, len(it.paths)-1; i < j; i, j = i+1, j-1 {
+		it.paths[i], it.paths[j] = it.paths[j], it.paths[i]
+	}
+}
+
+// NewWALIterator returns an iterator over the WAL segments of dir, such as the
+// walStorage directory of a store. Unlike OpenWAL, it never modifies dir.
+func NewWALIterator(dir string) (*WALIterator, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(segments))
+	for i, n := range segments {
+		paths[i] = segmentPath(dir, n)
+	}
+	return &WALIterator{paths: paths}, nil
+}
+
+// NewWALReverseIterator is NewWALIterator for a reverse iterator.
+func NewWALReverseIterator(dir string) (*WALIterator, error) {
+	it, err := NewWALIterator(dir)
+	if err != nil {
+		return nil, err
+	}
+	it.setReverse()
+	return it, nil
+}
+
+// Next moves the iterator to the next entry. It returns false when the WAL
+// is exhausted or an error occurred.
+func (it *WALIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.reverse {
+		return it.prev()
+	}
+
+	for it.file == nil || it.offset >= it.size || footerAt(it.file, it.offset) {
+		if !it.nextSegment() {
+			return false
+		}
+	}
+
+	entry, next, watermark, err := readWALEntryAt(it.file, it.offset)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.entry, it.watermark, it.offset = entry, watermark, next
+	return true
+}
+
+// prev moves a reverse iterator to the entry before the current one.
+func (it *WALIterator) prev() bool {
+	for len(it.offsets) == 0 {
+		if it.tailErr != nil {
+			it.err = it.tailErr
+			return false
+		}
+		if !it.nextSegment() {
+			return false
+		}
+		it.scanOffsets()
+	}
+
+	offset := it.offsets[len(it.offsets)-1]
+	it.offsets = it.offsets[:len(it.offsets)-1]
+	entry, _, watermark, err := readWALEntryAt(it.file, offset)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.entry, it.watermark = entry, watermark
+	return true
+}
+
+// scanOffsets reads the offsets of the entries of the segment just opened.
+func (it *WALIterator) scanOffsets() {
+	for offset := it.offset; offset < it.size && !footerAt(it.file, offset); {
+		_, next, _, err := readWALEntryAt(it.file, offset)
+		if err != nil {
+			it.tailErr = err
+			return
+		}
+		it.offsets = append(it.offsets, offset)
+		offset = next
+	}
+}
+
+// nextSegment opens the next segment to read, and reports whether there was one.
+func (it *WALIterator) nextSegment() bool {
+	if it.file != nil {
+		it.file.Close()
+		it.file = nil
+	}
+
+	for len(it.paths) > 0 {
+		path := it.paths[0]
+		it.paths = it.paths[1:]
+
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			it.err = err
+			return false
+		}
+		start, err := readWALHeader(file)
+		if err != nil {
+			file.Close()
+			it.err = err
+			return false
+		}
+
+		it.file, it.size, it.offset = file, info.Size(), start
+		return true
+	}
+
+	return false
+}
+
+// Entry returns the entry the iterator is positioned on.
+func (it *WALIterator) Entry() WALEntry {
+	return it.entry
+}
+
+// Seq returns the sequence number of the entry, 0 for entries written before
+// sequence numbers were introduced.
+func (it *WALIterator) Seq() uint64 {
+	return it.entry.Seq
+}
+
+// Op returns the operation of the entry, such as SET or DEL.
+func (it *WALIterator) Op() string {
+	return it.entry.Operation
+}
+
+// Key returns the key of the entry.
+func (it *WALIterator) Key() []byte {
+	return it.entry.Key
+}
+
+// Value returns the value of the entry, decompressed.
+func (it *WALIterator) Value() []byte {
+	return it.entry.Value
+}
+
+// Flushed reports whether the entry is marked by the watermark as flushed to
+// an SST file.
+func (it *WALIterator) Flushed() bool {
+	return it.watermark == Watermark
+}
+
+// Segment returns the name of the segment file holding the entry.
+func (it *WALIterator) Segment() string {
+	if it.file == nil {
+		return ""
+	}
+	return filepath.Base(it.file.Name())
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *WALIterator) Err() error {
+	return it.err
+}
+
+// Close releases the file held by the iterator.
+func (it *WALIterator) Close() error {
+	if it.file == nil {
+		return nil
+	}
+	err := it.file.Close()
+	it.file = nil
+	it.paths = nil
+	return err
+}
@@ -0,0 +1,114 @@
+package kvstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALIterator(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir, WALSegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for i := 0; i < 10; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	mem.Del([]byte("key3"))
+	if segments, _ := listSegments(mem.opts.WALDir); len(segments) < 2 {
+		t.Fatalf("Expected several segments, got %v", segments)
+	}
+
+	check := func(it *WALIterator) {
+		t.Helper()
+		defer it.Close()
+
+		var seq uint64
+		for it.Next() {
+			seq++
+			if it.Seq() != seq {
+				t.Fatalf("Expected sequence number %d, got %d", seq, it.Seq())
+			}
+			wantOp, wantKey := "SET", fmt.Sprintf("key%d", seq-1)
+			if seq == 11 {
+				wantOp, wantKey = "DEL", "key3"
+			}
+			if it.Op() != wantOp || string(it.Key()) != wantKey || it.Flushed() {
+				t.Errorf("Unexpected entry %d: %s %s (flushed %v)", seq, it.Op(), it.Key(), it.Flushed())
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Error iterating: %v", err)
+		}
+		if seq != 11 {
+			t.Errorf("Expected 11 entries, got %d", seq)
+		}
+	}
+
+	it, err := mem.wal.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(it)
+
+	it, err = NewWALIterator(filepath.Join(dir, "walStorage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(it)
+
+	// An empty directory has no entries.
+	it, err = NewWALIterator(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Expected no entries in an empty directory, got error %v", it.Err())
+	}
+}
+
+func TestWALReverseIterator(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		wal.AppendEntry(WatermarkPlaceholder, "SET", []byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+
+	it, err := wal.ReverseIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	if it.Err() != nil || len(keys) != 7 || keys[0] != "key6" || keys[6] != "key0" {
+		t.Errorf("Expected the keys newest first, got %v (%v)", keys, it.Err())
+	}
+
+	// Tear the last entry: the readable ones come first, then the error.
+	wal.file.Write([]byte{0, 0, 0, 0, 'S', 'E'})
+	wal.Close()
+	it, err = NewWALReverseIterator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	keys = keys[:0]
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if it.Err() == nil {
+		t.Errorf("Expected an error for the torn entry")
+	}
+	if len(keys) == 0 || keys[0] != "key6" {
+		t.Errorf("Expected the entries before the torn one, got %v", keys)
+	}
+}
@@ -0,0 +1,247 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrWALGap is returned by WALTail.Next when the entries a tail needs next
+// were already checkpointed out of the WAL, so the follower has to be
+// brought up to date some other way, such as from a copy of the SST files.
+var ErrWALGap = errors.New("WAL entries were checkpointed before being tailed")
+
+// tailState is the end of the complete entries of the WAL, which tails read up
+// to and wait on.
+type tailState struct {
+	mu      sync.Mutex
+	path    string // The active segment.
+	segment int
+	end     int64 // Offset after the last complete entry of the active segment.
+	closed  bool
+	wake    chan struct{} // Closed by the next publish, nil if no tail waits.
+}
+
+// publish makes the entries written to the active segment so far visible to
+// the tails, and wakes those waiting for them.
+func (w *WAL) publish() {
+	t := &w.tails
+	t.mu.Lock()
+	t.path, t.segment, t.end = w.path, w.segment, w.written
+	if t.wake != nil {
+		close(t.wake)
+		t.wake = nil
+	}
+	t.mu.Unlock()
+}
+
+// closeTails wakes the tails for good, once the WAL is closed.
+func (w *WAL) closeTails() {
+	t := &w.tails
+	t.mu.Lock()
+	t.closed = true
+	if t.wake != nil {
+		close(t.wake)
+		t.wake = nil
+	}
+	t.mu.Unlock()
+}
+
+// WALTail streams the entries of a WAL as they are appended, as the source of
+// log shipping to a follower. It reads them from the segment files, so it
+// never holds up writers, and can start from any entry not checkpointed out
+// of the WAL yet. A WALTail is not safe for concurrent use.
+type WALTail struct {
+	w    *WAL
+	next uint64 // Sequence number of the next entry to return, 0 for the first one.
+
+	file    *os.File // Segment being read.
+	segment int
+	offset  int64
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// Tail returns a tail streaming the entries of the WAL from the one numbered
+// fromSeq, or from the oldest one if fromSeq is 0. Entries are streamed once
+// they are written to the file, which a buffered WAL does when it is flushed,
+// and once they are synced with SyncAlways.
+func (w *WAL) Tail(fromSeq uint64) (*WALTail, error) {
+	t := &WALTail{w: w, next: fromSeq, stop: make(chan struct{})}
+
+	w.tails.mu.Lock()
+	segment, path, closed := w.tails.segment, w.tails.path, w.tails.closed
+	w.tails.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	// Start with the oldest segment left, or the only file of a NewWAL.
+	if segment > 0 {
+		segments, err := listSegments(w.dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) > 0 && segments[0] < segment {
+			segment, path = segments[0], segmentPath(w.dir, segments[0])
+		}
+	}
+	if err := t.open(segment, path); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TailWAL returns a tail of the WAL of the store, streaming the writes from
+// the one numbered fromSeq. See WAL.Tail.
+func (mem *MemDB) TailWAL(fromSeq uint64) (*WALTail, error) {
+	if mem.closed.Load() {
+		return nil, ErrClosed
+	}
+	return mem.wal.Tail(fromSeq)
+}
+
+// open moves the tail to the start of a segment.
+func (t *WALTail) open(segment int, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	offset, err := readWALHeader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if t.file != nil {
+		t.file.Close()
+	}
+	t.file, t.segment, t.offset = file, segment, offset
+	return nil
+}
+
+// Next returns the next entry, waiting for it to be appended if needed. It
+// fails with ctx.Err() if ctx is done first, ErrClosed once the tail or the
+// WAL is closed, and ErrWALGap if the entry is no longer in the WAL.
+func (t *WALTail) Next(ctx context.Context) (WALEntry, error) {
+	for {
+		entry, err := t.read(ctx)
+		if err != nil {
+			return WALEntry{}, err
+		}
+
+		// Entries from before sequence numbers, or before fromSeq, are skipped.
+		if entry.Seq == 0 || entry.Seq < t.next {
+			continue
+		}
+		if t.next != 0 && entry.Seq != t.next {
+			return WALEntry{}, fmt.Errorf("%w: expected entry %d, found %d", ErrWALGap, t.next, entry.Seq)
+		}
+		t.next = entry.Seq + 1
+		return entry, nil
+	}
+}
+
+// read returns the entry at the position of the tail, moving on to the next
+// segment at the end of a rotated out one, and waiting at the end of the
+// active one.
+func (t *WALTail) read(ctx context.Context) (WALEntry, error) {
+	for {
+		select {
+		case <-t.stop:
+			return WALEntry{}, ErrClosed
+		default:
+		}
+
+		active, end, wake, err := t.limit()
+		if err != nil {
+			return WALEntry{}, err
+		}
+
+		if !active {
+			// A rotated out segment is complete, up to its footer or end.
+			info, err := t.file.Stat()
+			if err != nil {
+				return WALEntry{}, err
+			}
+			if t.offset >= info.Size() || footerAt(t.file, t.offset) {
+				if err := t.nextSegment(); err != nil {
+					return WALEntry{}, err
+				}
+				continue
+			}
+			end = info.Size()
+		}
+
+		if t.offset < end {
+			entry, next, _, err := readWALEntryAt(t.file, t.offset)
+			if err != nil {
+				// A checkpoint may have recycled the segment while it was read.
+				if _, statErr := os.Stat(t.file.Name()); !active && os.IsNotExist(statErr) {
+					return WALEntry{}, fmt.Errorf("%w: %s was recycled", ErrWALGap, filepath.Base(t.file.Name()))
+				}
+				return WALEntry{}, err
+			}
+			t.offset = next
+			return entry, nil
+		}
+
+		select {
+		case <-wake:
+		case <-t.stop:
+			return WALEntry{}, ErrClosed
+		case <-ctx.Done():
+			return WALEntry{}, ctx.Err()
+		}
+	}
+}
+
+// limit reports whether the tail is on the active segment and, if so, the
+// offset it can read up to. At that offset, wake is closed once more entries
+// are appended.
+func (t *WALTail) limit() (active bool, end int64, wake chan struct{}, err error) {
+	s := &t.w.tails
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false, 0, nil, ErrClosed
+	}
+	if t.segment != s.segment {
+		return false, 0, nil, nil
+	}
+	if t.offset >= s.end {
+		if s.wake == nil {
+			s.wake = make(chan struct{})
+		}
+		wake = s.wake
+	}
+	return true, s.end, wake, nil
+}
+
+// nextSegment moves the tail to the first segment after its own.
+func (t *WALTail) nextSegment() error {
+	segments, err := listSegments(t.w.dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		if n > t.segment {
+			return t.open(n, segmentPath(t.w.dir, n))
+		}
+	}
+	return fmt.Errorf("no WAL segment after %s", t.file.Name())
+}
+
+// Close stops the tail, making Next return ErrClosed.
+func (t *WALTail) Close() error {
+	var err error
+	t.once.Do(func() {
+		close(t.stop)
+		err = t.file.Close()
+	})
+	return err
+}
@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWALTail(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 76) // Two entries per segment.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	for i := 1; i <= 3; i++ {
+		wal.AppendEntry(WatermarkPlaceholder, "SET", []byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+
+	tail, err := wal.Tail(2)
+	if err != nil {
+		t.Fatalf("Error tailing WAL: %v", err)
+	}
+	defer tail.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, want := range []string{"key2", "key3"} {
+		entry, err := tail.Next(ctx)
+		if err != nil || string(entry.Key) != want {
+			t.Fatalf("Expected %s, got %+v (%v)", want, entry, err)
+		}
+	}
+
+	// Entries appended later wake the tail up, across segments.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := 4; i <= 6; i++ {
+			wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte(fmt.Sprintf("key%d", i)), nil)
+		}
+	}()
+	for i := 4; i <= 6; i++ {
+		entry, err := tail.Next(ctx)
+		if err != nil || entry.Seq != uint64(i) || entry.Operation != "DEL" {
+			t.Fatalf("Expected the deletion %d, got %+v (%v)", i, entry, err)
+		}
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if _, err := tail.Next(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+
+	wal.Close()
+	if _, err := tail.Next(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after closing the WAL, got %v", err)
+	}
+}
+
+func TestWALTailGap(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("a"), []byte("1"))
+	if err := wal.UpdateWatermark(); err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("b"), []byte("2"))
+
+	tail, err := wal.Tail(1)
+	if err != nil {
+		t.Fatalf("Error tailing WAL: %v", err)
+	}
+	defer tail.Close()
+	if _, err := tail.Next(context.Background()); !errors.Is(err, ErrWALGap) {
+		t.Errorf("Expected ErrWALGap for a checkpointed entry, got %v", err)
+	}
+
+	tail, err = wal.Tail(2)
+	if err != nil {
+		t.Fatalf("Error tailing WAL: %v", err)
+	}
+	if entry, err := tail.Next(context.Background()); err != nil || string(entry.Key) != "b" {
+		t.Errorf("Expected the entry after the checkpoint, got %+v (%v)", entry, err)
+	}
+	tail.Close()
+	if _, err := tail.Next(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after closing the tail, got %v", err)
+	}
+}
@@ -0,0 +1,849 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendAndReadEntry(t *testing.T) {
+	// Create a temporary file for testing.
+	tmpfile, err := os.CreateTemp(".", "wal_test")
+	if err != nil {
+		fmt.Println("Error creating temporary file:", err)
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// Create a WAL instance.
+	wal, _ := NewWAL(tmpfile.Name())
+	if wal == nil {
+		t.Fatal("Error creating WAL")
+	}
+	defer wal.Close()
+
+	// Test data.
+	operation := "SET"
+	key := []byte("test_key")
+	value := []byte("test_value")
+
+	// Append an entry to the WAL.
+	err = wal.AppendEntry(WatermarkPlaceholder, operation, key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the entry from the WAL.
+	readEntry, _, _, err := readWALEntryAt(tmpfile, walHeaderSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Operation: %s\n", readEntry.Operation)
+	t.Logf("Key Length: %d\n", len(readEntry.Key))
+	t.Logf("Key: %s\n", readEntry.Key)
+	t.Logf("Value Length: %d\n", len(readEntry.Value))
+	t.Logf("Value: %s\n", readEntry.Value)
+
+	// Compare the written and read data.
+	if readEntry.Operation != operation || !bytesEqual(readEntry.Key, key) || !bytesEqual(readEntry.Value, value) {
+		t.Errorf("Expected %+v, got %+v", WALEntry{Operation: operation, Key: key, Value: value}, readEntry)
+	}
+}
+
+func TestAppendOnlyPrinciple(t *testing.T) {
+	// Create a temporary WAL file for testing.
+	tmpfile, err := os.CreateTemp(".", "wal_test")
+	if err != nil {
+		t.Fatal("Error creating temporary file:", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	// Create a WAL instance.
+	wal, _ := NewWAL(tmpfile.Name())
+	if wal == nil {
+		t.Fatal("Error creating WAL")
+	}
+	defer wal.Close()
+
+	// Test data.
+	key1 := []byte("key1")
+	value1 := []byte("value1")
+	key2 := []byte("key2")
+	value2 := []byte("value2")
+
+	// Append the first key-value pair to the WAL.
+	err = wal.AppendEntry(WatermarkPlaceholder, "SET", key1, value1)
+	if err != nil {
+		t.Fatal("Error appending entry:", err)
+	}
+
+	// Append the second key-value pair to the WAL.
+	err = wal.AppendEntry(WatermarkPlaceholder, "SET", key2, value2)
+	if err != nil {
+		t.Fatal("Error appending entry:", err)
+	}
+
+	readEntry1, currentPos, _, err := readWALEntryAt(tmpfile, walHeaderSize)
+	if err != nil {
+		t.Fatal("Error reading entry from WAL:", err)
+	}
+
+	t.Logf("readEntry1:\n")
+	t.Logf("Operation: %s\n", readEntry1.Operation)
+	t.Logf("Key Length: %d\n", len(readEntry1.Key))
+	t.Logf("Key: %s\n", readEntry1.Key)
+	t.Logf("Value Length: %d\n", len(readEntry1.Value))
+	t.Logf("Value: %s\n", readEntry1.Value)
+
+	t.Logf("---Current position: %d", currentPos)
+
+	// Read the second entry from the WAL.
+	readEntry2, _, _, err := readWALEntryAt(tmpfile, currentPos)
+	if err != nil {
+		t.Fatal("Error reading entry from WAL:", err)
+	}
+
+	t.Logf("readEntry2:\n")
+	t.Logf("Operation: %s\n", readEntry2.Operation)
+	t.Logf("Key Length: %d\n", len(readEntry2.Key))
+	t.Logf("Key: %s\n", readEntry2.Key)
+	t.Logf("Value Length: %d\n", len(readEntry2.Value))
+	t.Logf("Value: %s\n", readEntry2.Value)
+
+	// Check that the second key-value pair is the last one.
+	if !bytesEqual(readEntry2.Key, key2) || !bytesEqual(readEntry2.Value, value2) {
+		t.Errorf("Expected %+v, got %+v", WALEntry{Operation: readEntry2.Operation, Key: key2, Value: value2}, readEntry2)
+	}
+}
+
+func TestWALChecksum(t *testing.T) {
+	wal, err := NewWAL(filepath.Join(t.TempDir(), "wal.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if err := wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := readWALEntryAt(wal.file, walHeaderSize); err != nil {
+		t.Fatalf("Error reading intact entry: %v", err)
+	}
+
+	// Flip a bit of the value: 8 bytes of header, 4 of watermark, 8 of sequence number, 1 of opcode, 4+3 of key and 4 of value length.
+	// The WAL is opened for appending, so write through another handle.
+	corrupt, err := os.OpenFile(wal.file.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corrupt.Close()
+	if _, err := corrupt.WriteAt([]byte{'v' ^ 1}, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := readWALEntryAt(wal.file, walHeaderSize); err == nil {
+		t.Errorf("Expected a checksum error reading a corrupted entry")
+	}
+}
+
+func TestWALCorruptLength(t *testing.T) {
+	// The key length is after 8 bytes of header, 4 of watermark, 8 of sequence number and 1 of opcode, the value length after 4+3 bytes of key.
+	for name, at := range map[string]int64{"key": 21, "value": 28} {
+		t.Run(name, func(t *testing.T) {
+			wal, err := NewWAL(filepath.Join(t.TempDir(), "wal.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wal.Close()
+			if err := wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("key"), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+
+			corrupt, err := os.OpenFile(wal.file.Name(), os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer corrupt.Close()
+			if _, err := corrupt.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, at); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, _, err = readWALEntryAt(wal.file, walHeaderSize)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Expected a length past the end of the file to read as truncated, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWALLegacyEntry(t *testing.T) {
+	wal, err := NewWAL(filepath.Join(t.TempDir(), "wal.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	// An entry written before checksums existed has no flag and no trailer.
+	writeBinary(wal.file, WatermarkPlaceholder, []byte("SET"), uint32(3), []byte("key"), uint32(5), []byte("value"))
+	wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte("key"), nil)
+
+	entry, next, watermark, err := readWALEntryAt(wal.file, walHeaderSize)
+	if err != nil || entry.Operation != "SET" || watermark != WatermarkPlaceholder {
+		t.Fatalf("Error reading legacy entry: %+v, watermark %x (%v)", entry, watermark, err)
+	}
+	if entry, _, _, err = readWALEntryAt(wal.file, next); err != nil || entry.Operation != "DEL" {
+		t.Errorf("Error reading the entry after a legacy one: %+v (%v)", entry, err)
+	}
+}
+
+func TestWALSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 76)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each entry takes 4+8+1+4+4+4+5+4 = 34 bytes after the 8 of the header, so every other one fills a segment.
+	for i := 0; i < 5; i++ {
+		if err := wal.AppendEntry(WatermarkPlaceholder, "SET", []byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if segments, _ := listSegments(dir); len(segments) != 3 {
+		t.Errorf("Expected 3 segments, got %v", segments)
+	}
+	last, err := wal.LastOperation()
+	if err != nil || string(last.Key) != "key4" {
+		t.Errorf("Expected key4 as the last operation, got %+v (%v)", last, err)
+	}
+
+	// Removing the segments before the active one keeps only its entries.
+	if err := wal.RemoveBefore(wal.segment); err != nil {
+		t.Fatalf("Error removing segments: %v", err)
+	}
+	if segments, _ := listSegments(dir); len(segments) != 1 || segments[0] != 3 {
+		t.Errorf("Expected only segment 3 left, got %v", segments)
+	}
+	wal.Close()
+
+	// Reopening appends to the last segment.
+	wal, err = OpenWAL(dir, 76)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	if wal.segment != 3 || wal.written != 42 {
+		t.Errorf("Expected to reopen segment 3 with 42 bytes, got segment %d with %d", wal.segment, wal.written)
+	}
+}
+
+func TestFlushRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir, WALSegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	for i := 0; i < 4; i++ {
+		mem.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Set([]byte("after"), []byte("flush"))
+
+	// The write may still be buffered.
+	mem.mu.Lock()
+	err = mem.wal.Flush()
+	mem.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Error flushing the WAL buffer: %v", err)
+	}
+
+	paths, err := mem.wal.segments()
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("Expected a single segment after the flush, got %v (%v)", paths, err)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if entry, _, _, err := readWALEntryAt(file, walHeaderSize); err != nil || string(entry.Key) != "after" {
+		t.Errorf("Expected only the write made after the flush, got %+v (%v)", entry, err)
+	}
+}
+
+func TestMigrateLegacyWAL(t *testing.T) {
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "walStorage")
+	if err := os.MkdirAll(walDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := NewWAL(filepath.Join(walDir, "wal.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.AppendEntry(Watermark, "SET", []byte("flushed"), []byte("1"))
+	legacy.AppendEntry(WatermarkPlaceholder, "SET", []byte("live"), []byte("2"))
+	legacy.Close()
+
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening store with a legacy WAL: %v", err)
+	}
+	defer mem.Close()
+
+	if value, err := mem.Get([]byte("live")); err != nil || string(value) != "2" {
+		t.Errorf("Expected the live entry to be migrated, got %q (%v)", value, err)
+	}
+	if _, err := mem.Get([]byte("flushed")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the flushed entry to be left out, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(walDir, "wal.bin.legacy")); err != nil {
+		t.Errorf("Expected the legacy WAL to be kept aside: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(walDir, "wal.bin")); !os.IsNotExist(err) {
+		t.Errorf("Expected wal.bin to be gone, got %v", err)
+	}
+}
+
+func TestMigrateLegacyWALLayout(t *testing.T) {
+	dir := t.TempDir()
+	legacyDir := filepath.Join(dir, "walStorage")
+	if err := os.MkdirAll(legacyDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := NewWAL(filepath.Join(legacyDir, "wal.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.AppendEntry(WatermarkPlaceholder, "SET", []byte("live"), []byte("1"))
+	legacy.Close()
+
+	// The WAL has moved out of the data directory since the store was created.
+	mem, err := OpenWithOptions(Options{DataDir: dir, WALDir: filepath.Join(dir, "wal")})
+	if err != nil {
+		t.Fatalf("Error opening store laid out the old way: %v", err)
+	}
+	if value, err := mem.Get([]byte("live")); err != nil || string(value) != "1" {
+		t.Errorf("Expected the legacy entry to be migrated, got %q (%v)", value, err)
+	}
+	if _, err := os.Stat(filepath.Join(legacyDir, "wal.bin.legacy")); err != nil {
+		t.Errorf("Expected the legacy WAL to be kept aside: %v", err)
+	}
+	mem.Close()
+
+	// The migrated entry survives a reopen without being copied twice.
+	mem, err = OpenWithOptions(Options{DataDir: dir, WALDir: filepath.Join(dir, "wal")})
+	if err != nil {
+		t.Fatalf("Error reopening store: %v", err)
+	}
+	defer mem.Close()
+	if value, err := mem.Get([]byte("live")); err != nil || string(value) != "1" {
+		t.Errorf("Expected the migrated entry after reopening, got %q (%v)", value, err)
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("flushed"), []byte("1"))
+	n, err := wal.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("live"), []byte("2"))
+
+	flushed, err := os.ReadFile(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Checkpoint(n, 1); err != nil {
+		t.Fatalf("Error checkpointing: %v", err)
+	}
+	wal.Close()
+
+	// Simulate a crash between the checkpoint and the deletion of the segment.
+	if err := os.WriteFile(segmentPath(dir, 1), flushed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err = OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("Error reopening WAL: %v", err)
+	}
+	defer wal.Close()
+
+	paths, err := wal.segments()
+	if err != nil || len(paths) != 1 || paths[0] != segmentPath(dir, 2) {
+		t.Errorf("Expected only segment 2 to be replayed, got %v (%v)", paths, err)
+	}
+	if _, err := os.Stat(segmentPath(dir, 1)); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpointed segment to be deleted, got %v", err)
+	}
+}
+
+func TestWALSequenceNumbers(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("a"), []byte("1"))
+	mem.Set([]byte("b"), []byte("2"))
+	mem.Del([]byte("a"))
+	if seq := mem.LastSeq(); seq != 3 {
+		t.Errorf("Expected sequence number 3 after three writes, got %d", seq)
+	}
+	entry, err := mem.wal.LastOperation()
+	if err != nil || entry.Seq != 3 {
+		t.Errorf("Expected the last entry to have sequence number 3, got %+v (%v)", entry, err)
+	}
+
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Set([]byte("c"), []byte("3"))
+	mem.Close()
+
+	// The numbering goes on from the replayed entries.
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := mem.LastSeq(); seq != 4 {
+		t.Errorf("Expected sequence number 4 after reopening, got %d", seq)
+	}
+	if err := mem.FlushToDisk(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	mem.Close()
+
+	// And from the checkpoint once every entry is flushed.
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if seq := mem.LastSeq(); seq != 4 {
+		t.Errorf("Expected sequence number 4 from the checkpoint, got %d", seq)
+	}
+	mem.Set([]byte("d"), []byte("4"))
+	if seq := mem.LastSeq(); seq != 5 {
+		t.Errorf("Expected sequence number 5 after a new write, got %d", seq)
+	}
+}
+
+func TestWALConcurrentAppends(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	// Every entry gets its own sequence number, and is written whole.
+	const writers, appends = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < appends; i++ {
+				key := []byte(fmt.Sprintf("key%d-%03d", w, i))
+				if err := wal.AppendEntry(WatermarkPlaceholder, "SET", key, bytes.Repeat(key, w+1)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := wal.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := NewWALIterator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var seq uint64
+	for it.Next() {
+		if seq++; it.Seq() != seq {
+			t.Fatalf("Expected entry %d to have sequence number %d, got %d", seq, seq, it.Seq())
+		}
+		var w int
+		fmt.Sscanf(string(it.Key()), "key%d-", &w)
+		if !bytes.Equal(it.Value(), bytes.Repeat(it.Key(), w+1)) {
+			t.Fatalf("Unexpected value of %s: %q", it.Key(), it.Value())
+		}
+	}
+	if err := it.Err(); err != nil || seq != writers*appends {
+		t.Errorf("Expected %d entries, got %d (%v)", writers*appends, seq, err)
+	}
+}
+
+func TestWALCompression(t *testing.T) {
+	dir := t.TempDir()
+	large := bytes.Repeat([]byte(`{"field": "value"} `), 100)
+
+	// A log mixing compressed and uncompressed values.
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("plain"), large)
+	wal.compress = true
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("compressed"), large)
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("small"), []byte("value"))
+	wal.Close()
+
+	file, err := os.Open(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	offset := int64(walHeaderSize)
+	var sizes []int64
+	for _, key := range []string{"plain", "compressed", "small"} {
+		entry, next, _, err := readWALEntryAt(file, offset)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", key, err)
+		}
+		want := large
+		if key == "small" {
+			want = []byte("value")
+		}
+		if string(entry.Key) != key || !bytes.Equal(entry.Value, want) {
+			t.Errorf("Expected %s with its original value, got %s with %d bytes", key, entry.Key, len(entry.Value))
+		}
+		sizes = append(sizes, next-offset)
+		offset = next
+	}
+	if sizes[1] >= sizes[0]/2 {
+		t.Errorf("Expected the compressed entry to be much smaller, got %d bytes against %d", sizes[1], sizes[0])
+	}
+}
+
+func TestWALCompressionOption(t *testing.T) {
+	dir := t.TempDir()
+	large := bytes.Repeat([]byte("compressible "), 100)
+
+	mem, err := OpenWithOptions(Options{DataDir: dir, WALCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.Set([]byte("key"), large)
+	stats, err := mem.Stats()
+	if err != nil {
+		t.Fatalf("Error computing stats: %v", err)
+	}
+	if stats.WALBytes >= int64(len(large)) {
+		t.Errorf("Expected the WAL smaller than the value, got %d bytes", stats.WALBytes)
+	}
+	mem.Close()
+
+	// Stores reading a compressed log don't need the option.
+	mem, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	if value, err := mem.Get([]byte("key")); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("Expected the value back after replay, got %d bytes (%v)", len(value), err)
+	}
+}
+
+func TestWALOwnDirectory(t *testing.T) {
+	dirs := []string{t.TempDir(), filepath.Join(t.TempDir(), "nested")}
+	if err := os.MkdirAll(dirs[1], os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	wals := make([]*WAL, len(dirs))
+	for i, dir := range dirs {
+		wal, err := OpenWAL(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer wal.Close()
+		wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("key"), []byte("value"))
+		wals[i] = wal
+	}
+
+	// Checkpointing one WAL leaves the other alone.
+	if err := wals[0].UpdateWatermark(); err != nil {
+		t.Fatalf("Error updating watermark: %v", err)
+	}
+	if err := wals[0].Clear(); err != nil {
+		t.Fatalf("Error clearing: %v", err)
+	}
+	if last, err := wals[0].LastOperation(); err != nil || last != nil {
+		t.Errorf("Expected an empty WAL after the checkpoint, got %+v (%v)", last, err)
+	}
+	if last, err := wals[1].LastOperation(); err != nil || last == nil || string(last.Key) != "key" {
+		t.Errorf("Expected the other WAL untouched, got %+v (%v)", last, err)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, checkpointFile+".tmp")); !os.IsNotExist(err) {
+			t.Errorf("Expected no temporary file left in %s, got %v", dir, err)
+		}
+	}
+}
+
+func TestWALHeader(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("key"), []byte("value"))
+	wal.Close()
+
+	data, err := os.ReadFile(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:walHeaderSize], []byte{'W', 'A', 'L', 'F', 0, 0, 0, 1}) {
+		t.Errorf("Unexpected WAL header % x", data[:walHeaderSize])
+	}
+
+	// A segment written before headers existed is read from its start.
+	if err := os.WriteFile(segmentPath(dir, 1), data[walHeaderSize:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	wal, err = OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("Error opening a WAL without header: %v", err)
+	}
+	if last, err := wal.LastOperation(); err != nil || last == nil || string(last.Key) != "key" {
+		t.Errorf("Expected the entry of the headerless segment, got %+v (%v)", last, err)
+	}
+	wal.Close()
+
+	// A newer format is refused instead of misparsed.
+	binary.BigEndian.PutUint32(data[4:], walVersion+1)
+	if err := os.WriteFile(segmentPath(dir, 1), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWAL(dir, 0); err == nil {
+		t.Errorf("Expected an error opening a WAL of a newer format")
+	}
+}
+
+func TestLastOperationFooter(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("first"), []byte("1"))
+	wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte("second"), nil)
+	if last, err := wal.LastOperation(); err != nil || string(last.Key) != "second" {
+		t.Errorf("Expected second as the last operation, got %+v (%v)", last, err)
+	}
+	if _, err := wal.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// The rotated segment ends with a footer pointing at its last entry.
+	data, err := os.ReadFile(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer := data[len(data)-walFooterSize:]
+	if string(footer[:4]) != "WFTR" {
+		t.Fatalf("Expected a footer at the end of the segment, got % x", footer)
+	}
+
+	wal, err = OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	file, err := os.Open(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	offset, err := lastEntryOffset(file)
+	if err != nil || offset != int64(binary.BigEndian.Uint64(footer[4:])) {
+		t.Errorf("Expected the offset of the footer, got %d (%v)", offset, err)
+	}
+	if last, err := wal.LastOperation(); err != nil || last == nil || string(last.Key) != "second" {
+		t.Errorf("Expected second as the last operation after reopening, got %+v (%v)", last, err)
+	}
+
+	// Readers stop at the footer.
+	it, err := wal.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 2 {
+		t.Errorf("Expected 2 entries, got %d (%v)", count, it.Err())
+	}
+}
+
+func TestWALRecycleSegments(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { wal.Close() }()
+	wal.recycle, wal.preallocate = 2, true
+
+	for i := 0; i < 10; i++ {
+		wal.AppendEntry(WatermarkPlaceholder, "SET", []byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	if err := wal.RemoveBefore(wal.segment); err != nil {
+		t.Fatalf("Error removing segments: %v", err)
+	}
+	spares, err := wal.spares()
+	if err != nil || len(spares) != 2 {
+		t.Fatalf("Expected 2 spare segments, got %v (%v)", spares, err)
+	}
+	if segments, _ := listSegments(dir); len(segments) != 1 {
+		t.Errorf("Expected only the active segment left, got %v", segments)
+	}
+	spare, err := os.Stat(spares[0])
+	if err != nil || spare.Size() != 0 {
+		t.Fatalf("Expected an empty spare, got %v", err)
+	}
+
+	// The next segment is a renamed spare.
+	if _, err := wal.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	active, err := os.Stat(wal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(spare, active) {
+		t.Errorf("Expected the new segment to reuse the spare %s", spares[0])
+	}
+	if active.Size() != walHeaderSize {
+		t.Errorf("Expected the preallocated segment to keep its size, got %d", active.Size())
+	}
+
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("recycled"), []byte("value"))
+	wal.Close()
+	wal, err = OpenWAL(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last, err := wal.LastOperation(); err != nil || last == nil || string(last.Key) != "recycled" {
+		t.Errorf("Expected the entry of the recycled segment, got %+v (%v)", last, err)
+	}
+}
+
+func TestWALBuffer(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.setBuffer(1024); err != nil {
+		t.Fatal(err)
+	}
+
+	onDisk := func() int64 {
+		t.Helper()
+		info, err := os.Stat(wal.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("a"), []byte("1"))
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("b"), []byte("2"))
+	if size := onDisk(); size != walHeaderSize {
+		t.Errorf("Expected the entries to be buffered, got %d bytes on disk", size)
+	}
+	if err := wal.Flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if size := onDisk(); size != wal.written {
+		t.Errorf("Expected %d bytes on disk after a flush, got %d", wal.written, size)
+	}
+
+	// Entries larger than the buffer go through, and syncs flush it first.
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("c"), bytes.Repeat([]byte("x"), 2048))
+	wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte("a"), nil)
+	if err := wal.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if size := onDisk(); size != wal.written {
+		t.Errorf("Expected %d bytes on disk after a sync, got %d", wal.written, size)
+	}
+
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("d"), []byte("4"))
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	it, err := NewWALIterator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var keys string
+	for it.Next() {
+		keys += string(it.Key())
+	}
+	if keys != "abcad" || it.Err() != nil {
+		t.Errorf("Expected every entry after closing, got %q (%v)", keys, it.Err())
+	}
+}
+
+func TestWALBackgroundFlush(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := OpenWithOptions(Options{DataDir: dir, WALFlushInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	mem.Set([]byte("key"), []byte("value"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		it, err := NewWALIterator(mem.opts.WALDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := it.Next()
+		it.Close()
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the write to reach the file in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
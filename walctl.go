@@ -0,0 +1,91 @@
+package kvstore
+
+import (
+	"os"
+)
+
+// WALRecord is an entry read by InspectWAL, with where it was found.
+type WALRecord struct {
+	Offset  int64
+	Size    int64
+	Entry   WALEntry
+	Flushed bool // Whether the watermark marks the entry as flushed to an SST file.
+}
+
+// WALReport describes a WAL segment file checked by InspectWAL or RepairWAL.
+type WALReport struct {
+	Path    string
+	Size    int64 // Size of the file.
+	Entries int   // Readable entries.
+	End     int64 // Offset reading stopped at: the footer, the end of the file or the first corrupt entry.
+	Footer  bool  // Whether reading stopped at the footer of a rotated out segment.
+	Corrupt error // Why the entry at End can't be read, nil when the file is intact.
+}
+
+// InspectWAL reads the WAL segment file at path the way recovery does, calling
+// fn with every readable entry. Reading stops at the first entry that can't be
+// read, which the report describes; the error is only for failures to read
+// the file at all, or the one fn returned.
+func InspectWAL(path string, fn func(WALRecord) error) (WALReport, error) {
+	report := WALReport{Path: path}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+	report.Size = info.Size()
+
+	offset, err := readWALHeader(file)
+	if err != nil {
+		return report, err
+	}
+	for offset < report.Size {
+		if footerAt(file, offset) {
+			report.Footer = true
+			break
+		}
+		entry, next, watermark, err := readWALEntryAt(file, offset)
+		if err != nil {
+			report.Corrupt = err
+			break
+		}
+		if fn != nil {
+			if err := fn(WALRecord{Offset: offset, Size: next - offset, Entry: entry, Flushed: watermark == Watermark}); err != nil {
+				return report, err
+			}
+		}
+		report.Entries++
+		offset = next
+	}
+	report.End = offset
+
+	return report, nil
+}
+
+// RepairWAL truncates the WAL segment file at path before its first corrupt
+// entry, dropping that entry and everything after it. It returns the report of
+// the file as it was, and leaves intact files alone. The store must not be
+// running, as its WAL would keep writing at the old end of the file.
+func RepairWAL(path string) (WALReport, error) {
+	report, err := InspectWAL(path, nil)
+	if err != nil || report.Corrupt == nil {
+		return report, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(report.End); err != nil {
+		return report, err
+	}
+	return report, file.Sync()
+}
@@ -0,0 +1,80 @@
+package kvstore
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInspectAndRepairWAL(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("a"), []byte("1"))
+	wal.AppendEntry(WatermarkPlaceholder, "DEL", []byte("a"), nil)
+	path := wal.path
+	wal.Close()
+
+	// Tear the last entry.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []WALRecord
+	report, err := InspectWAL(path, func(record WALRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error inspecting WAL: %v", err)
+	}
+	if report.Entries != 1 || len(records) != 1 || records[0].Entry.Operation != "SET" || records[0].Offset != walHeaderSize {
+		t.Errorf("Expected the SET entry only, got %+v", records)
+	}
+	if report.Corrupt == nil || report.End != walHeaderSize+records[0].Size || report.Footer {
+		t.Errorf("Expected the corruption after the first entry, got %+v", report)
+	}
+
+	repaired, err := RepairWAL(path)
+	if err != nil {
+		t.Fatalf("Error repairing WAL: %v", err)
+	}
+	if repaired.End != report.End || repaired.Corrupt == nil {
+		t.Errorf("Expected the report of the corrupt file, got %+v", repaired)
+	}
+	if info, _ := os.Stat(path); info.Size() != report.End {
+		t.Errorf("Expected the file truncated to %d bytes, got %d", report.End, info.Size())
+	}
+
+	report, err = InspectWAL(path, nil)
+	if err != nil || report.Corrupt != nil || report.Entries != 1 {
+		t.Errorf("Expected an intact file after the repair, got %+v (%v)", report, err)
+	}
+}
+
+func TestInspectWALStopsAtFooter(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	wal.AppendEntry(WatermarkPlaceholder, "SET", []byte("a"), []byte("1"))
+	path := wal.path
+	if _, err := wal.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairWAL(path)
+	if err != nil || report.Corrupt != nil || !report.Footer || report.Entries != 1 {
+		t.Errorf("Expected an intact segment with a footer, got %+v (%v)", report, err)
+	}
+	if info, _ := os.Stat(path); info.Size() != report.Size {
+		t.Errorf("Expected an intact segment to be left alone, got %d bytes instead of %d", info.Size(), report.Size)
+	}
+}